@@ -1,20 +1,34 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/cakra17/social/internal/config"
+	"github.com/cakra17/social/internal/events"
 	"github.com/cakra17/social/internal/handlers"
+	"github.com/cakra17/social/internal/mail"
+	"github.com/cakra17/social/internal/notifications"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/realtime"
+	"github.com/cakra17/social/internal/storage"
 	"github.com/cakra17/social/internal/store"
 	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/internal/video"
+	"github.com/cakra17/social/internal/worker"
 	"github.com/cakra17/social/pkg/jwt"
 	"github.com/cakra17/social/pkg/prom"
+	"github.com/cakra17/social/pkg/urlsign"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	_ "github.com/lib/pq"
@@ -30,111 +44,394 @@ func main() {
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Logger)
 
-	r.Use(middleware.Timeout(time.Minute))
+	// realtime streams are meant to stay open, everything else times out
+	timeout := middleware.Timeout(time.Minute)
+	r.Use(func(next http.Handler) http.Handler {
+		limited := timeout(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/api/v1/realtime/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			limited.ServeHTTP(w, r)
+		})
+	})
 
 	ctx := context.Background()
 
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
 	server := http.Server{
-		Addr: ":6969",
-		Handler: r,
-		WriteTimeout: 30 * time.Second,
-		ReadTimeout: 10 * time.Second,
-		IdleTimeout: time.Minute,
+		Addr:         cfg.HTTP.Addr,
+		Handler:      r,
+		WriteTimeout: cfg.HTTP.WriteTimeout,
+		ReadTimeout:  cfg.HTTP.ReadTimeout,
+		IdleTimeout:  cfg.HTTP.IdleTimeout,
 	}
 
 	db := store.ConnectDB(store.DBConfig{
-		DB_USERNAME: "admin",
-		DB_PASSWORD: "adminsecret",
-		DB_HOST: "localhost",
-		DB_PORT: "5432",
-		DB_NAME: "social",
-		DB_MaxOpenConn: 30,
-		DB_MaxIdleConn: 30,
-		DB_MaxConnLifetime: 15 * time.Minute,
-		DB_MaxConnIdletime: 15 * time.Minute,
+		DB_USERNAME:        cfg.DB.Username,
+		DB_PASSWORD:        cfg.DB.Password,
+		DB_HOST:            cfg.DB.Host,
+		DB_PORT:            cfg.DB.Port,
+		DB_NAME:            cfg.DB.Name,
+		DB_MaxOpenConn:     cfg.DB.MaxOpenConn,
+		DB_MaxIdleConn:     cfg.DB.MaxIdleConn,
+		DB_MaxConnLifetime: cfg.DB.MaxConnLifetime,
+		DB_MaxConnIdletime: cfg.DB.MaxConnIdletime,
 	})
 
 	logger := utils.NewLogger()
 
-	jwtAuthenticator := jwt.NewJWTAuthenticator("mysecret", 5 * time.Hour)
 	rdb := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		Password: "",
-		DB: 0,
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
 		MaintNotificationsConfig: &maintnotifications.Config{
 			Mode: maintnotifications.ModeDisabled,
 		},
 	})
 	store.TestRedis(ctx, rdb)
+
+	userRepo := store.NewUserRepo(db, logger)
+	jwtAuthenticator := jwt.NewJWTAuthenticator(cfg.JWT.Secret, cfg.JWT.AccessTTL).
+		WithDenylist(jwt.NewDenylist(rdb, &userRepo))
+
+	var keyring *jwt.Keyring
+	if cfg.JWT.Algorithm != "HS256" {
+		if cfg.JWT.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(cfg.JWT.PrivateKeyFile)
+			if err != nil {
+				log.Fatalf("Failed to read signing key: %v", err)
+			}
+			keyring, err = jwt.NewStaticKeyring(filepath.Base(cfg.JWT.PrivateKeyFile), pem)
+			if err != nil {
+				log.Fatalf("Failed to load signing key: %v", err)
+			}
+		} else {
+			kek, err := cfg.Keys.KEK()
+			if err != nil {
+				log.Fatalf("Failed to load key-encryption key: %v", err)
+			}
+			keyring, err = jwt.NewKeyring(ctx, rdb, jwt.KeyringConfig{
+				Algorithm:   cfg.JWT.Algorithm,
+				RotateEvery: cfg.JWT.RotateEvery,
+				TokenTTL:    cfg.JWT.AccessTTL,
+				KEK:         kek,
+			})
+			if err != nil {
+				log.Fatalf("Failed to load signing keys: %v", err)
+			}
+			go keyring.Run(ctx)
+		}
+
+		jwtAuthenticator.WithKeyring(keyring)
+	}
+
+	authz := policy.New(jwtAuthenticator)
 	promClient := prom.NewPrometheusService()
 	promClient.Register()
 	r.Use(promClient.RequestMetricMiddleware)
 
-	userRepo := store.NewUserRepo(db, logger)
-	postRepo := store.NewPostRepo(db, logger)
-	followRepo := store.NewFollowRepo(db, logger)
+	tokenRepo := store.NewTokenRepo(db, logger)
+	accountTokenRepo := store.NewAccountTokenRepo(db, logger)
+	timeline := store.NewTimeline(db, rdb, logger)
+	postRepo := store.NewPostRepo(db, timeline, logger)
+	followRepo := store.NewFollowRepo(db, timeline, logger)
 	likesRepo := store.NewLikesRepo(db, logger)
 	favoriteRepo := store.NewFavoriteRepo(db, logger)
+	commentRepo := store.NewCommentRepo(db, logger)
+	uploadRepo := store.NewUploadRepo(db, logger)
+	outboxRepo := store.NewOutboxRepo(db, logger)
+	notificationRepo := store.NewNotificationRepo(db, logger)
+
+	jobQueue := worker.NewQueue(rdb, "jobs")
 
 	userHandler := handlers.NewUserHandler(handlers.UserHandlerConfig{
-		UserRepo: userRepo,
-		JWTAuthenticator: jwtAuthenticator,
-		Redis: rdb,
-		Logger: logger,
+		UserRepo:             userRepo,
+		TokenRepo:            tokenRepo,
+		AccountTokenRepo:     accountTokenRepo,
+		JWTAuthenticator:     jwtAuthenticator,
+		RefreshTokenTTL:      cfg.JWT.RefreshTTL,
+		Queue:                jobQueue,
+		RequireVerifiedEmail: cfg.Account.RequireVerifiedEmail,
+		VerificationTTL:      cfg.Account.VerificationTTL,
+		ResendEvery:          cfg.Account.ResendEvery,
+		PasswordResetTTL:     cfg.Account.PasswordResetTTL,
+		PasswordResetEvery:   cfg.Account.PasswordResetEvery,
+		Redis:                rdb,
+		Logger:               logger,
 	})
 
-	posthandler := handlers.NewPostHandler(handlers.PostHandlerConfig{
-		PostRepo: postRepo,
-		Logger: logger,
+	// uploads are staged in a private store every replica can reach
+	var mediaStore, uploadStaging storage.MediaStore
+	switch cfg.Storage.Driver {
+	case "s3":
+		s3Config := storage.S3Config{
+			Endpoint:  cfg.Storage.S3.Endpoint,
+			Region:    cfg.Storage.S3.Region,
+			Bucket:    cfg.Storage.S3.Bucket,
+			AccessKey: cfg.Storage.S3.AccessKey,
+			SecretKey: cfg.Storage.S3.SecretKey,
+			PathStyle: cfg.Storage.S3.PathStyle,
+			PublicURL: cmp.Or(cfg.Storage.S3.PublicURL, cfg.Storage.BaseURL),
+		}
+		mediaStore, err = storage.NewS3Store(s3Config)
+		if err == nil {
+			s3Config.Bucket = cfg.Storage.S3.StagingBucket
+			s3Config.PublicURL = ""
+			uploadStaging, err = storage.NewS3Store(s3Config)
+		}
+	default:
+		mediaStore, err = storage.NewLocalStore(cfg.UploadDir, cfg.Storage.BaseURL)
+		if err == nil {
+			uploadStaging, err = storage.NewLocalStore(cfg.Uploads.StagingDir, "")
+		}
+	}
+	if err != nil {
+		log.Fatalf("Failed to create media store: %v", err)
+	}
+
+	var mediaSigner *urlsign.Signer
+	if cfg.Storage.SignURLs {
+		signerConfig := urlsign.SignerConfig{
+			TTL:         cfg.Storage.URLTTL,
+			RotateEvery: cfg.Storage.KeyRotateEvery,
+		}
+		signingKey, err := cfg.Storage.StaticSigningKey()
+		if err != nil {
+			log.Fatalf("Failed to read media signing key: %v", err)
+		}
+		if signingKey != "" {
+			mediaSigner, err = urlsign.NewStaticSigner(signerConfig, "static", []byte(signingKey))
+		} else if signerConfig.KEK, err = cfg.Keys.KEK(); err == nil {
+			mediaSigner, err = urlsign.NewSigner(ctx, rdb, signerConfig)
+		}
+		if err != nil {
+			log.Fatalf("Failed to create media url signer: %v", err)
+		}
+		go mediaSigner.Run(ctx)
+
+		mediaStore = storage.NewSignedStore(mediaStore, mediaSigner)
+	}
+
+	if err := os.MkdirAll(cfg.Video.WorkDir, 0o750); err != nil {
+		log.Fatalf("Failed to create video work dir: %v", err)
+	}
+
+	videoProcessor := video.NewProcessor(video.ProcessorConfig{
+		PostRepo:    postRepo,
+		UploadRepo:  uploadRepo,
+		MediaStore:  mediaStore,
+		Staging:     uploadStaging,
+		Transcoder:  video.NewTranscoder(cfg.Video.FFmpegPath, cfg.Video.FFprobePath),
+		WorkDir:     cfg.Video.WorkDir,
+		MaxDuration: cfg.Video.MaxDuration,
+		Logger:      logger,
+	})
+	mailTemplates, err := mail.NewTemplates()
+	if err != nil {
+		log.Fatalf("Failed to load mail templates: %v", err)
+	}
+	mailer, err := mail.NewSMTPMailer(mail.SMTPConfig{
+		Host:     cfg.Mail.SMTP.Host,
+		Port:     cfg.Mail.SMTP.Port,
+		Username: cfg.Mail.SMTP.Username,
+		Password: cfg.Mail.SMTP.Password,
+		TLS:      cfg.Mail.SMTP.TLS,
+		From:     cfg.Mail.From,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create mailer: %v", err)
+	}
+	mailSender := mail.NewSender(mail.SenderConfig{
+		Mailer:    mailer,
+		Templates: mailTemplates,
+		AppURL:    cfg.Mail.AppURL,
+		Logger:    logger,
+	})
+
+	workerPool := worker.NewPool(jobQueue, worker.PoolConfig{
+		Concurrency:       cfg.Worker.Concurrency,
+		PollInterval:      cfg.Worker.PollInterval,
+		VisibilityTimeout: cfg.Worker.VisibilityTimeout,
+		Backoff:           cfg.Worker.Backoff,
+		MaxBackoff:        cfg.Worker.MaxBackoff,
+	}, logger)
+	worker.Register(workerPool, videoProcessor.Process)
+	worker.Register(workerPool, mailSender.Process)
+
+	realtimeBroker := realtime.NewBroker(rdb, realtime.BrokerConfig{
+		History:    cfg.Realtime.History,
+		HistoryTTL: cfg.Realtime.HistoryTTL,
+	})
+
+	bus := events.NewBus(jobQueue, workerPool)
+	notifications.Subscribe(bus, notificationRepo, realtimeBroker)
+	realtime.Subscribe(bus, realtimeBroker, timeline, likesRepo)
+	workerPool.Start()
+
+	backgroundCtx, stopBackground := context.WithCancel(ctx)
+	relay := events.NewRelay(events.RelayConfig{
+		OutboxRepo:   outboxRepo,
+		Bus:          bus,
+		PollInterval: cfg.Outbox.PollInterval,
+		BatchSize:    cfg.Outbox.BatchSize,
+		Logger:       logger,
 	})
+	go relay.Run(backgroundCtx)
 
-	posthandler.Init()
+	realtimeHub := realtime.NewHub(realtimeBroker, logger)
+	go realtimeHub.Run(backgroundCtx)
+	// streams are closed on shutdown, clients reconnect to another instance
+	server.RegisterOnShutdown(realtimeHub.Close)
+
+	uploadHandler := handlers.NewUploadHandler(handlers.UploadHandlerConfig{
+		UploadRepo: uploadRepo,
+		Staging:    uploadStaging,
+		Logger:     logger,
+	})
+	go uploadHandler.RunCleanup(backgroundCtx, cfg.Uploads.CleanupEvery)
+	go userHandler.RunVerificationMailer(backgroundCtx, cfg.Account.VerificationMailEvery)
+
+	posthandler := handlers.NewPostHandler(handlers.PostHandlerConfig{
+		PostRepo:   postRepo,
+		UploadRepo: uploadRepo,
+		MediaStore: mediaStore,
+		Staging:    uploadStaging,
+		Queue:      jobQueue,
+		Logger:     logger,
+	})
 
 	followHandler := handlers.NewFollowHandler(handlers.FollowHandlerConfig{
 		FollowRepo: followRepo,
-		Redis: rdb,
-		JWTAuthenticator: jwtAuthenticator,
-		Logger: logger,
+		Redis:      rdb,
+		Logger:     logger,
 	})
 
 	likesHandler := handlers.NewLikesHandler(handlers.LikesHandlerConfig{
 		LikesRepo: likesRepo,
-		JWTAuthenticator: jwtAuthenticator,
-		Logger: logger,
+		Logger:    logger,
+	})
+
+	commentHandler := handlers.NewCommentHandler(handlers.CommentHandlerConfig{
+		CommentRepo: commentRepo,
+		Logger:      logger,
+	})
+
+	feedHandler := handlers.NewFeedHandler(handlers.FeedHandlerConfig{
+		PostRepo:   postRepo,
+		Timeline:   timeline,
+		MediaStore: mediaStore,
+		Logger:     logger,
 	})
 
 	favoriteHandler := handlers.NewFavoriteHandler(handlers.FavoriteHandlerConfig{
 		FavoriteRepo: favoriteRepo,
-		Logger: logger,
-		JWTAuthenticator: jwtAuthenticator,
+		MediaStore:   mediaStore,
+		Logger:       logger,
+	})
+
+	notificationHandler := handlers.NewNotificationHandler(handlers.NotificationHandlerConfig{
+		NotificationRepo: notificationRepo,
+		Logger:           logger,
+	})
+
+	streamTickets := policy.NewTickets(rdb)
+	realtimeHandler := handlers.NewRealtimeHandler(handlers.RealtimeHandlerConfig{
+		Hub:        realtimeHub,
+		Policy:     authz,
+		Tickets:    streamTickets,
+		PostRepo:   postRepo,
+		Origins:    cfg.SocketOrigins(),
+		Heartbeat:  cfg.Realtime.Heartbeat,
+		Revalidate: cfg.Realtime.RevalidateEvery,
+		Logger:     logger,
+	})
+
+	mediaHandler := handlers.NewMediaHandler(handlers.MediaHandlerConfig{
+		MediaStore: mediaStore,
+		Signer:     mediaSigner,
+		Logger:     logger,
 	})
-	
+
+	if keyring != nil {
+		r.Get("/.well-known/jwks.json", keyring.JWKSHandler)
+	}
+
+	mediaPath, err := url.Parse(cfg.Storage.BaseURL)
+	if err != nil {
+		log.Fatalf("Invalid media base url: %v", err)
+	}
+	r.Get(strings.TrimSuffix(mediaPath.Path, "/")+"/{key}", mediaHandler.ServeMedia)
+
 	r.Route("/api/v1", func(r chi.Router) {
-		
+
 		r.Get("/metrics", promClient.Handler())
 
 		r.Post("/login", userHandler.Authenticate)
-		
+		r.With(authz.Authenticate).Post("/logout", userHandler.Logout)
+		r.With(authz.Authenticate).Post("/logout/all", userHandler.LogoutAll)
+		r.Post("/token/refresh", userHandler.RefreshToken)
+		r.Post("/password/forgot", userHandler.ForgotPassword)
+		r.Post("/password/reset", userHandler.ResetPassword)
+
 		r.Route("/users", func(r chi.Router) {
 			r.Post("/", userHandler.CreateUser)
+			r.Post("/verify", userHandler.VerifyEmail)
 
 			r.Group(func(r chi.Router) {
-				r.Use(jwtAuthenticator.JWTMiddleware)
+				r.Use(authz.Authenticate)
+				r.Post("/verify/resend", userHandler.ResendVerification)
 				r.Get("/logged", userHandler.GetUser)
+				r.Put("/password", userHandler.ChangePassword)
+				r.Get("/{id}/posts", posthandler.GetUserPosts)
 				r.Put("/{id}", userHandler.UpdateUser)
 				r.Delete("/{id}", userHandler.DeleteUser)
 			})
 		})
 
 		r.Route("/posts", func(r chi.Router) {
-			r.Use(jwtAuthenticator.JWTMiddleware)
-			r.Post("/", posthandler.CreatePost)
+			r.Use(authz.Authenticate)
+			r.With(userHandler.RequireVerifiedEmail).Post("/", posthandler.CreatePost)
+			r.Get("/{id}", posthandler.GetPost)
 			r.Put("/{id}", posthandler.UpdatePost)
 			r.Delete("/{id}", posthandler.DeletePost)
+
+			r.Route("/{id}/comments", func(r chi.Router) {
+				r.With(userHandler.RequireVerifiedEmail).Post("/", commentHandler.CreateComment)
+				r.Get("/", commentHandler.GetComments)
+				r.Get("/{commentId}", commentHandler.GetThread)
+				r.Put("/{commentId}", commentHandler.UpdateComment)
+				r.Delete("/{commentId}", commentHandler.DeleteComment)
+			})
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(authz.Authenticate)
+			r.Get("/feed", feedHandler.GetFeed)
+		})
+
+		r.Route("/uploads", func(r chi.Router) {
+			r.Use(uploadHandler.Tus)
+			r.Options("/", uploadHandler.Options)
+
+			r.Group(func(r chi.Router) {
+				r.Use(authz.Authenticate)
+				r.With(userHandler.RequireVerifiedEmail).Post("/", uploadHandler.CreateUpload)
+				r.Head("/{id}", uploadHandler.HeadUpload)
+				r.Patch("/{id}", uploadHandler.PatchUpload)
+				r.Get("/{id}", uploadHandler.GetUpload)
+				r.Delete("/{id}", uploadHandler.DeleteUpload)
+				r.Post("/{id}/finalize", uploadHandler.FinalizeUpload)
+			})
 		})
 
 		r.Route("/follows", func(r chi.Router) {
-			r.Use(jwtAuthenticator.JWTMiddleware)
+			r.Use(authz.Authenticate)
 			r.Post("/", followHandler.Follow)
 			r.Get("/followers", followHandler.GetFollowers)
 			r.Get("/following", followHandler.GetFollowing)
@@ -142,21 +439,36 @@ func main() {
 		})
 
 		r.Route("/likes", func(r chi.Router) {
-			r.Use(jwtAuthenticator.JWTMiddleware)
+			r.Use(authz.Authenticate)
 			r.Post("/{postId}", likesHandler.Like)
 			r.Get("/{postId}", likesHandler.GetPostLikes)
 			r.Delete("/{likesId}", likesHandler.Unlike)
 		})
 
 		r.Route("/favorites", func(r chi.Router) {
-			r.Use(jwtAuthenticator.JWTMiddleware)
+			r.Use(authz.Authenticate)
 			r.Post("/{postId}", favoriteHandler.AddFavorite)
 			r.Get("/", favoriteHandler.GetFavouritePost)
 			r.Delete("/{postId}", favoriteHandler.DeleteFavorite)
 		})
+
+		r.Route("/notifications", func(r chi.Router) {
+			r.Use(authz.Authenticate)
+			r.Get("/", notificationHandler.GetNotifications)
+			r.Get("/unread_count", notificationHandler.GetUnreadCount)
+			r.Post("/read", notificationHandler.MarkRead)
+		})
+
+		r.Route("/realtime", func(r chi.Router) {
+			r.With(authz.Authenticate).Post("/ticket", realtimeHandler.CreateTicket)
+			r.Group(func(r chi.Router) {
+				r.Use(authz.AuthenticateTicket(streamTickets))
+				r.Get("/events", realtimeHandler.Events)
+				r.Get("/ws", realtimeHandler.Socket)
+			})
+		})
 	})
 
-	
 	closed := make(chan struct{})
 
 	// gracefully shutdown
@@ -166,12 +478,20 @@ func main() {
 		signal := <-sigint
 
 		log.Printf("Received %s signal, shutting down server", signal.String())
-		ctx, cancel := context.WithTimeout(ctx, 5 * time.Second)
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 		defer cancel()
 
 		if err := server.Shutdown(ctx); err != nil {
 			log.Fatalf("Server forced to shutdown: %v", err)
 		}
+		stopBackground()
+
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Worker.DrainTimeout)
+		defer cancelDrain()
+		// jobs still running when the drain times out are put back in the queue
+		if err := workerPool.Shutdown(drainCtx); err != nil {
+			log.Printf("Workers interrupted: %v", err)
+		}
 		close(closed)
 	}()
 
@@ -182,4 +502,4 @@ func main() {
 
 	<-closed
 	log.Println("Server shutdown gracefully")
-}
\ No newline at end of file
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_followers_followers_id;
+DROP INDEX IF EXISTS idx_posts_user_id_id;
\ No newline at end of file
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_posts_user_id_id ON posts (user_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_followers_followers_id ON followers (followers_id);
\ No newline at end of file
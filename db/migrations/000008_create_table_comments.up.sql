@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS comments (
+  id UUID PRIMARY KEY,
+  post_id UUID NOT NULL,
+  user_id UUID NOT NULL,
+  parent_id UUID NULL,
+  body TEXT NOT NULL,
+  deleted_at timestamp(0) WITH TIME ZONE NULL,
+  created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_comments_post
+    FOREIGN KEY(post_id)
+      REFERENCES posts(id)
+      ON DELETE CASCADE,
+  CONSTRAINT fk_comments_user
+    FOREIGN KEY(user_id)
+      REFERENCES users(id),
+  CONSTRAINT fk_comments_parent
+    FOREIGN KEY(parent_id)
+      REFERENCES comments(id)
+      ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_comments_post_id_id ON comments (post_id, id);
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments (parent_id);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON comments
+FOR EACH ROW
+EXECUTE FUNCTION trigger_update_timestamp();
\ No newline at end of file
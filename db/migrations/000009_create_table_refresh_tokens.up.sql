@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id UUID PRIMARY KEY,
+  user_id UUID NOT NULL,
+  family_id UUID NOT NULL,
+  token_hash bytea UNIQUE NOT NULL,
+  replaced_by UUID NULL,
+  expires_at timestamp(0) WITH TIME ZONE NOT NULL,
+  revoked_at timestamp(0) WITH TIME ZONE NULL,
+  created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_refresh_tokens_user
+    FOREIGN KEY(user_id)
+      REFERENCES users(id)
+      ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
\ No newline at end of file
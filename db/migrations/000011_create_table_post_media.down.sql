@@ -0,0 +1,8 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS media TEXT DEFAULT NULL;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS media_variants JSONB NOT NULL DEFAULT '[]';
+
+UPDATE posts p SET media = pm.media_key, media_variants = pm.variants
+FROM post_media pm
+WHERE pm.post_id = p.id AND pm.position = 0;
+
+DROP TABLE IF EXISTS post_media;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS post_media (
+  id UUID PRIMARY KEY,
+  post_id UUID NOT NULL,
+  position SMALLINT NOT NULL,
+  media_key TEXT NOT NULL,
+  alt_text TEXT NOT NULL DEFAULT '',
+  width INT NOT NULL DEFAULT 0,
+  height INT NOT NULL DEFAULT 0,
+  variants JSONB NOT NULL DEFAULT '[]',
+  created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_post_media_post
+    FOREIGN KEY(post_id)
+      REFERENCES posts(id)
+      ON DELETE CASCADE,
+  CONSTRAINT uq_post_media_position
+    UNIQUE (post_id, position),
+  CONSTRAINT chk_post_media_position
+    CHECK (position >= 0 AND position < 10)
+);
+
+INSERT INTO post_media (id, post_id, position, media_key, width, height, variants)
+SELECT
+  gen_random_uuid(),
+  p.id,
+  0,
+  p.media,
+  COALESCE((SELECT (v->>'width')::int FROM jsonb_array_elements(p.media_variants) v WHERE v->>'name' = 'full'), 0),
+  COALESCE((SELECT (v->>'height')::int FROM jsonb_array_elements(p.media_variants) v WHERE v->>'name' = 'full'), 0),
+  p.media_variants
+FROM posts p
+WHERE p.media IS NOT NULL AND p.media <> '';
+
+ALTER TABLE posts DROP COLUMN IF EXISTS media_variants;
+ALTER TABLE posts DROP COLUMN IF EXISTS media;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS uploads;
+
+ALTER TABLE post_media DROP COLUMN IF EXISTS duration_ms;
+ALTER TABLE post_media DROP COLUMN IF EXISTS kind;
+
+ALTER TABLE posts DROP COLUMN IF EXISTS processing_error;
+ALTER TABLE posts DROP COLUMN IF EXISTS status;
@@ -0,0 +1,34 @@
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'ready';
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS processing_error TEXT NULL;
+
+ALTER TABLE post_media ADD COLUMN IF NOT EXISTS kind VARCHAR(16) NOT NULL DEFAULT 'image';
+ALTER TABLE post_media ADD COLUMN IF NOT EXISTS duration_ms INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS uploads (
+  id UUID PRIMARY KEY,
+  user_id UUID NOT NULL,
+  post_id UUID NULL,
+  filename TEXT NOT NULL DEFAULT '',
+  size BIGINT NOT NULL,
+  received BIGINT NOT NULL DEFAULT 0,
+  expires_at timestamp(0) WITH TIME ZONE NOT NULL,
+  created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_uploads_user
+    FOREIGN KEY(user_id)
+      REFERENCES users(id)
+      ON DELETE CASCADE,
+  CONSTRAINT fk_uploads_post
+    FOREIGN KEY(post_id)
+      REFERENCES posts(id)
+      ON DELETE CASCADE,
+  CONSTRAINT chk_uploads_received
+    CHECK (received >= 0 AND received <= size)
+);
+
+CREATE INDEX IF NOT EXISTS idx_uploads_post_id ON uploads (post_id) WHERE post_id IS NOT NULL;
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON uploads
+FOR EACH ROW
+EXECUTE FUNCTION trigger_update_timestamp();
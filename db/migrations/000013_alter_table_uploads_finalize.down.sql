@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS upload_chunks;
+DROP INDEX IF EXISTS idx_uploads_expires_at;
+
+ALTER TABLE uploads DROP COLUMN IF EXISTS finalized_at;
+ALTER TABLE uploads DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,19 @@
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS kind VARCHAR(16) NULL;
+ALTER TABLE uploads ADD COLUMN IF NOT EXISTS finalized_at timestamp(0) WITH TIME ZONE NULL;
+
+CREATE INDEX IF NOT EXISTS idx_uploads_expires_at ON uploads (expires_at) WHERE post_id IS NULL;
+
+CREATE TABLE IF NOT EXISTS upload_chunks (
+  upload_id UUID NOT NULL,
+  start_offset BIGINT NOT NULL,
+  size BIGINT NOT NULL,
+  key TEXT NOT NULL,
+  created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (upload_id, start_offset),
+  CONSTRAINT fk_upload_chunks_upload
+    FOREIGN KEY(upload_id)
+      REFERENCES uploads(id)
+      ON DELETE CASCADE,
+  CONSTRAINT chk_upload_chunks_size
+    CHECK (start_offset >= 0 AND size > 0)
+);
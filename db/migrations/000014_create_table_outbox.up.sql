@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS outbox (
+  id UUID PRIMARY KEY,
+  aggregate_type VARCHAR(32) NOT NULL,
+  aggregate_id UUID NOT NULL,
+  event_type VARCHAR(64) NOT NULL,
+  payload JSONB NOT NULL,
+  created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  dispatched_at timestamp(0) WITH TIME ZONE NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (id) WHERE dispatched_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_dispatched_at ON outbox (dispatched_at) WHERE dispatched_at IS NOT NULL;
@@ -0,0 +1,51 @@
+CREATE TABLE IF NOT EXISTS notifications (
+  id UUID PRIMARY KEY,
+  user_id UUID NOT NULL,
+  type VARCHAR(32) NOT NULL,
+  post_id UUID NULL,
+  comment_id UUID NULL,
+  group_key TEXT NOT NULL,
+  last_event_id UUID NOT NULL,
+  read_at timestamp(0) WITH TIME ZONE NULL,
+  created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_notifications_user
+    FOREIGN KEY(user_id)
+      REFERENCES users(id)
+      ON DELETE CASCADE,
+  CONSTRAINT fk_notifications_post
+    FOREIGN KEY(post_id)
+      REFERENCES posts(id)
+      ON DELETE CASCADE,
+  CONSTRAINT fk_notifications_comment
+    FOREIGN KEY(comment_id)
+      REFERENCES comments(id)
+      ON DELETE CASCADE
+);
+
+-- activity is aggregated into the unread notification of its group
+CREATE UNIQUE INDEX IF NOT EXISTS uq_notifications_unread_group ON notifications (user_id, group_key) WHERE read_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_notifications_user ON notifications (user_id, last_event_id DESC);
+
+CREATE TRIGGER set_timestamp
+BEFORE UPDATE ON notifications
+FOR EACH ROW
+EXECUTE FUNCTION trigger_update_timestamp();
+
+CREATE TABLE IF NOT EXISTS notification_actors (
+  notification_id UUID NOT NULL,
+  actor_id UUID NOT NULL,
+  event_id UUID NOT NULL,
+  created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (notification_id, actor_id),
+  CONSTRAINT fk_notification_actors_notification
+    FOREIGN KEY(notification_id)
+      REFERENCES notifications(id)
+      ON DELETE CASCADE,
+  CONSTRAINT fk_notification_actors_actor
+    FOREIGN KEY(actor_id)
+      REFERENCES users(id)
+      ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_actors_event ON notification_actors (event_id);
@@ -0,0 +1,21 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at timestamp(0) WITH TIME ZONE NULL;
+
+-- accounts created before verification existed start out unverified, they
+-- are mailed a verification link by the app
+
+CREATE TABLE IF NOT EXISTS account_tokens (
+  id UUID PRIMARY KEY,
+  user_id UUID NOT NULL,
+  purpose VARCHAR(32) NOT NULL,
+  token_hash bytea UNIQUE NOT NULL,
+  -- the address the token was sent to, it is void once the email changes
+  email citext NOT NULL,
+  expires_at timestamp(0) WITH TIME ZONE NOT NULL,
+  created_at timestamp(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  CONSTRAINT fk_account_tokens_user
+    FOREIGN KEY(user_id)
+      REFERENCES users(id)
+      ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_tokens_user_id ON account_tokens (user_id, purpose);
@@ -0,0 +1,7 @@
+-- kept by the follow and unfollow transactions so the timeline can tell
+-- celebrity accounts apart without counting followers on every read
+ALTER TABLE users ADD COLUMN IF NOT EXISTS followers_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE users u SET followers_count = (
+  SELECT COUNT(*) FROM followers f WHERE f.followee_id = u.id
+);
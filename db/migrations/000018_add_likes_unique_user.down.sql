@@ -0,0 +1 @@
+ALTER TABLE likes DROP CONSTRAINT IF EXISTS uq_likes_post_user;
@@ -0,0 +1,5 @@
+-- a user likes a post once, the oldest of duplicated likes is kept
+DELETE FROM likes l USING likes d
+WHERE l.post_id = d.post_id AND l.user_id = d.user_id AND l.id > d.id;
+
+ALTER TABLE likes ADD CONSTRAINT uq_likes_post_user UNIQUE (post_id, user_id);
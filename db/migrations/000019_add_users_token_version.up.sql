@@ -0,0 +1,4 @@
+-- the version access tokens of the user are issued with, redis only caches
+-- it. Versions that were only kept in redis are carried over on the next
+-- revocation of the user.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version BIGINT NOT NULL DEFAULT 0;
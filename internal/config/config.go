@@ -0,0 +1,689 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvTest        = "test"
+	EnvProduction  = "production"
+)
+
+type HTTPConfig struct {
+	Addr         string        `yaml:"addr"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+}
+
+type DBConfig struct {
+	Username        string        `yaml:"username"`
+	Password        string        `yaml:"password"`
+	Host            string        `yaml:"host"`
+	Port            string        `yaml:"port"`
+	Name            string        `yaml:"name"`
+	MaxOpenConn     int           `yaml:"max_open_conn"`
+	MaxIdleConn     int           `yaml:"max_idle_conn"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdletime time.Duration `yaml:"max_conn_idletime"`
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+type JWTConfig struct {
+	// Algorithm is HS256 to sign with Secret, or RS256/EdDSA to sign with
+	// the key in PrivateKeyFile or, without one, with keys rotating every
+	// RotateEvery that the replicas share through redis.
+	Algorithm      string        `yaml:"algorithm"`
+	Secret         string        `yaml:"secret"`
+	PrivateKeyFile string        `yaml:"private_key_file"`
+	AccessTTL      time.Duration `yaml:"access_ttl"`
+	RefreshTTL     time.Duration `yaml:"refresh_ttl"`
+	RotateEvery    time.Duration `yaml:"rotate_every"`
+}
+
+// KeysConfig holds the key-encryption key the rotating signing keys are
+// encrypted with in redis, the same on every replica. It is the base64 of 32
+// random bytes, given as EncryptionKey or in EncryptionKeyFile.
+type KeysConfig struct {
+	EncryptionKey     string `yaml:"encryption_key"`
+	EncryptionKeyFile string `yaml:"encryption_key_file"`
+}
+
+// KEK decodes the key-encryption key.
+func (c KeysConfig) KEK() ([]byte, error) {
+	encoded := c.EncryptionKey
+	if c.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(c.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("KEYS_ENCRYPTION_KEY_FILE: %w", err)
+		}
+		encoded = string(data)
+	}
+	if strings.TrimSpace(encoded) == "" {
+		return nil, errors.New("KEYS_ENCRYPTION_KEY or KEYS_ENCRYPTION_KEY_FILE is required to keep rotating keys in redis")
+	}
+
+	kek, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(kek) != 32 {
+		return nil, errors.New("KEYS_ENCRYPTION_KEY must be the base64 of 32 bytes")
+	}
+	return kek, nil
+}
+
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+	PathStyle bool   `yaml:"path_style"`
+	PublicURL string `yaml:"public_url"`
+	// StagingBucket keeps the chunks of uploads, a private bucket other
+	// than Bucket.
+	StagingBucket string `yaml:"staging_bucket"`
+}
+
+type StorageConfig struct {
+	// Driver is local to keep media in UploadDir or s3 to keep it in a
+	// bucket of an S3 compatible service.
+	Driver  string   `yaml:"driver"`
+	BaseURL string   `yaml:"base_url"`
+	S3      S3Config `yaml:"s3"`
+	// SignURLs makes media URLs expire after URLTTL. They are signed with
+	// SigningKey or the key in SigningKeyFile, the same on every replica, or
+	// without one with keys rotating every KeyRotateEvery that the replicas
+	// share through redis, encrypted with the key-encryption key.
+	SignURLs       bool          `yaml:"sign_urls"`
+	URLTTL         time.Duration `yaml:"url_ttl"`
+	SigningKey     string        `yaml:"signing_key"`
+	SigningKeyFile string        `yaml:"signing_key_file"`
+	KeyRotateEvery time.Duration `yaml:"key_rotate_every"`
+}
+
+// StaticSigningKey returns the configured media signing key, empty when the
+// keys rotate.
+func (c StorageConfig) StaticSigningKey() (string, error) {
+	if c.SigningKeyFile == "" {
+		return c.SigningKey, nil
+	}
+	data, err := os.ReadFile(c.SigningKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("MEDIA_SIGNING_KEY_FILE: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// UploadsConfig is about resumable uploads. Their chunks are staged in a
+// store of their own until attached to a post, the S3 staging bucket or
+// StagingDir with the local driver, so any replica can resume an upload or
+// process it and chunks are never served.
+type UploadsConfig struct {
+	StagingDir string `yaml:"staging_dir"`
+	// CleanupEvery is how often abandoned uploads are deleted.
+	CleanupEvery time.Duration `yaml:"cleanup_every"`
+}
+
+type VideoConfig struct {
+	FFmpegPath  string        `yaml:"ffmpeg_path"`
+	FFprobePath string        `yaml:"ffprobe_path"`
+	MaxDuration time.Duration `yaml:"max_duration"`
+	// WorkDir is the local scratch directory videos are downloaded to and
+	// transcoded in, nothing is kept there after a job.
+	WorkDir string `yaml:"work_dir"`
+}
+
+type WorkerConfig struct {
+	Concurrency       int           `yaml:"concurrency"`
+	PollInterval      time.Duration `yaml:"poll_interval"`
+	VisibilityTimeout time.Duration `yaml:"visibility_timeout"`
+	Backoff           time.Duration `yaml:"backoff"`
+	MaxBackoff        time.Duration `yaml:"max_backoff"`
+	// DrainTimeout is how long a shutdown waits for running jobs before
+	// interrupting them and putting them back in the queue.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+type OutboxConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size"`
+}
+
+type RealtimeConfig struct {
+	// Heartbeat is how often idle realtime connections are written to.
+	Heartbeat time.Duration `yaml:"heartbeat"`
+	// History is about how many messages are kept per user for clients
+	// reconnecting with their last event id, for up to HistoryTTL.
+	History    int           `yaml:"history"`
+	HistoryTTL time.Duration `yaml:"history_ttl"`
+	// RevalidateEvery is how often open connections check that the token
+	// they were opened with was not revoked since.
+	RevalidateEvery time.Duration `yaml:"revalidate_every"`
+	// AllowedOrigins are the origins browsers may open WebSockets from,
+	// the origin of the app URL when empty.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// SocketOrigins returns the origins browsers may open WebSockets from.
+func (c *Config) SocketOrigins() []string {
+	if len(c.Realtime.AllowedOrigins) > 0 {
+		return c.Realtime.AllowedOrigins
+	}
+	u, err := url.Parse(c.Mail.AppURL)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	return []string{u.Scheme + "://" + u.Host}
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// TLS is starttls, tls for implicit TLS or none for local stand-ins
+	// like MailHog.
+	TLS string `yaml:"tls"`
+}
+
+type MailConfig struct {
+	SMTP SMTPConfig `yaml:"smtp"`
+	From string     `yaml:"from"`
+	// AppURL is the address of the app linked from mails.
+	AppURL string `yaml:"app_url"`
+}
+
+type AccountConfig struct {
+	// RequireVerifiedEmail keeps accounts from posting, commenting and
+	// uploading until their email address is verified.
+	RequireVerifiedEmail bool          `yaml:"require_verified_email"`
+	VerificationTTL      time.Duration `yaml:"verification_ttl"`
+	// ResendEvery is how often a user may ask for the verification mail.
+	ResendEvery time.Duration `yaml:"resend_every"`
+	// VerificationMailEvery is how often unverified accounts that were
+	// never given a link, like those created before addresses were
+	// verified, are mailed one.
+	VerificationMailEvery time.Duration `yaml:"verification_mail_every"`
+	PasswordResetTTL      time.Duration `yaml:"password_reset_ttl"`
+	// PasswordResetEvery is how often a reset mail may be asked for per
+	// address.
+	PasswordResetEvery time.Duration `yaml:"password_reset_every"`
+}
+
+type Config struct {
+	Env       string         `yaml:"env"`
+	HTTP      HTTPConfig     `yaml:"http"`
+	DB        DBConfig       `yaml:"db"`
+	Redis     RedisConfig    `yaml:"redis"`
+	JWT       JWTConfig      `yaml:"jwt"`
+	Keys      KeysConfig     `yaml:"keys"`
+	Storage   StorageConfig  `yaml:"storage"`
+	Uploads   UploadsConfig  `yaml:"uploads"`
+	Video     VideoConfig    `yaml:"video"`
+	Worker    WorkerConfig   `yaml:"worker"`
+	Outbox    OutboxConfig   `yaml:"outbox"`
+	Realtime  RealtimeConfig `yaml:"realtime"`
+	Mail      MailConfig     `yaml:"mail"`
+	Account   AccountConfig  `yaml:"account"`
+	UploadDir string         `yaml:"upload_dir"`
+}
+
+// defaults returns the base configuration of a profile. Only development
+// and test come with credentials, production must provide them.
+func defaults(env string) Config {
+	cfg := Config{
+		Env: env,
+		HTTP: HTTPConfig{
+			Addr:         ":6969",
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+			IdleTimeout:  time.Minute,
+		},
+		DB: DBConfig{
+			Port:            "5432",
+			MaxOpenConn:     30,
+			MaxIdleConn:     30,
+			MaxConnLifetime: 15 * time.Minute,
+			MaxConnIdletime: 15 * time.Minute,
+		},
+		JWT: JWTConfig{
+			Algorithm:   "EdDSA",
+			AccessTTL:   15 * time.Minute,
+			RefreshTTL:  30 * 24 * time.Hour,
+			RotateEvery: 24 * time.Hour,
+		},
+		Storage: StorageConfig{
+			Driver:  "local",
+			BaseURL: "/media",
+			S3: S3Config{
+				Region: "us-east-1",
+			},
+			SignURLs:       true,
+			URLTTL:         time.Hour,
+			KeyRotateEvery: 24 * time.Hour,
+		},
+		Uploads: UploadsConfig{
+			StagingDir:   "./uploads-staging",
+			CleanupEvery: time.Hour,
+		},
+		Video: VideoConfig{
+			FFmpegPath:  "ffmpeg",
+			FFprobePath: "ffprobe",
+			MaxDuration: 3 * time.Minute,
+			WorkDir:     os.TempDir(),
+		},
+		Worker: WorkerConfig{
+			Concurrency:       4,
+			PollInterval:      time.Second,
+			VisibilityTimeout: 5 * time.Minute,
+			Backoff:           5 * time.Second,
+			MaxBackoff:        10 * time.Minute,
+			DrainTimeout:      30 * time.Second,
+		},
+		Outbox: OutboxConfig{
+			PollInterval: time.Second,
+			BatchSize:    100,
+		},
+		Realtime: RealtimeConfig{
+			Heartbeat:       25 * time.Second,
+			History:         200,
+			HistoryTTL:      24 * time.Hour,
+			RevalidateEvery: 30 * time.Second,
+		},
+		Account: AccountConfig{
+			VerificationTTL:       48 * time.Hour,
+			ResendEvery:           time.Minute,
+			VerificationMailEvery: 10 * time.Minute,
+			PasswordResetTTL:      time.Hour,
+			PasswordResetEvery:    time.Minute,
+		},
+		Mail: MailConfig{
+			SMTP: SMTPConfig{
+				Port: 587,
+				TLS:  "starttls",
+			},
+		},
+		UploadDir: "./uploads",
+	}
+
+	if env != EnvProduction {
+		cfg.DB.Username = "admin"
+		cfg.DB.Password = "adminsecret"
+		cfg.DB.Host = "localhost"
+		cfg.DB.Name = "social"
+		cfg.Redis.Addr = "localhost:6379"
+		cfg.JWT.Secret = "mysecret"
+		cfg.Keys.EncryptionKey = "ZGV2ZWxvcG1lbnQga2V5IGVuY3J5cHRpb24ga2V5ISE="
+		cfg.Mail.SMTP.Host = "localhost"
+		cfg.Mail.SMTP.Port = 1025
+		cfg.Mail.SMTP.TLS = "none"
+		cfg.Mail.From = "Social <no-reply@social.local>"
+		cfg.Mail.AppURL = "http://localhost:6969"
+	}
+
+	return cfg
+}
+
+// Load builds the configuration of the APP_ENV profile (development by
+// default). Values come from the profile defaults, then the YAML file named
+// by CONFIG_FILE, then environment variables, which may also be set in a
+// .env file in the working directory.
+func Load() (*Config, error) {
+	if err := loadDotEnv(".env"); err != nil {
+		return nil, err
+	}
+
+	env := os.Getenv("APP_ENV")
+	if env == "" {
+		env = EnvDevelopment
+	}
+	if env != EnvDevelopment && env != EnvTest && env != EnvProduction {
+		return nil, fmt.Errorf("config: APP_ENV must be one of %s, %s or %s, got %q", EnvDevelopment, EnvTest, EnvProduction, env)
+	}
+
+	cfg := defaults(env)
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return nil, fmt.Errorf("config: %s: %w", path, err)
+		}
+		cfg.Env = env
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	var errs []error
+
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	num := func(key string, dst *int) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be an integer, got %q", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be true or false, got %q", key, v))
+				return
+			}
+			*dst = b
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = nil
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+	dur := func(key string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a duration like 15m, got %q", key, v))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	str("HTTP_ADDR", &c.HTTP.Addr)
+	dur("HTTP_READ_TIMEOUT", &c.HTTP.ReadTimeout)
+	dur("HTTP_WRITE_TIMEOUT", &c.HTTP.WriteTimeout)
+	dur("HTTP_IDLE_TIMEOUT", &c.HTTP.IdleTimeout)
+
+	str("DB_USERNAME", &c.DB.Username)
+	str("DB_PASSWORD", &c.DB.Password)
+	str("DB_HOST", &c.DB.Host)
+	str("DB_PORT", &c.DB.Port)
+	str("DB_NAME", &c.DB.Name)
+	num("DB_MAX_OPEN_CONN", &c.DB.MaxOpenConn)
+	num("DB_MAX_IDLE_CONN", &c.DB.MaxIdleConn)
+	dur("DB_MAX_CONN_LIFETIME", &c.DB.MaxConnLifetime)
+	dur("DB_MAX_CONN_IDLETIME", &c.DB.MaxConnIdletime)
+
+	str("REDIS_ADDR", &c.Redis.Addr)
+	str("REDIS_PASSWORD", &c.Redis.Password)
+	num("REDIS_DB", &c.Redis.DB)
+
+	str("JWT_ALGORITHM", &c.JWT.Algorithm)
+	str("JWT_SECRET", &c.JWT.Secret)
+	str("JWT_PRIVATE_KEY_FILE", &c.JWT.PrivateKeyFile)
+	dur("JWT_ACCESS_TTL", &c.JWT.AccessTTL)
+	dur("JWT_REFRESH_TTL", &c.JWT.RefreshTTL)
+	dur("JWT_ROTATE_EVERY", &c.JWT.RotateEvery)
+
+	str("KEYS_ENCRYPTION_KEY", &c.Keys.EncryptionKey)
+	str("KEYS_ENCRYPTION_KEY_FILE", &c.Keys.EncryptionKeyFile)
+
+	str("STORAGE_DRIVER", &c.Storage.Driver)
+	str("MEDIA_BASE_URL", &c.Storage.BaseURL)
+	str("S3_ENDPOINT", &c.Storage.S3.Endpoint)
+	str("S3_REGION", &c.Storage.S3.Region)
+	str("S3_BUCKET", &c.Storage.S3.Bucket)
+	str("S3_ACCESS_KEY", &c.Storage.S3.AccessKey)
+	str("S3_SECRET_KEY", &c.Storage.S3.SecretKey)
+	boolean("S3_PATH_STYLE", &c.Storage.S3.PathStyle)
+	str("S3_PUBLIC_URL", &c.Storage.S3.PublicURL)
+	str("S3_STAGING_BUCKET", &c.Storage.S3.StagingBucket)
+	boolean("MEDIA_SIGNED_URLS", &c.Storage.SignURLs)
+	dur("MEDIA_URL_TTL", &c.Storage.URLTTL)
+	str("MEDIA_SIGNING_KEY", &c.Storage.SigningKey)
+	str("MEDIA_SIGNING_KEY_FILE", &c.Storage.SigningKeyFile)
+	dur("MEDIA_KEY_ROTATE_EVERY", &c.Storage.KeyRotateEvery)
+
+	str("UPLOAD_STAGING_DIR", &c.Uploads.StagingDir)
+	dur("UPLOAD_CLEANUP_EVERY", &c.Uploads.CleanupEvery)
+	str("FFMPEG_PATH", &c.Video.FFmpegPath)
+	str("FFPROBE_PATH", &c.Video.FFprobePath)
+	dur("MAX_VIDEO_DURATION", &c.Video.MaxDuration)
+	str("VIDEO_WORK_DIR", &c.Video.WorkDir)
+
+	num("WORKER_CONCURRENCY", &c.Worker.Concurrency)
+	dur("WORKER_POLL_INTERVAL", &c.Worker.PollInterval)
+	dur("WORKER_VISIBILITY_TIMEOUT", &c.Worker.VisibilityTimeout)
+	dur("WORKER_BACKOFF", &c.Worker.Backoff)
+	dur("WORKER_MAX_BACKOFF", &c.Worker.MaxBackoff)
+	dur("WORKER_DRAIN_TIMEOUT", &c.Worker.DrainTimeout)
+
+	dur("OUTBOX_POLL_INTERVAL", &c.Outbox.PollInterval)
+	num("OUTBOX_BATCH_SIZE", &c.Outbox.BatchSize)
+
+	dur("REALTIME_HEARTBEAT", &c.Realtime.Heartbeat)
+	num("REALTIME_HISTORY", &c.Realtime.History)
+	dur("REALTIME_HISTORY_TTL", &c.Realtime.HistoryTTL)
+	dur("REALTIME_REVALIDATE_EVERY", &c.Realtime.RevalidateEvery)
+	list("REALTIME_ALLOWED_ORIGINS", &c.Realtime.AllowedOrigins)
+
+	str("SMTP_HOST", &c.Mail.SMTP.Host)
+	num("SMTP_PORT", &c.Mail.SMTP.Port)
+	str("SMTP_USERNAME", &c.Mail.SMTP.Username)
+	str("SMTP_PASSWORD", &c.Mail.SMTP.Password)
+	str("SMTP_TLS", &c.Mail.SMTP.TLS)
+	str("MAIL_FROM", &c.Mail.From)
+	str("APP_URL", &c.Mail.AppURL)
+
+	boolean("REQUIRE_VERIFIED_EMAIL", &c.Account.RequireVerifiedEmail)
+	dur("EMAIL_VERIFICATION_TTL", &c.Account.VerificationTTL)
+	dur("EMAIL_VERIFICATION_RESEND_EVERY", &c.Account.ResendEvery)
+	dur("EMAIL_VERIFICATION_MAIL_EVERY", &c.Account.VerificationMailEvery)
+	dur("PASSWORD_RESET_TTL", &c.Account.PasswordResetTTL)
+	dur("PASSWORD_RESET_EVERY", &c.Account.PasswordResetEvery)
+
+	str("UPLOAD_DIR", &c.UploadDir)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Validate reports every missing or invalid value at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	required := func(name, value string) {
+		if strings.TrimSpace(value) == "" {
+			errs = append(errs, fmt.Errorf("%s is required", name))
+		}
+	}
+	positive := func(name string, value int64) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be greater than zero", name))
+		}
+	}
+
+	required("HTTP_ADDR", c.HTTP.Addr)
+	required("DB_USERNAME", c.DB.Username)
+	required("DB_PASSWORD", c.DB.Password)
+	required("DB_HOST", c.DB.Host)
+	required("DB_PORT", c.DB.Port)
+	required("DB_NAME", c.DB.Name)
+	positive("DB_MAX_OPEN_CONN", int64(c.DB.MaxOpenConn))
+	positive("DB_MAX_IDLE_CONN", int64(c.DB.MaxIdleConn))
+	required("REDIS_ADDR", c.Redis.Addr)
+	positive("JWT_ACCESS_TTL", int64(c.JWT.AccessTTL))
+	positive("JWT_REFRESH_TTL", int64(c.JWT.RefreshTTL))
+
+	switch c.JWT.Algorithm {
+	case "HS256":
+		required("JWT_SECRET", c.JWT.Secret)
+		if c.Env == EnvProduction && len(c.JWT.Secret) < 32 {
+			errs = append(errs, errors.New("JWT_SECRET must be at least 32 characters in production"))
+		}
+	case "RS256", "EdDSA":
+		// without a key file the keys rotate in redis, encrypted
+		if c.JWT.PrivateKeyFile == "" {
+			if _, err := c.Keys.KEK(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("JWT_ALGORITHM must be HS256, RS256 or EdDSA, got %q", c.JWT.Algorithm))
+	}
+
+	required("MEDIA_BASE_URL", c.Storage.BaseURL)
+	if c.Storage.SignURLs {
+		positive("MEDIA_URL_TTL", int64(c.Storage.URLTTL))
+		key, err := c.Storage.StaticSigningKey()
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case key == "":
+			// the keys rotate in redis, encrypted
+			if _, err := c.Keys.KEK(); err != nil {
+				errs = append(errs, err)
+			}
+		case len(key) < 32:
+			errs = append(errs, errors.New("MEDIA_SIGNING_KEY must be at least 32 characters"))
+		}
+		// the bucket would serve media without checking signatures
+		if c.Storage.Driver == "s3" && c.Storage.S3.PublicURL != "" {
+			errs = append(errs, errors.New("S3_PUBLIC_URL must not be set with MEDIA_SIGNED_URLS, media is served through MEDIA_BASE_URL"))
+		}
+	}
+	switch c.Storage.Driver {
+	case "local":
+		required("UPLOAD_DIR", c.UploadDir)
+		required("UPLOAD_STAGING_DIR", c.Uploads.StagingDir)
+		if filepath.Clean(c.Uploads.StagingDir) == filepath.Clean(c.UploadDir) {
+			errs = append(errs, errors.New("UPLOAD_STAGING_DIR must not be UPLOAD_DIR, chunks must not be served"))
+		}
+	case "s3":
+		required("S3_ENDPOINT", c.Storage.S3.Endpoint)
+		required("S3_BUCKET", c.Storage.S3.Bucket)
+		required("S3_ACCESS_KEY", c.Storage.S3.AccessKey)
+		required("S3_SECRET_KEY", c.Storage.S3.SecretKey)
+		required("S3_STAGING_BUCKET", c.Storage.S3.StagingBucket)
+		if c.Storage.S3.StagingBucket == c.Storage.S3.Bucket {
+			errs = append(errs, errors.New("S3_STAGING_BUCKET must not be S3_BUCKET, chunks must not be served"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("STORAGE_DRIVER must be local or s3, got %q", c.Storage.Driver))
+	}
+
+	positive("UPLOAD_CLEANUP_EVERY", int64(c.Uploads.CleanupEvery))
+	required("FFMPEG_PATH", c.Video.FFmpegPath)
+	required("FFPROBE_PATH", c.Video.FFprobePath)
+	required("VIDEO_WORK_DIR", c.Video.WorkDir)
+	positive("WORKER_CONCURRENCY", int64(c.Worker.Concurrency))
+	positive("WORKER_POLL_INTERVAL", int64(c.Worker.PollInterval))
+	positive("WORKER_VISIBILITY_TIMEOUT", int64(c.Worker.VisibilityTimeout))
+	positive("WORKER_BACKOFF", int64(c.Worker.Backoff))
+	if c.Worker.MaxBackoff < c.Worker.Backoff {
+		errs = append(errs, errors.New("WORKER_MAX_BACKOFF must not be shorter than WORKER_BACKOFF"))
+	}
+	positive("WORKER_DRAIN_TIMEOUT", int64(c.Worker.DrainTimeout))
+	positive("OUTBOX_POLL_INTERVAL", int64(c.Outbox.PollInterval))
+	positive("OUTBOX_BATCH_SIZE", int64(c.Outbox.BatchSize))
+	positive("REALTIME_HEARTBEAT", int64(c.Realtime.Heartbeat))
+	positive("REALTIME_HISTORY", int64(c.Realtime.History))
+	positive("REALTIME_HISTORY_TTL", int64(c.Realtime.HistoryTTL))
+	positive("REALTIME_REVALIDATE_EVERY", int64(c.Realtime.RevalidateEvery))
+	for _, origin := range c.Realtime.AllowedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+			errs = append(errs, fmt.Errorf("REALTIME_ALLOWED_ORIGINS must hold origins like https://app.example.com, got %q", origin))
+		}
+	}
+
+	required("SMTP_HOST", c.Mail.SMTP.Host)
+	positive("SMTP_PORT", int64(c.Mail.SMTP.Port))
+	switch c.Mail.SMTP.TLS {
+	case "starttls", "tls":
+	case "none":
+		if c.Env == EnvProduction {
+			errs = append(errs, errors.New("SMTP_TLS must be starttls or tls in production"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("SMTP_TLS must be starttls, tls or none, got %q", c.Mail.SMTP.TLS))
+	}
+	required("MAIL_FROM", c.Mail.From)
+	required("APP_URL", c.Mail.AppURL)
+	positive("EMAIL_VERIFICATION_TTL", int64(c.Account.VerificationTTL))
+	positive("EMAIL_VERIFICATION_RESEND_EVERY", int64(c.Account.ResendEvery))
+	positive("EMAIL_VERIFICATION_MAIL_EVERY", int64(c.Account.VerificationMailEvery))
+	positive("PASSWORD_RESET_TTL", int64(c.Account.PasswordResetTTL))
+	positive("PASSWORD_RESET_EVERY", int64(c.Account.PasswordResetEvery))
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: invalid %s configuration: %w", c.Env, errors.Join(errs...))
+	}
+	return nil
+}
+
+// loadDotEnv sets the KEY=VALUE pairs of the file as environment variables
+// without overriding variables that are already set. A missing file is not
+// an error.
+func loadDotEnv(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("config: %w", err)
+	}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("config: %s:%d: expected KEY=VALUE", path, i+1)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+
+		if _, exists := os.LookupEnv(key); !exists {
+			os.Setenv(key, value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    string
+		modify func(c *Config)
+		// wantErr is part of the error message, empty when valid
+		wantErr string
+	}{
+		{"development defaults", EnvDevelopment, func(c *Config) {}, ""},
+		{"test defaults", EnvTest, func(c *Config) {}, ""},
+		{"production without credentials", EnvProduction, func(c *Config) {}, "DB_PASSWORD is required"},
+		{"missing db host", EnvDevelopment, func(c *Config) { c.DB.Host = " " }, "DB_HOST is required"},
+		{"zero access ttl", EnvDevelopment, func(c *Config) { c.JWT.AccessTTL = 0 }, "JWT_ACCESS_TTL must be greater than zero"},
+		{"unknown jwt algorithm", EnvDevelopment, func(c *Config) { c.JWT.Algorithm = "none" }, "JWT_ALGORITHM must be"},
+		{"short jwt secret in production", EnvProduction, func(c *Config) {
+			setProductionCredentials(c)
+			c.JWT.Algorithm = "HS256"
+			c.JWT.Secret = "short"
+		}, "JWT_SECRET must be at least 32 characters"},
+		{"short jwt secret in development", EnvDevelopment, func(c *Config) {
+			c.JWT.Algorithm = "HS256"
+			c.JWT.Secret = "short"
+		}, ""},
+		{"production with credentials", EnvProduction, setProductionCredentials, ""},
+		{"rotating jwt keys without kek", EnvProduction, func(c *Config) {
+			setProductionCredentials(c)
+			c.Keys.EncryptionKey = ""
+		}, "KEYS_ENCRYPTION_KEY or KEYS_ENCRYPTION_KEY_FILE is required"},
+		{"static keys without kek", EnvProduction, func(c *Config) {
+			setProductionCredentials(c)
+			c.Keys.EncryptionKey = ""
+			c.JWT.PrivateKeyFile = "/run/secrets/jwt.pem"
+			c.Storage.SigningKey = strings.Repeat("k", 32)
+		}, ""},
+		{"rotating media keys without kek", EnvProduction, func(c *Config) {
+			setProductionCredentials(c)
+			c.Keys.EncryptionKey = ""
+			c.JWT.PrivateKeyFile = "/run/secrets/jwt.pem"
+		}, "KEYS_ENCRYPTION_KEY or KEYS_ENCRYPTION_KEY_FILE is required"},
+		{"missing media signing key file", EnvDevelopment, func(c *Config) {
+			c.Storage.SigningKeyFile = "/nonexistent/media_signing_key"
+		}, "MEDIA_SIGNING_KEY_FILE"},
+		{"public bucket with signed media", EnvDevelopment, func(c *Config) {
+			c.Storage.Driver = "s3"
+			c.Storage.S3.Endpoint = "http://localhost:9000"
+			c.Storage.S3.Bucket = "media"
+			c.Storage.S3.StagingBucket = "staging"
+			c.Storage.S3.AccessKey = "key"
+			c.Storage.S3.SecretKey = "secret"
+			c.Storage.S3.PublicURL = "https://media.example.com"
+		}, "S3_PUBLIC_URL must not be set with MEDIA_SIGNED_URLS"},
+		{"public bucket with unsigned media", EnvDevelopment, func(c *Config) {
+			c.Storage.SignURLs = false
+			c.Storage.Driver = "s3"
+			c.Storage.S3.Endpoint = "http://localhost:9000"
+			c.Storage.S3.Bucket = "media"
+			c.Storage.S3.StagingBucket = "staging"
+			c.Storage.S3.AccessKey = "key"
+			c.Storage.S3.SecretKey = "secret"
+			c.Storage.S3.PublicURL = "https://media.example.com"
+		}, ""},
+		{"short kek", EnvDevelopment, func(c *Config) { c.Keys.EncryptionKey = "c2hvcnQ=" }, "KEYS_ENCRYPTION_KEY must be the base64 of 32 bytes"},
+		{"short media signing key", EnvDevelopment, func(c *Config) { c.Storage.SigningKey = "short" }, "MEDIA_SIGNING_KEY must be at least 32 characters"},
+		{"unsigned media ignores ttl", EnvDevelopment, func(c *Config) {
+			c.Storage.SignURLs = false
+			c.Storage.URLTTL = 0
+		}, ""},
+		{"unknown storage driver", EnvDevelopment, func(c *Config) { c.Storage.Driver = "ftp" }, "STORAGE_DRIVER must be local or s3"},
+		{"s3 without bucket", EnvDevelopment, func(c *Config) {
+			c.Storage.Driver = "s3"
+			c.Storage.S3.Endpoint = "http://localhost:9000"
+			c.Storage.S3.AccessKey = "key"
+			c.Storage.S3.SecretKey = "secret"
+		}, "S3_BUCKET is required"},
+		{"s3 staging in the media bucket", EnvDevelopment, func(c *Config) {
+			c.Storage.Driver = "s3"
+			c.Storage.S3.Endpoint = "http://localhost:9000"
+			c.Storage.S3.Bucket = "media"
+			c.Storage.S3.StagingBucket = "media"
+			c.Storage.S3.AccessKey = "key"
+			c.Storage.S3.SecretKey = "secret"
+		}, "S3_STAGING_BUCKET must not be S3_BUCKET"},
+		{"local staging in the media dir", EnvDevelopment, func(c *Config) { c.Uploads.StagingDir = c.UploadDir + "/" }, "UPLOAD_STAGING_DIR must not be UPLOAD_DIR"},
+		{"missing ffmpeg", EnvDevelopment, func(c *Config) { c.Video.FFmpegPath = "" }, "FFMPEG_PATH is required"},
+		{"zero upload cleanup interval", EnvDevelopment, func(c *Config) { c.Uploads.CleanupEvery = 0 }, "UPLOAD_CLEANUP_EVERY must be greater than zero"},
+		{"zero verification mail interval", EnvDevelopment, func(c *Config) { c.Account.VerificationMailEvery = 0 }, "EMAIL_VERIFICATION_MAIL_EVERY must be greater than zero"},
+		{"worker backoff", EnvDevelopment, func(c *Config) { c.Worker.MaxBackoff = c.Worker.Backoff - time.Second }, "WORKER_MAX_BACKOFF must not be shorter"},
+		{"zero outbox batch", EnvDevelopment, func(c *Config) { c.Outbox.BatchSize = 0 }, "OUTBOX_BATCH_SIZE must be greater than zero"},
+		{"socket origin with a path", EnvDevelopment, func(c *Config) {
+			c.Realtime.AllowedOrigins = []string{"https://app.example.com/feed"}
+		}, "REALTIME_ALLOWED_ORIGINS must hold origins"},
+		{"zero stream revalidation", EnvDevelopment, func(c *Config) { c.Realtime.RevalidateEvery = 0 }, "REALTIME_REVALIDATE_EVERY must be greater than zero"},
+		{"plain smtp in production", EnvProduction, func(c *Config) {
+			setProductionCredentials(c)
+			c.Mail.SMTP.TLS = "none"
+		}, "SMTP_TLS must be starttls or tls in production"},
+		{"unknown smtp tls", EnvDevelopment, func(c *Config) { c.Mail.SMTP.TLS = "ssl" }, "SMTP_TLS must be starttls, tls or none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaults(tt.env)
+			tt.modify(&c)
+
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v want none", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// setProductionCredentials fills in what production has no default for.
+func setProductionCredentials(c *Config) {
+	c.DB.Username = "social"
+	c.DB.Password = "secret"
+	c.DB.Host = "db"
+	c.DB.Name = "social"
+	c.Redis.Addr = "redis:6379"
+	c.Keys.EncryptionKey = "cHJvZHVjdGlvbiBrZXkgZW5jcnlwdGlvbiBrZXkhISE="
+	c.Mail.SMTP.Host = "smtp"
+	c.Mail.SMTP.Port = 587
+	c.Mail.From = "Social <no-reply@example.com>"
+	c.Mail.AppURL = "https://example.com"
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		check   func(c *Config) bool
+		wantErr string
+	}{
+		{"string", "DB_HOST", "db.internal", func(c *Config) bool { return c.DB.Host == "db.internal" }, ""},
+		{"integer", "DB_MAX_OPEN_CONN", "8", func(c *Config) bool { return c.DB.MaxOpenConn == 8 }, ""},
+		{"duration", "JWT_ACCESS_TTL", "5m", func(c *Config) bool { return c.JWT.AccessTTL == 5*time.Minute }, ""},
+		{"boolean", "S3_PATH_STYLE", "true", func(c *Config) bool { return c.Storage.S3.PathStyle }, ""},
+		{"list", "REALTIME_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com,", func(c *Config) bool {
+			return strings.Join(c.Realtime.AllowedOrigins, " ") == "https://a.example.com https://b.example.com"
+		}, ""},
+		{"bad integer", "DB_MAX_OPEN_CONN", "many", nil, "DB_MAX_OPEN_CONN must be an integer"},
+		{"bad duration", "JWT_ACCESS_TTL", "15", nil, "JWT_ACCESS_TTL must be a duration"},
+		{"bad boolean", "S3_PATH_STYLE", "maybe", nil, "S3_PATH_STYLE must be true or false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+
+			c := defaults(EnvDevelopment)
+			err := c.applyEnv()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v want none", err)
+			}
+			if !tt.check(&c) {
+				t.Errorf("%s=%s was not applied", tt.key, tt.value)
+			}
+		})
+	}
+}
+
+func TestSocketOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		appURL  string
+		want    string
+	}{
+		{"configured", []string{"https://a.example.com", "https://b.example.com"}, "https://example.com", "https://a.example.com https://b.example.com"},
+		{"origin of the app", nil, "https://example.com/app/", "https://example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaults(EnvDevelopment)
+			c.Realtime.AllowedOrigins = tt.allowed
+			c.Mail.AppURL = tt.appURL
+
+			if got := strings.Join(c.SocketOrigins(), " "); got != tt.want {
+				t.Errorf("got origins %q want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/worker"
+)
+
+// dedupeTTL is how long an event delivered to a subscriber is remembered,
+// a relay that publishes it again within this time is ignored.
+const dedupeTTL = 24 * time.Hour
+
+// Publisher receives every event dispatched from the outbox.
+type Publisher interface {
+	Publish(ctx context.Context, event models.Event) error
+}
+
+// delivery is the job carrying an event to one subscriber. Every
+// subscriber gets its own job so a failing one is retried alone.
+type delivery struct {
+	Subscriber string       `json:"subscriber"`
+	Event      models.Event `json:"event"`
+}
+
+func (d delivery) JobType() string { return "event:" + d.Subscriber }
+
+// Bus delivers outbox events to subscribers through the job queue and to
+// any extra publishers.
+type Bus struct {
+	queue *worker.Queue
+	pool  *worker.Pool
+	// subscribers by event type
+	subscribers map[string][]string
+	publishers  []Publisher
+}
+
+func NewBus(q *worker.Queue, p *worker.Pool) *Bus {
+	return &Bus{
+		queue:       q,
+		pool:        p,
+		subscribers: map[string][]string{},
+	}
+}
+
+// AddPublisher makes p receive every event. Publishing stops at the first
+// error and is retried, publishers must tolerate duplicates.
+func (b *Bus) AddPublisher(p Publisher) {
+	b.publishers = append(b.publishers, p)
+}
+
+// Subscribe runs fn in the worker pool for every event of eventType, with
+// its payload decoded into a T. name identifies the subscriber and must be
+// unique per event type. Subscriptions must be made before the pool starts.
+func Subscribe[T any](b *Bus, eventType, name string, fn func(ctx context.Context, event models.Event, payload T) error) {
+	subscriber := eventType + ":" + name
+	b.subscribers[eventType] = append(b.subscribers[eventType], subscriber)
+
+	b.pool.Handle(delivery{Subscriber: subscriber}.JobType(), func(ctx context.Context, job *worker.Job) error {
+		var d delivery
+		if err := json.Unmarshal(job.Payload, &d); err != nil {
+			return worker.Permanent(fmt.Errorf("decode delivery: %w", err))
+		}
+
+		var payload T
+		if err := json.Unmarshal(d.Event.Payload, &payload); err != nil {
+			return worker.Permanent(fmt.Errorf("decode %s payload: %w", d.Event.Type, err))
+		}
+		return fn(ctx, d.Event, payload)
+	})
+}
+
+// Publish enqueues the event for each of its subscribers, at most once per
+// subscriber within dedupeTTL.
+func (b *Bus) Publish(ctx context.Context, event models.Event) error {
+	for _, subscriber := range b.subscribers[event.Type] {
+		d := delivery{Subscriber: subscriber, Event: event}
+		if _, err := b.queue.EnqueueOnce(ctx, event.ID+":"+subscriber, dedupeTTL, d); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range b.publishers {
+		if err := p.Publish(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
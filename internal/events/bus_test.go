@@ -0,0 +1,109 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/internal/worker"
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/go-redis/v9/maintnotifications"
+)
+
+type publisherFunc func(ctx context.Context, event models.Event) error
+
+func (f publisherFunc) Publish(ctx context.Context, event models.Event) error { return f(ctx, event) }
+
+func newTestBus(t *testing.T) (*Bus, *worker.Pool, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		MaintNotificationsConfig: &maintnotifications.Config{
+			Mode: maintnotifications.ModeDisabled,
+		},
+	})
+	t.Cleanup(func() { rdb.Close() })
+
+	q := worker.NewQueue(rdb, "jobs")
+	pool := worker.NewPool(q, worker.PoolConfig{PollInterval: 10 * time.Millisecond}, utils.NewLogger())
+	return NewBus(q, pool), pool, rdb
+}
+
+func postCreated(id string) models.Event {
+	return models.Event{
+		ID:      id,
+		Type:    models.EventPostCreated,
+		Payload: []byte(`{"post_id":"post-1","user_id":"user-1"}`),
+	}
+}
+
+func TestPublish(t *testing.T) {
+	ctx := context.Background()
+	errPublish := errors.New("publish failed")
+
+	tests := []struct {
+		name string
+		// events published in order
+		events       []models.Event
+		publisherErr error
+		wantJobs     int64
+		wantErr      error
+	}{
+		{"one job per subscriber", []models.Event{postCreated("e1")}, nil, 2, nil},
+		{"published again", []models.Event{postCreated("e1"), postCreated("e1")}, nil, 2, nil},
+		{"two events", []models.Event{postCreated("e1"), postCreated("e2")}, nil, 4, nil},
+		{"no subscribers", []models.Event{{ID: "e1", Type: models.EventFollowCreated}}, nil, 0, nil},
+		{"publisher fails", []models.Event{postCreated("e1")}, errPublish, 2, errPublish},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus, _, rdb := newTestBus(t)
+			for _, name := range []string{"feed", "notifications"} {
+				Subscribe(bus, models.EventPostCreated, name, func(context.Context, models.Event, models.PostEvent) error { return nil })
+			}
+			bus.AddPublisher(publisherFunc(func(context.Context, models.Event) error { return tt.publisherErr }))
+
+			var err error
+			for _, event := range tt.events {
+				err = bus.Publish(ctx, event)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v want %v", err, tt.wantErr)
+			}
+			if n := rdb.LLen(ctx, "jobs:ready").Val(); n != tt.wantJobs {
+				t.Errorf("got %d jobs want %d", n, tt.wantJobs)
+			}
+		})
+	}
+}
+
+func TestSubscriberReceivesPayload(t *testing.T) {
+	bus, pool, _ := newTestBus(t)
+
+	received := make(chan models.PostEvent, 1)
+	Subscribe(bus, models.EventPostCreated, "test", func(_ context.Context, _ models.Event, p models.PostEvent) error {
+		received <- p
+		return nil
+	})
+	pool.Start()
+	t.Cleanup(func() { pool.Shutdown(context.Background()) })
+
+	if err := bus.Publish(context.Background(), postCreated("e1")); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	select {
+	case p := <-received:
+		if p.PostID != "post-1" || p.UserID != "user-1" {
+			t.Errorf("got payload %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+}
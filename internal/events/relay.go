@@ -0,0 +1,81 @@
+package events
+
+import (
+	"cmp"
+	"context"
+	"time"
+
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+)
+
+const (
+	defaultBatchSize = 100
+	// dispatched events are kept this long for debugging
+	retention  = 7 * 24 * time.Hour
+	pruneEvery = time.Hour
+)
+
+type RelayConfig struct {
+	OutboxRepo store.OutboxRepo
+	Bus        *Bus
+	// PollInterval is how long the relay waits when the outbox is empty.
+	PollInterval time.Duration
+	BatchSize    int
+	Logger       *utils.Logger
+}
+
+// Relay moves the events committed to the outbox onto the bus. Events are
+// published at least once, the bus drops the duplicates a crashed relay
+// may publish again.
+type Relay struct {
+	outboxRepo   store.OutboxRepo
+	bus          *Bus
+	pollInterval time.Duration
+	batchSize    int
+	logger       *utils.Logger
+}
+
+func NewRelay(cfg RelayConfig) *Relay {
+	return &Relay{
+		outboxRepo:   cfg.OutboxRepo,
+		bus:          cfg.Bus,
+		pollInterval: cmp.Or(cfg.PollInterval, time.Second),
+		batchSize:    cmp.Or(cfg.BatchSize, defaultBatchSize),
+		logger:       cfg.Logger,
+	}
+}
+
+// Run relays events until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var lastPrune time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		n, err := r.outboxRepo.Dispatch(ctx, r.batchSize, r.bus.Publish)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("Outbox Relay Error", "msg", err.Error())
+		}
+
+		if time.Since(lastPrune) > pruneEvery {
+			lastPrune = time.Now()
+			if _, err := r.outboxRepo.Prune(ctx, lastPrune.Add(-retention)); err != nil && ctx.Err() == nil {
+				r.logger.Error("Outbox Relay Error", "msg", err.Error())
+			}
+		}
+
+		// keep going while there is a backlog
+		if n == r.batchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(r.pollInterval)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	. "github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+	"github.com/cakra17/social/pkg/validation"
+	"github.com/google/uuid"
+)
+
+type CommentHandler struct {
+	commentRepo store.CommentRepo
+	logger      *utils.Logger
+}
+
+type CommentHandlerConfig struct {
+	CommentRepo store.CommentRepo
+	Logger      *utils.Logger
+}
+
+func NewCommentHandler(cfg CommentHandlerConfig) CommentHandler {
+	return CommentHandler{
+		commentRepo: cfg.CommentRepo,
+		logger:      cfg.Logger,
+	}
+}
+
+func parseDepth(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("depth")
+	if v == "" {
+		return store.DefaultCommentDepth, nil
+	}
+
+	depth, err := strconv.Atoi(v)
+	if err != nil || depth < 0 {
+		return 0, errors.New("invalid depth")
+	}
+	return min(depth, store.MaxCommentDepth), nil
+}
+
+func writeCommentError(w http.ResponseWriter, err error, fallback CustomError) {
+	switch {
+	case errors.Is(err, policy.ErrForbidden):
+		WriteError(w, ErrForbidden)
+	case errors.Is(err, store.ErrCommentNotFound):
+		WriteError(w, ErrCommentNotFound)
+	case errors.Is(err, store.ErrPostNotFound):
+		WriteError(w, ErrPostNotFound)
+	default:
+		WriteError(w, fallback)
+	}
+}
+
+func (h *CommentHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
+	var payload models.CreateCommentPayload
+
+	if err := utils.ParseBody(r, &payload); err != nil {
+		h.logger.Error("Comment Handler Error", "Failed to decode payload", err.Error())
+		WriteError(w, ErrPayloadMalformed)
+		return
+	}
+
+	if err := validation.Validate(&payload); err != nil {
+		h.logger.Error("Comment Handler Error", "Failed to validate payload", err)
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	postID := r.PathValue("id")
+	if uuid.Validate(postID) != nil {
+		WriteError(w, ErrPostNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		h.logger.Error("Comment Handler Error", "Failed get actor")
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		h.logger.Error("Comment Handler Error", "Failed to create id", err.Error())
+		WriteError(w, ErrFailedToCreateComment)
+		return
+	}
+
+	comment := &models.Comment{
+		ID:     id.String(),
+		PostID: postID,
+		UserID: userID,
+		Body:   payload.Body,
+	}
+	if payload.ParentID != "" {
+		comment.ParentID = &payload.ParentID
+	}
+
+	err = h.commentRepo.Create(ctx, comment)
+	if err != nil {
+		h.logger.Error("Comment Handler Error", "Failed to create comment", err.Error())
+		writeCommentError(w, err, ErrFailedToCreateComment)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code:    http.StatusCreated,
+		Message: "Comment created successfully",
+		Data:    comment,
+	})
+}
+
+func (h *CommentHandler) GetComments(w http.ResponseWriter, r *http.Request) {
+	page, err := pagination.Parse(r)
+	if err != nil {
+		h.logger.Error("Comment Handler Error", "Invalid page", err.Error())
+		WriteError(w, ErrInvalidPage)
+		return
+	}
+
+	depth, err := parseDepth(r)
+	if err != nil {
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	postID := r.PathValue("id")
+	if uuid.Validate(postID) != nil {
+		WriteError(w, ErrPostNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	actorID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	comments, next, err := h.commentRepo.GetByPost(ctx, postID, actorID, depth, page)
+	if err != nil {
+		h.logger.Error("Comment Handler Error", "Failed to get comments", err.Error())
+		writeCommentError(w, err, ErrFailedToGetComment)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code:       http.StatusOK,
+		Data:       comments,
+		NextCursor: next,
+	})
+}
+
+func (h *CommentHandler) GetThread(w http.ResponseWriter, r *http.Request) {
+	depth, err := parseDepth(r)
+	if err != nil {
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	postID, id := r.PathValue("id"), r.PathValue("commentId")
+	if uuid.Validate(postID) != nil {
+		WriteError(w, ErrPostNotFound)
+		return
+	}
+	if uuid.Validate(id) != nil {
+		WriteError(w, ErrCommentNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	actorID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	comment, err := h.commentRepo.GetThread(ctx, postID, id, actorID, depth)
+	if err != nil {
+		h.logger.Error("Comment Handler Error", "Failed to get comment", err.Error())
+		writeCommentError(w, err, ErrFailedToGetComment)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code: http.StatusOK,
+		Data: comment,
+	})
+}
+
+func (h *CommentHandler) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	var payload models.UpdateCommentPayload
+
+	if err := utils.ParseBody(r, &payload); err != nil {
+		h.logger.Error("Comment Handler Error", "Failed to decode payload", err.Error())
+		WriteError(w, ErrPayloadMalformed)
+		return
+	}
+
+	if err := validation.Validate(&payload); err != nil {
+		h.logger.Error("Comment Handler Error", "Failed to validate payload", err)
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		h.logger.Error("Comment Handler Error", "Failed get actor")
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	comment := &models.Comment{
+		ID:     r.PathValue("commentId"),
+		PostID: r.PathValue("id"),
+		UserID: userID,
+		Body:   payload.Body,
+	}
+
+	err := h.commentRepo.Update(ctx, comment)
+	if err != nil {
+		h.logger.Error("Comment Handler Error", "Failed to update comment", err.Error())
+		writeCommentError(w, err, ErrFailedToUpdateComment)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code:    http.StatusOK,
+		Message: "Comment updated successfully",
+		Data:    comment,
+	})
+}
+
+func (h *CommentHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		h.logger.Error("Comment Handler Error", "Failed get actor")
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	err := h.commentRepo.Delete(ctx, r.PathValue("id"), r.PathValue("commentId"), userID)
+	if err != nil {
+		h.logger.Error("Comment Handler Error", "Failed to delete comment", err.Error())
+		writeCommentError(w, err, ErrFailedToDeleteComment)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
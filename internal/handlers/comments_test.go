@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+)
+
+func TestParseDepth(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{"", store.DefaultCommentDepth, false},
+		{"?depth=0", 0, false},
+		{"?depth=2", 2, false},
+		{"?depth=100", store.MaxCommentDepth, false},
+		{"?depth=-1", 0, true},
+		{"?depth=deep", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := parseDepth(httptest.NewRequest("GET", "/posts/1/comments"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetCommentsOfHiddenPost(t *testing.T) {
+	actorID, postID := newID(), newID()
+
+	tests := []struct {
+		name   string
+		postID string
+		// visible is whether the actor may see the post
+		visible bool
+		want    int
+	}{
+		{"visible post", postID, true, http.StatusOK},
+		// unknown, still processing or failed post of another user
+		{"hidden post", postID, false, http.StatusNotFound},
+		{"not an id", "not-a-uuid", false, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			logger := utils.NewLogger()
+			h := NewCommentHandler(CommentHandlerConfig{CommentRepo: store.NewCommentRepo(db, logger), Logger: logger})
+
+			if tt.postID == postID {
+				mock.ExpectQuery(regexp.QuoteMeta(`WHERE c.post_id = $1 AND c.parent_id IS NULL AND (p.status = 'ready' OR p.user_id = $3)`)).
+					WithArgs(postID, pagination.DefaultLimit+1, actorID).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1`)).
+					WithArgs(postID, actorID).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.visible))
+			}
+
+			r := httptest.NewRequest("GET", "/posts/"+tt.postID+"/comments?depth=0", nil)
+			w := serveAs(t, h.GetComments, actorID, r, "id", tt.postID)
+			if w.Code != tt.want {
+				t.Errorf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
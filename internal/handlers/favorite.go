@@ -1,49 +1,51 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/storage"
 	"github.com/cakra17/social/internal/store"
 	"github.com/cakra17/social/internal/utils"
-	"github.com/cakra17/social/pkg/jwt"
+	"github.com/cakra17/social/pkg/pagination"
 	"github.com/google/uuid"
 )
 
 type FavoriteHandler struct {
 	favoriteRepo store.FavoriteRepo
-	logger *utils.Logger
-	jwtAuthenticator *jwt.JWTAuthenticator
+	mediaStore   storage.MediaStore
+	logger       *utils.Logger
 }
 
 type FavoriteHandlerConfig struct {
 	FavoriteRepo store.FavoriteRepo
-	Logger *utils.Logger
-	JWTAuthenticator *jwt.JWTAuthenticator
+	MediaStore   storage.MediaStore
+	Logger       *utils.Logger
 }
 
 func NewFavoriteHandler(cfg FavoriteHandlerConfig) FavoriteHandler {
 	return FavoriteHandler{
 		favoriteRepo: cfg.FavoriteRepo,
-		logger: cfg.Logger,
-		jwtAuthenticator: cfg.JWTAuthenticator,
+		mediaStore:   cfg.MediaStore,
+		logger:       cfg.Logger,
 	}
 }
 
 func (h *FavoriteHandler) AddFavorite(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	claims, ok := h.jwtAuthenticator.GetClaims(ctx)
+	userID, ok := policy.ActorID(ctx)
 	if !ok {
-		h.logger.Error("Authetication Error", "claim not found")
+		h.logger.Error("Authetication Error", "actor not found")
+		utils.WriteError(w, utils.ErrTokenExpires)
 		return
 	}
-
-	userID := claims["userId"].(string)
 	postID := r.PathValue("postId")
 
 	favorite := models.Favorite{
-		ID: uuid.Must(uuid.NewV7()).String(),
+		ID:     uuid.Must(uuid.NewV7()).String(),
 		PostId: postID,
 		UserId: userID,
 	}
@@ -52,57 +54,76 @@ func (h *FavoriteHandler) AddFavorite(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		h.logger.Error("Favorite Handler Error", "Failed add post to favorite", err.Error())
 		utils.WriteError(w, utils.CustomError{
-			Code: http.StatusInternalServerError,
+			Code:    http.StatusInternalServerError,
 			Message: err.Error(),
 		})
 		return
 	}
 
 	utils.WriteJson(w, utils.CustomSuccess{
-		Code: http.StatusCreated,
+		Code:    http.StatusCreated,
 		Message: "Added to your favorite",
-		Data: favorite,
+		Data:    favorite,
 	})
-} 
+}
 
 func (h *FavoriteHandler) GetFavouritePost(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	claims, ok := h.jwtAuthenticator.GetClaims(ctx)
+	userID, ok := policy.ActorID(ctx)
 	if !ok {
-		h.logger.Error("Authetication Error", "claim not found")
+		h.logger.Error("Authetication Error", "actor not found")
+		utils.WriteError(w, utils.ErrTokenExpires)
 		return
 	}
 
-	userID := claims["userId"].(string)
+	page, err := pagination.Parse(r)
+	if err != nil {
+		h.logger.Error("Favorite Handler Error", "Invalid page", err.Error())
+		utils.WriteError(w, utils.ErrInvalidPage)
+		return
+	}
 
-	favorites, err := h.favoriteRepo.GetFavouritePost(ctx, userID)
+	favorites, next, err := h.favoriteRepo.GetFavouritePost(ctx, userID, page)
 	if err != nil {
 		h.logger.Error("Favorite Handler Error", "Failed to get favorite", err.Error())
 		utils.WriteError(w, utils.CustomError{
-			Code: http.StatusInternalServerError,
+			Code:    http.StatusInternalServerError,
 			Message: err.Error(),
 		})
 		return
 	}
+	setMediaURLsOf(h.mediaStore, userID, favorites)
 
 	utils.WriteJson(w, utils.CustomSuccess{
-		Code: http.StatusOK,
-		Message: "Succes to getlikes data",
-		Data: favorites,
+		Code:       http.StatusOK,
+		Message:    "Succes to getlikes data",
+		Data:       favorites,
+		NextCursor: next,
 	})
 }
 
 func (h *FavoriteHandler) DeleteFavorite(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		h.logger.Error("Authetication Error", "actor not found")
+		utils.WriteError(w, utils.ErrTokenExpires)
+		return
+	}
+
 	postID := r.PathValue("postId")
 
-	err := h.favoriteRepo.Delete(ctx, postID)
+	err := h.favoriteRepo.Delete(ctx, postID, userID)
 	if err != nil {
 		h.logger.Error("Favorite Error", "Failed to delete favorite", err.Error())
+		if errors.Is(err, store.ErrFavoriteNotFound) {
+			utils.WriteError(w, utils.ErrFavoriteNotFound)
+			return
+		}
 		utils.WriteError(w, utils.CustomError{
-			Code: http.StatusInternalServerError,
+			Code:    http.StatusInternalServerError,
 			Message: err.Error(),
 		})
 		return
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/storage"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	. "github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+)
+
+type FeedHandler struct {
+	postRepo   store.PostRepo
+	timeline   *store.Timeline
+	mediaStore storage.MediaStore
+	logger     *utils.Logger
+}
+
+type FeedHandlerConfig struct {
+	PostRepo   store.PostRepo
+	Timeline   *store.Timeline
+	MediaStore storage.MediaStore
+	Logger     *utils.Logger
+}
+
+func NewFeedHandler(cfg FeedHandlerConfig) FeedHandler {
+	return FeedHandler{
+		postRepo:   cfg.PostRepo,
+		timeline:   cfg.Timeline,
+		mediaStore: cfg.MediaStore,
+		logger:     cfg.Logger,
+	}
+}
+
+// getPosts reads the feed from the cached timeline and falls back to
+// computing it from postgres when redis is unavailable.
+func (h *FeedHandler) getPosts(ctx context.Context, userID string, page pagination.Page) ([]models.Post, string, error) {
+	if h.timeline != nil {
+		ids, next, err := h.timeline.Feed(ctx, userID, page)
+		if err == nil {
+			posts, err := h.postRepo.GetByIDs(ctx, ids, userID)
+			return posts, next, err
+		}
+		h.logger.Error("Feed Handler Error", "Failed to read timeline", err.Error())
+	}
+	return h.postRepo.GetFeed(ctx, userID, page)
+}
+
+// GetFeed returns posts of the logged user and the accounts they follow,
+// newest first. Post ids are UUIDv7 so they are already ordered by time and
+// the id of the last post is used as the cursor for the next page.
+func (h *FeedHandler) GetFeed(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		h.logger.Error("Feed Handler Error", "Failed get actor")
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	page, err := pagination.Parse(r)
+	if err != nil {
+		h.logger.Error("Feed Handler Error", "Invalid page", err.Error())
+		WriteError(w, ErrInvalidPage)
+		return
+	}
+
+	posts, next, err := h.getPosts(ctx, userID, page)
+	if err != nil {
+		h.logger.Error("Feed Handler Error", "Failed to get feed", err.Error())
+		WriteError(w, ErrFailedToGetFeed)
+		return
+	}
+	setMediaURLsOf(h.mediaStore, userID, posts)
+
+	WriteJson(w, CustomSuccess{
+		Code:       http.StatusOK,
+		Data:       posts,
+		NextCursor: next,
+	})
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+)
+
+var (
+	selectPulledIDs = regexp.QuoteMeta(`u.followers_count > $2`)
+	selectPostsByID = regexp.QuoteMeta(`WHERE p.id = ANY($1) AND (p.status = 'ready' OR p.user_id = $2)`)
+)
+
+func TestGetFeedFromTimeline(t *testing.T) {
+	actorID := newID()
+	own, followed := newID(), newID()
+
+	db, mock := newTestDB(t)
+	rdb, mr := newTestRedis(t)
+	logger := utils.NewLogger()
+	tl := store.NewTimeline(db, rdb, logger)
+	h := NewFeedHandler(FeedHandlerConfig{
+		PostRepo:   store.NewPostRepo(db, tl, logger),
+		Timeline:   tl,
+		MediaStore: newTestMediaStore(t),
+		Logger:     logger,
+	})
+
+	for _, id := range []string{"", own, followed} {
+		mr.ZAdd("timeline:"+actorID, 0, id)
+	}
+	mock.ExpectQuery(selectPulledIDs).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	// the post of the followed account is still processing and is left out
+	// by the query
+	rows := addPost(postRows(), own, actorID, models.PostStatusProcessing)
+	mock.ExpectQuery(selectPostsByID).WithArgs(`{"`+followed+`","`+own+`"}`, actorID).WillReturnRows(rows)
+	mock.ExpectQuery(selectPostMedia).WillReturnRows(mediaRows())
+
+	w := serveAs(t, h.GetFeed, actorID, httptest.NewRequest("GET", "/feed", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var posts []models.Post
+	decodeResponse(t, w, &posts)
+	if len(posts) != 1 || posts[0].ID != own {
+		t.Errorf("got %+v want only the own post", posts)
+	}
+}
@@ -1,13 +1,15 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
 	"github.com/cakra17/social/internal/store"
 	"github.com/cakra17/social/internal/utils"
 	. "github.com/cakra17/social/internal/utils"
-	"github.com/cakra17/social/pkg/jwt"
+	"github.com/cakra17/social/pkg/pagination"
 	"github.com/cakra17/social/pkg/validation"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
@@ -15,24 +17,21 @@ import (
 
 type FollowHandler struct {
 	followRepo store.FollowRepo
-	redis *redis.Client
-	jwtAuthenticator *jwt.JWTAuthenticator
-	logger *utils.Logger
+	redis      *redis.Client
+	logger     *utils.Logger
 }
 
 type FollowHandlerConfig struct {
 	FollowRepo store.FollowRepo
-	Redis *redis.Client
-	JWTAuthenticator *jwt.JWTAuthenticator
-	Logger *utils.Logger
+	Redis      *redis.Client
+	Logger     *utils.Logger
 }
 
 func NewFollowHandler(cfg FollowHandlerConfig) FollowHandler {
 	return FollowHandler{
 		followRepo: cfg.FollowRepo,
-		redis: cfg.Redis,
-		jwtAuthenticator: cfg.JWTAuthenticator,
-		logger: cfg.Logger,
+		redis:      cfg.Redis,
+		logger:     cfg.Logger,
 	}
 }
 
@@ -45,92 +44,112 @@ func (h *FollowHandler) Follow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := validation.Validate(&payload); err != nil {
-    WriteError(w, ErrInvalidPayload)
+		WriteError(w, ErrInvalidPayload)
 		return
 	}
 
 	ctx := r.Context()
+	userId, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	if payload.FolloweeID == userId {
+		WriteError(w, ErrCannotFollowSelf)
+		return
+	}
 
 	id, err := uuid.NewV7()
 	if err != nil {
 		h.logger.Error("Favorite Handler Error", "Failed to create id", err.Error())
 		WriteError(w, CustomError{
-			Code: http.StatusInternalServerError,
+			Code:    http.StatusInternalServerError,
 			Message: "Failed to follow user",
 		})
 		return
 	}
 
 	follow := models.Follow{
-		ID: id.String(),
+		ID:         id.String(),
 		FolloweeID: payload.FolloweeID,
-		FollowerID: payload.FollowerID,
+		FollowerID: userId,
 	}
 
 	err = h.followRepo.Follow(ctx, follow)
 	if err != nil {
 		h.logger.Error("Favorite Handler Error", "Failed to follow user", err.Error())
 		WriteError(w, CustomError{
-			Code: http.StatusInternalServerError,
+			Code:    http.StatusInternalServerError,
 			Message: "Failed to follow user",
 		})
 		return
 	}
 
 	WriteJson(w, CustomSuccess{
-		Code: http.StatusCreated,
+		Code:    http.StatusCreated,
 		Message: "started to follow",
-		Data: follow,
+		Data:    follow,
 	})
 }
 
 func (h *FollowHandler) GetFollowers(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, ok := h.jwtAuthenticator.GetClaims(ctx)
+	userId, ok := policy.ActorID(ctx)
 	if !ok {
-    WriteError(w, ErrTokenExpires)
+		WriteError(w, ErrTokenExpires)
 		return
 	}
 
-	userId, _ := claims["userId"].(string)
+	page, err := pagination.Parse(r)
+	if err != nil {
+		WriteError(w, ErrInvalidPage)
+		return
+	}
 
-	followers, err := h.followRepo.GetFollowers(ctx, userId)
+	followers, next, err := h.followRepo.GetFollowers(ctx, userId, page)
 	if err != nil {
 		WriteError(w, CustomError{
-			Code: http.StatusInternalServerError,
+			Code:    http.StatusInternalServerError,
 			Message: "Failed to get followers",
 		})
 		return
 	}
 
 	WriteJson(w, CustomSuccess{
-		Code: http.StatusOK,
-		Data: followers,
+		Code:       http.StatusOK,
+		Data:       followers,
+		NextCursor: next,
 	})
 }
 
 func (h *FollowHandler) GetFollowing(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	claims, ok := h.jwtAuthenticator.GetClaims(ctx)
+	userId, ok := policy.ActorID(ctx)
 	if !ok {
-    WriteError(w,ErrTokenExpires)
+		WriteError(w, ErrTokenExpires)
 		return
 	}
 
-	userId, _ := claims["userId"].(string)
+	page, err := pagination.Parse(r)
+	if err != nil {
+		WriteError(w, ErrInvalidPage)
+		return
+	}
 
-	following, err := h.followRepo.GetFollowing(ctx, userId)
+	following, next, err := h.followRepo.GetFollowing(ctx, userId, page)
 	if err != nil {
-    WriteError(w, CustomError{
-			Code: http.StatusInternalServerError, 
+		WriteError(w, CustomError{
+			Code:    http.StatusInternalServerError,
 			Message: "Failed to get followers",
 		})
 		return
 	}
 
 	WriteJson(w, CustomSuccess{
-		Code: http.StatusOK,
-		Data: following,
+		Code:       http.StatusOK,
+		Data:       following,
+		NextCursor: next,
 	})
 }
 
@@ -138,12 +157,26 @@ func (h *FollowHandler) Unfollow(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
 	ctx := r.Context()
-	err := h.followRepo.Unfollow(ctx, id)
+	userId, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	err := h.followRepo.Unfollow(ctx, id, userId)
 	if err != nil {
-		WriteError(w, CustomError{
-			Code: http.StatusInternalServerError, 
-			Message: "Failed to unfollow",
-		})
+		h.logger.Error("Follow Handler Error", "Failed to unfollow", err.Error())
+		switch {
+		case errors.Is(err, policy.ErrForbidden):
+			WriteError(w, ErrForbidden)
+		case errors.Is(err, store.ErrFollowNotFound):
+			WriteError(w, ErrFollowNotFound)
+		default:
+			WriteError(w, CustomError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to unfollow",
+			})
+		}
 		return
 	}
 
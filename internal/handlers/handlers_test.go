@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/storage"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/jwt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/go-redis/v9/maintnotifications"
+)
+
+var testAuth = jwt.NewJWTAuthenticator("testsecret", time.Hour)
+
+func newTestDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet expectations: %v", err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+func newTestRedis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		MaintNotificationsConfig: &maintnotifications.Config{
+			Mode: maintnotifications.ModeDisabled,
+		},
+	})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb, mr
+}
+
+func newTestMediaStore(t *testing.T) storage.MediaStore {
+	t.Helper()
+
+	ms, err := storage.NewLocalStore(t.TempDir(), "http://media.test")
+	if err != nil {
+		t.Fatalf("Failed to create media store: %v", err)
+	}
+	return ms
+}
+
+func newID() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
+
+// serveAs runs a handler behind policy.Authenticate with a token of actorID.
+// Path values are set as pairs of name and value.
+func serveAs(t *testing.T, h http.HandlerFunc, actorID string, r *http.Request, pathValues ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	token, err := testAuth.GenerateToken(context.Background(), jwt.JWTUser{ID: actorID})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	for i := 0; i+1 < len(pathValues); i += 2 {
+		r.SetPathValue(pathValues[i], pathValues[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	policy.New(testAuth).Authenticate(h).ServeHTTP(w, r)
+	return w
+}
+
+// decodeResponse returns the body of a success response.
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, data any) utils.Response {
+	t.Helper()
+
+	res := utils.Response{Data: data}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return res
+}
+
+// postRows returns rows as read with the columns of store's postSelect.
+func postRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"id", "caption", "user_id", "username", "status", "processing_error",
+		"likes", "favorites", "comments", "created_at", "updated_at",
+	})
+}
+
+func addPost(rows *sqlmock.Rows, id, userID, status string) *sqlmock.Rows {
+	now := time.Now()
+	return rows.AddRow(id, "caption", userID, "user", status, "", 0, 0, 0, now, now)
+}
+
+func mediaRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"id", "post_id", "position", "kind", "media_key",
+		"alt_text", "width", "height", "duration_ms", "variants",
+	})
+}
@@ -1,49 +1,47 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
 	"github.com/cakra17/social/internal/store"
 	"github.com/cakra17/social/internal/utils"
-	"github.com/cakra17/social/pkg/jwt"
+	"github.com/cakra17/social/pkg/pagination"
 	"github.com/google/uuid"
 )
 
 type LikesHandler struct {
 	likesRepo store.LikesRepo
-	jwtAuthenticator *jwt.JWTAuthenticator
-	logger *utils.Logger
+	logger    *utils.Logger
 }
 
 type LikesHandlerConfig struct {
 	LikesRepo store.LikesRepo
-	JWTAuthenticator *jwt.JWTAuthenticator
-	Logger *utils.Logger
+	Logger    *utils.Logger
 }
 
 func NewLikesHandler(cfg LikesHandlerConfig) LikesHandler {
 	return LikesHandler{
 		likesRepo: cfg.LikesRepo,
-		jwtAuthenticator: cfg.JWTAuthenticator,
-		logger: cfg.Logger,
+		logger:    cfg.Logger,
 	}
 }
 
 func (h *LikesHandler) Like(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	claims, ok := h.jwtAuthenticator.GetClaims(ctx)
+	userID, ok := policy.ActorID(ctx)
 	if !ok {
-		h.logger.Error("Authetication Error", "claim not found")
+		h.logger.Error("Authetication Error", "actor not found")
+		utils.WriteError(w, utils.ErrTokenExpires)
 		return
 	}
-
-	userID := claims["userId"].(string)
 	postID := r.PathValue("postId")
 
-	likes := models.Likes{
-		ID: uuid.Must(uuid.NewV7()).String(),
+	likes := &models.Likes{
+		ID:     uuid.Must(uuid.NewV7()).String(),
 		PostId: postID,
 		UserId: userID,
 	}
@@ -51,10 +49,11 @@ func (h *LikesHandler) Like(w http.ResponseWriter, r *http.Request) {
 	err := h.likesRepo.Like(ctx, likes)
 	if err != nil {
 		h.logger.Error("Like Handler Error", "Failed to liked post", err.Error())
-		utils.WriteError(w, utils.CustomError{
-			Code: http.StatusInternalServerError,
-			Message: err.Error(),
-		})
+		if errors.Is(err, store.ErrPostNotFound) {
+			utils.WriteError(w, utils.ErrPostNotFound)
+			return
+		}
+		utils.WriteError(w, utils.ErrFailedToLikePost)
 		return
 	}
 
@@ -69,37 +68,53 @@ func (h *LikesHandler) GetPostLikes(w http.ResponseWriter, r *http.Request) {
 
 	postID := r.PathValue("postId")
 
-	likes, err := h.likesRepo.GetLikes(ctx, postID)
+	page, err := pagination.Parse(r)
+	if err != nil {
+		h.logger.Error("Like Handler Error", "Invalid page", err.Error())
+		utils.WriteError(w, utils.ErrInvalidPage)
+		return
+	}
+
+	likes, next, err := h.likesRepo.GetLikes(ctx, postID, page)
 	if err != nil {
 		h.logger.Error("Like Handler Error", "Failed to get liked post", err.Error())
-		utils.WriteError(w, utils.CustomError{
-			Code: http.StatusInternalServerError,
-			Message: err.Error(),
-		})
+		utils.WriteError(w, utils.ErrFailedToGetLikes)
 		return
 	}
 
 	utils.WriteJson(w, utils.CustomSuccess{
-		Code: http.StatusOK,
-		Message: "Succes to get likes data",
-		Data: likes.Users,
+		Code:       http.StatusOK,
+		Message:    "Succes to get likes data",
+		Data:       likes.Users,
+		NextCursor: next,
 	})
 }
 
 func (h *LikesHandler) Unlike(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	postID := r.PathValue("likesId")
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		h.logger.Error("Authetication Error", "actor not found")
+		utils.WriteError(w, utils.ErrTokenExpires)
+		return
+	}
 
-	err := h.likesRepo.Unlike(ctx, postID)
+	likeID := r.PathValue("likesId")
+
+	err := h.likesRepo.Unlike(ctx, likeID, userID)
 	if err != nil {
-		utils.WriteError(w, utils.CustomError{
-			Code: http.StatusInternalServerError,
-			Message: err.Error(),
-		})
 		h.logger.Error("Like Handler Error", "Failed to unlike post", err.Error())
+		switch {
+		case errors.Is(err, policy.ErrForbidden):
+			utils.WriteError(w, utils.ErrForbidden)
+		case errors.Is(err, store.ErrLikeNotFound):
+			utils.WriteError(w, utils.ErrLikeNotFound)
+		default:
+			utils.WriteError(w, utils.ErrFailedToUnlikePost)
+		}
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
-}
\ No newline at end of file
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/lib/pq"
+)
+
+var (
+	insertLike     = regexp.QuoteMeta(`ON CONFLICT (post_id, user_id) DO NOTHING`)
+	selectLike     = regexp.QuoteMeta(`SELECT id FROM likes WHERE post_id = $1 AND user_id = $2`)
+	deleteLike     = regexp.QuoteMeta(`DELETE FROM likes l WHERE l.id = $1 AND l.user_id = $2`)
+	likeExists     = regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM likes WHERE id = $1)`)
+	insertOutbox   = regexp.QuoteMeta(`INSERT INTO outbox`)
+	internalDetail = "database failed"
+)
+
+func newTestLikesHandler(t *testing.T) (LikesHandler, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock := newTestDB(t)
+	logger := utils.NewLogger()
+	return NewLikesHandler(LikesHandlerConfig{
+		LikesRepo: store.NewLikesRepo(db, logger),
+		Logger:    logger,
+	}), mock
+}
+
+func TestLike(t *testing.T) {
+	actorID, postID, authorID, likedID := newID(), newID(), newID(), newID()
+
+	tests := []struct {
+		name   string
+		expect func(sqlmock.Sqlmock)
+		want   int
+		// wantID is the id of the like returned, empty for a new like
+		wantID string
+	}{
+		{"liked", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(insertLike).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(authorID))
+			mock.ExpectExec(insertOutbox).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, http.StatusCreated, ""},
+		{"liked again", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(insertLike).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+			mock.ExpectQuery(selectLike).WithArgs(postID, actorID).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(likedID))
+			mock.ExpectRollback()
+		}, http.StatusCreated, likedID},
+		{"unknown post", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(insertLike).WillReturnError(&pq.Error{Code: "23503"})
+			mock.ExpectRollback()
+		}, http.StatusNotFound, ""},
+		{"database error", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(insertLike).WillReturnError(errDatabaseFailed)
+			mock.ExpectRollback()
+		}, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newTestLikesHandler(t)
+			mock.ExpectBegin()
+			tt.expect(mock)
+
+			w := serveAs(t, h.Like, actorID, httptest.NewRequest("POST", "/likes/"+postID, nil), "postId", postID)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if strings.Contains(w.Body.String(), internalDetail) {
+				t.Errorf("response leaks the error: %s", w.Body)
+			}
+			if tt.wantID == "" {
+				return
+			}
+
+			var like models.Likes
+			decodeResponse(t, w, &like)
+			if like.ID != tt.wantID {
+				t.Errorf("got like %s want %s", like.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestUnlike(t *testing.T) {
+	actorID, likeID := newID(), newID()
+
+	tests := []struct {
+		name   string
+		expect func(sqlmock.Sqlmock)
+		want   int
+	}{
+		{"unliked", func(mock sqlmock.Sqlmock) {
+			rows := sqlmock.NewRows([]string{"post_id", "user_id"}).AddRow(newID(), newID())
+			mock.ExpectQuery(deleteLike).WithArgs(likeID, actorID).WillReturnRows(rows)
+			mock.ExpectExec(insertOutbox).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, http.StatusNoContent},
+		{"like of another user", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(deleteLike).WithArgs(likeID, actorID).WillReturnRows(sqlmock.NewRows([]string{"post_id", "user_id"}))
+			mock.ExpectQuery(likeExists).WithArgs(likeID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectRollback()
+		}, http.StatusForbidden},
+		{"not found", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(deleteLike).WithArgs(likeID, actorID).WillReturnRows(sqlmock.NewRows([]string{"post_id", "user_id"}))
+			mock.ExpectQuery(likeExists).WithArgs(likeID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			mock.ExpectRollback()
+		}, http.StatusNotFound},
+		{"database error", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(deleteLike).WillReturnError(errDatabaseFailed)
+			mock.ExpectRollback()
+		}, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newTestLikesHandler(t)
+			mock.ExpectBegin()
+			tt.expect(mock)
+
+			w := serveAs(t, h.Unlike, actorID, httptest.NewRequest("DELETE", "/likes/"+likeID, nil), "likesId", likeID)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if strings.Contains(w.Body.String(), internalDetail) {
+				t.Errorf("response leaks the error: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestGetPostLikesHidesErrors(t *testing.T) {
+	h, mock := newTestLikesHandler(t)
+	postID := newID()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM likes WHERE post_id = $1`)).WithArgs(postID).WillReturnError(errDatabaseFailed)
+
+	w := serveAs(t, h.GetPostLikes, newID(), httptest.NewRequest("GET", "/likes/"+postID, nil), "postId", postID)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("got status %d want %d", w.Code, http.StatusInternalServerError)
+	}
+	if strings.Contains(w.Body.String(), internalDetail) {
+		t.Errorf("response leaks the error: %s", w.Body)
+	}
+}
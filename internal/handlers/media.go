@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/storage"
+	"github.com/cakra17/social/internal/utils"
+	. "github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/urlsign"
+)
+
+// media keys are unique per upload, so a served file never changes
+const mediaCacheControl = "public, max-age=31536000, immutable"
+
+type MediaHandler struct {
+	mediaStore storage.MediaStore
+	signer     *urlsign.Signer
+	logger     *utils.Logger
+}
+
+type MediaHandlerConfig struct {
+	MediaStore storage.MediaStore
+	// Signer, when set, makes the handler only serve URLs it signed.
+	Signer *urlsign.Signer
+	Logger *utils.Logger
+}
+
+func NewMediaHandler(cfg MediaHandlerConfig) MediaHandler {
+	return MediaHandler{
+		mediaStore: cfg.MediaStore,
+		signer:     cfg.Signer,
+		logger:     cfg.Logger,
+	}
+}
+
+// setMediaURLs fills the media URLs of posts from their stored media keys.
+// The stores only return posts actorID may see, posts that are not ready
+// are still checked again so their media is never signed for others.
+func setMediaURLs(ms storage.MediaStore, actorID string, posts ...*models.Post) {
+	for _, post := range posts {
+		if post.Status != models.PostStatusReady && post.UserID != actorID {
+			continue
+		}
+		for i := range post.Media {
+			m := &post.Media[i]
+			m.URL = ms.URL(m.Key)
+			for j := range m.Variants {
+				m.Variants[j].URL = ms.URL(m.Variants[j].Key)
+			}
+		}
+		if len(post.Media) > 0 {
+			post.MediaURL = post.Media[0].URL
+		}
+	}
+}
+
+func setMediaURLsOf(ms storage.MediaStore, actorID string, posts []models.Post) {
+	for i := range posts {
+		setMediaURLs(ms, actorID, &posts[i])
+	}
+}
+
+// ServeMedia streams a stored file. Conditional requests are answered with
+// 304 and Range requests with 206 by http.ServeContent.
+func (h *MediaHandler) ServeMedia(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if strings.HasPrefix(key, chunkKeyPrefix) {
+		WriteError(w, ErrMediaNotFound)
+		return
+	}
+
+	cacheControl := mediaCacheControl
+	if h.signer != nil {
+		expiresAt, err := h.signer.Verify(key, r.URL.Query())
+		if err != nil {
+			WriteError(w, ErrInvalidMediaURL)
+			return
+		}
+		// a signed URL must not outlive its expiry in any cache
+		cacheControl = fmt.Sprintf("private, max-age=%d", int(time.Until(expiresAt).Seconds()))
+	}
+
+	obj, err := h.mediaStore.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			WriteError(w, ErrMediaNotFound)
+			return
+		}
+		h.logger.Error("Media Handler Error", "Failed to get media", err.Error())
+		WriteError(w, ErrFailedToGetMedia)
+		return
+	}
+	defer obj.Body.Close()
+
+	if obj.ContentType != "" {
+		w.Header().Set("Content-Type", obj.ContentType)
+	}
+	if obj.ETag != "" {
+		w.Header().Set("ETag", obj.ETag)
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, key, obj.LastModified, obj.Body)
+}
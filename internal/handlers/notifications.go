@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	. "github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+	"github.com/cakra17/social/pkg/validation"
+)
+
+type NotificationHandler struct {
+	notificationRepo store.NotificationRepo
+	logger           *utils.Logger
+}
+
+type NotificationHandlerConfig struct {
+	NotificationRepo store.NotificationRepo
+	Logger           *utils.Logger
+}
+
+func NewNotificationHandler(cfg NotificationHandlerConfig) NotificationHandler {
+	return NotificationHandler{
+		notificationRepo: cfg.NotificationRepo,
+		logger:           cfg.Logger,
+	}
+}
+
+// GetNotifications lists the notifications of the user, only the unread
+// ones with ?unread=true, along with the unread count.
+func (h *NotificationHandler) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	page, err := pagination.Parse(r)
+	if err != nil {
+		h.logger.Error("Notification Handler Error", "Invalid page", err.Error())
+		WriteError(w, ErrInvalidPage)
+		return
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	notifications, next, err := h.notificationRepo.List(ctx, userID, unreadOnly, page)
+	if err != nil {
+		h.logger.Error("Notification Handler Error", "Failed to get notifications", err.Error())
+		WriteError(w, ErrFailedToGetNotifications)
+		return
+	}
+
+	unread, err := h.notificationRepo.UnreadCount(ctx, userID)
+	if err != nil {
+		h.logger.Error("Notification Handler Error", "Failed to count notifications", err.Error())
+		WriteError(w, ErrFailedToGetNotifications)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code: http.StatusOK,
+		Data: models.NotificationList{
+			Notifications: notifications,
+			UnreadCount:   unread,
+		},
+		NextCursor: next,
+	})
+}
+
+func (h *NotificationHandler) GetUnreadCount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	unread, err := h.notificationRepo.UnreadCount(ctx, userID)
+	if err != nil {
+		h.logger.Error("Notification Handler Error", "Failed to count notifications", err.Error())
+		WriteError(w, ErrFailedToGetNotifications)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code: http.StatusOK,
+		Data: map[string]int{"unread_count": unread},
+	})
+}
+
+// MarkRead marks the notifications listed in the body as read, or all of
+// them when the body is empty.
+func (h *NotificationHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	var payload models.MarkNotificationsReadPayload
+
+	if err := utils.ParseBody(r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		h.logger.Error("Notification Handler Error", "Failed to decode payload", err.Error())
+		WriteError(w, ErrPayloadMalformed)
+		return
+	}
+
+	if err := validation.Validate(&payload); err != nil {
+		h.logger.Error("Notification Handler Error", "Failed to validate payload", err)
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	if _, err := h.notificationRepo.MarkRead(ctx, userID, payload.IDs); err != nil {
+		h.logger.Error("Notification Handler Error", "Failed to mark notifications", err.Error())
+		WriteError(w, ErrFailedToUpdateNotifications)
+		return
+	}
+
+	unread, err := h.notificationRepo.UnreadCount(ctx, userID)
+	if err != nil {
+		h.logger.Error("Notification Handler Error", "Failed to count notifications", err.Error())
+		WriteError(w, ErrFailedToGetNotifications)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code:    http.StatusOK,
+		Message: "Notifications marked as read",
+		Data:    map[string]int{"unread_count": unread},
+	})
+}
@@ -1,249 +1,476 @@
 package handlers
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
-	"time"
+	"slices"
+	"unicode/utf8"
 
+	"github.com/cakra17/social/internal/imaging"
 	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/storage"
 	"github.com/cakra17/social/internal/store"
 	"github.com/cakra17/social/internal/utils"
 	. "github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/internal/video"
+	"github.com/cakra17/social/internal/worker"
+	"github.com/cakra17/social/pkg/pagination"
 	"github.com/google/uuid"
 )
 
 const (
+	// MaxUploadSize is the limit of a single media part.
 	MaxUploadSize = 10 << 20
-	UploadDir = "./uploads"
+	// media parts beyond this size are spooled to disk while parsing
+	maxFormMemory    = 32 << 20
+	maxAltTextLength = 1000
 )
 
-var allowedType map[string]bool = map[string]bool{
-	".jpg": true,
-	".jpeg": true,
-	".png": true,
-}
-
 type PostHandler struct {
-	postRepo store.PostRepo
-	logger *utils.Logger
+	postRepo   store.PostRepo
+	uploadRepo store.UploadRepo
+	mediaStore storage.MediaStore
+	staging    storage.MediaStore
+	queue      *worker.Queue
+	logger     *utils.Logger
 }
 
 type PostHandlerConfig struct {
-	PostRepo store.PostRepo
+	PostRepo   store.PostRepo
+	UploadRepo store.UploadRepo
+	MediaStore storage.MediaStore
+	// Staging is where the chunks of uploads attached to posts are read
+	// from.
+	Staging storage.MediaStore
+	// Queue runs the transcoding of video posts.
+	Queue  *worker.Queue
 	Logger *utils.Logger
 }
 
 func NewPostHandler(cfg PostHandlerConfig) PostHandler {
 	return PostHandler{
-		postRepo: cfg.PostRepo,
-		logger: cfg.Logger,
+		postRepo:   cfg.PostRepo,
+		uploadRepo: cfg.UploadRepo,
+		mediaStore: cfg.MediaStore,
+		staging:    cfg.Staging,
+		queue:      cfg.Queue,
+		logger:     cfg.Logger,
 	}
 }
 
-func generateUniqueFilename(originalFilename string) string {
-	ext := filepath.Ext(originalFilename)
-	timestamp := time.Now().Unix()
-	hash := sha256.Sum256(fmt.Appendf(nil, "%s%d", ext, timestamp))
-	hashStr := hex.EncodeToString(hash[:])[:16]
-	return fmt.Sprintf("%d_%s%s", timestamp, hashStr, ext)
-}
+// mediaSource opens the content of a media item, a multipart part or a
+// finalized upload.
+type mediaSource func() (io.ReadCloser, error)
 
-func deletePhoto(filepath string) error {
-	if err := os.Remove(filepath); err != nil {
-		return err
+func partSource(file *multipart.FileHeader) mediaSource {
+	return func() (io.ReadCloser, error) {
+		return file.Open()
 	}
-	return  nil
 }
 
-func uploadPhoto(filepath string, media multipart.File) error {
-	dst, err := os.Create(filepath)
-	if err != nil {
-		log.Println("Failed to save a file")
-		return fmt.Errorf("Failed to save a file")
+func chunkSource(ctx context.Context, staging storage.MediaStore, keys []string) mediaSource {
+	return func() (io.ReadCloser, error) {
+		return storage.Concat(ctx, staging, keys), nil
 	}
-	defer dst.Close()
+}
 
-	_, err = io.Copy(dst, media)
+// processPhoto decodes an image and renders its size variants.
+func processPhoto(media io.Reader) ([]imaging.Variant, error) {
+	data, err := io.ReadAll(media)
 	if err != nil {
-		log.Println("Failed to save a file")
-		return fmt.Errorf("Failed to save a file")
+		return nil, err
 	}
-	return nil
+	return imaging.Process(data, imaging.DefaultLimits)
 }
 
-func (h *PostHandler) Init() {
-	if err := os.MkdirAll(UploadDir, 0755); err != nil {
-		log.Printf("Failed to create directory: %s", err.Error())
-		return
+// writeUploadError tells the client why an upload was rejected.
+func writeUploadError(w http.ResponseWriter, err error) {
+	var imgErr *imaging.Error
+	switch {
+	case errors.As(err, &imgErr) && errors.Is(err, imaging.ErrUnsupportedFormat):
+		WriteError(w, ErrInvalidFileType.WithDetails(imgErr.Details))
+	case errors.As(err, &imgErr):
+		WriteError(w, ErrInvalidUploadedFile.WithDetails(imgErr.Details))
+	default:
+		WriteError(w, ErrFailedToUploadPhoto)
 	}
 }
 
-func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
-	caption := r.FormValue("caption")
-	userid := r.FormValue("userID")
-
-	// limit file photo size
-	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
-	if err := r.ParseMultipartForm(MaxUploadSize); err != nil {
+// readMediaForm parses the multipart form of a post and returns its media
+// parts, or the ids of the finalized uploads holding its media, with their
+// alt texts matched by order. The error response is already written when ok
+// is false.
+func (h *PostHandler) readMediaForm(w http.ResponseWriter, r *http.Request) (files []*multipart.FileHeader, uploadIDs, alts []string, ok bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, store.MaxPostMedia*MaxUploadSize)
+	if err := r.ParseMultipartForm(maxFormMemory); err != nil {
 		h.logger.Error("Post Handler Error", "Failed to retrive data", err.Error())
 		WriteError(w, ErrInvalidFileSize)
-		return
-	}	
+		return nil, nil, nil, false
+	}
+
+	files = r.MultipartForm.File["media"]
+	uploadIDs = r.MultipartForm.Value["upload_id"]
+	alts = r.MultipartForm.Value["alt_text"]
+
+	switch {
+	case len(files) > 0 && len(uploadIDs) > 0:
+		WriteError(w, ErrInvalidPayload.WithDetails("media parts and upload_id can't be combined"))
+		return nil, nil, nil, false
+	case len(files)+len(uploadIDs) > store.MaxPostMedia:
+		WriteError(w, ErrInvalidUploadedFile.WithDetails(fmt.Sprintf("a post holds at most %d media", store.MaxPostMedia)))
+		return nil, nil, nil, false
+	case len(alts) > len(files)+len(uploadIDs):
+		WriteError(w, ErrInvalidPayload.WithDetails("there are more alt_text values than media"))
+		return nil, nil, nil, false
+	}
+
+	for i, file := range files {
+		if file.Size > MaxUploadSize {
+			WriteError(w, ErrInvalidFileSize.WithDetails(fmt.Sprintf("media %d is too large", i+1)))
+			return nil, nil, nil, false
+		}
+	}
+	for i, alt := range alts {
+		if utf8.RuneCountInString(alt) > maxAltTextLength {
+			WriteError(w, ErrInvalidPayload.WithDetails(fmt.Sprintf("alt_text %d is longer than %d characters", i+1, maxAltTextLength)))
+			return nil, nil, nil, false
+		}
+	}
+
+	return files, uploadIDs, alts, true
+}
+
+// uploadMedia runs every media item through the image pipeline and stores
+// its variants. Nothing stays stored when one of them fails.
+func (h *PostHandler) uploadMedia(ctx context.Context, sources []mediaSource, alts []string) ([]models.PostMedia, error) {
+	media := make([]models.PostMedia, 0, len(sources))
+	for i, source := range sources {
+		item, err := h.uploadPhoto(ctx, source)
+		if err != nil {
+			h.deletePhoto(ctx, store.MediaKeys(media))
+
+			var imgErr *imaging.Error
+			if errors.As(err, &imgErr) {
+				imgErr.Details = fmt.Sprintf("media %d: %s", i+1, imgErr.Details)
+			}
+			return nil, err
+		}
+
+		item.Position = i
+		if i < len(alts) {
+			item.AltText = alts[i]
+		}
+		media = append(media, item)
+	}
+	return media, nil
+}
 
-	media, header, err := r.FormFile("media")
+// uploadPhoto stores every variant of an image under a new key. The full
+// variant is the media item itself.
+func (h *PostHandler) uploadPhoto(ctx context.Context, source mediaSource) (models.PostMedia, error) {
+	f, err := source()
 	if err != nil {
-		h.logger.Error("Post Handler Error", "Failed to retrive data", err.Error())
-		WriteError(w, CustomError{
-			Code: http.StatusBadRequest,
-			Message: "Failed retrieving data",
+		return models.PostMedia{}, err
+	}
+	defer f.Close()
+
+	variants, err := processPhoto(f)
+	if err != nil {
+		return models.PostMedia{}, err
+	}
+
+	item := models.PostMedia{ID: uuid.Must(uuid.NewV7()).String()}
+	base := uuid.NewString()
+	for _, v := range variants {
+		key := base + "_" + v.Name + v.Ext
+		if err := h.mediaStore.Put(ctx, key, bytes.NewReader(v.Data), int64(len(v.Data)), v.ContentType); err != nil {
+			h.deletePhoto(ctx, store.MediaKeys([]models.PostMedia{item}))
+			return models.PostMedia{}, err
+		}
+
+		item.Variants = append(item.Variants, models.MediaVariant{
+			Name:   v.Name,
+			Key:    key,
+			Width:  v.Width,
+			Height: v.Height,
 		})
+		if v.Name == "full" {
+			item.Key, item.Width, item.Height = key, v.Width, v.Height
+		}
+	}
+
+	return item, nil
+}
+
+func (h *PostHandler) deletePhoto(ctx context.Context, keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		if err := h.mediaStore.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writePostError answers 403 when the post belongs to another user, 404 when
+// it doesn't exist and fallback otherwise.
+func writePostError(w http.ResponseWriter, err error, fallback CustomError) {
+	switch {
+	case errors.Is(err, policy.ErrForbidden):
+		WriteError(w, ErrForbidden)
+	case errors.Is(err, store.ErrPostNotFound):
+		WriteError(w, ErrPostNotFound)
+	default:
+		WriteError(w, fallback)
+	}
+}
+
+func (h *PostHandler) CreatePost(w http.ResponseWriter, r *http.Request) {
+	userid, ok := policy.ActorID(r.Context())
+	if !ok {
+		WriteError(w, ErrTokenExpires)
 		return
 	}
-	defer media.Close()
 
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if !allowedType[ext] {
-		h.logger.Error("Post Handler Error", "Failed to retrive data", "Invalid file type")
-		WriteError(w, ErrInvalidFileType)
+	files, uploadIDs, alts, ok := h.readMediaForm(w, r)
+	if !ok {
+		return
+	}
+	if len(files)+len(uploadIDs) == 0 {
+		WriteError(w, ErrInvalidUploadedFile.WithDetails("a post needs at least one media"))
 		return
 	}
 
-	filename := generateUniqueFilename(header.Filename)
-	filepath := filepath.Join(UploadDir, filename)
+	caption := r.FormValue("caption")
+
+	ctx := r.Context()
+	sources := make([]mediaSource, 0, len(files)+len(uploadIDs))
+	var chunkKeys []string
+	for _, file := range files {
+		sources = append(sources, partSource(file))
+	}
+	if len(uploadIDs) > 0 {
+		uploads, ok := h.getUploads(w, r, userid, uploadIDs)
+		if !ok {
+			return
+		}
+		if uploads[0].Kind == models.MediaKindVideo {
+			h.createVideoPost(w, r, userid, caption, uploads[0].ID)
+			return
+		}
+		for _, upload := range uploads {
+			keys, err := h.uploadRepo.ChunkKeys(ctx, upload.ID)
+			if err != nil {
+				h.logger.Error("Post Handler Error", "Failed to get upload chunks", err.Error())
+				WriteError(w, ErrFailedToCreatePost)
+				return
+			}
+			sources = append(sources, chunkSource(ctx, h.staging, keys))
+			chunkKeys = append(chunkKeys, keys...)
+		}
+	}
 
-	err = uploadPhoto(filepath, media)
+	media, err := h.uploadMedia(ctx, sources, alts)
 	if err != nil {
 		h.logger.Error("Post Handler Error", "Failed to Upload", err.Error())
-		WriteError(w, CustomError{
-			Code: http.StatusInternalServerError,
-			Message: "failed to upload image",
-		})
+		writeUploadError(w, err)
 		return
 	}
 
 	id, err := uuid.NewV7()
 	if err != nil {
+		h.deletePhoto(ctx, store.MediaKeys(media))
 		h.logger.Error("Post Handler Error", "Failed to create id", err.Error())
 		WriteError(w, ErrFailedToCreatePost)
 		return
 	}
-	
+
 	post := &models.Post{
-		ID: id.String(),
+		ID:      id.String(),
 		Caption: caption,
-		Media: filename,
-		UserID: userid,
+		Media:   media,
+		UserID:  userid,
 	}
+	setMediaURLs(h.mediaStore, userid, post)
 
-	ctx :=  r.Context()
-
-	err = h.postRepo.Create(ctx, post)
+	err = h.postRepo.Create(ctx, post, uploadIDs...)
 	if err != nil {
+		h.deletePhoto(ctx, store.MediaKeys(media))
 		h.logger.Error("Post Handler Error", "Failed to create post", err.Error())
 		log.Println(err.Error())
+		if errors.Is(err, store.ErrUploadNotReady) {
+			WriteError(w, ErrUploadNotReady)
+			return
+		}
 		WriteError(w, ErrFailedToCreatePost)
 		return
 	}
 
+	deleteChunks(ctx, h.staging, chunkKeys, h.logger)
+
 	WriteJson(w, CustomSuccess{
-		Code: http.StatusCreated,
+		Code:    http.StatusCreated,
 		Message: "Post Created successfully",
-		Data: post,
+		Data:    post,
 	})
-} 
+}
 
-func (h *PostHandler) UpdatePost(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	caption := r.FormValue("caption")
-	userID := r.FormValue("userID")
+// getUploads returns the finalized uploads a post is made from. They must
+// all be images or a single video. The error response is already written
+// when ok is false.
+func (h *PostHandler) getUploads(w http.ResponseWriter, r *http.Request, userID string, ids []string) (uploads []*models.Upload, ok bool) {
+	for i, id := range ids {
+		if slices.Contains(ids[:i], id) {
+			WriteError(w, ErrInvalidPayload.WithDetails(fmt.Sprintf("upload %s is used twice", id)))
+			return nil, false
+		}
+
+		upload, err := h.uploadRepo.Get(r.Context(), id, userID)
+		if err != nil {
+			h.logger.Error("Post Handler Error", "Failed to get upload", err.Error())
+			writeUploadRepoError(w, err, ErrFailedToCreatePost)
+			return nil, false
+		}
+		if !upload.Finalized() || upload.PostID != nil {
+			WriteError(w, ErrUploadNotReady.WithDetails(fmt.Sprintf("upload %s is not finalized or already used", id)))
+			return nil, false
+		}
+		uploads = append(uploads, upload)
+	}
 
-	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
-	if err := r.ParseMultipartForm(MaxUploadSize); err != nil {
-		h.logger.Error("Post Handler Error", "Failed to retrive data", err.Error())
-		WriteError(w, ErrInvalidFileSize)
+	for _, upload := range uploads {
+		if upload.Kind == models.MediaKindVideo && len(uploads) > 1 {
+			WriteError(w, ErrInvalidPayload.WithDetails("a video post can't hold other media"))
+			return nil, false
+		}
+	}
+	return uploads, true
+}
+
+// createVideoPost creates a post from a finalized video upload. The post stays
+// hidden from feeds until the video is processed.
+func (h *PostHandler) createVideoPost(w http.ResponseWriter, r *http.Request, userID, caption, uploadID string) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		h.logger.Error("Post Handler Error", "Failed to create id", err.Error())
+		WriteError(w, ErrFailedToCreatePost)
 		return
-	}	
+	}
+
+	post := &models.Post{
+		ID:      id.String(),
+		Caption: caption,
+		Media:   []models.PostMedia{},
+		UserID:  userID,
+	}
 
-	media, header, err := r.FormFile("media")
+	ctx := r.Context()
+	err = h.postRepo.CreateVideo(ctx, post, uploadID)
 	if err != nil {
-		h.logger.Error("Post Handler Error", "Failed to retrive data", err.Error())
-		WriteError(w, CustomError{
-			Code:http.StatusBadRequest, 
-			Message: "Failed retrieving data",
-		})
+		h.logger.Error("Post Handler Error", "Failed to create post", err.Error())
+		if errors.Is(err, store.ErrUploadNotReady) {
+			WriteError(w, ErrUploadNotReady)
+			return
+		}
+		WriteError(w, ErrFailedToCreatePost)
 		return
 	}
-	defer media.Close()
 
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if !allowedType[ext] {
-		h.logger.Error("Post Handler Error", "Failed to retrive data", "Invalid file type")
-		WriteError(w, ErrInvalidFileType)
+	err = h.queue.Enqueue(ctx, video.TranscodeJob{PostID: post.ID, UploadID: uploadID})
+	if err != nil {
+		h.logger.Error("Post Handler Error", "Failed to enqueue video", err.Error())
+		if err := h.postRepo.FailProcessing(ctx, post.ID, "the video could not be scheduled for processing"); err != nil {
+			h.logger.Error("Post Handler Error", "Failed to mark post as failed", err.Error())
+		}
+		WriteError(w, ErrFailedToCreatePost)
 		return
 	}
 
-	newFilename := generateUniqueFilename(header.Filename)
-	newFilepath := filepath.Join(UploadDir, newFilename)
+	WriteJson(w, CustomSuccess{
+		Code:    http.StatusAccepted,
+		Message: "Post created, the video is being processed",
+		Data:    post,
+	})
+}
+
+func (h *PostHandler) UpdatePost(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	userID, ok := policy.ActorID(r.Context())
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	files, uploadIDs, alts, ok := h.readMediaForm(w, r)
+	if !ok {
+		return
+	}
+	if len(uploadIDs) > 0 {
+		WriteError(w, ErrInvalidPayload.WithDetails("media of an existing post are replaced with media parts"))
+		return
+	}
+
+	caption := r.FormValue("caption")
 
 	ctx := r.Context()
-	oldFilename, err := h.postRepo.GetPhoto(ctx, id)
+	oldKeys, err := h.postRepo.GetMediaKeys(ctx, id, userID)
 	if err != nil {
 		h.logger.Error("Post Handler Error", "Failed to get expected post", err.Error())
-		WriteError(w, CustomError{
-			Code:http.StatusNotFound, 
-			Message: "Post not found",
-		})
+		writePostError(w, err, ErrFailedToGetPost)
 		return
 	}
 
-	oldFilepath := filepath.Join(UploadDir, oldFilename)
-
-	post := &models.Post{
-		ID: id,
-		Caption: caption,
-		Media: newFilename,
-		UserID: userID,
+	// without media parts only the caption changes
+	sources := make([]mediaSource, 0, len(files))
+	for _, file := range files {
+		sources = append(sources, partSource(file))
 	}
-
-	if err := uploadPhoto(newFilepath, media); err != nil {
+	media, err := h.uploadMedia(ctx, sources, alts)
+	if err != nil {
 		h.logger.Error("Post Handler Error", "Failed to upload", err.Error())
-		WriteError(w, CustomError{
-			Code:http.StatusInternalServerError,
-			Message: "Failed upload photo",
-		})
+		writeUploadError(w, err)
 		return
 	}
 
+	post := &models.Post{
+		ID:      id,
+		Caption: caption,
+		Media:   media,
+		UserID:  userID,
+	}
+
 	err = h.postRepo.Update(ctx, post)
 	if err != nil {
-		deletePhoto(newFilepath)
+		h.deletePhoto(ctx, store.MediaKeys(media))
 		h.logger.Error("Post Handler Error", "Failed to update post", err.Error())
-		WriteError(w, CustomError{
-			Code: http.StatusInternalServerError,
+		writePostError(w, err, CustomError{
+			Code:    http.StatusInternalServerError,
 			Message: "Failed to update post",
 		})
 		return
 	}
 
-	if err := deletePhoto(oldFilepath); err != nil {
+	if len(media) == 0 {
+		oldKeys = nil
+	}
+	if err := h.deletePhoto(ctx, oldKeys); err != nil {
 		h.logger.Error("Post Handler Error", "Failed to delete old photo", err.Error())
 		WriteError(w, CustomError{
-			Code:http.StatusInternalServerError,
+			Code:    http.StatusInternalServerError,
 			Message: "Failed to update post",
 		})
-    return
+		return
 	}
 
 	WriteJson(w, CustomSuccess{
-		Code: http.StatusOK,
+		Code:    http.StatusOK,
 		Message: "Post updated successfully",
 	})
 }
@@ -252,40 +479,120 @@ func (h *PostHandler) DeletePost(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
 	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
 
-	filename, err := h.postRepo.GetPhoto(ctx, id)
+	keys, err := h.postRepo.GetMediaKeys(ctx, id, userID)
 	if err != nil {
 		h.logger.Error("Post Handler Error", "Failed to get expected post", err.Error())
-		WriteError(w, CustomError{
-			Code: http.StatusNotFound, 
-			Message: "Post not found",
-		})
+		writePostError(w, err, ErrFailedToGetPost)
+		return
+	}
+	// a video still being processed keeps its upload
+	chunkKeys, err := h.uploadRepo.PostChunkKeys(ctx, id)
+	if err != nil {
+		h.logger.Error("Post Handler Error", "Failed to get upload chunks", err.Error())
+		WriteError(w, ErrFailedToGetPost)
 		return
 	}
 
-	err = h.postRepo.Delete(r.Context(), id)
+	err = h.postRepo.Delete(ctx, id, userID)
 	if err != nil {
 		h.logger.Error("Post Handler Error", "Failed to delete post", err.Error())
-		WriteError(w, CustomError{
-			Code: http.StatusInternalServerError,
+		writePostError(w, err, CustomError{
+			Code:    http.StatusInternalServerError,
 			Message: "Failed to delete post",
 		})
 		return
 	}
+	deleteChunks(ctx, h.staging, chunkKeys, h.logger)
 
-	filepath := filepath.Join(UploadDir, filename)
-
-	if err := deletePhoto(filepath); err != nil {
+	if err := h.deletePhoto(ctx, keys); err != nil {
 		h.logger.Error("Post Handler Error", "Failed to delete photo", err.Error())
 		WriteError(w, CustomError{
-			Code: http.StatusInternalServerError, 
+			Code:    http.StatusInternalServerError,
 			Message: "Failed to delete post",
 		})
 		return
 	}
 
 	WriteJson(w, CustomSuccess{
-		Code: http.StatusOK,
+		Code:    http.StatusOK,
 		Message: "Data deleted successfully",
 	})
-}
\ No newline at end of file
+}
+
+func (h *PostHandler) GetPost(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if uuid.Validate(id) != nil {
+		WriteError(w, ErrPostNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	actorID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	post, err := h.postRepo.GetByID(ctx, id, actorID)
+	if err != nil {
+		h.logger.Error("Post Handler Error", "Failed to get post", err.Error())
+		if errors.Is(err, store.ErrPostNotFound) {
+			WriteError(w, ErrPostNotFound)
+			return
+		}
+		WriteError(w, ErrFailedToGetPost)
+		return
+	}
+	setMediaURLs(h.mediaStore, actorID, post)
+
+	WriteJson(w, CustomSuccess{
+		Code: http.StatusOK,
+		Data: post,
+	})
+}
+
+func (h *PostHandler) GetUserPosts(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	if uuid.Validate(userID) != nil {
+		WriteError(w, ErrUserNotFound)
+		return
+	}
+
+	page, err := pagination.Parse(r)
+	if err != nil {
+		h.logger.Error("Post Handler Error", "Invalid page", err.Error())
+		WriteError(w, ErrInvalidPage)
+		return
+	}
+
+	ctx := r.Context()
+	actorID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	posts, next, err := h.postRepo.GetByUser(ctx, userID, actorID, page)
+	if err != nil {
+		h.logger.Error("Post Handler Error", "Failed to get user posts", err.Error())
+		if errors.Is(err, store.ErrUserNotFound) {
+			WriteError(w, ErrUserNotFound)
+			return
+		}
+		WriteError(w, ErrFailedToGetPost)
+		return
+	}
+	setMediaURLsOf(h.mediaStore, actorID, posts)
+
+	WriteJson(w, CustomSuccess{
+		Code:       http.StatusOK,
+		Data:       posts,
+		NextCursor: next,
+	})
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+)
+
+var (
+	selectPostByID    = regexp.QuoteMeta(`WHERE p.id = $1 AND (p.status = 'ready' OR p.user_id = $2)`)
+	selectUserPosts   = regexp.QuoteMeta(`WHERE p.user_id = $1 AND (p.status = 'ready' OR p.user_id = $3)`)
+	selectPostMedia   = regexp.QuoteMeta(`FROM post_media WHERE post_id = ANY($1)`)
+	selectUserExists  = regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`)
+	errDatabaseFailed = errors.New("database failed")
+)
+
+func newTestPostHandler(t *testing.T) (PostHandler, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock := newTestDB(t)
+	logger := utils.NewLogger()
+	return NewPostHandler(PostHandlerConfig{
+		PostRepo:   store.NewPostRepo(db, nil, logger),
+		UploadRepo: store.NewUploadRepo(db, logger),
+		MediaStore: newTestMediaStore(t),
+		Logger:     logger,
+	}), mock
+}
+
+func TestGetPost(t *testing.T) {
+	actorID, authorID, postID := newID(), newID(), newID()
+
+	tests := []struct {
+		name string
+		id   string
+		// rows is what the post query returns, nil when it fails
+		rows *sqlmock.Rows
+		want int
+	}{
+		{"found", postID, addPost(postRows(), postID, authorID, models.PostStatusReady), http.StatusOK},
+		{"not found", postID, postRows(), http.StatusNotFound},
+		{"not an id", "not-a-uuid", nil, http.StatusNotFound},
+		{"database error", postID, nil, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newTestPostHandler(t)
+			switch {
+			case tt.rows != nil:
+				mock.ExpectQuery(selectPostByID).WithArgs(tt.id, actorID).WillReturnRows(tt.rows)
+			case tt.id == postID:
+				mock.ExpectQuery(selectPostByID).WithArgs(tt.id, actorID).WillReturnError(errDatabaseFailed)
+			}
+			if tt.want == http.StatusOK {
+				media := mediaRows().AddRow(newID(), postID, 0, "image", "a_full.jpg", "", 10, 10, 0, []byte(`[]`))
+				mock.ExpectQuery(selectPostMedia).WillReturnRows(media)
+			}
+
+			w := serveAs(t, h.GetPost, actorID, httptest.NewRequest("GET", "/posts/"+tt.id, nil), "id", tt.id)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var post models.Post
+			decodeResponse(t, w, &post)
+			if post.ID != postID || len(post.Media) != 1 {
+				t.Fatalf("got %+v want post %s with one media", post, postID)
+			}
+			if post.MediaURL != "http://media.test/a_full.jpg" {
+				t.Errorf("got media url %q", post.MediaURL)
+			}
+		})
+	}
+}
+
+func TestGetUserPosts(t *testing.T) {
+	actorID, userID := newID(), newID()
+	first, second, third := newID(), newID(), newID()
+
+	tests := []struct {
+		name   string
+		userID string
+		limit  int
+		query  string
+		// posts is what the query returns, the page holds at most limit
+		posts      []string
+		exists     bool
+		want       int
+		wantPosts  int
+		wantCursor string
+	}{
+		{"page", userID, 20, "", []string{second, first}, true, http.StatusOK, 2, ""},
+		{"more posts", userID, 2, "?limit=2", []string{third, second, first}, true, http.StatusOK, 2, pagination.EncodeCursor(second)},
+		{"full last page", userID, 2, "?limit=2", []string{second, first}, true, http.StatusOK, 2, ""},
+		{"no posts", userID, 20, "", nil, true, http.StatusOK, 0, ""},
+		{"unknown user", userID, 20, "", nil, false, http.StatusNotFound, 0, ""},
+		{"not an id", "not-a-uuid", 20, "", nil, false, http.StatusNotFound, 0, ""},
+		{"invalid cursor", userID, 20, "?cursor=nope", nil, false, http.StatusBadRequest, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newTestPostHandler(t)
+			if tt.userID == userID && tt.want != http.StatusBadRequest {
+				rows := postRows()
+				for _, id := range tt.posts {
+					addPost(rows, id, userID, models.PostStatusReady)
+				}
+				mock.ExpectQuery(selectUserPosts).WithArgs(userID, tt.limit+1, actorID).WillReturnRows(rows)
+				if len(tt.posts) > 0 {
+					mock.ExpectQuery(selectPostMedia).WillReturnRows(mediaRows())
+				} else {
+					exists := sqlmock.NewRows([]string{"exists"}).AddRow(tt.exists)
+					mock.ExpectQuery(selectUserExists).WithArgs(userID).WillReturnRows(exists)
+				}
+			}
+
+			r := httptest.NewRequest("GET", "/users/"+tt.userID+"/posts"+tt.query, nil)
+			w := serveAs(t, h.GetUserPosts, actorID, r, "id", tt.userID)
+			if w.Code != tt.want {
+				t.Fatalf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var posts []models.Post
+			res := decodeResponse(t, w, &posts)
+			if len(posts) != tt.wantPosts {
+				t.Errorf("got %d posts want %d", len(posts), tt.wantPosts)
+			}
+			if res.NextCursor != tt.wantCursor {
+				t.Errorf("got cursor %q want %q", res.NextCursor, tt.wantCursor)
+			}
+		})
+	}
+}
+
+func TestDeletePostOwnership(t *testing.T) {
+	actorID, postID := newID(), newID()
+
+	tests := []struct {
+		name string
+		// ownerID is the author of the post, empty when it doesn't exist
+		ownerID string
+		want    int
+	}{
+		{"post of another user", newID(), http.StatusForbidden},
+		{"not found", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newTestPostHandler(t)
+			rows := sqlmock.NewRows([]string{"user_id"})
+			if tt.ownerID != "" {
+				rows.AddRow(tt.ownerID)
+			}
+			mock.ExpectQuery(regexp.QuoteMeta(`SELECT user_id FROM posts WHERE id = $1`)).WithArgs(postID).WillReturnRows(rows)
+
+			w := serveAs(t, h.DeletePost, actorID, httptest.NewRequest("DELETE", "/posts/"+postID, nil), "id", postID)
+			if w.Code != tt.want {
+				t.Errorf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestMediaURLsOnlyForVisiblePosts(t *testing.T) {
+	actorID, authorID := newID(), newID()
+
+	tests := []struct {
+		name    string
+		userID  string
+		status  string
+		wantURL bool
+	}{
+		{"ready post", authorID, models.PostStatusReady, true},
+		{"own processing post", actorID, models.PostStatusProcessing, true},
+		{"processing post of another user", authorID, models.PostStatusProcessing, false},
+		{"failed post of another user", authorID, models.PostStatusFailed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			post := &models.Post{
+				UserID: tt.userID,
+				Status: tt.status,
+				Media:  []models.PostMedia{{Key: "a_full.jpg"}},
+			}
+			setMediaURLs(newTestMediaStore(t), actorID, post)
+
+			if got := post.Media[0].URL != ""; got != tt.wantURL {
+				t.Errorf("got media url %q want one %v", post.Media[0].URL, tt.wantURL)
+			}
+		})
+	}
+}
+
+// carouselForm returns a post form with a png of each size as its media
+// parts, in order.
+func carouselForm(t *testing.T, alts []string, sizes ...int) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, size := range sizes {
+		part, err := mw.CreateFormFile("media", fmt.Sprintf("%d.png", i))
+		if err != nil {
+			t.Fatalf("Failed to create part: %v", err)
+		}
+		if err := png.Encode(part, image.NewRGBA(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatalf("Failed to encode image: %v", err)
+		}
+	}
+	for _, alt := range alts {
+		mw.WriteField("alt_text", alt)
+	}
+	mw.WriteField("caption", "carousel")
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/posts", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestCreateCarouselPost(t *testing.T) {
+	actorID := newID()
+	h, mock := newTestPostHandler(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO posts`)).
+		WithArgs(sqlmock.AnyArg(), "carousel", actorID, models.PostStatusReady).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at"}).AddRow(time.Now(), time.Now()))
+	// the media keep the order of the form with the alt text matched by it
+	insertMedia := regexp.QuoteMeta(`INSERT INTO post_media`)
+	mock.ExpectExec(insertMedia).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 0, models.MediaKindImage, sqlmock.AnyArg(), "first", 30, 30, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(insertMedia).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 1, models.MediaKindImage, sqlmock.AnyArg(), "", 20, 20, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox`)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := serveAs(t, h.CreatePost, actorID, carouselForm(t, []string{"first"}, 30, 20))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+
+	var post models.Post
+	decodeResponse(t, w, &post)
+	if len(post.Media) != 2 {
+		t.Fatalf("got %d media want 2", len(post.Media))
+	}
+	for i, m := range post.Media {
+		if m.Position != i || m.URL == "" {
+			t.Errorf("got media %d at position %d with url %q", i, m.Position, m.URL)
+		}
+	}
+	if post.Media[0].AltText != "first" || post.Media[0].Width != 30 {
+		t.Errorf("got first media %+v want alt text first and width 30", post.Media[0])
+	}
+}
+
+func TestCreatePostRejectsMedia(t *testing.T) {
+	tooMany := make([]int, store.MaxPostMedia+1)
+	for i := range tooMany {
+		tooMany[i] = 1
+	}
+
+	tests := []struct {
+		name  string
+		alts  []string
+		sizes []int
+		want  int
+	}{
+		{"no media", nil, nil, http.StatusBadRequest},
+		{"too many media", nil, tooMany, http.StatusBadRequest},
+		{"more alt texts than media", []string{"a", "b"}, []int{1}, http.StatusBadRequest},
+		{"alt text too long", []string{strings.Repeat("a", maxAltTextLength+1)}, []int{1}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// nothing is stored
+			h, _ := newTestPostHandler(t)
+
+			w := serveAs(t, h.CreatePost, newID(), carouselForm(t, tt.alts, tt.sizes...))
+			if w.Code != tt.want {
+				t.Errorf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/realtime"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	. "github.com/cakra17/social/internal/utils"
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// sseRetry is how long browsers wait before reconnecting a stream.
+	sseRetry = 3 * time.Second
+	// socketWriteWait bounds how long a write to a socket may block.
+	socketWriteWait = 10 * time.Second
+)
+
+type RealtimeHandler struct {
+	hub        *realtime.Hub
+	policy     *policy.Policy
+	tickets    *policy.Tickets
+	postRepo   store.PostRepo
+	origins    []string
+	heartbeat  time.Duration
+	revalidate time.Duration
+	logger     *utils.Logger
+}
+
+type RealtimeHandlerConfig struct {
+	Hub    *realtime.Hub
+	Policy *policy.Policy
+	// Tickets authenticate the streams of clients that can't send a bearer
+	// token.
+	Tickets  *policy.Tickets
+	PostRepo store.PostRepo
+	// Origins are the origins browsers may open WebSockets from.
+	Origins []string
+	// Heartbeat is how often an idle connection is written to, so proxies
+	// keep it open and dead clients are noticed.
+	Heartbeat time.Duration
+	// Revalidate is how often a connection checks that the token it was
+	// opened with was not revoked since.
+	Revalidate time.Duration
+	Logger     *utils.Logger
+}
+
+func NewRealtimeHandler(cfg RealtimeHandlerConfig) RealtimeHandler {
+	return RealtimeHandler{
+		hub:        cfg.Hub,
+		policy:     cfg.Policy,
+		tickets:    cfg.Tickets,
+		postRepo:   cfg.PostRepo,
+		origins:    cfg.Origins,
+		heartbeat:  cfg.Heartbeat,
+		revalidate: cfg.Revalidate,
+		logger:     cfg.Logger,
+	}
+}
+
+// CreateTicket returns a stream ticket for clients that open streams
+// without an Authorization header, it is sent as the ticket query
+// parameter and works once.
+func (h *RealtimeHandler) CreateTicket(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, ok := h.policy.Claims(ctx)
+	if !ok {
+		WriteError(w, ErrTokenNotContainsInfo)
+		return
+	}
+
+	ticket, err := h.tickets.Issue(ctx, claims)
+	if err != nil {
+		h.logger.Error("Realtime Handler Error", "Failed to issue ticket", err.Error())
+		WriteError(w, ErrFailedToOpenStream)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	WriteJson(w, CustomSuccess{
+		Code: http.StatusCreated,
+		Data: map[string]any{
+			"ticket":     ticket,
+			"expires_in": int(policy.StreamTicketTTL.Seconds()),
+		},
+	})
+}
+
+// socketCommand is sent by socket clients to change the posts they watch.
+type socketCommand struct {
+	Action  string   `json:"action"`
+	PostIDs []string `json:"post_ids"`
+}
+
+func validPostIDs(ids []string) bool {
+	for _, id := range ids {
+		if uuid.Validate(id) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// visiblePosts returns the posts of ids the user may see, with the rule
+// of GetByID, so nobody watches the like counts of a post they can't read.
+func (h *RealtimeHandler) visiblePosts(ctx context.Context, userID string, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	posts, err := h.postRepo.GetByIDs(ctx, ids, userID)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]string, len(posts))
+	for i, post := range posts {
+		visible[i] = post.ID
+	}
+	return visible, nil
+}
+
+// allowedOrigin reports whether a WebSocket may be opened from the origin
+// of the request. Browsers always send one, other clients are not exposed
+// to cross-site requests and may leave it out.
+func (h *RealtimeHandler) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.origins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// revoked reports whether the stream must end because its token was
+// revoked, which is assumed when that can't be checked.
+func (h *RealtimeHandler) revoked(ctx context.Context) bool {
+	revoked, err := h.policy.Revoked(ctx)
+	if err != nil {
+		h.logger.Error("Realtime Handler Error", "Failed to check token", err.Error())
+		return true
+	}
+	return revoked
+}
+
+// connect registers the client of the request, resuming after the
+// Last-Event-ID header or the last_event_id query parameter, and watching
+// the comma separated posts of the posts query parameter, which must all be
+// visible to the user.
+func (h *RealtimeHandler) connect(w http.ResponseWriter, r *http.Request) (*realtime.Client, []realtime.Message, bool) {
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return nil, nil, false
+	}
+
+	query := r.URL.Query()
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("last_event_id")
+	}
+	if lastEventID != "" && !realtime.ValidEventID(lastEventID) {
+		WriteError(w, ErrInvalidEventID)
+		return nil, nil, false
+	}
+
+	var postIDs []string
+	if v := query.Get("posts"); v != "" {
+		postIDs = strings.Split(v, ",")
+		slices.Sort(postIDs)
+		postIDs = slices.Compact(postIDs)
+	}
+	if len(postIDs) > realtime.MaxWatchedPosts || !validPostIDs(postIDs) {
+		WriteError(w, ErrInvalidPayload)
+		return nil, nil, false
+	}
+	visible, err := h.visiblePosts(ctx, userID, postIDs)
+	if err != nil {
+		h.logger.Error("Realtime Handler Error", "Failed to get posts", err.Error())
+		WriteError(w, ErrFailedToOpenStream)
+		return nil, nil, false
+	}
+	if len(visible) != len(postIDs) {
+		WriteError(w, ErrPostNotFound)
+		return nil, nil, false
+	}
+
+	client, missed, err := h.hub.Connect(ctx, userID, lastEventID)
+	if err != nil {
+		h.logger.Error("Realtime Handler Error", "Failed to connect client", err.Error())
+		WriteError(w, ErrFailedToOpenStream)
+		return nil, nil, false
+	}
+
+	if err := h.hub.Watch(ctx, client, postIDs...); err != nil {
+		h.hub.Disconnect(client)
+		h.logger.Error("Realtime Handler Error", "Failed to watch posts", err.Error())
+		WriteError(w, ErrFailedToOpenStream)
+		return nil, nil, false
+	}
+
+	// streams outlive the server timeouts meant for regular requests
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	return client, missed, true
+}
+
+// Events streams the messages of the user as server-sent events.
+func (h *RealtimeHandler) Events(w http.ResponseWriter, r *http.Request) {
+	client, missed, ok := h.connect(w, r)
+	if !ok {
+		return
+	}
+	defer h.hub.Disconnect(client)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds()); err != nil {
+		return
+	}
+
+	write := func(m realtime.Message) error {
+		if !client.Accept(m) {
+			return nil
+		}
+		if m.ID != "" {
+			if _, err := fmt.Fprintf(w, "id: %s\n", m.ID); err != nil {
+				return err
+			}
+		}
+		data := m.Data
+		if data == nil {
+			data = json.RawMessage("{}")
+		}
+		_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", m.Type, data)
+		return err
+	}
+
+	for _, m := range missed {
+		if err := write(m); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+	revalidate := time.NewTicker(h.revalidate)
+	defer revalidate.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.Done():
+			return
+		case <-revalidate.C:
+			if h.revoked(r.Context()) {
+				return
+			}
+		case m := <-client.Messages():
+			err = write(m)
+		case <-ticker.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Socket streams the messages of the user over a WebSocket. Clients may
+// send socketCommands to watch or unwatch posts.
+func (h *RealtimeHandler) Socket(w http.ResponseWriter, r *http.Request) {
+	// WebSockets are not bound by CORS, other sites must not open them with
+	// a ticket they got hold of
+	if !h.allowedOrigin(r) {
+		WriteError(w, ErrOriginNotAllowed)
+		return
+	}
+
+	client, missed, ok := h.connect(w, r)
+	if !ok {
+		return
+	}
+	defer h.hub.Disconnect(client)
+
+	server := websocket.Server{
+		// the origin is checked above, requests without one included
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			h.serveSocket(ws, client, missed)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+func (h *RealtimeHandler) serveSocket(ws *websocket.Conn, client *realtime.Client, missed []realtime.Message) {
+	ctx := ws.Request().Context()
+	userID, _ := policy.ActorID(ctx)
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(closed)
+		for {
+			var cmd socketCommand
+			if err := websocket.JSON.Receive(ws, &cmd); err != nil {
+				return
+			}
+			if len(cmd.PostIDs) > realtime.MaxWatchedPosts || !validPostIDs(cmd.PostIDs) {
+				continue
+			}
+
+			switch cmd.Action {
+			case "watch":
+				// posts the user may not see are left out
+				postIDs, err := h.visiblePosts(ctx, userID, cmd.PostIDs)
+				if err != nil {
+					h.logger.Error("Realtime Handler Error", "Failed to get posts", err.Error())
+					continue
+				}
+				if err := h.hub.Watch(ctx, client, postIDs...); err != nil && !errors.Is(err, realtime.ErrTooManyPosts) {
+					h.logger.Error("Realtime Handler Error", "Failed to watch posts", err.Error())
+				}
+			case "unwatch":
+				h.hub.Unwatch(ctx, client, cmd.PostIDs...)
+			}
+		}
+	}()
+
+	write := func(m realtime.Message) error {
+		if !client.Accept(m) {
+			return nil
+		}
+		ws.SetWriteDeadline(time.Now().Add(socketWriteWait))
+		return websocket.JSON.Send(ws, m)
+	}
+
+	for _, m := range missed {
+		if err := write(m); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+	revalidate := time.NewTicker(h.revalidate)
+	defer revalidate.Stop()
+
+	for {
+		var err error
+		select {
+		case <-closed:
+			return
+		case <-client.Done():
+			return
+		case <-revalidate.C:
+			if h.revoked(ctx) {
+				return
+			}
+		case m := <-client.Messages():
+			err = write(m)
+		case <-ticker.C:
+			err = write(realtime.Message{Type: realtime.TypeHeartbeat})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/realtime"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+)
+
+var selectPostsByIDs = regexp.QuoteMeta(`WHERE p.id = ANY($1) AND (p.status = 'ready' OR p.user_id = $2)`)
+
+// staticVersions is a jwt.VersionStore whose users are all at version 0
+// until they are revoked.
+type staticVersions struct{}
+
+func (staticVersions) TokenVersion(context.Context, string) (int64, error) { return 0, nil }
+
+func (staticVersions) BumpTokenVersion(_ context.Context, _ string, min int64) (int64, error) {
+	return min + 1, nil
+}
+
+type realtimeTest struct {
+	handler RealtimeHandler
+	mock    sqlmock.Sqlmock
+	auth    *jwt.JWTAuthenticator
+	authz   *policy.Policy
+	tickets *policy.Tickets
+}
+
+// newTestRealtime returns a handler whose streams check their token every
+// few milliseconds and never send heartbeats.
+func newTestRealtime(t *testing.T) realtimeTest {
+	t.Helper()
+
+	rdb, _ := newTestRedis(t)
+	db, mock := newTestDB(t)
+	logger := utils.NewLogger()
+
+	hub := realtime.NewHub(realtime.NewBroker(rdb, realtime.BrokerConfig{History: 10, HistoryTTL: time.Hour}), logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	go hub.Run(ctx)
+	t.Cleanup(func() {
+		hub.Close()
+		cancel()
+	})
+
+	auth := jwt.NewJWTAuthenticator("testsecret", time.Hour).WithDenylist(jwt.NewDenylist(rdb, staticVersions{}))
+	authz := policy.New(auth)
+	tickets := policy.NewTickets(rdb)
+	return realtimeTest{
+		handler: NewRealtimeHandler(RealtimeHandlerConfig{
+			Hub:        hub,
+			Policy:     authz,
+			Tickets:    tickets,
+			PostRepo:   store.NewPostRepo(db, nil, logger),
+			Origins:    []string{"https://app.example.com"},
+			Heartbeat:  time.Hour,
+			Revalidate: 10 * time.Millisecond,
+			Logger:     logger,
+		}),
+		mock:    mock,
+		auth:    auth,
+		authz:   authz,
+		tickets: tickets,
+	}
+}
+
+// token returns an access token of the user along with its claims.
+func (rt realtimeTest) token(t *testing.T, userID string) (string, gojwt.MapClaims) {
+	t.Helper()
+
+	token, err := rt.auth.GenerateToken(context.Background(), jwt.JWTUser{ID: userID})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	parsed, err := rt.auth.ValidateToken(token)
+	if err != nil {
+		t.Fatalf("Failed to validate token: %v", err)
+	}
+	return token, parsed.Claims.(gojwt.MapClaims)
+}
+
+// ticket returns a stream ticket issued with the token.
+func (rt realtimeTest) ticket(t *testing.T, token string) string {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/stream/ticket", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	rt.authz.Authenticate(http.HandlerFunc(rt.handler.CreateTicket)).ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+
+	var data struct {
+		Ticket string `json:"ticket"`
+	}
+	decodeResponse(t, w, &data)
+	return data.Ticket
+}
+
+func TestEventsEndWhenRevoked(t *testing.T) {
+	userID := newID()
+
+	tests := []struct {
+		name string
+		// ticket opens the stream with a ticket instead of the token
+		ticket bool
+		revoke func(ctx context.Context, auth *jwt.JWTAuthenticator, claims gojwt.MapClaims) error
+	}{
+		{"logout", false, func(ctx context.Context, auth *jwt.JWTAuthenticator, claims gojwt.MapClaims) error {
+			return auth.Revoke(ctx, claims)
+		}},
+		{"logout with ticket", true, func(ctx context.Context, auth *jwt.JWTAuthenticator, claims gojwt.MapClaims) error {
+			return auth.Revoke(ctx, claims)
+		}},
+		// a password change or logging out everywhere
+		{"user revoked", false, func(ctx context.Context, auth *jwt.JWTAuthenticator, _ gojwt.MapClaims) error {
+			return auth.RevokeUser(ctx, userID)
+		}},
+		{"user revoked with ticket", true, func(ctx context.Context, auth *jwt.JWTAuthenticator, _ gojwt.MapClaims) error {
+			return auth.RevokeUser(ctx, userID)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newTestRealtime(t)
+			srv := httptest.NewServer(rt.authz.AuthenticateTicket(rt.tickets)(http.HandlerFunc(rt.handler.Events)))
+			defer srv.Close()
+
+			token, claims := rt.token(t, userID)
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			if tt.ticket {
+				req.URL.RawQuery = "ticket=" + rt.ticket(t, token)
+			} else {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to open stream: %v", err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d want %d", res.StatusCode, http.StatusOK)
+			}
+			body := bufio.NewReader(res.Body)
+			if line, err := body.ReadString('\n'); err != nil || !strings.HasPrefix(line, "retry:") {
+				t.Fatalf("got %q, %v want the retry line", line, err)
+			}
+
+			if err := tt.revoke(context.Background(), rt.auth, claims); err != nil {
+				t.Fatalf("Failed to revoke: %v", err)
+			}
+
+			ended := make(chan error, 1)
+			go func() {
+				_, err := io.Copy(io.Discard, body)
+				ended <- err
+			}()
+			select {
+			case err := <-ended:
+				if err != nil {
+					t.Errorf("stream ended with %v want its end", err)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("stream still open after revoking its token")
+			}
+		})
+	}
+}
+
+func TestTicketWorksOnce(t *testing.T) {
+	rt := newTestRealtime(t)
+	userID := newID()
+	token, _ := rt.token(t, userID)
+	ticket := rt.ticket(t, token)
+
+	claims, err := rt.tickets.Redeem(context.Background(), ticket)
+	if err != nil {
+		t.Fatalf("Failed to redeem ticket: %v", err)
+	}
+	if claims["userId"] != userID {
+		t.Errorf("got user %v want %s", claims["userId"], userID)
+	}
+	if _, err := rt.tickets.Redeem(context.Background(), ticket); !errors.Is(err, policy.ErrInvalidTicket) {
+		t.Errorf("got error %v want %v", err, policy.ErrInvalidTicket)
+	}
+}
+
+func TestTicketOfRevokedToken(t *testing.T) {
+	rt := newTestRealtime(t)
+	token, claims := rt.token(t, newID())
+	ticket := rt.ticket(t, token)
+	if err := rt.auth.Revoke(context.Background(), claims); err != nil {
+		t.Fatalf("Failed to revoke: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	rt.authz.AuthenticateTicket(rt.tickets)(http.HandlerFunc(rt.handler.Events)).ServeHTTP(w, httptest.NewRequest("GET", "/stream/events?ticket="+ticket, nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+}
+
+func TestStreamOfHiddenPost(t *testing.T) {
+	rt := newTestRealtime(t)
+	actorID, postID := newID(), newID()
+	token, _ := rt.token(t, actorID)
+	// the post is unknown, or still processing and of another user
+	rt.mock.ExpectQuery(selectPostsByIDs).WithArgs(sqlmock.AnyArg(), actorID).WillReturnRows(postRows())
+
+	r := httptest.NewRequest("GET", "/stream/events?posts="+postID, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	rt.authz.Authenticate(http.HandlerFunc(rt.handler.Events)).ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d want %d: %s", w.Code, http.StatusNotFound, w.Body)
+	}
+}
+
+func TestSocketOrigin(t *testing.T) {
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{"allowed", "https://app.example.com", true},
+		{"allowed in another case", "https://App.Example.com", true},
+		// clients other than browsers send none
+		{"no origin", "", true},
+		{"another site", "https://evil.example.com", false},
+		{"another scheme", "http://app.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newTestRealtime(t)
+			r := httptest.NewRequest("GET", "/stream/socket", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+
+			if got := rt.handler.allowedOrigin(r); got != tt.want {
+				t.Errorf("got allowed %v want %v", got, tt.want)
+			}
+			if tt.want {
+				return
+			}
+
+			token, _ := rt.token(t, newID())
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			rt.authz.Authenticate(http.HandlerFunc(rt.handler.Socket)).ServeHTTP(w, r)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("got status %d want %d: %s", w.Code, http.StatusForbidden, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,519 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cakra17/social/internal/imaging"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/storage"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	. "github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/internal/video"
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxVideoSize is the largest file accepted by an upload.
+	MaxVideoSize = 256 << 20
+	// MaxChunkSize is the largest chunk accepted by a single PATCH.
+	MaxChunkSize = 8 << 20
+	// uploads must be finalized and attached to a post within this time
+	uploadTTL = 24 * time.Hour
+	// staged chunks older than this are deleted even if an upload still
+	// refers to them, which leaves a day to process the post it was
+	// attached to
+	chunkRetention = 2 * uploadTTL
+	// how long storing a chunk may take once its body arrived
+	chunkSaveTimeout = time.Minute
+
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,expiration,termination"
+	tusContentType = "application/offset+octet-stream"
+)
+
+// UploadHandler implements the core, creation, expiration and termination
+// parts of the tus resumable upload protocol (https://tus.io/protocols/resumable-upload).
+// A client creates an upload, PATCHes chunks at the offset returned by HEAD
+// until it is complete and finalizes it, after which it can be attached to
+// a post.
+type UploadHandler struct {
+	uploadRepo store.UploadRepo
+	staging    storage.MediaStore
+	logger     *utils.Logger
+}
+
+type UploadHandlerConfig struct {
+	UploadRepo store.UploadRepo
+	// Staging keeps the chunks of the uploads until they are attached to a
+	// post.
+	Staging storage.MediaStore
+	Logger  *utils.Logger
+}
+
+func NewUploadHandler(cfg UploadHandlerConfig) UploadHandler {
+	return UploadHandler{
+		uploadRepo: cfg.UploadRepo,
+		staging:    cfg.Staging,
+		logger:     cfg.Logger,
+	}
+}
+
+// chunkKeyPrefix starts the keys of upload chunks in the staging store.
+const chunkKeyPrefix = "upload-"
+
+// chunkKey names a chunk of an upload in the staging store, chunks written
+// concurrently at the same offset get different keys.
+func chunkKey(uploadID string) string {
+	return fmt.Sprintf("%s%s-%s", chunkKeyPrefix, uploadID, uuid.NewString())
+}
+
+// deleteChunks removes the chunks of uploads from the staging store.
+func deleteChunks(ctx context.Context, staging storage.MediaStore, keys []string, logger *utils.Logger) {
+	for _, key := range keys {
+		if err := staging.Delete(ctx, key); err != nil {
+			logger.Error("Upload Handler Error", "Failed to delete upload chunk", err.Error())
+		}
+	}
+}
+
+func writeUploadRepoError(w http.ResponseWriter, err error, fallback CustomError) {
+	switch {
+	case errors.Is(err, policy.ErrForbidden):
+		WriteError(w, ErrForbidden)
+	case errors.Is(err, store.ErrUploadNotFound):
+		WriteError(w, ErrUploadNotFound)
+	case errors.Is(err, store.ErrUploadNotReady):
+		WriteError(w, ErrUploadNotReady)
+	default:
+		WriteError(w, fallback)
+	}
+}
+
+func setUploadHeaders(w http.ResponseWriter, upload *models.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Received, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+// parseUploadMetadata decodes the comma separated "key base64value" pairs
+// of the Upload-Metadata header.
+func parseUploadMetadata(v string) (map[string]string, error) {
+	meta := map[string]string{}
+	if strings.TrimSpace(v) == "" {
+		return meta, nil
+	}
+
+	for pair := range strings.SplitSeq(v, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value of %s", key)
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
+
+// Tus answers every upload request with the protocol version and rejects
+// clients speaking another one.
+func (h *UploadHandler) Tus(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if v := r.Header.Get("Tus-Resumable"); v != "" && v != tusVersion && r.Method != http.MethodOptions {
+			w.Header().Set("Tus-Version", tusVersion)
+			WriteError(w, ErrTusVersion)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Options advertises what the server supports.
+func (h *UploadHandler) Options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(MaxVideoSize))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload creates an empty upload of Upload-Length bytes. The file
+// name can be sent as the filename key of Upload-Metadata.
+func (h *UploadHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		WriteError(w, ErrInvalidPayload.WithDetails("Upload-Length must be a positive integer"))
+		return
+	}
+	if size > MaxVideoSize {
+		WriteError(w, ErrUploadTooLarge.WithDetails(fmt.Sprintf("uploads are limited to %dmb", MaxVideoSize>>20)))
+		return
+	}
+
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		WriteError(w, ErrInvalidPayload.WithDetails(err.Error()))
+		return
+	}
+	filename := filepath.Base(meta["filename"])
+	if filename == "." || filename == "/" || len(filename) > 255 {
+		filename = ""
+	}
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		h.logger.Error("Upload Handler Error", "Failed to create id", err.Error())
+		WriteError(w, ErrFailedToUpload)
+		return
+	}
+
+	upload := &models.Upload{
+		ID:        id.String(),
+		UserID:    userID,
+		Filename:  filename,
+		Size:      size,
+		ExpiresAt: time.Now().Add(uploadTTL),
+	}
+
+	if err := h.uploadRepo.Create(ctx, upload); err != nil {
+		h.logger.Error("Upload Handler Error", "Failed to create upload", err.Error())
+		WriteError(w, ErrFailedToUpload)
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	w.Header().Set("Location", "/api/v1/uploads/"+upload.ID)
+	WriteJson(w, CustomSuccess{
+		Code:    http.StatusCreated,
+		Message: "Upload created successfully",
+		Data:    upload,
+	})
+}
+
+// HeadUpload tells a client where to resume an interrupted upload.
+func (h *UploadHandler) HeadUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	upload, err := h.uploadRepo.Get(ctx, id, userID)
+	if err != nil {
+		h.logger.Error("Upload Handler Error", "Failed to get upload", err.Error())
+		writeUploadRepoError(w, err, ErrFailedToUpload)
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload appends the body at Upload-Offset, which must be the number
+// of bytes received so far. The bytes that arrived before a disconnect are
+// kept, HEAD returns where to resume.
+//
+// The chunk is stored before the offset moves, no lock is held while the
+// body arrives. Chunks sent concurrently at the same offset are all stored
+// but only the first one recorded is kept, the others are rejected.
+func (h *UploadHandler) PatchUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	if r.Header.Get("Content-Type") != tusContentType {
+		WriteError(w, ErrUnsupportedMediaType.WithDetails("chunks must be sent as "+tusContentType))
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		WriteError(w, ErrInvalidPayload.WithDetails("Upload-Offset must be a non-negative integer"))
+		return
+	}
+	if r.ContentLength > MaxChunkSize {
+		WriteError(w, ErrUploadTooLarge.WithDetails(fmt.Sprintf("chunks are limited to %dmb", MaxChunkSize>>20)))
+		return
+	}
+
+	upload, err := h.uploadRepo.Get(ctx, id, userID)
+	if err == nil && upload.Finalized() {
+		err = store.ErrUploadNotFound
+	}
+	if err != nil {
+		h.logger.Error("Upload Handler Error", "Failed to get upload", err.Error())
+		writeUploadRepoError(w, err, ErrFailedToUpload)
+		return
+	}
+	setUploadHeaders(w, upload)
+
+	if offset != upload.Received {
+		WriteError(w, ErrUploadOffsetMismatch.WithDetails(fmt.Sprintf("next chunk must start at %d", upload.Received)))
+		return
+	}
+	if r.ContentLength > upload.Size-upload.Received {
+		WriteError(w, ErrInvalidPayload.WithDetails(errChunkTooLong.Error()))
+		return
+	}
+
+	// what arrived before a read error is kept
+	var chunk bytes.Buffer
+	body := http.MaxBytesReader(w, r.Body, MaxChunkSize)
+	_, readErr := chunk.ReadFrom(io.LimitReader(body, upload.Size-upload.Received))
+
+	if chunk.Len() > 0 {
+		// the request context is gone after a disconnect, the received part
+		// still has to be saved
+		saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), chunkSaveTimeout)
+		defer cancel()
+
+		saved, err := h.saveChunk(saveCtx, upload, &chunk, userID)
+		switch {
+		case errors.Is(err, store.ErrUploadOffsetMismatch):
+			if current, err := h.uploadRepo.Get(saveCtx, id, userID); err == nil {
+				upload = current
+				setUploadHeaders(w, upload)
+			}
+			WriteError(w, ErrUploadOffsetMismatch.WithDetails(fmt.Sprintf("next chunk must start at %d", upload.Received)))
+			return
+		case err != nil:
+			h.logger.Error("Upload Handler Error", "Failed to write chunk", err.Error())
+			WriteError(w, ErrFailedToUpload)
+			return
+		}
+		setUploadHeaders(w, saved)
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(readErr, &maxBytesErr):
+		WriteError(w, ErrUploadTooLarge.WithDetails(fmt.Sprintf("chunks are limited to %dmb", MaxChunkSize>>20)))
+	case readErr != nil:
+		h.logger.Error("Upload Handler Error", "Failed to read chunk", readErr.Error())
+		WriteError(w, ErrFailedToUpload)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// saveChunk stores a chunk read at the offset of upload and moves the
+// offset past it. The chunk is deleted again when it can't be recorded.
+func (h *UploadHandler) saveChunk(ctx context.Context, upload *models.Upload, chunk *bytes.Buffer, userID string) (*models.Upload, error) {
+	key := chunkKey(upload.ID)
+	size := int64(chunk.Len())
+	if err := h.staging.Put(ctx, key, chunk, size, tusContentType); err != nil {
+		return nil, err
+	}
+
+	saved, err := h.uploadRepo.AddChunk(ctx, upload.ID, userID, upload.Received, size, key)
+	if err != nil {
+		deleteChunks(ctx, h.staging, []string{key}, h.logger)
+		return nil, err
+	}
+	return saved, nil
+}
+
+var errChunkTooLong = errors.New("chunk goes past Upload-Length")
+
+func (h *UploadHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	upload, err := h.uploadRepo.Get(ctx, id, userID)
+	if err != nil {
+		h.logger.Error("Upload Handler Error", "Failed to get upload", err.Error())
+		writeUploadRepoError(w, err, ErrFailedToUpload)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code: http.StatusOK,
+		Data: upload,
+	})
+}
+
+// FinalizeUpload checks the content of a complete upload. Images are
+// validated like media parts and must fit in MaxUploadSize, videos are
+// checked by ffprobe once attached to a post.
+func (h *UploadHandler) FinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	upload, err := h.uploadRepo.Get(ctx, id, userID)
+	if err != nil {
+		h.logger.Error("Upload Handler Error", "Failed to get upload", err.Error())
+		writeUploadRepoError(w, err, ErrFailedToUpload)
+		return
+	}
+	if !upload.Complete() {
+		WriteError(w, ErrUploadNotReady.WithDetails(fmt.Sprintf("%d of %d bytes received", upload.Received, upload.Size)))
+		return
+	}
+
+	kind, err := h.detectKind(ctx, upload)
+	if err != nil {
+		h.logger.Error("Upload Handler Error", "Invalid upload", err.Error())
+		var imgErr *imaging.Error
+		switch {
+		case errors.Is(err, errUnknownUploadKind):
+			WriteError(w, ErrInvalidFileType.WithDetails(err.Error()))
+		case errors.Is(err, errImageTooLarge):
+			WriteError(w, ErrInvalidFileSize)
+		case errors.As(err, &imgErr):
+			writeUploadError(w, err)
+		default:
+			WriteError(w, ErrFailedToUpload)
+		}
+		return
+	}
+
+	upload, err = h.uploadRepo.Finalize(ctx, id, userID, kind)
+	if err != nil {
+		h.logger.Error("Upload Handler Error", "Failed to finalize upload", err.Error())
+		writeUploadRepoError(w, err, ErrFailedToUpload)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code:    http.StatusOK,
+		Message: "Upload finalized successfully",
+		Data:    upload,
+	})
+}
+
+var (
+	errUnknownUploadKind = errors.New("uploads must be an image or a video")
+	errImageTooLarge     = errors.New("image upload is too large")
+)
+
+// detectKind tells whether an upload holds an image or a video.
+func (h *UploadHandler) detectKind(ctx context.Context, upload *models.Upload) (string, error) {
+	keys, err := h.uploadRepo.ChunkKeys(ctx, upload.ID)
+	if err != nil {
+		return "", err
+	}
+	content := storage.Concat(ctx, h.staging, keys)
+	defer content.Close()
+
+	head := make([]byte, video.SniffLen)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	head = head[:n]
+
+	if _, err := imaging.Sniff(head); err == nil {
+		if upload.Size > MaxUploadSize {
+			return "", errImageTooLarge
+		}
+		rest, err := io.ReadAll(content)
+		if err != nil {
+			return "", err
+		}
+		if _, err := imaging.Validate(append(head, rest...), imaging.DefaultLimits); err != nil {
+			return "", err
+		}
+		return models.MediaKindImage, nil
+	}
+
+	if video.Sniff(head) {
+		return models.MediaKindVideo, nil
+	}
+	return "", errUnknownUploadKind
+}
+
+// DeleteUpload terminates an upload that is not attached to a post.
+func (h *UploadHandler) DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	keys, err := h.uploadRepo.Discard(ctx, id, userID)
+	if err != nil {
+		h.logger.Error("Upload Handler Error", "Failed to delete upload", err.Error())
+		writeUploadRepoError(w, err, ErrFailedToUpload)
+		return
+	}
+	deleteChunks(ctx, h.staging, keys, h.logger)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunCleanup deletes abandoned uploads and their chunks on schedule until
+// ctx is done. Chunks no upload refers to, like those stored by a replica
+// that stopped before recording them or those of deleted users, expire
+// after chunkRetention when the staging store supports it.
+func (h *UploadHandler) RunCleanup(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		h.cleanup(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *UploadHandler) cleanup(ctx context.Context) {
+	keys, err := h.uploadRepo.DeleteExpired(ctx)
+	if err != nil {
+		h.logger.Error("Upload Handler Error", "Failed to delete expired uploads", err.Error())
+	}
+	deleteChunks(ctx, h.staging, keys, h.logger)
+
+	if expirer, ok := h.staging.(storage.Expirer); ok {
+		if _, err := expirer.DeleteBefore(ctx, chunkKeyPrefix, time.Now().Add(-chunkRetention)); err != nil {
+			h.logger.Error("Upload Handler Error", "Failed to expire upload chunks", err.Error())
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/storage"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+)
+
+var (
+	selectUpload     = regexp.QuoteMeta(`FROM uploads WHERE id = $1 AND expires_at > NOW()`)
+	advanceUpload    = regexp.QuoteMeta(`UPDATE uploads SET received = received + $1`)
+	insertChunk      = regexp.QuoteMeta(`INSERT INTO upload_chunks`)
+	deleteExpiredIDs = regexp.QuoteMeta(`DELETE FROM upload_chunks c USING uploads u`)
+	deleteExpired    = regexp.QuoteMeta(`DELETE FROM uploads WHERE post_id IS NULL AND expires_at <= NOW()`)
+)
+
+// newTestUploadHandler returns a handler staging chunks in the returned
+// directory.
+func newTestUploadHandler(t *testing.T) (UploadHandler, sqlmock.Sqlmock, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	staging, err := storage.NewLocalStore(dir, "")
+	if err != nil {
+		t.Fatalf("Failed to create staging store: %v", err)
+	}
+
+	db, mock := newTestDB(t)
+	logger := utils.NewLogger()
+	return NewUploadHandler(UploadHandlerConfig{
+		UploadRepo: store.NewUploadRepo(db, logger),
+		Staging:    staging,
+		Logger:     logger,
+	}), mock, dir
+}
+
+func uploadRows(id, userID string, size, received int64) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"id", "user_id", "post_id", "filename", "size", "received",
+		"kind", "finalized_at", "expires_at", "created_at",
+	}).AddRow(id, userID, nil, "clip.mp4", size, received, "", nil, time.Now().Add(time.Hour), time.Now())
+}
+
+func stagedChunks(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read staging dir: %v", err)
+	}
+	var keys []string
+	for _, e := range entries {
+		keys = append(keys, e.Name())
+	}
+	return keys
+}
+
+func TestPatchUpload(t *testing.T) {
+	actorID, uploadID := newID(), newID()
+
+	tests := []struct {
+		name   string
+		offset string
+		expect func(sqlmock.Sqlmock)
+		want   int
+		// wantOffset is the Upload-Offset returned
+		wantOffset string
+		wantChunks int
+	}{
+		{"stored", "0", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(advanceUpload).WithArgs(5, uploadID, actorID, 0).WillReturnRows(uploadRows(uploadID, actorID, 10, 5))
+			mock.ExpectExec(insertChunk).WithArgs(uploadID, 0, 5, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, http.StatusNoContent, "5", 1},
+		{"wrong offset", "3", func(mock sqlmock.Sqlmock) {}, http.StatusConflict, "0", 0},
+		// another chunk was recorded at the offset while this one arrived
+		{"lost the race", "0", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(advanceUpload).WillReturnRows(sqlmock.NewRows(nil))
+			mock.ExpectRollback()
+			mock.ExpectQuery(selectUpload).WithArgs(uploadID).WillReturnRows(uploadRows(uploadID, actorID, 10, 5))
+		}, http.StatusConflict, "5", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock, dir := newTestUploadHandler(t)
+			mock.ExpectQuery(selectUpload).WithArgs(uploadID).WillReturnRows(uploadRows(uploadID, actorID, 10, 0))
+			tt.expect(mock)
+
+			r := httptest.NewRequest("PATCH", "/uploads/"+uploadID, strings.NewReader("hello"))
+			r.Header.Set("Content-Type", tusContentType)
+			r.Header.Set("Upload-Offset", tt.offset)
+			w := serveAs(t, h.PatchUpload, actorID, r, "id", uploadID)
+
+			if w.Code != tt.want {
+				t.Fatalf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if got := w.Header().Get("Upload-Offset"); got != tt.wantOffset {
+				t.Errorf("got offset %s want %s", got, tt.wantOffset)
+			}
+			// chunks that could not be recorded are not kept
+			if got := stagedChunks(t, dir); len(got) != tt.wantChunks {
+				t.Errorf("got staged chunks %v want %d", got, tt.wantChunks)
+			}
+		})
+	}
+}
+
+func TestUploadCleanup(t *testing.T) {
+	h, mock, dir := newTestUploadHandler(t)
+	ctx := context.Background()
+
+	old := time.Now().Add(-chunkRetention - time.Hour)
+	chunks := []struct {
+		key      string
+		modified time.Time
+		wantKept bool
+	}{
+		// a chunk of an expired upload
+		{chunkKeyPrefix + "expired", time.Now(), false},
+		// a chunk no upload refers to, like one of a deleted user
+		{chunkKeyPrefix + "orphan", old, false},
+		{chunkKeyPrefix + "current", time.Now(), true},
+	}
+	for _, c := range chunks {
+		if err := os.WriteFile(filepath.Join(dir, c.key), []byte("x"), 0o600); err != nil {
+			t.Fatalf("Failed to write chunk: %v", err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, c.key), c.modified, c.modified); err != nil {
+			t.Fatalf("Failed to set modification time: %v", err)
+		}
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(deleteExpiredIDs).WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow(chunkKeyPrefix + "expired"))
+	mock.ExpectExec(deleteExpired).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	h.cleanup(ctx)
+
+	kept := strings.Join(stagedChunks(t, dir), ",")
+	for _, c := range chunks {
+		if got := strings.Contains(kept, c.key); got != c.wantKept {
+			t.Errorf("%s kept %v want %v", c.key, got, c.wantKept)
+		}
+	}
+}
@@ -1,44 +1,234 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cakra17/social/internal/mail"
 	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
 	"github.com/cakra17/social/internal/store"
 	"github.com/cakra17/social/internal/utils"
 	. "github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/internal/worker"
 	"github.com/cakra17/social/pkg/jwt"
 	"github.com/cakra17/social/pkg/validation"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
+// unverifiedBatchSize is how many unverified accounts are mailed a
+// verification link at a time.
+const unverifiedBatchSize = 100
+
 type UserHandler struct {
-	userRepo store.UserRepo
-	redis *redis.Client
-	jwtAuthenticator *jwt.JWTAuthenticator
-	logger *utils.Logger
+	userRepo             store.UserRepo
+	tokenRepo            store.TokenRepo
+	accountTokenRepo     store.AccountTokenRepo
+	redis                *redis.Client
+	jwtAuthenticator     *jwt.JWTAuthenticator
+	refreshTokenTTL      time.Duration
+	queue                *worker.Queue
+	requireVerifiedEmail bool
+	verificationTTL      time.Duration
+	resendEvery          time.Duration
+	passwordResetTTL     time.Duration
+	passwordResetEvery   time.Duration
+	logger               *utils.Logger
 }
 
 type UserHandlerConfig struct {
-	UserRepo store.UserRepo
-	Redis *redis.Client
+	UserRepo         store.UserRepo
+	TokenRepo        store.TokenRepo
+	AccountTokenRepo store.AccountTokenRepo
+	Redis            *redis.Client
 	JWTAuthenticator *jwt.JWTAuthenticator
-	Logger *utils.Logger
+	RefreshTokenTTL  time.Duration
+	// Queue runs the jobs sending mail.
+	Queue *worker.Queue
+	// RequireVerifiedEmail makes the RequireVerifiedEmail middleware
+	// reject accounts whose address is not verified yet.
+	RequireVerifiedEmail bool
+	VerificationTTL      time.Duration
+	// ResendEvery is how often the verification mail may be asked for.
+	ResendEvery      time.Duration
+	PasswordResetTTL time.Duration
+	// PasswordResetEvery is how often a reset mail may be asked for per
+	// address.
+	PasswordResetEvery time.Duration
+	Logger             *utils.Logger
 }
 
 func NewUserHandler(cfg UserHandlerConfig) UserHandler {
 	return UserHandler{
-		userRepo: cfg.UserRepo,
-		redis: cfg.Redis,
-		jwtAuthenticator: cfg.JWTAuthenticator,
-		logger: cfg.Logger,
+		userRepo:             cfg.UserRepo,
+		tokenRepo:            cfg.TokenRepo,
+		accountTokenRepo:     cfg.AccountTokenRepo,
+		redis:                cfg.Redis,
+		jwtAuthenticator:     cfg.JWTAuthenticator,
+		refreshTokenTTL:      cfg.RefreshTokenTTL,
+		queue:                cfg.Queue,
+		requireVerifiedEmail: cfg.RequireVerifiedEmail,
+		verificationTTL:      cfg.VerificationTTL,
+		resendEvery:          cfg.ResendEvery,
+		passwordResetTTL:     cfg.PasswordResetTTL,
+		passwordResetEvery:   cfg.PasswordResetEvery,
+		logger:               cfg.Logger,
+	}
+}
+
+// authorize writes the error response and returns false when the actor may
+// not manage the account with the given id.
+func (h *UserHandler) authorize(w http.ResponseWriter, r *http.Request, id string) bool {
+	actorID, ok := policy.ActorID(r.Context())
+	if !ok {
+		WriteError(w, ErrTokenExpires)
+		return false
+	}
+
+	if err := policy.CanManageUser(actorID, id); err != nil {
+		h.logger.Error("User Handler Error", "Forbidden", err.Error())
+		WriteError(w, ErrForbidden)
+		return false
+	}
+	return true
+}
+
+// sendMail queues the mail template to the address in the language of the
+// request. Failing to queue is logged, callers sending mail on the side of
+// another action may ignore the error.
+func (h *UserHandler) sendMail(r *http.Request, to, template string, data map[string]string) error {
+	return h.queueMail(r.Context(), to, template, r.Header.Get("Accept-Language"), data)
+}
+
+func (h *UserHandler) queueMail(ctx context.Context, to, template, locale string, data map[string]string) error {
+	err := h.queue.Enqueue(ctx, mail.SendJob{
+		To:       to,
+		Template: template,
+		Locale:   locale,
+		Data:     data,
+	})
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to queue mail", template, err.Error())
+	}
+	return err
+}
+
+// newAccountToken stores a token of the purpose for the current address of
+// the user and returns it, replacing the earlier ones.
+func (h *UserHandler) newAccountToken(ctx context.Context, user *models.User, purpose string, ttl time.Duration) (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+
+	token, hash, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = h.accountTokenRepo.Create(ctx, &models.AccountToken{
+		ID:        id.String(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// sendVerification mails a new verification link to the user in the
+// language of the request.
+func (h *UserHandler) sendVerification(r *http.Request, user *models.User) error {
+	return h.mailVerification(r.Context(), user, r.Header.Get("Accept-Language"))
+}
+
+func (h *UserHandler) mailVerification(ctx context.Context, user *models.User, locale string) error {
+	token, err := h.newAccountToken(ctx, user, models.TokenPurposeVerifyEmail, h.verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return h.queueMail(ctx, user.Email, "verify_email", locale, map[string]string{
+		"Username": user.Username,
+		"Token":    token,
+	})
+}
+
+// RunVerificationMailer mails a verification link on schedule to the
+// unverified accounts that were never given one, the accounts created before
+// addresses were verified and those whose first link could not be stored,
+// until ctx is done. One replica does so per interval.
+func (h *UserHandler) RunVerificationMailer(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		h.mailUnverified(ctx, every)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *UserHandler) mailUnverified(ctx context.Context, every time.Duration) {
+	wait, err := h.throttle(ctx, "verify:unverified", every)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to throttle", err.Error())
+		return
+	}
+	if wait > 0 {
+		return
+	}
+
+	for {
+		users, err := h.userRepo.GetUnverifiedWithoutToken(ctx, unverifiedBatchSize)
+		if err != nil {
+			h.logger.Error("User Handler Error", "Failed to get unverified users", err.Error())
+			return
+		}
+		// a user whose token could not be stored would come back in the
+		// next batch, the next run tries again
+		for i := range users {
+			if err := h.mailVerification(ctx, &users[i], ""); err != nil {
+				h.logger.Error("User Handler Error", "Failed to send verification", err.Error())
+				return
+			}
+		}
+		if len(users) < unverifiedBatchSize {
+			return
+		}
 	}
 }
 
-func(h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+// throttle lets an action identified by key happen once per interval. It
+// returns how long to wait when the action happened too recently.
+func (h *UserHandler) throttle(ctx context.Context, key string, interval time.Duration) (time.Duration, error) {
+	ok, err := h.redis.SetNX(ctx, key, 1, interval).Result()
+	if err != nil || ok {
+		return 0, err
+	}
+
+	wait, err := h.redis.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	return max(wait, time.Second), nil
+}
+
+func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var payload models.RegisterPayload
 
 	if err := utils.ParseBody(r, &payload); err != nil {
@@ -77,12 +267,12 @@ func(h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user = &models.User{
-		ID: id.String(),
+		ID:       id.String(),
 		Username: payload.Username,
-		Email: payload.Email,
+		Email:    payload.Email,
 		Password: hashedPassword,
 	}
-	
+
 	err = h.userRepo.CreateUser(ctx, user)
 	if err != nil {
 		h.logger.Error("User Handler Error", "Failed to create user", err.Error())
@@ -90,13 +280,24 @@ func(h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the welcome mail carries the first verification link, a user whose
+	// token could not be stored asks for another one
+	data := map[string]string{"Username": user.Username}
+	token, err := h.newAccountToken(ctx, user, models.TokenPurposeVerifyEmail, h.verificationTTL)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to create verification token", err.Error())
+	} else {
+		data["Token"] = token
+	}
+	h.sendMail(r, user.Email, "welcome", data)
+
 	WriteJson(w, CustomSuccess{
 		Code: http.StatusCreated,
 		Data: user,
 	})
 }
 
-func(h *UserHandler) Authenticate(w http.ResponseWriter, r *http.Request) {
+func (h *UserHandler) Authenticate(w http.ResponseWriter, r *http.Request) {
 	var payload models.LoginPayload
 
 	if err := utils.ParseBody(r, &payload); err != nil {
@@ -119,7 +320,7 @@ func(h *UserHandler) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.redis.Set(ctx, user.ID, user, 30 * time.Second)
+	h.redis.Set(ctx, user.ID, user, 30*time.Second)
 
 	if ok := ComparePassword(payload.Password, user.Password); !ok {
 		h.logger.Error("User Handler Error", "Failed to login", "Wrong password")
@@ -127,42 +328,227 @@ func(h *UserHandler) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.jwtAuthenticator.GenerateToken(jwt.JWTUser{
-		ID: user.ID,
+	familyID, err := uuid.NewV7()
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to generate token", err.Error())
+		WriteError(w, ErrFailedToGenerateToken)
+		return
+	}
+
+	refreshToken, next, err := h.newRefreshToken(familyID.String())
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to generate token", err.Error())
+		WriteError(w, ErrFailedToGenerateToken)
+		return
+	}
+	next.UserID = user.ID
+
+	if err := h.tokenRepo.Create(ctx, next); err != nil {
+		h.logger.Error("User Handler Error", "Failed to save refresh token", err.Error())
+		WriteError(w, ErrFailedToGenerateToken)
+		return
+	}
+
+	token, err := h.jwtAuthenticator.GenerateToken(ctx, jwt.JWTUser{
+		ID:    user.ID,
 		Email: user.Email,
 	})
 
-	if err != nil {	
+	if err != nil {
 		h.logger.Error("User Handler Error", "Failed to generate token", err.Error())
 		WriteError(w, CustomError{
-			Code: http.StatusInternalServerError, 
+			Code:    http.StatusInternalServerError,
 			Message: err.Error(),
 		})
 		return
 	}
 
 	WriteJson(w, CustomSuccess{
-		Code: http.StatusOK,
+		Code:    http.StatusOK,
 		Message: "success to login",
 		Data: models.AuthResponse{
-			AccessToken: token,
+			AccessToken:  token,
+			RefreshToken: refreshToken,
+		},
+	})
+}
+
+// newRefreshToken returns a new opaque refresh token and the record to store
+// for it, the caller fills in the owner.
+func (h *UserHandler) newRefreshToken(familyID string) (string, *models.RefreshToken, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, hash, err := GenerateOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, &models.RefreshToken{
+		ID:        id.String(),
+		FamilyID:  familyID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(h.refreshTokenTTL),
+	}, nil
+}
+
+func (h *UserHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var payload models.RefreshTokenPayload
+
+	if err := utils.ParseBody(r, &payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to decode payload", err.Error())
+		WriteError(w, ErrPayloadMalformed)
+		return
+	}
+
+	if err := validation.Validate(&payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to validate payload", err)
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	refreshToken, next, err := h.newRefreshToken("")
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to generate token", err.Error())
+		WriteError(w, ErrFailedToGenerateToken)
+		return
+	}
+
+	ctx := r.Context()
+	err = h.tokenRepo.Rotate(ctx, HashOpaqueToken(payload.RefreshToken), next)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to rotate refresh token", err.Error())
+		if errors.Is(err, store.ErrInvalidRefreshToken) || errors.Is(err, store.ErrRefreshTokenReused) {
+			WriteError(w, ErrInvalidRefreshToken)
+			return
+		}
+		WriteError(w, ErrFailedToGenerateToken)
+		return
+	}
+
+	user, err := h.userRepo.GetUserById(ctx, next.UserID)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to get user", err.Error())
+		WriteError(w, ErrUserNotFound)
+		return
+	}
+
+	token, err := h.jwtAuthenticator.GenerateToken(ctx, jwt.JWTUser{
+		ID:    user.ID,
+		Email: user.Email,
+	})
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to generate token", err.Error())
+		WriteError(w, ErrFailedToGenerateToken)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code: http.StatusOK,
+		Data: models.AuthResponse{
+			AccessToken:  token,
+			RefreshToken: refreshToken,
 		},
 	})
 }
 
+// Logout ends the session of the request, the access token it was made
+// with and every refresh token of the session the given one belongs to are
+// revoked.
+func (h *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var payload models.RefreshTokenPayload
+
+	if err := utils.ParseBody(r, &payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to decode payload", err.Error())
+		WriteError(w, ErrPayloadMalformed)
+		return
+	}
+
+	if err := validation.Validate(&payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to validate payload", err)
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	claims, hasClaims := h.jwtAuthenticator.GetClaims(ctx)
+	if !ok || !hasClaims {
+		h.logger.Error("User Handler Error", "Failed get actor")
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	if err := h.jwtAuthenticator.Revoke(ctx, claims); err != nil {
+		h.logger.Error("User Handler Error", "Failed to logout", err.Error())
+		WriteError(w, CustomError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to logout",
+		})
+		return
+	}
+
+	err := h.tokenRepo.RevokeFamily(ctx, userID, HashOpaqueToken(payload.RefreshToken))
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to logout", err.Error())
+		if errors.Is(err, store.ErrInvalidRefreshToken) {
+			WriteError(w, ErrInvalidRefreshToken)
+			return
+		}
+		WriteError(w, CustomError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to logout",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll signs the user out of every device by revoking all of their
+// access and refresh tokens.
+func (h *UserHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		h.logger.Error("User Handler Error", "Failed get actor")
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	if err := h.revokeSessions(ctx, userID); err != nil {
+		h.logger.Error("User Handler Error", "Failed to logout", err.Error())
+		WriteError(w, CustomError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to logout",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeSessions invalidates every token issued to the user.
+func (h *UserHandler) revokeSessions(ctx context.Context, userID string) error {
+	if err := h.jwtAuthenticator.RevokeUser(ctx, userID); err != nil {
+		return err
+	}
+	return h.tokenRepo.RevokeAll(ctx, userID)
+}
+
 func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	var user *models.User
-	
+
 	ctx := r.Context()
-	claims, ok := h.jwtAuthenticator.GetClaims(ctx)
+	userID, ok := policy.ActorID(ctx)
 	if !ok {
-		h.logger.Error("User Handler Error", "Failed get claims")
+		h.logger.Error("User Handler Error", "Failed get actor")
 		WriteError(w, ErrTokenExpires)
 		return
 	}
 
-	userID, _ := claims["userId"].(string)
-
 	s, err := h.redis.Get(ctx, userID).Result()
 	if err != nil {
 		user, err = h.userRepo.GetUserById(ctx, userID)
@@ -170,7 +556,7 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 			WriteError(w, ErrUserNotFound)
 			return
 		}
-		err :=h.redis.Set(ctx, userID, user, time.Minute).Err()
+		err := h.redis.Set(ctx, userID, user, time.Minute).Err()
 		if err != nil {
 			h.logger.Error("User Handler Error", "Failed to save in redis", err.Error())
 		}
@@ -204,18 +590,35 @@ func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
 	ctx := r.Context()
-	err := h.userRepo.UpdateUser(ctx, &payload, id)
+	if !h.authorize(w, r, id) {
+		return
+	}
+
+	emailChanged, err := h.userRepo.UpdateUser(ctx, &payload, id)
 	if err != nil {
 		h.logger.Error("User Handler Error", "Failed to update user", err.Error())
+		if errors.Is(err, store.ErrUserNotFound) {
+			WriteError(w, ErrUserNotFound)
+			return
+		}
 		WriteError(w, CustomError{
-			Code :http.StatusInternalServerError, 
+			Code:    http.StatusInternalServerError,
 			Message: "Failed to update user",
 		})
 		return
-	}	
+	}
+
+	h.redis.Del(ctx, id)
+
+	if emailChanged {
+		user := &models.User{ID: id, Username: payload.Username, Email: payload.Email}
+		if err := h.sendVerification(r, user); err != nil {
+			h.logger.Error("User Handler Error", "Failed to send verification", err.Error())
+		}
+	}
 
 	WriteJson(w, CustomSuccess{
-		Code: http.StatusOK,
+		Code:    http.StatusOK,
 		Message: "Data Updated successfully",
 	})
 }
@@ -224,18 +627,365 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 
 	ctx := r.Context()
+	if !h.authorize(w, r, id) {
+		return
+	}
+
+	// the token version is kept on the user, so tokens are revoked before
+	// the user is gone
+	if err := h.jwtAuthenticator.RevokeUser(ctx, id); err != nil {
+		h.logger.Error("User Handler Error", "Failed to revoke tokens", err.Error())
+		WriteError(w, CustomError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to delete user",
+		})
+		return
+	}
+
 	err := h.userRepo.Delete(ctx, id)
 	if err != nil {
 		h.logger.Error("User Handler Error", "Failed to delete user", err.Error())
+		if errors.Is(err, store.ErrUserNotFound) {
+			WriteError(w, ErrUserNotFound)
+			return
+		}
 		WriteError(w, CustomError{
-			Code: http.StatusInternalServerError,
+			Code:    http.StatusInternalServerError,
 			Message: "Failed to delete user",
 		})
 		return
-	}	
+	}
 
 	WriteJson(w, CustomSuccess{
-		Code: http.StatusOK,
+		Code:    http.StatusOK,
 		Message: "Data deleted successfully",
 	})
 }
+
+// VerifyEmail marks the address the verification token was sent to as
+// verified. The token works once and does not need a session, the link may
+// be opened on another device.
+func (h *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var payload models.VerifyEmailPayload
+
+	if err := utils.ParseBody(r, &payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to decode payload", err.Error())
+		WriteError(w, ErrPayloadMalformed)
+		return
+	}
+
+	if err := validation.Validate(&payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to validate payload", err)
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	ctx := r.Context()
+	userID, err := h.userRepo.VerifyEmail(ctx, HashOpaqueToken(payload.Token))
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to verify email", err.Error())
+		if errors.Is(err, store.ErrInvalidAccountToken) {
+			WriteError(w, ErrInvalidVerificationToken)
+			return
+		}
+		WriteError(w, CustomError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to verify email",
+		})
+		return
+	}
+
+	h.redis.Del(ctx, userID)
+
+	WriteJson(w, CustomSuccess{
+		Code:    http.StatusOK,
+		Message: "Email verified successfully",
+	})
+}
+
+// ResendVerification mails a new verification link to the user, at most
+// once per resend interval.
+func (h *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		h.logger.Error("User Handler Error", "Failed get actor")
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	user, err := h.userRepo.GetUserById(ctx, userID)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to get user", err.Error())
+		WriteError(w, ErrUserNotFound)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		WriteError(w, ErrEmailAlreadyVerified)
+		return
+	}
+
+	wait, err := h.throttle(ctx, "verify:resend:"+userID, h.resendEvery)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to throttle", err.Error())
+		WriteError(w, ErrFailedToSendVerification)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+		WriteError(w, ErrTooManyRequests)
+		return
+	}
+
+	if err := h.sendVerification(r, user); err != nil {
+		h.logger.Error("User Handler Error", "Failed to send verification", err.Error())
+		WriteError(w, ErrFailedToSendVerification)
+		return
+	}
+
+	WriteJson(w, CustomSuccess{
+		Code:    http.StatusAccepted,
+		Message: "Verification email sent",
+	})
+}
+
+// ForgotPassword mails a password reset link to the address, at most once
+// per reset interval. The response is the same whether or not an account
+// uses the address, so it cannot be used to find out who signed up.
+func (h *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload models.ForgotPasswordPayload
+
+	if err := utils.ParseBody(r, &payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to decode payload", err.Error())
+		WriteError(w, ErrPayloadMalformed)
+		return
+	}
+
+	if err := validation.Validate(&payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to validate payload", err)
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	accepted := CustomSuccess{
+		Code:    http.StatusAccepted,
+		Message: "If an account uses this email, a password reset link was sent to it",
+	}
+
+	ctx := r.Context()
+	email := strings.ToLower(payload.Email)
+	wait, err := h.throttle(ctx, "password:forgot:"+email, h.passwordResetEvery)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to throttle", err.Error())
+		WriteError(w, ErrFailedToSendPasswordReset)
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Round(time.Second).Seconds())))
+		WriteError(w, ErrTooManyRequests)
+		return
+	}
+
+	user, err := h.userRepo.GetUserByEmail(ctx, payload.Email)
+	if err != nil {
+		if errors.Is(err, store.ErrUserNotFound) {
+			WriteJson(w, accepted)
+			return
+		}
+		h.logger.Error("User Handler Error", "Failed to get user", err.Error())
+		WriteError(w, ErrFailedToSendPasswordReset)
+		return
+	}
+
+	token, err := h.newAccountToken(ctx, user, models.TokenPurposeResetPassword, h.passwordResetTTL)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to create reset token", err.Error())
+		WriteError(w, ErrFailedToSendPasswordReset)
+		return
+	}
+
+	// failing to queue is logged, answering otherwise would tell that an
+	// account uses the address
+	h.sendMail(r, user.Email, "reset_password", map[string]string{
+		"Username": user.Username,
+		"Token":    token,
+	})
+
+	WriteJson(w, accepted)
+}
+
+// ResetPassword sets a new password with the token from the reset mail. The
+// token works once, every session of the user is signed out.
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload models.ResetPasswordPayload
+
+	if err := utils.ParseBody(r, &payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to decode payload", err.Error())
+		WriteError(w, ErrPayloadMalformed)
+		return
+	}
+
+	if err := validation.Validate(&payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to validate payload", err)
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	hashedPassword, err := HashPassword(payload.Password)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to hash password", err.Error())
+		WriteError(w, CustomError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to reset password",
+		})
+		return
+	}
+
+	ctx := r.Context()
+	userID, err := h.userRepo.ResetPassword(ctx, HashOpaqueToken(payload.Token), hashedPassword)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to reset password", err.Error())
+		if errors.Is(err, store.ErrInvalidAccountToken) {
+			WriteError(w, ErrInvalidResetToken)
+			return
+		}
+		WriteError(w, CustomError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to reset password",
+		})
+		return
+	}
+
+	h.passwordChanged(r, userID)
+
+	WriteJson(w, CustomSuccess{
+		Code:    http.StatusOK,
+		Message: "Password reset successfully, please login again",
+	})
+}
+
+// ChangePassword sets a new password for the signed in user after checking
+// the current one. Every session, this one included, is signed out.
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var payload models.ChangePasswordPayload
+
+	if err := utils.ParseBody(r, &payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to decode payload", err.Error())
+		WriteError(w, ErrPayloadMalformed)
+		return
+	}
+
+	if err := validation.Validate(&payload); err != nil {
+		h.logger.Error("User Handler Error", "Failed to validate payload", err)
+		WriteError(w, ErrInvalidPayload)
+		return
+	}
+
+	ctx := r.Context()
+	userID, ok := policy.ActorID(ctx)
+	if !ok {
+		h.logger.Error("User Handler Error", "Failed get actor")
+		WriteError(w, ErrTokenExpires)
+		return
+	}
+
+	current, err := h.userRepo.GetPassword(ctx, userID)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to get user", err.Error())
+		WriteError(w, ErrUserNotFound)
+		return
+	}
+
+	if ok := ComparePassword(payload.CurrentPassword, current); !ok {
+		h.logger.Error("User Handler Error", "Failed to change password", "Wrong password")
+		WriteError(w, ErrWrongPassword)
+		return
+	}
+
+	hashedPassword, err := HashPassword(payload.NewPassword)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to hash password", err.Error())
+		WriteError(w, CustomError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to change password",
+		})
+		return
+	}
+
+	if err := h.userRepo.UpdatePassword(ctx, userID, hashedPassword); err != nil {
+		h.logger.Error("User Handler Error", "Failed to change password", err.Error())
+		if errors.Is(err, store.ErrUserNotFound) {
+			WriteError(w, ErrUserNotFound)
+			return
+		}
+		WriteError(w, CustomError{
+			Code:    http.StatusInternalServerError,
+			Message: "Failed to change password",
+		})
+		return
+	}
+
+	h.passwordChanged(r, userID)
+
+	WriteJson(w, CustomSuccess{
+		Code:    http.StatusOK,
+		Message: "Password changed successfully, please login again",
+	})
+}
+
+// passwordChanged signs the user out everywhere and lets them know by mail.
+// The password is already changed, failures are only logged.
+func (h *UserHandler) passwordChanged(r *http.Request, userID string) {
+	ctx := r.Context()
+	if err := h.revokeSessions(ctx, userID); err != nil {
+		h.logger.Error("User Handler Error", "Failed to revoke tokens", err.Error())
+	}
+	h.redis.Del(ctx, userID)
+
+	user, err := h.userRepo.GetUserById(ctx, userID)
+	if err != nil {
+		h.logger.Error("User Handler Error", "Failed to get user", err.Error())
+		return
+	}
+	h.sendMail(r, user.Email, "password_changed", map[string]string{
+		"Username": user.Username,
+	})
+}
+
+// RequireVerifiedEmail rejects requests of accounts whose address is not
+// verified yet, when the handler is configured to. It must run after
+// Authenticate.
+func (h *UserHandler) RequireVerifiedEmail(next http.Handler) http.Handler {
+	if !h.requireVerifiedEmail {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID, ok := policy.ActorID(ctx)
+		if !ok {
+			WriteError(w, ErrTokenExpires)
+			return
+		}
+
+		verified, err := h.userRepo.IsEmailVerified(ctx, userID)
+		if err != nil {
+			h.logger.Error("User Handler Error", "Failed to check verification", err.Error())
+			if errors.Is(err, store.ErrUserNotFound) {
+				WriteError(w, ErrUserNotFound)
+				return
+			}
+			WriteError(w, CustomError{
+				Code:    http.StatusInternalServerError,
+				Message: "Failed to check email verification",
+			})
+			return
+		}
+		if !verified {
+			WriteError(w, ErrEmailNotVerified)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/cakra17/social/internal/mail"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/internal/worker"
+)
+
+var (
+	selectUnverified  = regexp.QuoteMeta(`WHERE u.email_verified_at IS NULL`)
+	deleteTokens      = regexp.QuoteMeta(`DELETE FROM account_tokens WHERE user_id = $1 AND purpose = $2`)
+	insertToken       = regexp.QuoteMeta(`INSERT INTO account_tokens`)
+	selectByEmail     = regexp.QuoteMeta(`FROM users WHERE email = $1`)
+	selectByID        = regexp.QuoteMeta(`FROM users WHERE id = $1`)
+	selectPassword    = regexp.QuoteMeta(`SELECT password FROM users WHERE id = $1`)
+	consumeToken      = regexp.QuoteMeta(`DELETE FROM account_tokens t WHERE t.token_hash = $1 AND t.purpose = $2`)
+	resetPassword     = regexp.QuoteMeta(`UPDATE users SET password = $1, email_verified_at`)
+	updatePassword    = regexp.QuoteMeta(`UPDATE users SET password = $1 WHERE id = $2`)
+	revokeRefresh     = regexp.QuoteMeta(`UPDATE refresh_tokens SET revoked_at = NOW()`)
+	mailQueuePrefix   = "test:mail"
+	mailQueueReadyKey = mailQueuePrefix + ":ready"
+)
+
+type userTest struct {
+	handler UserHandler
+	mock    sqlmock.Sqlmock
+	// mr holds the throttles, queue the mail queue
+	mr    *miniredis.Miniredis
+	queue *miniredis.Miniredis
+}
+
+// newTestUserHandler returns a handler queueing mail on its own redis, so
+// queueing can fail while the rest works.
+func newTestUserHandler(t *testing.T) userTest {
+	t.Helper()
+
+	db, mock := newTestDB(t)
+	rdb, mr := newTestRedis(t)
+	qdb, qmr := newTestRedis(t)
+	logger := utils.NewLogger()
+	return userTest{
+		handler: NewUserHandler(UserHandlerConfig{
+			UserRepo:           store.NewUserRepo(db, logger),
+			TokenRepo:          store.NewTokenRepo(db, logger),
+			AccountTokenRepo:   store.NewAccountTokenRepo(db, logger),
+			Redis:              rdb,
+			JWTAuthenticator:   testAuth,
+			Queue:              worker.NewQueue(qdb, mailQueuePrefix),
+			VerificationTTL:    time.Hour,
+			ResendEvery:        time.Minute,
+			PasswordResetTTL:   time.Hour,
+			PasswordResetEvery: time.Minute,
+			Logger:             logger,
+		}),
+		mock:  mock,
+		mr:    mr,
+		queue: qmr,
+	}
+}
+
+// queuedMail returns the mail queued so far, oldest first.
+func (ut userTest) queuedMail(t *testing.T) []mail.SendJob {
+	t.Helper()
+
+	if !ut.queue.Exists(mailQueueReadyKey) {
+		return nil
+	}
+	list, err := ut.queue.List(mailQueueReadyKey)
+	if err != nil {
+		t.Fatalf("Failed to read mail queue: %v", err)
+	}
+
+	var sent []mail.SendJob
+	// jobs are pushed to the head of the list
+	for i := len(list) - 1; i >= 0; i-- {
+		var job worker.Job
+		if err := json.Unmarshal([]byte(list[i]), &job); err != nil {
+			t.Fatalf("Failed to decode job: %v", err)
+		}
+		var m mail.SendJob
+		if err := json.Unmarshal(job.Payload, &m); err != nil {
+			t.Fatalf("Failed to decode mail: %v", err)
+		}
+		sent = append(sent, m)
+	}
+	return sent
+}
+
+func expectAccountToken(mock sqlmock.Sqlmock, userID, purpose, email string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(deleteTokens).WithArgs(userID, purpose).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(insertToken).WithArgs(sqlmock.AnyArg(), userID, purpose, sqlmock.AnyArg(), email, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func userRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "username", "email", "email_verified_at", "created_at"})
+}
+
+func TestMailUnverified(t *testing.T) {
+	ut := newTestUserHandler(t)
+	ctx := context.Background()
+	alice, bob := newID(), newID()
+
+	// accounts created before addresses were verified
+	ut.mock.ExpectQuery(selectUnverified).WithArgs(models.TokenPurposeVerifyEmail, unverifiedBatchSize).WillReturnRows(
+		userRows().
+			AddRow(alice, "alice", "alice@example.com", nil, time.Now()).
+			AddRow(bob, "bob", "bob@example.com", nil, time.Now()),
+	)
+	expectAccountToken(ut.mock, alice, models.TokenPurposeVerifyEmail, "alice@example.com")
+	expectAccountToken(ut.mock, bob, models.TokenPurposeVerifyEmail, "bob@example.com")
+
+	ut.handler.mailUnverified(ctx, time.Minute)
+	// another replica within the interval does nothing
+	ut.handler.mailUnverified(ctx, time.Minute)
+
+	sent := ut.queuedMail(t)
+	if len(sent) != 2 {
+		t.Fatalf("got %d mails want 2", len(sent))
+	}
+	for i, to := range []string{"alice@example.com", "bob@example.com"} {
+		if sent[i].To != to || sent[i].Template != "verify_email" || sent[i].Data["Token"] == "" {
+			t.Errorf("got mail %+v want a verification link to %s", sent[i], to)
+		}
+	}
+}
+
+func TestMailUnverifiedInBatches(t *testing.T) {
+	ut := newTestUserHandler(t)
+
+	full := userRows()
+	ids := make([]string, unverifiedBatchSize)
+	for i := range ids {
+		ids[i] = newID()
+		full.AddRow(ids[i], "user", "user@example.com", nil, time.Now())
+	}
+	ut.mock.ExpectQuery(selectUnverified).WillReturnRows(full)
+	for _, id := range ids {
+		expectAccountToken(ut.mock, id, models.TokenPurposeVerifyEmail, "user@example.com")
+	}
+	// a full batch may not be the last one
+	ut.mock.ExpectQuery(selectUnverified).WillReturnRows(userRows())
+
+	ut.handler.mailUnverified(context.Background(), time.Minute)
+
+	if got := len(ut.queuedMail(t)); got != unverifiedBatchSize {
+		t.Errorf("got %d mails want %d", got, unverifiedBatchSize)
+	}
+}
+
+// expectPasswordChanged expects the sessions of the user to be revoked and
+// the user looked up for the mail telling them.
+func expectPasswordChanged(mock sqlmock.Sqlmock, userID string) {
+	mock.ExpectExec(revokeRefresh).WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(selectByID).WithArgs(userID).WillReturnRows(userRows().AddRow(userID, "alice", "alice@example.com", time.Now(), time.Now()))
+}
+
+func TestForgotPassword(t *testing.T) {
+	userID := newID()
+
+	tests := []struct {
+		name   string
+		expect func(sqlmock.Sqlmock)
+		// queueDown makes queueing the mail fail
+		queueDown bool
+		// throttled is whether a reset was asked for the address just now
+		throttled bool
+		want      int
+		wantMail  bool
+	}{
+		{"sent", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectByEmail).WithArgs("alice@example.com").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "password"}).AddRow(userID, "alice", "alice@example.com", "hash"))
+			expectAccountToken(mock, userID, models.TokenPurposeResetPassword, "alice@example.com")
+		}, false, false, http.StatusAccepted, true},
+		// the answer does not tell whether an account uses the address
+		{"unknown address", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectByEmail).WithArgs("alice@example.com").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "password"}))
+		}, false, false, http.StatusAccepted, false},
+		{"mail not queued", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectByEmail).WithArgs("alice@example.com").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "password"}).AddRow(userID, "alice", "alice@example.com", "hash"))
+			expectAccountToken(mock, userID, models.TokenPurposeResetPassword, "alice@example.com")
+		}, true, false, http.StatusAccepted, false},
+		{"throttled", func(mock sqlmock.Sqlmock) {}, false, true, http.StatusTooManyRequests, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestUserHandler(t)
+			tt.expect(ut.mock)
+			if tt.throttled {
+				ut.mr.Set("password:forgot:alice@example.com", "1")
+				ut.mr.SetTTL("password:forgot:alice@example.com", 30*time.Second)
+			}
+			if tt.queueDown {
+				ut.queue.Close()
+			}
+
+			r := httptest.NewRequest("POST", "/password/forgot", strings.NewReader(`{"email":"alice@example.com"}`))
+			w := httptest.NewRecorder()
+			ut.handler.ForgotPassword(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.throttled && w.Header().Get("Retry-After") != "30" {
+				t.Errorf("got Retry-After %q want 30", w.Header().Get("Retry-After"))
+			}
+			if tt.queueDown {
+				return
+			}
+			sent := ut.queuedMail(t)
+			if got := len(sent) == 1 && sent[0].Template == "reset_password" && sent[0].Data["Token"] != ""; got != tt.wantMail {
+				t.Errorf("got mail %+v want a reset link %v", sent, tt.wantMail)
+			}
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	userID := newID()
+
+	tests := []struct {
+		name   string
+		expect func(sqlmock.Sqlmock)
+		want   int
+	}{
+		{"reset", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(consumeToken).WithArgs(utils.HashOpaqueToken("token"), models.TokenPurposeResetPassword).WillReturnRows(
+				sqlmock.NewRows([]string{"user_id", "expires_at", "exists"}).AddRow(userID, time.Now().Add(time.Hour), true),
+			)
+			mock.ExpectExec(resetPassword).WithArgs(sqlmock.AnyArg(), userID).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			expectPasswordChanged(mock, userID)
+		}, http.StatusOK},
+		{"expired token", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(consumeToken).WillReturnRows(
+				sqlmock.NewRows([]string{"user_id", "expires_at", "exists"}).AddRow(userID, time.Now().Add(-time.Minute), true),
+			)
+			mock.ExpectRollback()
+		}, http.StatusBadRequest},
+		// the token was mailed to an address the account no longer has
+		{"old address", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(consumeToken).WillReturnRows(
+				sqlmock.NewRows([]string{"user_id", "expires_at", "exists"}).AddRow(userID, time.Now().Add(time.Hour), false),
+			)
+			mock.ExpectRollback()
+		}, http.StatusBadRequest},
+		{"used token", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(consumeToken).WillReturnRows(sqlmock.NewRows([]string{"user_id", "expires_at", "exists"}))
+			mock.ExpectRollback()
+		}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestUserHandler(t)
+			tt.expect(ut.mock)
+
+			r := httptest.NewRequest("POST", "/password/reset", strings.NewReader(`{"token":"token","password":"new password"}`))
+			w := httptest.NewRecorder()
+			ut.handler.ResetPassword(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+			sent := ut.queuedMail(t)
+			if got := len(sent) == 1 && sent[0].Template == "password_changed"; got != (tt.want == http.StatusOK) {
+				t.Errorf("got mail %+v want the password change told %v", sent, tt.want == http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	userID := newID()
+	current, err := utils.HashPassword("old password")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		expect func(sqlmock.Sqlmock)
+		want   int
+		// wantMessage is part of the error message returned
+		wantMessage string
+	}{
+		{"changed", `{"current_password":"old password","new_password":"new password"}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectPassword).WithArgs(userID).WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(current))
+			mock.ExpectBegin()
+			mock.ExpectExec(updatePassword).WithArgs(sqlmock.AnyArg(), userID).WillReturnResult(sqlmock.NewResult(0, 1))
+			// pending reset links stop working
+			mock.ExpectExec(deleteTokens).WithArgs(userID, models.TokenPurposeResetPassword).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+			expectPasswordChanged(mock, userID)
+		}, http.StatusOK, ""},
+		{"wrong password", `{"current_password":"guess","new_password":"new password"}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectPassword).WithArgs(userID).WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(current))
+		}, http.StatusBadRequest, utils.ErrWrongPassword.Message},
+		{"same password", `{"current_password":"old password","new_password":"old password"}`, func(mock sqlmock.Sqlmock) {}, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ut := newTestUserHandler(t)
+			tt.expect(ut.mock)
+
+			r := httptest.NewRequest("POST", "/users/me/password", strings.NewReader(tt.body))
+			w := serveAs(t, ut.handler.ChangePassword, userID, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("got status %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantMessage) {
+				t.Errorf("got body %s want %q", w.Body, tt.wantMessage)
+			}
+		})
+	}
+}
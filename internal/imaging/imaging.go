@@ -0,0 +1,144 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const jpegQuality = 85
+
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+// opaque is implemented by the image types of the standard library
+type opaque interface {
+	Opaque() bool
+}
+
+// Spec describes a size variant. Images are scaled down to fit in Width x
+// Height, or cropped to fill it exactly when Crop is set, and never scaled
+// up.
+type Spec struct {
+	Name   string
+	Width  int
+	Height int
+	Crop   bool
+}
+
+var Variants = []Spec{
+	{Name: "thumbnail", Width: 150, Height: 150, Crop: true},
+	{Name: "feed", Width: 1080, Height: 1350},
+	{Name: "full", Width: 2048, Height: 2048},
+}
+
+type Variant struct {
+	Name        string
+	Width       int
+	Height      int
+	ContentType string
+	// Ext is the file extension matching ContentType.
+	Ext  string
+	Data []byte
+}
+
+// Process validates and decodes an uploaded image, turns it upright
+// according to its EXIF orientation and encodes one Variant per Spec.
+// Variants are encoded from the decoded pixels so none of the original
+// metadata, such as GPS coordinates, survives.
+//
+// JPEG stays JPEG, PNG and GIF become PNG and WebP becomes JPEG unless it
+// has transparency. Only the first frame of an animated GIF is kept.
+func Process(data []byte, limits Limits) ([]Variant, error) {
+	format, err := Validate(data, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, invalid("failed to decode %s: %v", format, err)
+	}
+
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+
+	encodeJPEG := format == "jpeg"
+	if format == "webp" {
+		o, ok := img.(opaque)
+		encodeJPEG = ok && o.Opaque()
+	}
+
+	var encode func(*bytes.Buffer, image.Image) error
+	var contentType, ext string
+	if encodeJPEG {
+		contentType, ext = "image/jpeg", ".jpg"
+		encode = func(buf *bytes.Buffer, img image.Image) error {
+			return jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality})
+		}
+	} else {
+		contentType, ext = "image/png", ".png"
+		encode = func(buf *bytes.Buffer, img image.Image) error {
+			return png.Encode(buf, img)
+		}
+	}
+
+	variants := make([]Variant, 0, len(Variants))
+	for _, spec := range Variants {
+		resized := resize(img, spec)
+
+		var buf bytes.Buffer
+		if err := encode(&buf, resized); err != nil {
+			return nil, err
+		}
+
+		b := resized.Bounds()
+		variants = append(variants, Variant{
+			Name:        spec.Name,
+			Width:       b.Dx(),
+			Height:      b.Dy(),
+			ContentType: contentType,
+			Ext:         ext,
+			Data:        buf.Bytes(),
+		})
+	}
+
+	return variants, nil
+}
+
+func resize(img image.Image, spec Spec) image.Image {
+	src := img.Bounds()
+	w, h := src.Dx(), src.Dy()
+
+	if spec.Crop {
+		// crop the centered region with the aspect ratio of the spec
+		cw, ch := w, w*spec.Height/spec.Width
+		if ch > h {
+			cw, ch = h*spec.Width/spec.Height, h
+		}
+		x0 := src.Min.X + (w-cw)/2
+		y0 := src.Min.Y + (h-ch)/2
+		src = image.Rect(x0, y0, x0+cw, y0+ch)
+		w, h = cw, ch
+	}
+
+	// scale by the smaller ratio so both sides fit, never above 1
+	dw, dh := w, h
+	if dw > spec.Width {
+		dw, dh = spec.Width, h*spec.Width/w
+	}
+	if dh > spec.Height {
+		dw, dh = dw*spec.Height/dh, spec.Height
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,163 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("Failed to encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("Failed to encode gif: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withExif inserts an APP1 segment holding an empty big endian EXIF block
+// followed by payload right after the SOI marker.
+func withExif(data []byte, payload string) []byte {
+	exif := append([]byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00"), payload...)
+
+	segment := []byte{0xff, 0xe1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(exif)+2))
+	segment = append(segment, exif...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+// withPNGChunk inserts an ancillary chunk right after the IHDR chunk.
+func withPNGChunk(data []byte, typ string, content []byte) []byte {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(content)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, content...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestValidate(t *testing.T) {
+	small := Limits{MaxDimension: 100, MaxPixels: 2000}
+	pngData := encodePNG(t, testImage(40, 30))
+
+	tests := []struct {
+		name       string
+		data       []byte
+		limits     Limits
+		wantFormat string
+		wantErr    error
+	}{
+		{"png", pngData, small, "png", nil},
+		{"jpeg", encodeJPEG(t, testImage(40, 30)), small, "jpeg", nil},
+		{"gif", encodeGIF(t, testImage(40, 30)), small, "gif", nil},
+		{"zero padding", append(append([]byte{}, pngData...), 0, 0, 0), small, "png", nil},
+		{"bmp", []byte("BM\x36\x00\x00\x00\x00\x00\x00\x00"), small, "", ErrUnsupportedFormat},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), small, "", ErrUnsupportedFormat},
+		{"empty", nil, small, "", ErrUnsupportedFormat},
+		{"appended data", append(append([]byte{}, pngData...), "<?php echo 1; ?>"...), small, "", ErrInvalidImage},
+		{"embedded markup", withPNGChunk(pngData, "tEXt", []byte("Comment\x00<SCRIPT>alert(1)</script>")), small, "", ErrInvalidImage},
+		{"truncated", pngData[:len(pngData)/2], small, "", ErrInvalidImage},
+		{"side too long", encodePNG(t, testImage(101, 1)), small, "", ErrInvalidImage},
+		{"too many pixels", encodePNG(t, testImage(50, 41)), small, "", ErrInvalidImage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := Validate(tt.data, tt.limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v want %v", err, tt.wantErr)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("got format %q want %q", format, tt.wantFormat)
+			}
+
+			var imgErr *Error
+			if err != nil && (!errors.As(err, &imgErr) || imgErr.Details == "") {
+				t.Errorf("error %v does not tell the client what is wrong", err)
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            []byte
+		wantContentType string
+		// sizes of the thumbnail, feed and full variants
+		wantSizes [][2]int
+	}{
+		{"large jpeg", encodeJPEG(t, testImage(3000, 1500)), "image/jpeg", [][2]int{{150, 150}, {1080, 540}, {2048, 1024}}},
+		{"small png is not scaled up", encodePNG(t, testImage(300, 200)), "image/png", [][2]int{{150, 150}, {300, 200}, {300, 200}}},
+		{"jpeg with exif", withExif(encodeJPEG(t, testImage(100, 400)), "GPS 52.5200 13.4050"), "image/jpeg", [][2]int{{100, 100}, {100, 400}, {100, 400}}},
+		{"gif becomes png", encodeGIF(t, testImage(100, 400)), "image/png", [][2]int{{100, 100}, {100, 400}, {100, 400}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variants, err := Process(tt.data, DefaultLimits)
+			if err != nil {
+				t.Fatalf("Failed to process: %v", err)
+			}
+			if len(variants) != len(Variants) {
+				t.Fatalf("got %d variants want %d", len(variants), len(Variants))
+			}
+
+			for i, v := range variants {
+				if v.Name != Variants[i].Name || v.ContentType != tt.wantContentType {
+					t.Errorf("variant %d is %s %s want %s %s", i, v.Name, v.ContentType, Variants[i].Name, tt.wantContentType)
+				}
+				if got := [2]int{v.Width, v.Height}; got != tt.wantSizes[i] {
+					t.Errorf("%s is %v want %v", v.Name, got, tt.wantSizes[i])
+				}
+				cfg, _, err := image.DecodeConfig(bytes.NewReader(v.Data))
+				if err != nil || cfg.Width != v.Width || cfg.Height != v.Height {
+					t.Errorf("%s encodes %dx%d (%v) want %dx%d", v.Name, cfg.Width, cfg.Height, err, v.Width, v.Height)
+				}
+				if bytes.Contains(v.Data, []byte("Exif")) || bytes.Contains(v.Data, []byte("GPS")) {
+					t.Errorf("%s keeps the exif metadata", v.Name)
+				}
+			}
+		})
+	}
+}
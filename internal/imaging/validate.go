@@ -0,0 +1,246 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+)
+
+var ErrInvalidImage = errors.New("invalid image")
+
+// Error tells what was wrong with an upload. Err is ErrUnsupportedFormat or
+// ErrInvalidImage.
+type Error struct {
+	Err     error
+	Details string
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error() + ": " + e.Details
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func invalid(format string, args ...any) error {
+	return &Error{Err: ErrInvalidImage, Details: fmt.Sprintf(format, args...)}
+}
+
+// Limits bound the size of the decoded image, a small file can declare huge
+// dimensions and exhaust memory once decoded.
+type Limits struct {
+	MaxDimension int
+	MaxPixels    int
+}
+
+var DefaultLimits = Limits{
+	MaxDimension: 10000,
+	MaxPixels:    25_000_000,
+}
+
+// Sniff detects the format from the magic bytes of the file, ignoring
+// whatever name or content type the client sent.
+func Sniff(data []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xFF\xD8\xFF")):
+		return "jpeg", nil
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1A\n")):
+		return "png", nil
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif", nil
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp", nil
+	}
+	return "", &Error{Err: ErrUnsupportedFormat, Details: "only JPEG, PNG, GIF and WebP images are accepted"}
+}
+
+// markup that turns an image into something a browser or server may execute
+var polyglotSignatures = [][]byte{
+	[]byte("<script"),
+	[]byte("<html"),
+	[]byte("<svg"),
+	[]byte("<?php"),
+	[]byte("<!doctype"),
+}
+
+// Validate checks an upload before it is decoded: its format, that nothing
+// is appended after the image data, that it carries no markup and that its
+// declared dimensions are within limits.
+func Validate(data []byte, limits Limits) (string, error) {
+	format, err := Sniff(data)
+	if err != nil {
+		return "", err
+	}
+
+	end, err := imageEnd(format, data)
+	if err != nil {
+		return "", err
+	}
+	if len(bytes.Trim(data[end:], "\x00")) > 0 {
+		return "", invalid("%d unexpected bytes after the end of the %s data", len(data)-end, format)
+	}
+
+	lower := bytes.ToLower(data)
+	for _, sig := range polyglotSignatures {
+		if bytes.Contains(lower, sig) {
+			return "", invalid("file contains embedded %s markup", sig)
+		}
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", invalid("corrupt %s header: %v", format, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return "", invalid("image has no pixels")
+	}
+	if cfg.Width > limits.MaxDimension || cfg.Height > limits.MaxDimension {
+		return "", invalid("image is %dx%d pixels, sides are limited to %d", cfg.Width, cfg.Height, limits.MaxDimension)
+	}
+	if cfg.Width*cfg.Height > limits.MaxPixels {
+		return "", invalid("image is %dx%d pixels, limit is %d pixels", cfg.Width, cfg.Height, limits.MaxPixels)
+	}
+
+	return format, nil
+}
+
+// imageEnd returns the offset just past the image data by walking the
+// structure of the format.
+func imageEnd(format string, data []byte) (int, error) {
+	var end int
+	switch format {
+	case "jpeg":
+		end = jpegEnd(data)
+	case "png":
+		end = pngEnd(data)
+	case "gif":
+		end = gifEnd(data)
+	case "webp":
+		end = webpEnd(data)
+	}
+	if end < 0 {
+		return 0, invalid("truncated or malformed %s data", format)
+	}
+	return end, nil
+}
+
+func jpegEnd(data []byte) int {
+	i := 2
+	for i+2 <= len(data) {
+		if data[i] != 0xFF {
+			return -1
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// fill byte
+			i++
+			continue
+		case marker == 0xD9:
+			return i + 2
+		case marker >= 0xD0 && marker <= 0xD7, marker == 0x01:
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return -1
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if size < 2 || i+2+size > len(data) {
+			return -1
+		}
+		i += 2 + size
+
+		if marker == 0xDA {
+			// skip entropy coded data up to the next marker, FF00 is an
+			// escaped byte and FFD0-FFD7 are restart markers
+			for i+1 < len(data) {
+				if data[i] == 0xFF && data[i+1] != 0x00 && (data[i+1] < 0xD0 || data[i+1] > 0xD7) {
+					break
+				}
+				i++
+			}
+		}
+	}
+	return -1
+}
+
+func pngEnd(data []byte) int {
+	i := 8
+	for i+12 <= len(data) {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		next := i + 12 + size
+		if size < 0 || next > len(data) || next < i {
+			return -1
+		}
+		if typ == "IEND" {
+			return next
+		}
+		i = next
+	}
+	return -1
+}
+
+func gifEnd(data []byte) int {
+	if len(data) < 13 {
+		return -1
+	}
+
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+
+	subBlocks := func(i int) int {
+		for i < len(data) {
+			size := int(data[i])
+			i++
+			if size == 0 {
+				return i
+			}
+			i += size
+		}
+		return -1
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B:
+			return i + 1
+		case 0x21:
+			if i = subBlocks(i + 2); i < 0 {
+				return -1
+			}
+		case 0x2C:
+			if i+10 > len(data) {
+				return -1
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			// LZW minimum code size precedes the image data
+			if i = subBlocks(i + 1); i < 0 {
+				return -1
+			}
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+func webpEnd(data []byte) int {
+	size := int(binary.LittleEndian.Uint32(data[4:]))
+	end := 8 + size
+	if size < 4 || end > len(data) {
+		return -1
+	}
+	return end
+}
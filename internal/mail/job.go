@@ -0,0 +1,73 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/textproto"
+
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/internal/worker"
+)
+
+// SendJob asks for a mail to be rendered and sent in the background.
+type SendJob struct {
+	To       string `json:"to"`
+	Template string `json:"template"`
+	// Locale is the language preference of the recipient, a locale or an
+	// Accept-Language header value.
+	Locale string            `json:"locale"`
+	Data   map[string]string `json:"data"`
+}
+
+func (SendJob) JobType() string { return "mail.send" }
+
+type SenderConfig struct {
+	Mailer    Mailer
+	Templates *Templates
+	// AppURL is the address of the app, templates get it as .AppURL to
+	// build links.
+	AppURL string
+	Logger *utils.Logger
+}
+
+// Sender processes SendJobs.
+type Sender struct {
+	mailer    Mailer
+	templates *Templates
+	appURL    string
+	logger    *utils.Logger
+}
+
+func NewSender(cfg SenderConfig) *Sender {
+	return &Sender{
+		mailer:    cfg.Mailer,
+		templates: cfg.Templates,
+		appURL:    cfg.AppURL,
+		logger:    cfg.Logger,
+	}
+}
+
+// Process renders and sends the mail of the job. Mails the SMTP server
+// rejects for good are not retried.
+func (s *Sender) Process(ctx context.Context, job SendJob) error {
+	data := maps.Clone(job.Data)
+	if data == nil {
+		data = map[string]string{}
+	}
+	data["AppURL"] = s.appURL
+
+	msg, err := s.templates.Render(job.Template, job.Locale, data)
+	if err != nil {
+		return worker.Permanent(err)
+	}
+	msg.To = job.To
+
+	err = s.mailer.Send(ctx, msg)
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) && smtpErr.Code >= 500 {
+		s.logger.Error("Mail Sender Error", "Mail rejected", job.Template, err.Error())
+		return worker.Permanent(err)
+	}
+	return err
+}
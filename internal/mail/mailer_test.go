@@ -0,0 +1,241 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	netmail "net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/internal/worker"
+)
+
+// smtpServer is a local stand-in for an SMTP server like MailHog. It
+// accepts every mail unless rcptReply says otherwise.
+type smtpServer struct {
+	addr string
+	// extensions are advertised in reply to EHLO
+	extensions []string
+	rcptReply  string
+
+	mu       sync.Mutex
+	received []string
+}
+
+func newSMTPServer(t *testing.T, extensions ...string) *smtpServer {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	s := &smtpServer{addr: l.Addr().String(), extensions: extensions, rcptReply: "250 OK"}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch cmd {
+		case "EHLO":
+			lines := append([]string{"localhost"}, s.extensions...)
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, l)
+			}
+		case "RCPT":
+			tp.PrintfLine("%s", s.rcptReply)
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.received = append(s.received, string(data))
+			s.mu.Unlock()
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *smtpServer) mails() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.received...)
+}
+
+func newTestMailer(t *testing.T, s *smtpServer, tlsMode string) *SMTPMailer {
+	t.Helper()
+
+	host, port, _ := net.SplitHostPort(s.addr)
+	p, _ := strconv.Atoi(port)
+	m, err := NewSMTPMailer(SMTPConfig{Host: host, Port: p, TLS: tlsMode, From: "Social <no-reply@social.test>"})
+	if err != nil {
+		t.Fatalf("Failed to create mailer: %v", err)
+	}
+	return m
+}
+
+func TestSend(t *testing.T) {
+	s := newSMTPServer(t)
+	m := newTestMailer(t, s, TLSNone)
+
+	msg := Message{To: "Alice <alice@example.com>", Subject: "Selamat datang di Social", Text: "hello", HTML: "<p>hello</p>"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	mails := s.mails()
+	if len(mails) != 1 {
+		t.Fatalf("got %d mails want 1", len(mails))
+	}
+	parsed, err := netmail.ReadMessage(strings.NewReader(mails[0]))
+	if err != nil {
+		t.Fatalf("Failed to parse mail: %v", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("got subject %q want %q", subject, msg.Subject)
+	}
+	if got := parsed.Header.Get("To"); !strings.Contains(got, "alice@example.com") {
+		t.Errorf("got recipient %q", got)
+	}
+	if got := parsed.Header.Get("Message-Id"); !strings.HasSuffix(got, "@social.test>") {
+		t.Errorf("got message id %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("got content type %q want multipart/alternative", mediaType)
+	}
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	// text first, clients pick the last part they can show
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("Failed to read part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Type") != want.contentType || string(body) != want.body {
+			t.Errorf("got part %s %q want %s %q", part.Header.Get("Content-Type"), body, want.contentType, want.body)
+		}
+	}
+}
+
+func TestSendWithoutStartTLS(t *testing.T) {
+	s := newSMTPServer(t)
+	m := newTestMailer(t, s, TLSStartTLS)
+
+	err := m.Send(context.Background(), Message{To: "alice@example.com", Subject: "hi", Text: "hi", HTML: "hi"})
+	if !errors.Is(err, ErrStartTLSUnsupported) {
+		t.Errorf("got error %v want %v", err, ErrStartTLSUnsupported)
+	}
+	if got := s.mails(); len(got) != 0 {
+		t.Errorf("got %d mails sent in plain text want none", len(got))
+	}
+}
+
+func TestNewSMTPMailer(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		tls  string
+	}{
+		{"invalid sender", "not an address", TLSNone},
+		{"unknown tls mode", "no-reply@social.test", "ssl"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSMTPMailer(SMTPConfig{Host: "localhost", Port: 25, From: tt.from, TLS: tt.tls}); err == nil {
+				t.Error("got no error want one")
+			}
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	templates := newTestTemplates(t)
+
+	tests := []struct {
+		name      string
+		template  string
+		rcptReply string
+		wantSent  bool
+		// wantErr is whether the job fails, wantPermanent whether it is
+		// not retried
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{"sent", "welcome", "250 OK", true, false, false},
+		{"unknown template", "newsletter", "250 OK", false, true, true},
+		{"mailbox unavailable", "welcome", "550 no such user", false, true, true},
+		{"greylisted", "welcome", "451 try again later", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSMTPServer(t)
+			s.rcptReply = tt.rcptReply
+			sender := NewSender(SenderConfig{
+				Mailer:    newTestMailer(t, s, TLSNone),
+				Templates: templates,
+				AppURL:    "https://social.example.com",
+				Logger:    utils.NewLogger(),
+			})
+
+			job := SendJob{To: "alice@example.com", Template: tt.template, Locale: "id", Data: map[string]string{"Username": "alice"}}
+			err := sender.Process(context.Background(), job)
+			if (err != nil) != tt.wantErr || worker.IsPermanent(err) != tt.wantPermanent {
+				t.Fatalf("got error %v want error %v permanent %v", err, tt.wantErr, tt.wantPermanent)
+			}
+
+			mails := s.mails()
+			if (len(mails) == 1) != tt.wantSent {
+				t.Fatalf("got %d mails want sent %v", len(mails), tt.wantSent)
+			}
+			// the mail is in the language of the recipient and links the app
+			if tt.wantSent && (!strings.Contains(mails[0], "Selamat") || !strings.Contains(mails[0], "https://social.example.com")) {
+				t.Errorf("got mail %s want it in id linking the app", mails[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package mail
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"golang.org/x/text/language"
+)
+
+// defaultLocale is used for languages without a catalog and for messages
+// missing from the catalog of the recipient's language.
+const defaultLocale = "en"
+
+// Every mail has an HTML and a text template, templates/<name>.html and
+// templates/<name>.txt, and a subject in each catalog under
+// "<name>.subject". Templates translate with {{t "key" args...}}, the
+// catalogs in locales/<locale>.json map keys to fmt formats.
+//
+//go:embed templates locales
+var files embed.FS
+
+type Templates struct {
+	html     *htmltemplate.Template
+	text     *texttemplate.Template
+	catalogs map[string]map[string]string
+	locales  []string
+	matcher  language.Matcher
+}
+
+func NewTemplates() (*Templates, error) {
+	t := &Templates{catalogs: map[string]map[string]string{}}
+
+	// t is replaced with the catalog of the recipient on every render
+	noop := map[string]any{"t": func(string, ...any) string { return "" }}
+
+	var err error
+	t.html, err = htmltemplate.New("mail").Funcs(noop).ParseFS(files, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	t.text, err = texttemplate.New("mail").Funcs(noop).ParseFS(files, "templates/*.txt")
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := fs.Glob(files, "locales/*.json")
+	if err != nil {
+		return nil, err
+	}
+	t.locales = []string{defaultLocale}
+	for _, name := range names {
+		data, err := files.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		locale := strings.TrimSuffix(path.Base(name), ".json")
+		t.catalogs[locale] = catalog
+		if locale != defaultLocale {
+			t.locales = append(t.locales, locale)
+		}
+	}
+	if _, ok := t.catalogs[defaultLocale]; !ok {
+		return nil, fmt.Errorf("missing %s catalog", defaultLocale)
+	}
+
+	tags := make([]language.Tag, len(t.locales))
+	for i, locale := range t.locales {
+		tags[i] = language.Make(locale)
+	}
+	t.matcher = language.NewMatcher(tags)
+
+	return t, nil
+}
+
+// Locale returns the supported locale closest to the preference, a locale
+// or an Accept-Language header value.
+func (t *Templates) Locale(preference string) string {
+	tags, _, _ := language.ParseAcceptLanguage(preference)
+	_, i, _ := t.matcher.Match(tags...)
+	return t.locales[i]
+}
+
+func (t *Templates) translate(locale string) func(string, ...any) string {
+	return func(key string, args ...any) string {
+		format, ok := t.catalogs[locale][key]
+		if !ok {
+			format, ok = t.catalogs[defaultLocale][key]
+		}
+		if !ok {
+			return key
+		}
+		if len(args) == 0 {
+			return format
+		}
+		return fmt.Sprintf(format, args...)
+	}
+}
+
+// Render builds the mail called name in the language closest to the
+// preference. The recipient is left to the caller.
+func (t *Templates) Render(name, preference string, data any) (Message, error) {
+	tr := t.translate(t.Locale(preference))
+	funcs := map[string]any{"t": tr}
+
+	html := t.html.Lookup(name + ".html")
+	text := t.text.Lookup(name + ".txt")
+	if html == nil || text == nil {
+		return Message{}, fmt.Errorf("unknown mail template %q", name)
+	}
+
+	// templates are cloned so every render gets its own translations,
+	// the parsed originals are never executed
+	htmlSet, err := t.html.Clone()
+	if err != nil {
+		return Message{}, err
+	}
+	var htmlBody strings.Builder
+	if err := htmlSet.Funcs(funcs).ExecuteTemplate(&htmlBody, name+".html", data); err != nil {
+		return Message{}, err
+	}
+
+	textSet, err := t.text.Clone()
+	if err != nil {
+		return Message{}, err
+	}
+	var textBody strings.Builder
+	if err := textSet.Funcs(funcs).ExecuteTemplate(&textBody, name+".txt", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Subject: tr(name + ".subject"),
+		Text:    textBody.String(),
+		HTML:    htmlBody.String(),
+	}, nil
+}
@@ -0,0 +1,124 @@
+package mail
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newTestTemplates(t *testing.T) *Templates {
+	t.Helper()
+
+	templates, err := NewTemplates()
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+	return templates
+}
+
+func TestLocale(t *testing.T) {
+	templates := newTestTemplates(t)
+
+	tests := []struct {
+		preference string
+		want       string
+	}{
+		{"id", "id"},
+		{"id-ID", "id"},
+		{"fr-FR,id;q=0.8,en;q=0.5", "id"},
+		{"en-GB", "en"},
+		// languages without a catalog get the default
+		{"fr", "en"},
+		{"", "en"},
+		{"not a language", "en"},
+	}
+	for _, tt := range tests {
+		if got := templates.Locale(tt.preference); got != tt.want {
+			t.Errorf("%q: got locale %s want %s", tt.preference, got, tt.want)
+		}
+	}
+}
+
+func TestCatalogsHaveTheSameKeys(t *testing.T) {
+	templates := newTestTemplates(t)
+
+	for locale, catalog := range templates.catalogs {
+		for key := range templates.catalogs[defaultLocale] {
+			if _, ok := catalog[key]; !ok {
+				t.Errorf("%s catalog is missing %q", locale, key)
+			}
+		}
+		for key := range catalog {
+			if _, ok := templates.catalogs[defaultLocale][key]; !ok {
+				t.Errorf("%s catalog has %q the %s catalog lacks", locale, key, defaultLocale)
+			}
+		}
+	}
+}
+
+// untranslated matches catalog keys left in a rendered mail.
+var untranslated = regexp.MustCompile(`\b(signature|footer\.\w+|(welcome|verify_email|reset_password|password_changed)\.\w+)\b`)
+
+func TestRender(t *testing.T) {
+	templates := newTestTemplates(t)
+	names := []string{"welcome", "verify_email", "reset_password", "password_changed"}
+
+	for _, name := range names {
+		for _, locale := range templates.locales {
+			t.Run(name+" "+locale, func(t *testing.T) {
+				data := map[string]string{"Username": "<alice>", "Token": "tok", "AppURL": "https://social.example.com"}
+				msg, err := templates.Render(name, locale, data)
+				if err != nil {
+					t.Fatalf("Failed to render: %v", err)
+				}
+
+				if msg.Subject != templates.catalogs[locale][name+".subject"] {
+					t.Errorf("got subject %q", msg.Subject)
+				}
+				for part, body := range map[string]string{"text": msg.Text, "html": msg.HTML} {
+					if m := untranslated.FindString(body); m != "" {
+						t.Errorf("%s part has the untranslated key %q", part, m)
+					}
+				}
+				if !strings.Contains(msg.Text, "<alice>") {
+					t.Errorf("text part lacks the username: %s", msg.Text)
+				}
+				// the username is escaped in the html part
+				if strings.Contains(msg.HTML, "<alice>") || !strings.Contains(msg.HTML, "&lt;alice&gt;") {
+					t.Errorf("html part does not escape the username: %s", msg.HTML)
+				}
+			})
+		}
+	}
+}
+
+func TestRenderLinks(t *testing.T) {
+	templates := newTestTemplates(t)
+	data := map[string]string{"Username": "alice", "Token": "tok", "AppURL": "https://social.example.com"}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"verify_email", "https://social.example.com/verify-email?token=tok"},
+		{"reset_password", "https://social.example.com/reset-password?token=tok"},
+		{"welcome", "https://social.example.com/verify-email?token=tok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := templates.Render(tt.name, "en", data)
+			if err != nil {
+				t.Fatalf("Failed to render: %v", err)
+			}
+			if !strings.Contains(msg.Text, tt.want) || !strings.Contains(msg.HTML, tt.want) {
+				t.Errorf("mail lacks the link %s", tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	if _, err := newTestTemplates(t).Render("newsletter", "en", nil); err == nil {
+		t.Error("got no error want an unknown template error")
+	}
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type Comment struct {
+	ID         string     `json:"id"`
+	PostID     string     `json:"post_id"`
+	UserID     string     `json:"user_id"`
+	Username   string     `json:"username"`
+	ParentID   *string    `json:"parent_id"`
+	Body       string     `json:"body"`
+	Deleted    bool       `json:"deleted"`
+	ReplyCount int        `json:"reply_count"`
+	Replies    []*Comment `json:"replies,omitempty"`
+	CreatedAt  *time.Time `json:"created_at"`
+	UpdatedAt  *time.Time `json:"updated_at"`
+}
+
+type CreateCommentPayload struct {
+	Body     string `json:"body" validate:"required,max=2000"`
+	ParentID string `json:"parent_id" validate:"omitempty,uuid"`
+}
+
+type UpdateCommentPayload struct {
+	Body string `json:"body" validate:"required,max=2000"`
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventPostCreated          = "post.created"
+	EventPostUpdated          = "post.updated"
+	EventPostDeleted          = "post.deleted"
+	EventPostProcessingFailed = "post.processing_failed"
+	EventLikeCreated          = "like.created"
+	EventLikeDeleted          = "like.deleted"
+	EventFollowCreated        = "follow.created"
+	EventFollowDeleted        = "follow.deleted"
+	EventFavoriteCreated      = "favorite.created"
+	EventFavoriteDeleted      = "favorite.deleted"
+	EventCommentCreated       = "comment.created"
+)
+
+// Event is a domain change recorded in the outbox together with the change
+// itself. Consumers may see an event more than once and use ID to tell.
+type Event struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	Payload       json.RawMessage `json:"payload"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+type PostEvent struct {
+	PostID string `json:"post_id"`
+	UserID string `json:"user_id"`
+	// Reason is set on post.processing_failed.
+	Reason string `json:"reason,omitempty"`
+}
+
+type LikeEvent struct {
+	LikeID       string `json:"like_id"`
+	PostID       string `json:"post_id"`
+	UserID       string `json:"user_id"`
+	PostAuthorID string `json:"post_author_id"`
+}
+
+type FollowEvent struct {
+	FollowID   string `json:"follow_id"`
+	FollowerID string `json:"follower_id"`
+	FolloweeID string `json:"followee_id"`
+}
+
+type FavoriteEvent struct {
+	FavoriteID   string `json:"favorite_id"`
+	PostID       string `json:"post_id"`
+	UserID       string `json:"user_id"`
+	PostAuthorID string `json:"post_author_id"`
+}
+
+type CommentEvent struct {
+	CommentID      string  `json:"comment_id"`
+	PostID         string  `json:"post_id"`
+	UserID         string  `json:"user_id"`
+	PostAuthorID   string  `json:"post_author_id"`
+	ParentID       *string `json:"parent_id,omitempty"`
+	ParentAuthorID *string `json:"parent_author_id,omitempty"`
+}
@@ -1,18 +1,17 @@
 package models
 
 type Follower struct {
-	ID string `json:"follow_id" db:"id"`
-	UserID string `json:"user_id" db:"user_id"`
+	ID       string `json:"follow_id" db:"id"`
+	UserID   string `json:"user_id" db:"user_id"`
 	Username string `json:"username" db:"username"`
 }
 
 type FollowPayload struct {
-	FolloweeID string	`json:"followee_id" validate:"required"`
-	FollowerID string	`json:"follower_id" validate:"required"`
+	FolloweeID string `json:"followee_id" validate:"required,uuid"`
 }
 
 type Follow struct {
-	ID string
-	FolloweeID string	`db:"followee_id"`
-	FollowerID string	`db:"followers_id"`
-}
\ No newline at end of file
+	ID         string
+	FolloweeID string `db:"followee_id"`
+	FollowerID string `db:"followers_id"`
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	NotificationLike     = "like"
+	NotificationFavorite = "favorite"
+	NotificationFollow   = "follow"
+	NotificationComment  = "comment"
+	NotificationReply    = "reply"
+)
+
+type NotificationActor struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// Notification groups the activity of every actor on the same subject
+// until it is read, e.g. all likes of a post.
+type Notification struct {
+	ID        string  `json:"id"`
+	Type      string  `json:"type"`
+	PostID    *string `json:"post_id,omitempty"`
+	CommentID *string `json:"comment_id,omitempty"`
+	// Actors are the most recent actors, ActorCount counts all of them.
+	Actors     []NotificationActor `json:"actors"`
+	ActorCount int                 `json:"actor_count"`
+	Message    string              `json:"message"`
+	Read       bool                `json:"read"`
+	CreatedAt  *time.Time          `json:"created_at"`
+	UpdatedAt  *time.Time          `json:"updated_at"`
+}
+
+// Describe summarizes the notification, e.g. "alice and 12 others liked
+// your post".
+func (n *Notification) Describe() string {
+	var who string
+	switch {
+	case len(n.Actors) == 0:
+		who = "Someone"
+	case n.ActorCount <= 1:
+		who = n.Actors[0].Username
+	case n.ActorCount == 2 && len(n.Actors) > 1:
+		who = n.Actors[0].Username + " and " + n.Actors[1].Username
+	case n.ActorCount == 2:
+		who = n.Actors[0].Username + " and 1 other"
+	default:
+		who = fmt.Sprintf("%s and %d others", n.Actors[0].Username, n.ActorCount-1)
+	}
+
+	switch n.Type {
+	case NotificationLike:
+		return who + " liked your post"
+	case NotificationFavorite:
+		return who + " saved your post"
+	case NotificationFollow:
+		return who + " started following you"
+	case NotificationComment:
+		return who + " commented on your post"
+	case NotificationReply:
+		return who + " replied to your comment"
+	default:
+		return who + " interacted with you"
+	}
+}
+
+type NotificationList struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int            `json:"unread_count"`
+}
+
+type MarkNotificationsReadPayload struct {
+	// IDs are the notifications to mark as read, all of them when empty.
+	IDs []string `json:"ids" validate:"omitempty,max=100,dive,uuid"`
+}
@@ -2,11 +2,54 @@ package models
 
 import "time"
 
+const (
+	PostStatusReady      = "ready"
+	PostStatusProcessing = "processing"
+	PostStatusFailed     = "failed"
+
+	MediaKindImage = "image"
+	MediaKindVideo = "video"
+)
+
 type Post struct {
-	ID        string    `json:"id"`
-	Caption   string    `json:"caption"`
-	Media 		string		`json:"Media"`
-	UserID    string    `json:"user_id"`
-	CreatedAt *time.Time `json:"created_at"`
-	UpdatedAt *time.Time `json:"updated_at"`
+	ID      string `json:"id"`
+	Caption string `json:"caption"`
+	// MediaURL is the URL of the first media item, the cover of the post.
+	MediaURL string      `json:"media_url"`
+	Media    []PostMedia `json:"media"`
+	// Status is processing until the media of a video post is transcoded.
+	Status string `json:"status"`
+	// ProcessingError tells why a failed post could not be processed.
+	ProcessingError string     `json:"processing_error,omitempty"`
+	UserID          string     `json:"user_id"`
+	Username        string     `json:"username,omitempty"`
+	LikesCount      int        `json:"likes_count"`
+	FavoritesCount  int        `json:"favorites_count"`
+	CommentsCount   int        `json:"comments_count"`
+	CreatedAt       *time.Time `json:"created_at"`
+	UpdatedAt       *time.Time `json:"updated_at"`
+}
+
+// PostMedia is one image or video of a post, posts hold up to 10 ordered by
+// Position. The variants of a video are its poster frame.
+type PostMedia struct {
+	ID         string         `json:"id"`
+	Position   int            `json:"position"`
+	Kind       string         `json:"kind"`
+	Key        string         `json:"-"`
+	URL        string         `json:"url"`
+	AltText    string         `json:"alt_text"`
+	Width      int            `json:"width"`
+	Height     int            `json:"height"`
+	DurationMS int            `json:"duration_ms,omitempty"`
+	Variants   []MediaVariant `json:"variants"`
+}
+
+// MediaVariant is a resized copy of a media item.
+type MediaVariant struct {
+	Name   string `json:"name"`
+	Key    string `json:"-"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
 }
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+type RefreshToken struct {
+	ID        string
+	UserID    string
+	FamilyID  string
+	TokenHash []byte
+	ExpiresAt time.Time
+}
+
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+)
+
+// AccountToken is a single use token mailed to the owner of an address to
+// prove they can read it.
+type AccountToken struct {
+	ID        string
+	UserID    string
+	Purpose   string
+	TokenHash []byte
+	Email     string
+	ExpiresAt time.Time
+}
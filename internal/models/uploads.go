@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Upload is a file sent in chunks. Received is the offset the next chunk
+// must start at, the upload is complete when it reaches Size. A complete
+// upload is finalized once its content is checked, Kind then tells whether
+// it holds an image or a video and it can be attached to a post.
+type Upload struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	PostID      *string    `json:"post_id,omitempty"`
+	Filename    string     `json:"filename"`
+	Size        int64      `json:"size"`
+	Received    int64      `json:"received"`
+	Kind        string     `json:"kind,omitempty"`
+	FinalizedAt *time.Time `json:"finalized_at,omitempty"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	CreatedAt   *time.Time `json:"created_at"`
+}
+
+func (u *Upload) Complete() bool {
+	return u.Received == u.Size
+}
+
+func (u *Upload) Finalized() bool {
+	return u.FinalizedAt != nil
+}
@@ -6,11 +6,12 @@ import (
 )
 
 type User struct {
-	ID        string 			`json:"id"`
-	Username  string			`json:"username"`
-	Email			string			`json:"email"`
-	Password  string			`json:"-"`
-	CreatedAt *time.Time	`json:"created_at"`
+	ID              string     `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	Password        string     `json:"-"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       *time.Time `json:"created_at"`
 }
 
 func (u User) MarshalBinary() ([]byte, error) {
@@ -18,22 +19,40 @@ func (u User) MarshalBinary() ([]byte, error) {
 }
 
 type RegisterPayload struct {
-	Username  string			`json:"username" validate:"required"`
-	Email			string			`json:"email" validate:"required,email,max=255"`
-	Password  string			`json:"password" validate:"required,min=8,max=30"`
+	Username string `json:"username" validate:"required"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=8,max=30"`
 }
 
 type LoginPayload struct {
-	Email			string			`json:"email" validate:"required,email,max=255"`
-	Password  string			`json:"password" validate:"required,min=8,max=30"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required,min=8,max=30"`
 }
 
 type AuthResponse struct {
-	AccessToken string `json:"access_token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 type UpdateUserPayload struct {
-	Username  string			`json:"username" validate:"required"`
-	Email			string			`json:"email" validate:"required,email,max=255"`
+	Username string `json:"username" validate:"required"`
+	Email    string `json:"email" validate:"required,email,max=255"`
 }
 
+type VerifyEmailPayload struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=30"`
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=30,nefield=CurrentPassword"`
+}
@@ -0,0 +1,118 @@
+// Package notifications turns domain events into in-app notifications.
+package notifications
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cakra17/social/internal/events"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/store"
+)
+
+const subscriber = "notifications"
+
+// Pusher delivers notifications to the recipient as they change, along
+// with the number of unread notifications of the recipient.
+type Pusher interface {
+	PushNotification(ctx context.Context, userID string, n *models.Notification, unread int) error
+}
+
+type notifier struct {
+	repo   store.NotificationRepo
+	pusher Pusher
+}
+
+// Subscribe generates notifications from the events of the bus and hands
+// them to pusher when it is not nil.
+func Subscribe(bus *events.Bus, repo store.NotificationRepo, pusher Pusher) {
+	n := notifier{repo: repo, pusher: pusher}
+
+	events.Subscribe(bus, models.EventLikeCreated, subscriber, func(ctx context.Context, e models.Event, p models.LikeEvent) error {
+		return n.add(ctx, e, p.PostAuthorID, p.UserID, models.NotificationLike, &p.PostID, nil)
+	})
+	events.Subscribe(bus, models.EventLikeDeleted, subscriber, func(ctx context.Context, e models.Event, p models.LikeEvent) error {
+		return n.repo.RemoveActor(ctx, p.PostAuthorID, groupKey(models.NotificationLike, p.PostID), p.UserID)
+	})
+
+	events.Subscribe(bus, models.EventFavoriteCreated, subscriber, func(ctx context.Context, e models.Event, p models.FavoriteEvent) error {
+		return n.add(ctx, e, p.PostAuthorID, p.UserID, models.NotificationFavorite, &p.PostID, nil)
+	})
+	events.Subscribe(bus, models.EventFavoriteDeleted, subscriber, func(ctx context.Context, e models.Event, p models.FavoriteEvent) error {
+		return n.repo.RemoveActor(ctx, p.PostAuthorID, groupKey(models.NotificationFavorite, p.PostID), p.UserID)
+	})
+
+	events.Subscribe(bus, models.EventFollowCreated, subscriber, func(ctx context.Context, e models.Event, p models.FollowEvent) error {
+		return n.add(ctx, e, p.FolloweeID, p.FollowerID, models.NotificationFollow, nil, nil)
+	})
+	events.Subscribe(bus, models.EventFollowDeleted, subscriber, func(ctx context.Context, e models.Event, p models.FollowEvent) error {
+		return n.repo.RemoveActor(ctx, p.FolloweeID, groupKey(models.NotificationFollow, ""), p.FollowerID)
+	})
+
+	events.Subscribe(bus, models.EventCommentCreated, subscriber, func(ctx context.Context, e models.Event, p models.CommentEvent) error {
+		// the author of the parent hears about a reply once, even when they
+		// also wrote the post
+		if p.ParentID != nil && p.ParentAuthorID != nil {
+			if err := n.add(ctx, e, *p.ParentAuthorID, p.UserID, models.NotificationReply, &p.PostID, p.ParentID); err != nil {
+				return err
+			}
+			if *p.ParentAuthorID == p.PostAuthorID {
+				return nil
+			}
+		}
+		return n.add(ctx, e, p.PostAuthorID, p.UserID, models.NotificationComment, &p.PostID, nil)
+	})
+}
+
+// groupKey identifies the subject activity is aggregated on: the post for
+// likes, favorites and comments, the parent comment for replies and the
+// recipient for follows.
+func groupKey(kind, subjectID string) string {
+	if subjectID == "" {
+		return kind
+	}
+	return kind + ":" + subjectID
+}
+
+func (n notifier) add(ctx context.Context, e models.Event, recipientID, actorID, kind string, postID, commentID *string) error {
+	// nobody is notified of their own activity
+	if recipientID == "" || recipientID == actorID {
+		return nil
+	}
+
+	subjectID := ""
+	switch {
+	case commentID != nil:
+		subjectID = *commentID
+	case postID != nil:
+		subjectID = *postID
+	}
+
+	id, err := n.repo.Add(ctx, store.NotificationActivity{
+		UserID:    recipientID,
+		Type:      kind,
+		PostID:    postID,
+		CommentID: commentID,
+		GroupKey:  groupKey(kind, subjectID),
+		ActorID:   actorID,
+		EventID:   e.ID,
+	})
+	if err != nil || id == "" || n.pusher == nil {
+		return err
+	}
+
+	// a retried delivery pushes the notification again, which is harmless
+	// since it is the current state of the notification
+	notification, err := n.repo.Get(ctx, recipientID, id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotificationNotFound) {
+			return nil
+		}
+		return err
+	}
+	unread, err := n.repo.UnreadCount(ctx, recipientID)
+	if err != nil {
+		return err
+	}
+	return n.pusher.PushNotification(ctx, recipientID, notification, unread)
+}
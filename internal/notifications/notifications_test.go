@@ -0,0 +1,86 @@
+package notifications
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+)
+
+var selectNotifiedEvent = regexp.QuoteMeta(`WHERE a.event_id = $1 AND n.user_id = $2`)
+
+func newTestNotifier(t *testing.T) (notifier, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet expectations: %v", err)
+		}
+		db.Close()
+	})
+	return notifier{repo: store.NewNotificationRepo(db, utils.NewLogger())}, mock
+}
+
+// expectCounted expects the event to be found as already counted for the
+// recipient, which ends Add without further queries.
+func expectCounted(mock sqlmock.Sqlmock, eventID, recipientID string) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectNotifiedEvent).WithArgs(eventID, recipientID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("notification-1"))
+	mock.ExpectRollback()
+}
+
+func TestAdd(t *testing.T) {
+	event := models.Event{ID: "event-1"}
+	postID := "post-1"
+
+	tests := []struct {
+		name        string
+		recipientID string
+		actorID     string
+		notified    bool
+	}{
+		{"notified", "author", "actor", true},
+		{"own activity", "actor", "actor", false},
+		// e.g. the like of a deleted post
+		{"no recipient", "", "actor", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, mock := newTestNotifier(t)
+			if tt.notified {
+				expectCounted(mock, event.ID, tt.recipientID)
+			}
+
+			if err := n.add(context.Background(), event, tt.recipientID, tt.actorID, models.NotificationLike, &postID, nil); err != nil {
+				t.Errorf("Failed to add notification: %v", err)
+			}
+		})
+	}
+}
+
+func TestGroupKey(t *testing.T) {
+	tests := []struct {
+		kind      string
+		subjectID string
+		want      string
+	}{
+		{models.NotificationLike, "post-1", "like:post-1"},
+		{models.NotificationReply, "comment-1", "reply:comment-1"},
+		// follows of a user are aggregated into one notification
+		{models.NotificationFollow, "", "follow"},
+	}
+	for _, tt := range tests {
+		if got := groupKey(tt.kind, tt.subjectID); got != tt.want {
+			t.Errorf("got group key %q want %q", got, tt.want)
+		}
+	}
+}
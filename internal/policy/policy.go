@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+)
+
+// ErrForbidden is returned when the resource exists but the actor is not
+// allowed to act on it. Repositories return their own not found errors when
+// the resource does not exist at all so handlers can answer 403 and 404
+// consistently.
+var ErrForbidden = errors.New("forbidden")
+
+type actorKey struct{}
+
+type Policy struct {
+	jwtAuthenticator *jwt.JWTAuthenticator
+}
+
+func New(ja *jwt.JWTAuthenticator) *Policy {
+	return &Policy{jwtAuthenticator: ja}
+}
+
+// Authenticate validates the bearer token and stores the id of the
+// authenticated user as the actor of the request.
+func (p *Policy) Authenticate(next http.Handler) http.Handler {
+	return p.jwtAuthenticator.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := p.jwtAuthenticator.GetClaims(r.Context())
+		if !ok {
+			utils.WriteError(w, utils.ErrTokenNotContainsInfo)
+			return
+		}
+
+		actorID, _ := claims["userId"].(string)
+		if actorID == "" {
+			utils.WriteError(w, utils.ErrTokenNotContainsInfo)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), actorKey{}, actorID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}))
+}
+
+// ActorID returns the id of the authenticated user of the request.
+func ActorID(ctx context.Context) (string, bool) {
+	actorID, ok := ctx.Value(actorKey{}).(string)
+	return actorID, ok && actorID != ""
+}
+
+// Claims returns the claims of the token that authenticated the request.
+func (p *Policy) Claims(ctx context.Context) (gojwt.MapClaims, bool) {
+	return p.jwtAuthenticator.GetClaims(ctx)
+}
+
+// Revoked reports whether the token that authenticated the request expired
+// or was revoked since, by a logout, a password change or revoking every
+// session of the user. Streams check it while they are open.
+func (p *Policy) Revoked(ctx context.Context) (bool, error) {
+	claims, ok := p.jwtAuthenticator.GetClaims(ctx)
+	if !ok {
+		return true, nil
+	}
+	return p.jwtAuthenticator.Revoked(ctx, claims)
+}
+
+// CanManageUser reports whether the actor may modify the given account,
+// users can only manage themselves.
+func CanManageUser(actorID, userID string) error {
+	if actorID != userID {
+		return ErrForbidden
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/jwt"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// StreamTicketTTL is how long a stream ticket can be redeemed.
+const StreamTicketTTL = 30 * time.Second
+
+var ErrInvalidTicket = errors.New("stream ticket is invalid or already used")
+
+// Tickets hands out stream tickets, single-use credentials for clients such
+// as browsers opening an EventSource or a WebSocket that cannot set an
+// Authorization header. The ticket goes in the URL in place of the access
+// token, so URLs that end up in logs hold nothing that still works. It keeps
+// the claims of the token it was issued for, so streams opened with it end
+// when that token is revoked.
+type Tickets struct {
+	redis *redis.Client
+}
+
+func NewTickets(rdb *redis.Client) *Tickets {
+	return &Tickets{redis: rdb}
+}
+
+func ticketKey(ticket string) string {
+	return "stream_ticket:" + hex.EncodeToString(utils.HashOpaqueToken(ticket))
+}
+
+// Issue returns a ticket standing in for the token of the claims for
+// StreamTicketTTL.
+func (t *Tickets) Issue(ctx context.Context, claims gojwt.MapClaims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	ticket, _, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	if err := t.redis.Set(ctx, ticketKey(ticket), data, StreamTicketTTL).Err(); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// Redeem returns the claims of the token a ticket was issued for and
+// invalidates it.
+func (t *Tickets) Redeem(ctx context.Context, ticket string) (gojwt.MapClaims, error) {
+	data, err := t.redis.GetDel(ctx, ticketKey(ticket)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidTicket
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var claims gojwt.MapClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// AuthenticateTicket authenticates requests with the ticket query parameter
+// like Authenticate does with a bearer token, which requests without a
+// ticket still need.
+func (p *Policy) AuthenticateTicket(tickets *Tickets) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		withToken := p.Authenticate(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ticket := r.URL.Query().Get("ticket")
+			if ticket == "" {
+				withToken.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := tickets.Redeem(r.Context(), ticket)
+			if err != nil {
+				if errors.Is(err, ErrInvalidTicket) {
+					utils.WriteError(w, utils.ErrInvalidStreamTicket)
+				} else {
+					utils.WriteError(w, utils.ErrFailedToOpenStream)
+				}
+				return
+			}
+
+			// the token may have been revoked since the ticket was issued
+			if revoked, err := p.jwtAuthenticator.Revoked(r.Context(), claims); err != nil || revoked {
+				utils.WriteError(w, utils.ErrTokenRevoked)
+				return
+			}
+			actorID, _ := claims["userId"].(string)
+			if actorID == "" {
+				utils.WriteError(w, utils.ErrTokenNotContainsInfo)
+				return
+			}
+
+			ctx := context.WithValue(jwt.WithClaims(r.Context(), claims), actorKey{}, actorID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
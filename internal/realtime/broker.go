@@ -0,0 +1,143 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// sendBatchSize bounds how many users are sent a message per round trip.
+const sendBatchSize = 500
+
+// Messages to a user are appended to the user's stream, which keeps the
+// recent ones for clients resuming after a disconnect, and published with
+// the id of the entry in front. Both happen in one script so a message is
+// never published without being replayable.
+var sendScript = redis.NewScript(`
+local id = redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[2], '*', 'message', ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+redis.call('PUBLISH', KEYS[2], id .. ' ' .. ARGV[1])
+return id
+`)
+
+func streamKey(userID string) string {
+	return fmt.Sprintf("realtime:stream:%s", userID)
+}
+
+func userChannel(userID string) string {
+	return fmt.Sprintf("realtime:user:%s", userID)
+}
+
+func postChannel(postID string) string {
+	return fmt.Sprintf("realtime:post:%s", postID)
+}
+
+type BrokerConfig struct {
+	// History is about how many messages are kept per user for replay.
+	History int
+	// HistoryTTL is how long the history of an inactive user is kept.
+	HistoryTTL time.Duration
+}
+
+// Broker publishes messages to the clients of every instance.
+type Broker struct {
+	redis      *redis.Client
+	history    int
+	historyTTL time.Duration
+}
+
+func NewBroker(rdb *redis.Client, cfg BrokerConfig) *Broker {
+	return &Broker{
+		redis:      rdb,
+		history:    cfg.History,
+		historyTTL: cfg.HistoryTTL,
+	}
+}
+
+func encode(kind string, data any) (string, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(Message{Type: kind, Data: raw})
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// SendToUsers sends a message to every connected client of the users.
+func (b *Broker) SendToUsers(ctx context.Context, userIDs []string, kind string, data any) error {
+	body, err := encode(kind, data)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(userIDs); start += sendBatchSize {
+		end := min(start+sendBatchSize, len(userIDs))
+
+		pipe := b.redis.Pipeline()
+		for _, id := range userIDs[start:end] {
+			keys := []string{streamKey(id), userChannel(id)}
+			sendScript.Eval(ctx, pipe, keys, body, b.history, b.historyTTL.Milliseconds())
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Broker) SendToUser(ctx context.Context, userID, kind string, data any) error {
+	return b.SendToUsers(ctx, []string{userID}, kind, data)
+}
+
+// SendToPost sends a message to the clients watching the post. These
+// messages are not kept, a client that misses one gets the next.
+func (b *Broker) SendToPost(ctx context.Context, postID, kind string, data any) error {
+	body, err := encode(kind, data)
+	if err != nil {
+		return err
+	}
+	return b.redis.Publish(ctx, postChannel(postID), " "+body).Err()
+}
+
+// PushNotification sends the notification to the recipient.
+func (b *Broker) PushNotification(ctx context.Context, userID string, n *models.Notification, unread int) error {
+	return b.SendToUser(ctx, userID, TypeNotification, NotificationData{
+		Notification: n,
+		UnreadCount:  unread,
+	})
+}
+
+// Replay returns the messages to the user after lastEventID. When some of
+// them are no longer kept the messages start with a resync.
+func (b *Broker) Replay(ctx context.Context, userID, lastEventID string) ([]Message, error) {
+	entries, err := b.redis.XRange(ctx, streamKey(userID), lastEventID, "+").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(entries))
+	if len(entries) > 0 && entries[0].ID == lastEventID {
+		entries = entries[1:]
+	} else {
+		messages = append(messages, Message{Type: TypeResync})
+	}
+
+	for _, entry := range entries {
+		body, _ := entry.Values["message"].(string)
+
+		var m Message
+		if err := json.Unmarshal([]byte(body), &m); err != nil {
+			continue
+		}
+		m.ID = entry.ID
+		messages = append(messages, m)
+	}
+	return messages, nil
+}
@@ -0,0 +1,41 @@
+package realtime
+
+import (
+	"context"
+
+	"github.com/cakra17/social/internal/events"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/store"
+)
+
+const subscriber = "realtime"
+
+// Subscribe pushes new posts to the followers of their author and like
+// counts to the watchers of a post as the events come in.
+func Subscribe(bus *events.Bus, b *Broker, timeline *store.Timeline, likes store.LikesRepo) {
+	events.Subscribe(bus, models.EventPostCreated, subscriber, func(ctx context.Context, e models.Event, p models.PostEvent) error {
+		followers, err := timeline.Audience(ctx, p.UserID)
+		if err != nil {
+			return err
+		}
+		return b.SendToUsers(ctx, followers, TypeFeedPost, FeedPostData{
+			PostID: p.PostID,
+			UserID: p.UserID,
+		})
+	})
+
+	// the count is read when the event is handled, so a late or repeated
+	// event still sends the current count
+	likeCount := func(ctx context.Context, e models.Event, p models.LikeEvent) error {
+		count, err := likes.Count(ctx, p.PostID)
+		if err != nil {
+			return err
+		}
+		return b.SendToPost(ctx, p.PostID, TypePostLikes, PostLikesData{
+			PostID:     p.PostID,
+			LikesCount: count,
+		})
+	}
+	events.Subscribe(bus, models.EventLikeCreated, subscriber, likeCount)
+	events.Subscribe(bus, models.EventLikeDeleted, subscriber, likeCount)
+}
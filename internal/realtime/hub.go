@@ -0,0 +1,249 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/cakra17/social/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// clientBuffer is how many messages may wait for a slow client before
+	// it is disconnected, it resumes from its last event id on reconnect.
+	clientBuffer = 64
+	// MaxWatchedPosts bounds how many posts a client watches at once.
+	MaxWatchedPosts = 100
+)
+
+var (
+	ErrHubClosed    = errors.New("realtime hub closed")
+	ErrTooManyPosts = errors.New("too many watched posts")
+)
+
+// Client is one connection of a user.
+type Client struct {
+	userID string
+	send   chan Message
+	done   chan struct{}
+	once   sync.Once
+	// channels the client listens on, guarded by the hub
+	channels map[string]struct{}
+	// last is the id of the last message to the user handed out by Accept
+	last streamID
+}
+
+func (c *Client) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+// Messages delivers the live messages of the client.
+func (c *Client) Messages() <-chan Message { return c.send }
+
+// Done is closed when the client must disconnect, because it fell behind
+// or the server is shutting down.
+func (c *Client) Done() <-chan struct{} { return c.done }
+
+// Accept reports whether the message should be written to the client and
+// records it as written. Live messages already replayed are skipped.
+func (c *Client) Accept(m Message) bool {
+	if m.ID == "" {
+		return true
+	}
+	id, ok := parseStreamID(m.ID)
+	if !ok || !id.after(c.last) {
+		return false
+	}
+	c.last = id
+	return true
+}
+
+// Hub delivers the messages published on redis to the clients connected
+// to this instance. It only listens on the channels of its clients.
+type Hub struct {
+	broker *Broker
+	pubsub *redis.PubSub
+	logger *utils.Logger
+
+	mu       sync.RWMutex
+	channels map[string]map[*Client]struct{}
+	closed   bool
+}
+
+func NewHub(b *Broker, lg *utils.Logger) *Hub {
+	return &Hub{
+		broker:   b,
+		pubsub:   b.redis.Subscribe(context.Background()),
+		logger:   lg,
+		channels: map[string]map[*Client]struct{}{},
+	}
+}
+
+// Run delivers messages until ctx is done. The subscription is restored by
+// the redis client after a lost connection, clients resume on their own.
+func (h *Hub) Run(ctx context.Context) {
+	defer h.pubsub.Close()
+
+	messages := h.pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			h.dispatch(msg)
+		}
+	}
+}
+
+func (h *Hub) dispatch(msg *redis.Message) {
+	id, body, _ := strings.Cut(msg.Payload, " ")
+
+	var m Message
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		h.logger.Error("Realtime Hub Error", "Failed to decode message", err.Error())
+		return
+	}
+	m.ID = id
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for c := range h.channels[msg.Channel] {
+		select {
+		case c.send <- m:
+		default:
+			c.close()
+		}
+	}
+}
+
+// Close disconnects every client, the hub accepts no new ones.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, clients := range h.channels {
+		for c := range clients {
+			c.close()
+		}
+	}
+}
+
+func (h *Hub) listen(ctx context.Context, c *Client, channel string) error {
+	if _, ok := c.channels[channel]; ok {
+		return nil
+	}
+
+	clients, ok := h.channels[channel]
+	if !ok {
+		if err := h.pubsub.Subscribe(ctx, channel); err != nil {
+			return err
+		}
+		clients = map[*Client]struct{}{}
+		h.channels[channel] = clients
+	}
+	clients[c] = struct{}{}
+	c.channels[channel] = struct{}{}
+	return nil
+}
+
+func (h *Hub) leave(ctx context.Context, c *Client, channel string) {
+	if _, ok := c.channels[channel]; !ok {
+		return
+	}
+	delete(c.channels, channel)
+
+	clients := h.channels[channel]
+	delete(clients, c)
+	if len(clients) > 0 {
+		return
+	}
+	delete(h.channels, channel)
+	if err := h.pubsub.Unsubscribe(ctx, channel); err != nil {
+		h.logger.Error("Realtime Hub Error", "Failed to unsubscribe", err.Error())
+	}
+}
+
+// Connect registers a client of the user and returns it with the messages
+// it missed after lastEventID, none when lastEventID is empty.
+func (h *Hub) Connect(ctx context.Context, userID, lastEventID string) (*Client, []Message, error) {
+	c := &Client{
+		userID:   userID,
+		send:     make(chan Message, clientBuffer),
+		done:     make(chan struct{}),
+		channels: map[string]struct{}{},
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, nil, ErrHubClosed
+	}
+	err := h.listen(ctx, c, userChannel(userID))
+	h.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// listening first means nothing sent during the replay is lost, Accept
+	// drops what arrives twice
+	if lastEventID == "" {
+		return c, nil, nil
+	}
+	missed, err := h.broker.Replay(ctx, userID, lastEventID)
+	if err != nil {
+		h.Disconnect(c)
+		return nil, nil, err
+	}
+	c.last, _ = parseStreamID(lastEventID)
+	return c, missed, nil
+}
+
+// Watch makes the client receive the messages to the watchers of the posts.
+func (h *Hub) Watch(ctx context.Context, c *Client, postIDs ...string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	if len(c.channels)-1+len(postIDs) > MaxWatchedPosts {
+		return ErrTooManyPosts
+	}
+	for _, id := range postIDs {
+		if err := h.listen(ctx, c, postChannel(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hub) Unwatch(ctx context.Context, c *Client, postIDs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, id := range postIDs {
+		h.leave(ctx, c, postChannel(id))
+	}
+}
+
+// Disconnect unregisters the client.
+func (h *Hub) Disconnect(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ctx := context.Background()
+	for channel := range c.channels {
+		h.leave(ctx, c, channel)
+	}
+	c.close()
+}
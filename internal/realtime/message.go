@@ -0,0 +1,76 @@
+// Package realtime pushes notifications, new feed posts and like counts to
+// connected clients. Messages are fanned out through redis pub/sub so every
+// instance can deliver to the clients connected to it.
+package realtime
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const (
+	TypeNotification = "notification"
+	TypeFeedPost     = "feed.post"
+	TypePostLikes    = "post.likes"
+	TypeHeartbeat    = "heartbeat"
+	// TypeResync tells a resuming client that messages after its last event
+	// id are gone and its state must be fetched again.
+	TypeResync = "resync"
+)
+
+// Message is one message pushed to a client. Messages to a user carry the
+// id they are replayed from, messages to the watchers of a post have none.
+type Message struct {
+	ID   string          `json:"id,omitempty"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+type NotificationData struct {
+	Notification any `json:"notification"`
+	UnreadCount  int `json:"unread_count"`
+}
+
+type FeedPostData struct {
+	PostID string `json:"post_id"`
+	UserID string `json:"user_id"`
+}
+
+type PostLikesData struct {
+	PostID     string `json:"post_id"`
+	LikesCount int    `json:"likes_count"`
+}
+
+// streamID is the id of a redis stream entry, milliseconds and sequence.
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+func parseStreamID(s string) (streamID, bool) {
+	ms, seq, ok := strings.Cut(s, "-")
+	if !ok {
+		return streamID{}, false
+	}
+
+	var id streamID
+	var err error
+	if id.ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return streamID{}, false
+	}
+	if id.seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return streamID{}, false
+	}
+	return id, true
+}
+
+func (a streamID) after(b streamID) bool {
+	return a.ms > b.ms || (a.ms == b.ms && a.seq > b.seq)
+}
+
+// ValidEventID reports whether id can be resumed from.
+func ValidEventID(id string) bool {
+	_, ok := parseStreamID(id)
+	return ok
+}
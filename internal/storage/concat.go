@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// concatReader reads objects one after the other, each is opened once the
+// previous one is read.
+type concatReader struct {
+	ctx  context.Context
+	ms   MediaStore
+	keys []string
+	cur  io.ReadCloser
+}
+
+// Concat returns a reader of the objects stored under keys joined in order.
+func Concat(ctx context.Context, ms MediaStore, keys []string) io.ReadCloser {
+	return &concatReader{ctx: ctx, ms: ms, keys: keys}
+}
+
+func (r *concatReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.keys) == 0 {
+				return 0, io.EOF
+			}
+			obj, err := r.ms.Get(r.ctx, r.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			r.keys = r.keys[1:]
+			r.cur = obj.Body
+		}
+
+		n, err := r.cur.Read(p)
+		if errors.Is(err, io.EOF) {
+			r.cur.Close()
+			r.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *concatReader) Close() error {
+	if r.cur == nil {
+		return nil
+	}
+	err := r.cur.Close()
+	r.cur = nil
+	r.keys = nil
+	return err
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestConcat(t *testing.T) {
+	ctx := context.Background()
+	ls, err := NewLocalStore(t.TempDir(), "/media")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	for key, content := range map[string]string{"a": "hello ", "b": "", "c": "world"} {
+		if err := ls.Put(ctx, key, strings.NewReader(content), int64(len(content)), ""); err != nil {
+			t.Fatalf("Failed to put %s: %v", key, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		keys    []string
+		want    string
+		wantErr error
+	}{
+		{"in order", []string{"a", "b", "c"}, "hello world", nil},
+		{"reordered", []string{"c", "a"}, "worldhello ", nil},
+		{"no keys", nil, "", nil},
+		{"missing object", []string{"a", "missing"}, "hello ", ErrObjectNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Concat(ctx, ls, tt.keys)
+			defer r.Close()
+
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
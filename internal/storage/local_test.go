@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalDeleteBefore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := NewLocalStore(dir, "/media")
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	objects := []struct {
+		key      string
+		modified time.Time
+		wantKept bool
+	}{
+		{"upload-old", old, false},
+		{"upload-new", time.Now(), true},
+		// only keys with the prefix expire
+		{"photo.jpg", old, true},
+	}
+	for _, obj := range objects {
+		if err := s.Put(ctx, obj.key, strings.NewReader("x"), 1, ""); err != nil {
+			t.Fatalf("Failed to put: %v", err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, obj.key), obj.modified, obj.modified); err != nil {
+			t.Fatalf("Failed to set modification time: %v", err)
+		}
+	}
+
+	n, err := s.DeleteBefore(ctx, "upload-", time.Now().Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("got %d deleted want 1", n)
+	}
+	for _, obj := range objects {
+		got, err := s.Get(ctx, obj.key)
+		if err == nil {
+			got.Body.Close()
+		}
+		if kept := !errors.Is(err, ErrObjectNotFound); kept != obj.wantKept {
+			t.Errorf("%s kept %v want %v", obj.key, kept, obj.wantKept)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package storage
+
+import "github.com/cakra17/social/pkg/urlsign"
+
+// SignedStore hands out expiring signed URLs for the objects of a MediaStore
+// so they can't be hot-linked. Only the media endpoint verifies signatures,
+// so the URLs of the store must point at it and the bucket stay private.
+type SignedStore struct {
+	MediaStore
+	signer *urlsign.Signer
+}
+
+func NewSignedStore(ms MediaStore, signer *urlsign.Signer) *SignedStore {
+	return &SignedStore{MediaStore: ms, signer: signer}
+}
+
+func (s *SignedStore) URL(key string) string {
+	return s.MediaStore.URL(key) + "?" + s.signer.Sign(key).Encode()
+}
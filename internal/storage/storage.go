@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// Object is a stored media file opened for reading, Body must be closed.
+// Body is seekable so ranges of the object can be served.
+type Object struct {
+	Body         io.ReadSeekCloser
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+	// ETag is a quoted strong validator of the content.
+	ETag string
+}
+
+// MediaStore keeps uploaded media by key. Keys are plain file names without
+// directories, generated by the caller.
+type MediaStore interface {
+	// Put stores size bytes of r under key, replacing any previous object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key or returns ErrObjectNotFound.
+	Get(ctx context.Context, key string) (*Object, error)
+	// Delete removes the object stored under key, missing objects are not
+	// an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the address clients fetch the object from.
+	URL(key string) string
+}
+
+// Expirer is implemented by stores that can delete the objects stored
+// before a time. The staging store of uploads uses it to expire chunks no
+// upload refers to anymore.
+type Expirer interface {
+	// DeleteBefore removes the objects whose key starts with prefix that
+	// were stored before the given time and returns how many.
+	DeleteBefore(ctx context.Context, prefix string, before time.Time) (int, error)
+}
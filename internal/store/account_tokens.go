@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/utils"
+)
+
+var ErrInvalidAccountToken = errors.New("invalid account token")
+
+type AccountTokenRepo struct {
+	db     *sql.DB
+	logger *utils.Logger
+}
+
+func NewAccountTokenRepo(db *sql.DB, lg *utils.Logger) AccountTokenRepo {
+	return AccountTokenRepo{db: db, logger: lg}
+}
+
+// Create stores the token in place of the earlier tokens of the user with
+// the same purpose, only the latest one mailed can be used.
+func (r *AccountTokenRepo) Create(ctx context.Context, token *models.AccountToken) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM account_tokens WHERE user_id = $1 AND purpose = $2`
+	if _, err := tx.ExecContext(ctx, query, token.UserID, token.Purpose); err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO account_tokens (
+			id, user_id, purpose, token_hash, email, expires_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6
+		)
+	`
+	_, err = tx.ExecContext(
+		ctx, query,
+		token.ID,
+		token.UserID,
+		token.Purpose,
+		token.TokenHash,
+		token.Email,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// consumeAccountToken deletes the token with the given hash and returns
+// its owner. Tokens that expired or were sent to an address the account no
+// longer has are rejected with ErrInvalidAccountToken.
+func consumeAccountToken(ctx context.Context, tx *sql.Tx, purpose string, hash []byte) (string, error) {
+	var (
+		userID    string
+		current   bool
+		expiresAt time.Time
+	)
+	query := `
+		DELETE FROM account_tokens t WHERE t.token_hash = $1 AND t.purpose = $2
+		RETURNING t.user_id, t.expires_at, EXISTS (
+			SELECT 1 FROM users u WHERE u.id = t.user_id AND u.email = t.email
+		)
+	`
+	err := tx.QueryRowContext(ctx, query, hash, purpose).Scan(&userID, &expiresAt, &current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrInvalidAccountToken
+		}
+		return "", err
+	}
+
+	if !current || time.Now().After(expiresAt) {
+		return "", ErrInvalidAccountToken
+	}
+	return userID, nil
+}
@@ -0,0 +1,356 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultCommentDepth = 3
+	MaxCommentDepth     = 5
+)
+
+var ErrCommentNotFound = errors.New("comment not found")
+
+// Deleted comments that still have replies are kept as tombstones so the
+// thread stays intact, their body is never returned.
+const commentColumns = `
+	c.id,
+	c.post_id,
+	c.user_id,
+	u.username,
+	c.parent_id,
+	CASE WHEN c.deleted_at IS NULL THEN c.body ELSE '' END,
+	c.deleted_at IS NOT NULL,
+	(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id),
+	c.created_at,
+	c.updated_at
+`
+
+func scanComment(row rowScanner, comment *models.Comment) error {
+	return row.Scan(
+		&comment.ID,
+		&comment.PostID,
+		&comment.UserID,
+		&comment.Username,
+		&comment.ParentID,
+		&comment.Body,
+		&comment.Deleted,
+		&comment.ReplyCount,
+		&comment.CreatedAt,
+		&comment.UpdatedAt,
+	)
+}
+
+type CommentRepo struct {
+	db     *sql.DB
+	logger *utils.Logger
+}
+
+func NewCommentRepo(db *sql.DB, lg *utils.Logger) CommentRepo {
+	return CommentRepo{db: db, logger: lg}
+}
+
+// postVisible reports whether a post exists and actorID may see it, posts
+// still processing or that failed are only seen by their author.
+func postVisible(ctx context.Context, q queryer, postID, actorID string) (bool, error) {
+	var visible bool
+	query := `SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1 AND (status = 'ready' OR user_id = $2))`
+	if err := q.QueryRowContext(ctx, query, postID, actorID).Scan(&visible); err != nil {
+		return false, err
+	}
+	return visible, nil
+}
+
+// notFound tells a comment that does not exist apart from a post the actor
+// may not see.
+func notFound(ctx context.Context, q queryer, postID, actorID string) error {
+	visible, err := postVisible(ctx, q, postID, actorID)
+	if err != nil {
+		return err
+	}
+	if !visible {
+		return ErrPostNotFound
+	}
+	return ErrCommentNotFound
+}
+
+// Create adds a comment to a post its author may see. A reply is only added
+// when its parent belongs to the same post.
+func (r *CommentRepo) Create(ctx context.Context, comment *models.Comment) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	event := models.CommentEvent{
+		CommentID: comment.ID,
+		PostID:    comment.PostID,
+		UserID:    comment.UserID,
+		ParentID:  comment.ParentID,
+	}
+
+	query := `
+		INSERT INTO comments (
+			id, post_id, user_id, parent_id, body
+		)
+		SELECT $1, $2, $3, $4, $5
+		WHERE EXISTS (
+			SELECT 1 FROM posts WHERE id = $2 AND (status = 'ready' OR user_id = $3)
+		) AND ($4::uuid IS NULL OR EXISTS (
+			SELECT 1 FROM comments WHERE id = $4 AND post_id = $2
+		))
+		RETURNING
+			created_at,
+			updated_at,
+			(SELECT user_id FROM posts WHERE id = $2),
+			(SELECT user_id FROM comments WHERE id = $4)
+	`
+	err = tx.QueryRowContext(
+		ctx, query,
+		comment.ID,
+		comment.PostID,
+		comment.UserID,
+		comment.ParentID,
+		comment.Body,
+	).Scan(&comment.CreatedAt, &comment.UpdatedAt, &event.PostAuthorID, &event.ParentAuthorID)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return notFound(ctx, tx, comment.PostID, comment.UserID)
+		}
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrPostNotFound
+		}
+		return err
+	}
+
+	if err := insertEvent(ctx, tx, "comment", comment.ID, models.EventCommentCreated, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *CommentRepo) Update(ctx context.Context, comment *models.Comment) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE comments SET body = $1
+		WHERE id = $2 AND post_id = $3 AND user_id = $4 AND deleted_at IS NULL
+		RETURNING parent_id, created_at, updated_at
+	`
+	err := r.db.QueryRowContext(
+		ctx, query,
+		comment.Body,
+		comment.ID,
+		comment.PostID,
+		comment.UserID,
+	).Scan(&comment.ParentID, &comment.CreatedAt, &comment.UpdatedAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return resolveOwnership(ctx, r.db, "comments", comment.ID, ErrCommentNotFound)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Delete removes a comment, or turns it into a tombstone when it has replies.
+// Both the author of the comment and the owner of the post may delete it.
+func (r *CommentRepo) Delete(ctx context.Context, postID, id, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hasReplies bool
+	query := `SELECT EXISTS (SELECT 1 FROM comments WHERE parent_id = $1)`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&hasReplies); err != nil {
+		return err
+	}
+
+	if hasReplies {
+		query = `
+			UPDATE comments SET body = '', deleted_at = NOW()
+			WHERE id = $1 AND post_id = $2 AND deleted_at IS NULL AND (
+				user_id = $3 OR EXISTS (SELECT 1 FROM posts WHERE id = $2 AND user_id = $3)
+			)
+		`
+	} else {
+		query = `
+			DELETE FROM comments
+			WHERE id = $1 AND post_id = $2 AND (
+				user_id = $3 OR EXISTS (SELECT 1 FROM posts WHERE id = $2 AND user_id = $3)
+			)
+		`
+	}
+
+	res, err := tx.ExecContext(ctx, query, id, postID, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := checkOwnership(ctx, tx, res, "comments", id, ErrCommentNotFound); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetByPost returns a page of top level comments of a post, oldest first,
+// each with its replies loaded up to depth levels. Comments of posts actorID
+// may not see are not found.
+func (r *CommentRepo) GetByPost(ctx context.Context, postID, actorID string, depth int, page pagination.Page) ([]*models.Comment, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	args := []any{postID, page.Fetch(), actorID}
+	query := `SELECT ` + commentColumns + `
+		FROM comments c
+		INNER JOIN users u ON u.id = c.user_id
+		INNER JOIN posts p ON p.id = c.post_id
+		WHERE c.post_id = $1 AND c.parent_id IS NULL AND (p.status = 'ready' OR p.user_id = $3)
+	`
+	if page.After != "" {
+		args = append(args, page.After)
+		query += `AND c.id > $4 `
+	}
+	query += `ORDER BY c.id ASC LIMIT $2`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	comments := []*models.Comment{}
+	for rows.Next() {
+		comment := &models.Comment{}
+		if err := scanComment(rows, comment); err != nil {
+			return nil, "", err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	count := len(comments)
+	comments = pagination.Trim(page, comments)
+
+	// an empty page is told apart from a post that can't be seen
+	if count == 0 {
+		visible, err := postVisible(ctx, r.db, postID, actorID)
+		if err != nil {
+			return nil, "", err
+		}
+		if !visible {
+			return nil, "", ErrPostNotFound
+		}
+	}
+
+	if err := r.loadReplies(ctx, comments, depth); err != nil {
+		return nil, "", err
+	}
+
+	var last string
+	if len(comments) > 0 {
+		last = comments[len(comments)-1].ID
+	}
+	return comments, page.Next(count, last), nil
+}
+
+// GetThread returns a single comment of a post with its reply tree. Comments
+// of posts actorID may not see are not found.
+func (r *CommentRepo) GetThread(ctx context.Context, postID, id, actorID string, depth int) (*models.Comment, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `SELECT ` + commentColumns + `
+		FROM comments c
+		INNER JOIN users u ON u.id = c.user_id
+		INNER JOIN posts p ON p.id = c.post_id
+		WHERE c.id = $1 AND c.post_id = $2 AND (p.status = 'ready' OR p.user_id = $3)
+	`
+
+	comment := &models.Comment{}
+	if err := scanComment(r.db.QueryRowContext(ctx, query, id, postID, actorID), comment); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound(ctx, r.db, postID, actorID)
+		}
+		return nil, err
+	}
+
+	if err := r.loadReplies(ctx, []*models.Comment{comment}, depth); err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+func (r *CommentRepo) loadReplies(ctx context.Context, roots []*models.Comment, depth int) error {
+	if len(roots) == 0 || depth < 1 {
+		return nil
+	}
+
+	ids := make([]string, len(roots))
+	byID := make(map[string]*models.Comment, len(roots))
+	for i, c := range roots {
+		ids[i] = c.ID
+		byID[c.ID] = c
+	}
+
+	query := `
+		WITH RECURSIVE tree AS (
+			SELECT id, 1 AS depth FROM comments WHERE parent_id = ANY($1)
+			UNION ALL
+			SELECT c.id, t.depth + 1 FROM comments c
+			INNER JOIN tree t ON c.parent_id = t.id
+			WHERE t.depth < $2
+		)
+		SELECT ` + commentColumns + `
+		FROM tree t
+		INNER JOIN comments c ON c.id = t.id
+		INNER JOIN users u ON u.id = c.user_id
+		ORDER BY t.depth ASC, c.id ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(ids), depth)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// rows come ordered by depth so a parent is always seen before its replies
+	for rows.Next() {
+		reply := &models.Comment{}
+		if err := scanComment(rows, reply); err != nil {
+			return err
+		}
+		if parent, ok := byID[*reply.ParentID]; ok {
+			parent.Replies = append(parent.Replies, reply)
+		}
+		byID[reply.ID] = reply
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+)
+
+var (
+	selectThread     = regexp.QuoteMeta(`WHERE c.id = $1 AND c.post_id = $2 AND (p.status = 'ready' OR p.user_id = $3)`)
+	selectTopLevel   = regexp.QuoteMeta(`WHERE c.post_id = $1 AND c.parent_id IS NULL AND (p.status = 'ready' OR p.user_id = $3)`)
+	selectVisible    = regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM posts WHERE id = $1 AND (status = 'ready' OR user_id = $2))`)
+	selectReplies    = regexp.QuoteMeta(`WITH RECURSIVE tree AS`)
+	selectHasReplies = regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM comments WHERE parent_id = $1)`)
+	tombstoneComment = regexp.QuoteMeta(`UPDATE comments SET body = '', deleted_at = NOW()`)
+	deleteComment    = regexp.QuoteMeta(`DELETE FROM comments`)
+	commentExists    = regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM comments WHERE id = $1)`)
+)
+
+func commentRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{
+		"id", "post_id", "user_id", "username", "parent_id",
+		"body", "deleted", "reply_count", "created_at", "updated_at",
+	})
+}
+
+// addComment adds a comment as commentColumns reads it, deleted comments
+// come back without their body.
+func addComment(rows *sqlmock.Rows, id, postID string, parentID *string, deleted bool, replies int) *sqlmock.Rows {
+	body := "body of " + id
+	if deleted {
+		body = ""
+	}
+	now := time.Now()
+	return rows.AddRow(id, postID, "user-1", "user", parentID, body, deleted, replies, now, now)
+}
+
+func TestGetThread(t *testing.T) {
+	postID, actorID := newIDs(1)[0], newIDs(1)[0]
+	c := newIDs(4)
+	root, reply, nested, sibling := c[0], c[1], c[2], c[3]
+
+	db, mock := newTestDB(t)
+	// the root was deleted but has replies, it is kept as a tombstone
+	mock.ExpectQuery(selectThread).WithArgs(root, postID, actorID).WillReturnRows(addComment(commentRows(), root, postID, nil, true, 2))
+	replies := commentRows()
+	addComment(replies, reply, postID, &root, false, 1)
+	addComment(replies, sibling, postID, &root, false, 0)
+	addComment(replies, nested, postID, &reply, false, 0)
+	mock.ExpectQuery(selectReplies).WithArgs(`{"`+root+`"}`, 2).WillReturnRows(replies)
+
+	repo := NewCommentRepo(db, utils.NewLogger())
+	thread, err := repo.GetThread(context.Background(), postID, root, actorID, 2)
+	if err != nil {
+		t.Fatalf("Failed to get thread: %v", err)
+	}
+
+	if !thread.Deleted || thread.Body != "" {
+		t.Errorf("got tombstone %+v want a deleted comment without body", thread)
+	}
+	if len(thread.Replies) != 2 || thread.Replies[0].ID != reply || thread.Replies[1].ID != sibling {
+		t.Fatalf("got replies %+v want %s and %s", thread.Replies, reply, sibling)
+	}
+	if got := thread.Replies[0].Replies; len(got) != 1 || got[0].ID != nested {
+		t.Errorf("got nested replies %+v want %s", got, nested)
+	}
+}
+
+func TestGetThreadNotFound(t *testing.T) {
+	postID, id, actorID := newIDs(1)[0], newIDs(1)[0], newIDs(1)[0]
+
+	tests := []struct {
+		name string
+		// visible is whether the actor may see the post
+		visible bool
+		wantErr error
+	}{
+		{"unknown comment", true, ErrCommentNotFound},
+		// the post is unknown, still processing or failed
+		{"hidden post", false, ErrPostNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			mock.ExpectQuery(selectThread).WithArgs(id, postID, actorID).WillReturnRows(commentRows())
+			mock.ExpectQuery(selectVisible).WithArgs(postID, actorID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.visible))
+
+			repo := NewCommentRepo(db, utils.NewLogger())
+			if _, err := repo.GetThread(context.Background(), postID, id, actorID, 3); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetByPost(t *testing.T) {
+	postID, actorID := newIDs(1)[0], newIDs(1)[0]
+	c := newIDs(3)
+
+	tests := []struct {
+		name     string
+		depth    int
+		limit    int
+		comments []string
+		// loaded is whether the replies are queried
+		loaded bool
+		// visible is whether the actor may see the post, only asked when
+		// there are no comments
+		visible    bool
+		wantCount  int
+		wantCursor string
+		wantErr    error
+	}{
+		{"with replies", 3, 5, c, true, true, 3, "", nil},
+		{"without replies", 0, 5, c, false, true, 3, "", nil},
+		{"more comments", 3, 2, c, true, true, 2, pagination.EncodeCursor(c[1]), nil},
+		{"no comments", 3, 5, nil, false, true, 0, "", nil},
+		{"hidden post", 3, 5, nil, false, false, 0, "", ErrPostNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			rows := commentRows()
+			for _, id := range tt.comments {
+				addComment(rows, id, postID, nil, false, 0)
+			}
+			mock.ExpectQuery(selectTopLevel).WithArgs(postID, tt.limit+1, actorID).WillReturnRows(rows)
+			if len(tt.comments) == 0 {
+				mock.ExpectQuery(selectVisible).WithArgs(postID, actorID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.visible))
+			}
+			if tt.loaded {
+				mock.ExpectQuery(selectReplies).WithArgs(sqlmock.AnyArg(), tt.depth).WillReturnRows(commentRows())
+			}
+
+			repo := NewCommentRepo(db, utils.NewLogger())
+			comments, next, err := repo.GetByPost(context.Background(), postID, actorID, tt.depth, pagination.Page{Limit: tt.limit})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v want %v", err, tt.wantErr)
+			}
+			if len(comments) != tt.wantCount {
+				t.Errorf("got %d comments want %d", len(comments), tt.wantCount)
+			}
+			if next != tt.wantCursor {
+				t.Errorf("got cursor %q want %q", next, tt.wantCursor)
+			}
+		})
+	}
+}
+
+func TestDeleteComment(t *testing.T) {
+	postID, id, userID := newIDs(1)[0], newIDs(1)[0], newIDs(1)[0]
+
+	tests := []struct {
+		name       string
+		hasReplies bool
+		affected   int64
+		// exists is whether the comment exists when nothing was affected
+		exists  bool
+		wantErr error
+	}{
+		{"deleted", false, 1, false, nil},
+		{"tombstone", true, 1, false, nil},
+		{"not allowed", false, 0, true, policy.ErrForbidden},
+		{"not found", false, 0, false, ErrCommentNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectHasReplies).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.hasReplies))
+			statement := deleteComment
+			if tt.hasReplies {
+				statement = tombstoneComment
+			}
+			mock.ExpectExec(statement).WithArgs(id, postID, userID).WillReturnResult(sqlmock.NewResult(0, tt.affected))
+			if tt.affected == 0 {
+				mock.ExpectQuery(commentExists).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.exists))
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+
+			repo := NewCommentRepo(db, utils.NewLogger())
+			err := repo.Delete(context.Background(), postID, id, userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateNotFound(t *testing.T) {
+	ids := newIDs(3)
+	parentID := ids[2]
+
+	tests := []struct {
+		name string
+		// visible is whether the author of the comment may see the post
+		visible bool
+		wantErr error
+	}{
+		// the parent is not a comment of the post
+		{"reply to another post", true, ErrCommentNotFound},
+		{"hidden post", false, ErrPostNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comment := &models.Comment{ID: ids[0], PostID: ids[1], UserID: "user-1", ParentID: &parentID, Body: "reply"}
+
+			db, mock := newTestDB(t)
+			mock.ExpectBegin()
+			// nothing is inserted
+			mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO comments`)).WillReturnRows(sqlmock.NewRows([]string{"created_at", "updated_at", "post_author", "parent_author"}))
+			mock.ExpectQuery(selectVisible).WithArgs(comment.PostID, comment.UserID).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.visible))
+			mock.ExpectRollback()
+
+			repo := NewCommentRepo(db, utils.NewLogger())
+			if err := repo.Create(context.Background(), comment); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
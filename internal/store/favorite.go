@@ -3,20 +3,24 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/cakra17/social/internal/models"
 	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
 )
 
+var ErrFavoriteNotFound = errors.New("favorite not found")
+
 type FavoriteRepo struct {
-	db *sql.DB
+	db     *sql.DB
 	logger *utils.Logger
 }
 
 func NewFavoriteRepo(db *sql.DB, lg *utils.Logger) FavoriteRepo {
 	return FavoriteRepo{
-		db: db,
+		db:     db,
 		logger: lg,
 	}
 }
@@ -26,25 +30,30 @@ func (r *FavoriteRepo) Add(ctx context.Context, payload *models.Favorite) error
 	if err != nil {
 		return fmt.Errorf("Failed to begin transaction: %s", err.Error())
 	}
+	defer tx.Rollback()
 
-	defer func() {
-		if err != nil {
-			if rbErr := tx.Rollback(); err != nil {
-				r.logger.Error("Database Error", "Failed to rollback", rbErr.Error())
-			}
-		}
-	}()
-
+	var authorID string
 	query := `
-		INSERT INTO favorites (id, post_id, user_id) VALUES ($1, $2, $3) RETURNING created_at
+		INSERT INTO favorites (id, post_id, user_id) VALUES ($1, $2, $3)
+		RETURNING created_at, (SELECT user_id FROM posts WHERE id = $2)
 	`
-	err = r.db.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx, query, payload.ID, payload.PostId, payload.UserId,
-	).Scan(&payload.CreatedAt)
+	).Scan(&payload.CreatedAt, &authorID)
 	if err != nil {
 		return fmt.Errorf("Failed to insert data: %s", err.Error())
 	}
 
+	event := models.FavoriteEvent{
+		FavoriteID:   payload.ID,
+		PostID:       payload.PostId,
+		UserID:       payload.UserId,
+		PostAuthorID: authorID,
+	}
+	if err := insertEvent(ctx, tx, "favorite", payload.ID, models.EventFavoriteCreated, event); err != nil {
+		return fmt.Errorf("Failed to insert data: %s", err.Error())
+	}
+
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("Failed to commit transaction %s", err.Error())
 	}
@@ -52,57 +61,84 @@ func (r *FavoriteRepo) Add(ctx context.Context, payload *models.Favorite) error
 	return nil
 }
 
-func (r *FavoriteRepo) GetFavouritePost(ctx context.Context, userID string) ([]models.Post, error) {
-	var posts []models.Post
+func (r *FavoriteRepo) GetFavouritePost(ctx context.Context, userID string, page pagination.Page) ([]models.Post, string, error) {
+	posts := []models.Post{}
+	args := []any{userID, page.Fetch()}
 	query := `
 		SELECT 
+			fv.id,
 			p.id, 
-			p.caption, 
+			COALESCE(p.caption, ''), 
 			p.user_id,
-			p.media, 
+			p.status,
 			p.created_at, 
 			p.updated_at 
-		FROM posts p INNER JOIN favorites f 
-		ON p.user_id = f.user_id 
-		WHERE f.user_id = $1
+		FROM posts p INNER JOIN favorites fv 
+		ON p.id = fv.post_id 
+		WHERE fv.user_id = $1 AND (p.status = 'ready' OR p.user_id = $1)
 	`
+	if page.After != "" {
+		args = append(args, page.After)
+		query += `AND fv.id < $3 `
+	}
+	query += `ORDER BY fv.id DESC LIMIT $2`
 
-	rows, err := r.db.QueryContext(ctx, query, userID)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return []models.Post{}, fmt.Errorf("Failed to get data: %s", err.Error())
+		return nil, "", fmt.Errorf("Failed to get data: %s", err.Error())
 	}
 	defer rows.Close()
 
+	var favoriteIDs []string
 	for rows.Next() {
+		var favoriteID string
 		var post models.Post
-		err := rows.Scan(&post.ID, &post.Caption, &post.UserID, &post.Media, &post.CreatedAt, &post.UpdatedAt)
+		err := rows.Scan(&favoriteID, &post.ID, &post.Caption, &post.UserID, &post.Status, &post.CreatedAt, &post.UpdatedAt)
 		if err != nil {
-			return []models.Post{}, fmt.Errorf("Failed to scan: %s", err.Error())
+			return nil, "", fmt.Errorf("Failed to scan: %s", err.Error())
 		}
 		posts = append(posts, post)
+		favoriteIDs = append(favoriteIDs, favoriteID)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("Failed to get data: %s", err.Error())
+	}
+
+	count := len(posts)
+	posts = pagination.Trim(page, posts)
 
-	return posts, nil
+	if err := loadPostMedia(ctx, r.db, postRefs(posts)...); err != nil {
+		return nil, "", fmt.Errorf("Failed to get media: %s", err.Error())
+	}
+
+	var last string
+	if len(posts) > 0 {
+		last = favoriteIDs[len(posts)-1]
+	}
+	return posts, page.Next(count, last), nil
 }
 
-func (r *FavoriteRepo) Delete(ctx context.Context, favoriteID string) error {
+func (r *FavoriteRepo) Delete(ctx context.Context, postID, userID string) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("Failed to begin transaction: %s", err.Error())
 	}
+	defer tx.Rollback()
 
-	defer func() {
-		if err != nil {
-			if rbErr := tx.Rollback(); err != nil {
-				r.logger.Error("Database Error", "Failed to rollback", rbErr.Error())
-			}
+	event := models.FavoriteEvent{PostID: postID, UserID: userID}
+	query := `
+		DELETE FROM favorites f WHERE f.post_id = $1 AND f.user_id = $2
+		RETURNING f.id, (SELECT p.user_id FROM posts p WHERE p.id = f.post_id)
+	`
+	err = tx.QueryRowContext(ctx, query, postID, userID).Scan(&event.FavoriteID, &event.PostAuthorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrFavoriteNotFound
 		}
-	}()
-
-	query := `DELETE FROM favorites WHERE id = $1`
+		return fmt.Errorf("Failed to delete data: %s", err.Error())
+	}
 
-	_, err = r.db.ExecContext(ctx, query, favoriteID)
-	if err != nil {
+	if err := insertEvent(ctx, tx, "favorite", event.FavoriteID, models.EventFavoriteDeleted, event); err != nil {
 		return fmt.Errorf("Failed to delete data: %s", err.Error())
 	}
 
@@ -111,4 +147,4 @@ func (r *FavoriteRepo) Delete(ctx context.Context, favoriteID string) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
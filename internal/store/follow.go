@@ -3,21 +3,32 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 
 	"github.com/cakra17/social/internal/models"
 	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
 )
 
+var ErrFollowNotFound = errors.New("follow not found")
+
 type FollowRepo struct {
-	db *sql.DB
-	logger *utils.Logger
+	db       *sql.DB
+	timeline *Timeline
+	logger   *utils.Logger
 }
 
-func NewFollowRepo(db *sql.DB, lg *utils.Logger) FollowRepo {
-	return FollowRepo{db: db, logger: lg}
+func NewFollowRepo(db *sql.DB, tl *Timeline, lg *utils.Logger) FollowRepo {
+	return FollowRepo{db: db, timeline: tl, logger: lg}
 }
 
 func (r *FollowRepo) Follow(ctx context.Context, f models.Follow) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO followers (
 			id, followers_id, followee_id
@@ -25,66 +36,100 @@ func (r *FollowRepo) Follow(ctx context.Context, f models.Follow) error {
 			$1, $2, $3
 		)
 	`
-	_, err := r.db.ExecContext(ctx, query, f.ID, f.FollowerID, f.FolloweeID)
+	_, err = tx.ExecContext(ctx, query, f.ID, f.FollowerID, f.FolloweeID)
 	if err != nil {
 		return err
 	}
+	if err := addFollowers(ctx, tx, f.FolloweeID, 1); err != nil {
+		return err
+	}
+
+	event := models.FollowEvent{
+		FollowID:   f.ID,
+		FollowerID: f.FollowerID,
+		FolloweeID: f.FolloweeID,
+	}
+	if err := insertEvent(ctx, tx, "follow", f.ID, models.EventFollowCreated, event); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if r.timeline != nil {
+		if err := r.timeline.AddAuthor(ctx, f.FollowerID, f.FolloweeID); err != nil {
+			r.logger.Error("Timeline Error", "Failed to backfill timeline", err.Error())
+		}
+	}
 	return nil
 }
 
-func (r *FollowRepo) GetFollowers(ctx context.Context, userId string) ([]models.Follower, error) {
-	var followers []models.Follower
-	query := `
-		SELECT f.id, u.id AS user_id, u.username 
-		FROM followers f 
-		INNER JOIN users u ON u.id = f.followers_id 
-		WHERE f.followee_id = $1
-	`
+// addFollowers keeps users.followers_count in step with the followers table,
+// the timeline reads it to tell celebrity accounts apart.
+func addFollowers(ctx context.Context, tx *sql.Tx, userID string, delta int) error {
+	query := `UPDATE users SET followers_count = followers_count + $2 WHERE id = $1`
+	_, err := tx.ExecContext(ctx, query, userID, delta)
+	return err
+}
+
+func (r *FollowRepo) queryFollowers(ctx context.Context, query string, userId string, page pagination.Page) ([]models.Follower, string, error) {
+	followers := []models.Follower{}
+	args := []any{userId, page.Fetch()}
+	if page.After != "" {
+		args = append(args, page.After)
+		query += `AND f.id < $3 `
+	}
+	query += `ORDER BY f.id DESC LIMIT $2`
 
-	rows, err := r.db.QueryContext(ctx, query, userId)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var follower models.Follower
 		if err := rows.Scan(&follower.ID, &follower.UserID, &follower.Username); err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		followers = append(followers, follower)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
 
-	return followers, nil
+	count := len(followers)
+	followers = pagination.Trim(page, followers)
+
+	var last string
+	if len(followers) > 0 {
+		last = followers[len(followers)-1].ID
+	}
+	return followers, page.Next(count, last), nil
 }
 
-func (r *FollowRepo) GetFollowing(ctx context.Context, userId string) ([]models.Follower, error) {
-	var followers []models.Follower
+func (r *FollowRepo) GetFollowers(ctx context.Context, userId string, page pagination.Page) ([]models.Follower, string, error) {
+	query := `
+		SELECT f.id, u.id AS user_id, u.username 
+		FROM followers f 
+		INNER JOIN users u ON u.id = f.followers_id 
+		WHERE f.followee_id = $1
+	`
+	return r.queryFollowers(ctx, query, userId, page)
+}
+
+func (r *FollowRepo) GetFollowing(ctx context.Context, userId string, page pagination.Page) ([]models.Follower, string, error) {
 	query := `
 		SELECT f.id, u.id AS user_id, u.username 
 		FROM followers f 
 		INNER JOIN users u ON u.id = f.followee_id 
 		WHERE f.followers_id = $1
 	`
-
-	rows, err := r.db.QueryContext(ctx, query, userId)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var follower models.Follower
-		if err := rows.Scan(&follower.ID, &follower.UserID, &follower.Username); err != nil {
-			return nil, err
-		}
-		followers = append(followers, follower)
-	}
-
-	return followers, nil
+	return r.queryFollowers(ctx, query, userId, page)
 }
 
-func (r *FollowRepo) Unfollow(ctx context.Context, id string) error {
+func (r *FollowRepo) Unfollow(ctx context.Context, id, userID string) error {
 	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
@@ -94,11 +139,36 @@ func (r *FollowRepo) Unfollow(ctx context.Context, id string) error {
 	}
 	defer tx.Rollback()
 
-	query := `DELETE FROM followers WHERE id = $1`
-	_, err = r.db.ExecContext(ctx, query, id)
+	var followeeID string
+	query := `DELETE FROM followers WHERE id = $1 AND followers_id = $2 RETURNING followee_id`
+	err = tx.QueryRowContext(ctx, query, id, userID).Scan(&followeeID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return resolveOwnership(ctx, tx, "followers", id, ErrFollowNotFound)
+		}
+		return err
+	}
+	if err := addFollowers(ctx, tx, followeeID, -1); err != nil {
+		return err
+	}
+
+	event := models.FollowEvent{
+		FollowID:   id,
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	}
+	if err := insertEvent(ctx, tx, "follow", id, models.EventFollowDeleted, event); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
 		return err
 	}
 
-	return tx.Commit()
-}
\ No newline at end of file
+	if r.timeline != nil {
+		if err := r.timeline.RemoveAuthor(ctx, userID, followeeID); err != nil {
+			r.logger.Error("Timeline Error", "Failed to clean timeline", err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/utils"
+)
+
+var (
+	insertFollow   = regexp.QuoteMeta(`INSERT INTO followers`)
+	deleteFollow   = regexp.QuoteMeta(`DELETE FROM followers WHERE id = $1 AND followers_id = $2 RETURNING followee_id`)
+	countFollowers = regexp.QuoteMeta(`UPDATE users SET followers_count = followers_count + $2 WHERE id = $1`)
+	insertOutbox   = regexp.QuoteMeta(`INSERT INTO outbox`)
+	followExists   = regexp.QuoteMeta(`SELECT EXISTS (SELECT 1 FROM followers WHERE id = $1)`)
+)
+
+func TestFollowCountsFollowers(t *testing.T) {
+	ids := newIDs(3)
+	f := models.Follow{ID: ids[0], FollowerID: ids[1], FolloweeID: ids[2]}
+
+	db, mock := newTestDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(insertFollow).WithArgs(f.ID, f.FollowerID, f.FolloweeID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(countFollowers).WithArgs(f.FolloweeID, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(insertOutbox).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	repo := NewFollowRepo(db, nil, utils.NewLogger())
+	if err := repo.Follow(context.Background(), f); err != nil {
+		t.Fatalf("Failed to follow: %v", err)
+	}
+}
+
+func TestUnfollow(t *testing.T) {
+	ids := newIDs(3)
+	followID, userID, followeeID := ids[0], ids[1], ids[2]
+
+	tests := []struct {
+		name    string
+		deleted bool
+		// exists is whether a follow of another user has the id
+		exists  bool
+		wantErr error
+	}{
+		{"deleted", true, false, nil},
+		{"follow of another user", false, true, policy.ErrForbidden},
+		{"not found", false, false, ErrFollowNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			mock.ExpectBegin()
+			if tt.deleted {
+				mock.ExpectQuery(deleteFollow).WithArgs(followID, userID).WillReturnRows(mock.NewRows([]string{"followee_id"}).AddRow(followeeID))
+				mock.ExpectExec(countFollowers).WithArgs(followeeID, -1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(insertOutbox).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectQuery(deleteFollow).WithArgs(followID, userID).WillReturnRows(mock.NewRows([]string{"followee_id"}))
+				mock.ExpectQuery(followExists).WithArgs(followID).WillReturnRows(mock.NewRows([]string{"exists"}).AddRow(tt.exists))
+				mock.ExpectRollback()
+			}
+
+			repo := NewFollowRepo(db, nil, utils.NewLogger())
+			err := repo.Unfollow(context.Background(), followID, userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,14 +3,19 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/cakra17/social/internal/models"
 	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+	"github.com/lib/pq"
 )
 
+var ErrLikeNotFound = errors.New("like not found")
+
 type LikesRepo struct {
-	db *sql.DB
+	db     *sql.DB
 	logger *utils.Logger
 }
 
@@ -18,85 +23,145 @@ func NewLikesRepo(db *sql.DB, lg *utils.Logger) LikesRepo {
 	return LikesRepo{db: db, logger: lg}
 }
 
-func (r *LikesRepo) Like(ctx context.Context, likes models.Likes) error {
+// Like adds the like of a user to a post. A post already liked by the user
+// keeps its first like, whose id is set on likes, and no event is sent again.
+func (r *LikesRepo) Like(ctx context.Context, likes *models.Likes) error {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("begin transaction error: %s", err.Error())
 	}
+	defer tx.Rollback()
 
-	defer func() {
-		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				r.logger.Error("Database Error", "rollback failed", err.Error())
-			}
-		}
-	}()
-
+	var authorID string
 	query := `
 		INSERT INTO likes (id, post_id, user_id) VALUES ($1, $2, $3)
+		ON CONFLICT (post_id, user_id) DO NOTHING
+		RETURNING (SELECT user_id FROM posts WHERE id = $2)
 	`
-
-	_, err = r.db.ExecContext(ctx, query, likes.ID, likes.PostId, likes.UserId)
+	err = tx.QueryRowContext(ctx, query, likes.ID, likes.PostId, likes.UserId).Scan(&authorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		query := `SELECT id FROM likes WHERE post_id = $1 AND user_id = $2`
+		if err := tx.QueryRowContext(ctx, query, likes.PostId, likes.UserId).Scan(&likes.ID); err != nil {
+			return fmt.Errorf("Failed to get like: %s", err.Error())
+		}
+		return nil
+	}
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrPostNotFound
+		}
+		return fmt.Errorf("Failed to add like: %s", err.Error())
+	}
+
+	event := models.LikeEvent{
+		LikeID:       likes.ID,
+		PostID:       likes.PostId,
+		UserID:       likes.UserId,
+		PostAuthorID: authorID,
+	}
+	if err := insertEvent(ctx, tx, "like", likes.ID, models.EventLikeCreated, event); err != nil {
+		return fmt.Errorf("Failed to add like: %s", err.Error())
+	}
+
+	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("Failed to add like: %s", err.Error())
 	}
 
 	return nil
 }
 
-func (r *LikesRepo) GetLikes(ctx context.Context, postId string) (models.Likes,error) {
-	var likes models.Likes
+func (r *LikesRepo) GetLikes(ctx context.Context, postId string, page pagination.Page) (models.Likes, string, error) {
+	likes := models.Likes{PostId: postId, Users: []models.User{}}
+
+	countQuery := `SELECT COUNT(*) FROM likes WHERE post_id = $1`
+	if err := r.db.QueryRowContext(ctx, countQuery, postId).Scan(&likes.LikesCount); err != nil {
+		return models.Likes{}, "", fmt.Errorf("Failed to count likes: %s", err.Error())
+	}
+
+	args := []any{postId, page.Fetch()}
 	query := `
-		SELECT u.id, u.username FROM users u INNER JOIN likes l ON u.id = l.user_id WHERE l.post_id = $1
+		SELECT l.id, u.id, u.username FROM users u INNER JOIN likes l ON u.id = l.user_id WHERE l.post_id = $1
 	`
-	row, err := r.db.QueryContext(ctx, query, postId)	
+	if page.After != "" {
+		args = append(args, page.After)
+		query += `AND l.id < $3 `
+	}
+	query += `ORDER BY l.id DESC LIMIT $2`
+
+	row, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return models.Likes{}, fmt.Errorf("Failed post's likes not found")
-		}
-		return models.Likes{}, fmt.Errorf("Failed to get like data: %s", err.Error())
+		return models.Likes{}, "", fmt.Errorf("Failed to get like data: %s", err.Error())
 	}
-	defer row.Close()	
+	defer row.Close()
 
+	var likeIDs []string
 	for row.Next() {
-		var user models.User 
-		err := row.Scan(&user.ID, &user.Username)
+		var likeID string
+		var user models.User
+		err := row.Scan(&likeID, &user.ID, &user.Username)
 		if err != nil {
-			return models.Likes{}, err
+			return models.Likes{}, "", err
 		}
 		likes.Users = append(likes.Users, user)
+		likeIDs = append(likeIDs, likeID)
+	}
+	if err := row.Err(); err != nil {
+		return models.Likes{}, "", err
 	}
 
-	likes.LikesCount = len(likes.Users)
-	return likes, nil
+	count := len(likes.Users)
+	likes.Users = pagination.Trim(page, likes.Users)
+
+	var last string
+	if len(likes.Users) > 0 {
+		last = likeIDs[len(likes.Users)-1]
+	}
+	return likes, page.Next(count, last), nil
+}
+
+func (r *LikesRepo) Count(ctx context.Context, postID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	var count int
+	query := `SELECT COUNT(*) FROM likes WHERE post_id = $1`
+	if err := r.db.QueryRowContext(ctx, query, postID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
-func (r *LikesRepo) Unlike(ctx context.Context, postId string) error {
-	ctx, cancel := context.WithTimeout(ctx, defaultTimeout) 
+func (r *LikesRepo) Unlike(ctx context.Context, id, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	defer func() {
-		if err != nil {
-			if rbErr := tx.Rollback(); rbErr != nil {
-				r.logger.Error("Database Error", "rollback failed", err.Error())
-			}
+	event := models.LikeEvent{LikeID: id, UserID: userID}
+	query := `
+		DELETE FROM likes l WHERE l.id = $1 AND l.user_id = $2
+		RETURNING l.post_id, (SELECT p.user_id FROM posts p WHERE p.id = l.post_id)
+	`
+	err = tx.QueryRowContext(ctx, query, id, userID).Scan(&event.PostID, &event.PostAuthorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return resolveOwnership(ctx, tx, "likes", id, ErrLikeNotFound)
 		}
-	}()
+		return err
+	}
 
-	query := `DELETE FROM likes WHERE id = $1`
-	_, err = r.db.ExecContext(ctx, query, postId)
-	if err != nil {
+	if err := insertEvent(ctx, tx, "like", id, models.EventLikeDeleted, event); err != nil {
 		return err
 	}
 
-	if err != tx.Commit() {
+	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("Failed to unlike: %s", err.Error())
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,349 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// notificationActorsShown is how many actors are listed per notification.
+const notificationActorsShown = 3
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+// NotificationActivity is one actor acting on the subject of a
+// notification group.
+type NotificationActivity struct {
+	UserID    string
+	Type      string
+	PostID    *string
+	CommentID *string
+	// GroupKey identifies the subject, activity with the same key is
+	// aggregated while the notification is unread.
+	GroupKey string
+	ActorID  string
+	// EventID is the id of the event the activity comes from, an event is
+	// only ever counted once per recipient.
+	EventID string
+}
+
+type NotificationRepo struct {
+	db     *sql.DB
+	logger *utils.Logger
+}
+
+func NewNotificationRepo(db *sql.DB, lg *utils.Logger) NotificationRepo {
+	return NotificationRepo{db: db, logger: lg}
+}
+
+// Add records the activity in the unread notification of its group,
+// creating it when every earlier one was read, and returns the id of the
+// notification. Activity on a subject, or by an actor, deleted in the
+// meantime is dropped and no id is returned.
+func (r *NotificationRepo) Add(ctx context.Context, a NotificationActivity) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var notificationID string
+	query := `
+		SELECT n.id FROM notification_actors a
+		INNER JOIN notifications n ON n.id = a.notification_id
+		WHERE a.event_id = $1 AND n.user_id = $2
+		LIMIT 1
+	`
+	err = tx.QueryRowContext(ctx, query, a.EventID, a.UserID).Scan(&notificationID)
+	if err == nil {
+		return notificationID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+
+	query = `
+		INSERT INTO notifications (
+			id, user_id, type, post_id, comment_id, group_key, last_event_id
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		)
+		ON CONFLICT (user_id, group_key) WHERE read_at IS NULL
+		DO UPDATE SET last_event_id = GREATEST(notifications.last_event_id, EXCLUDED.last_event_id)
+		RETURNING id
+	`
+	err = tx.QueryRowContext(
+		ctx, query,
+		id.String(),
+		a.UserID,
+		a.Type,
+		a.PostID,
+		a.CommentID,
+		a.GroupKey,
+		a.EventID,
+	).Scan(&notificationID)
+	if err != nil {
+		return "", ignoreDeleted(err)
+	}
+
+	query = `
+		INSERT INTO notification_actors (
+			notification_id, actor_id, event_id
+		) VALUES (
+			$1, $2, $3
+		)
+		ON CONFLICT (notification_id, actor_id)
+		DO UPDATE SET event_id = EXCLUDED.event_id, created_at = NOW()
+	`
+	if _, err := tx.ExecContext(ctx, query, notificationID, a.ActorID, a.EventID); err != nil {
+		return "", ignoreDeleted(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return notificationID, nil
+}
+
+// ignoreDeleted swallows foreign key violations.
+func ignoreDeleted(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		return nil
+	}
+	return err
+}
+
+// RemoveActor takes back the activity of an actor, e.g. after an unlike,
+// from the unread notification of the group. A notification left without
+// actors is deleted. Read notifications are left alone.
+func (r *NotificationRepo) RemoveActor(ctx context.Context, userID, groupKey, actorID string) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM notification_actors a
+		USING notifications n
+		WHERE a.notification_id = n.id
+		AND n.user_id = $1 AND n.group_key = $2 AND n.read_at IS NULL
+		AND a.actor_id = $3
+	`
+	if _, err := tx.ExecContext(ctx, query, userID, groupKey, actorID); err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM notifications n
+		WHERE n.user_id = $1 AND n.group_key = $2 AND n.read_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM notification_actors a WHERE a.notification_id = n.id)
+	`
+	if _, err := tx.ExecContext(ctx, query, userID, groupKey); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const notificationSelect = `
+	SELECT
+		n.id,
+		n.type,
+		n.post_id,
+		n.comment_id,
+		n.last_event_id,
+		n.read_at IS NOT NULL,
+		(SELECT COUNT(*) FROM notification_actors a WHERE a.notification_id = n.id),
+		n.created_at,
+		n.updated_at
+	FROM notifications n
+`
+
+// queryNotifications runs a query selecting notificationSelect and returns
+// the notifications with their actors, along with their last event ids.
+func (r *NotificationRepo) queryNotifications(ctx context.Context, query string, args ...any) ([]models.Notification, []string, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	var lastEventIDs []string
+	for rows.Next() {
+		var lastEventID string
+		n := models.Notification{Actors: []models.NotificationActor{}}
+		err := rows.Scan(
+			&n.ID,
+			&n.Type,
+			&n.PostID,
+			&n.CommentID,
+			&lastEventID,
+			&n.Read,
+			&n.ActorCount,
+			&n.CreatedAt,
+			&n.UpdatedAt,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		notifications = append(notifications, n)
+		lastEventIDs = append(lastEventIDs, lastEventID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := r.loadActors(ctx, notifications); err != nil {
+		return nil, nil, err
+	}
+	for i := range notifications {
+		notifications[i].Message = notifications[i].Describe()
+	}
+
+	return notifications, lastEventIDs, nil
+}
+
+// List returns the notifications of the user, most recent activity first.
+// The cursor is the id of the latest event of a notification.
+func (r *NotificationRepo) List(ctx context.Context, userID string, unreadOnly bool, page pagination.Page) ([]models.Notification, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	args := []any{userID, page.Fetch()}
+	query := notificationSelect + `WHERE n.user_id = $1 `
+	if unreadOnly {
+		query += `AND n.read_at IS NULL `
+	}
+	if page.After != "" {
+		args = append(args, page.After)
+		query += `AND n.last_event_id < $3 `
+	}
+	query += `ORDER BY n.last_event_id DESC LIMIT $2`
+
+	notifications, lastEventIDs, err := r.queryNotifications(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	count := len(notifications)
+	notifications = pagination.Trim(page, notifications)
+
+	var last string
+	if len(notifications) > 0 {
+		last = lastEventIDs[len(notifications)-1]
+	}
+	return notifications, page.Next(count, last), nil
+}
+
+func (r *NotificationRepo) Get(ctx context.Context, userID, id string) (*models.Notification, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := notificationSelect + `WHERE n.user_id = $1 AND n.id = $2`
+	notifications, _, err := r.queryNotifications(ctx, query, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(notifications) == 0 {
+		return nil, ErrNotificationNotFound
+	}
+	return &notifications[0], nil
+}
+
+// loadActors fills in the most recent actors of each notification.
+func (r *NotificationRepo) loadActors(ctx context.Context, notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(notifications))
+	byID := make(map[string]*models.Notification, len(notifications))
+	for i := range notifications {
+		ids[i] = notifications[i].ID
+		byID[notifications[i].ID] = &notifications[i]
+	}
+
+	query := `
+		SELECT notification_id, actor_id, username FROM (
+			SELECT
+				a.notification_id,
+				a.actor_id,
+				u.username,
+				ROW_NUMBER() OVER (PARTITION BY a.notification_id ORDER BY a.created_at DESC, a.event_id DESC) AS rank
+			FROM notification_actors a
+			INNER JOIN users u ON u.id = a.actor_id
+			WHERE a.notification_id = ANY($1)
+		) ranked
+		WHERE rank <= $2
+		ORDER BY notification_id, rank
+	`
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(ids), notificationActorsShown)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var actor models.NotificationActor
+		if err := rows.Scan(&id, &actor.ID, &actor.Username); err != nil {
+			return err
+		}
+		if n, ok := byID[id]; ok {
+			n.Actors = append(n.Actors, actor)
+		}
+	}
+	return rows.Err()
+}
+
+func (r *NotificationRepo) UnreadCount(ctx context.Context, userID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	var count int
+	query := `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// MarkRead marks the given notifications of the user as read, or all of
+// them when ids is empty, and returns how many were unread.
+func (r *NotificationRepo) MarkRead(ctx context.Context, userID string, ids []string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	args := []any{userID}
+	query := `UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL`
+	if len(ids) > 0 {
+		args = append(args, pq.Array(ids))
+		query += ` AND id = ANY($2)`
+	}
+
+	res, err := r.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+	"github.com/lib/pq"
+)
+
+var (
+	selectNotifiedEvent = regexp.QuoteMeta(`WHERE a.event_id = $1 AND n.user_id = $2`)
+	upsertNotification  = regexp.QuoteMeta(`ON CONFLICT (user_id, group_key) WHERE read_at IS NULL`)
+	upsertActor         = regexp.QuoteMeta(`ON CONFLICT (notification_id, actor_id)`)
+	selectNotifications = regexp.QuoteMeta(`FROM notifications n WHERE n.user_id = $1`)
+	selectActors        = regexp.QuoteMeta(`FROM notification_actors a INNER JOIN users u ON u.id = a.actor_id`)
+)
+
+func TestAddNotification(t *testing.T) {
+	ids := newIDs(4)
+	userID, actorID, eventID, unreadID := ids[0], ids[1], ids[2], ids[3]
+	activity := NotificationActivity{
+		UserID:   userID,
+		Type:     models.NotificationLike,
+		GroupKey: "like:post-1",
+		ActorID:  actorID,
+		EventID:  eventID,
+	}
+
+	tests := []struct {
+		name   string
+		expect func(sqlmock.Sqlmock)
+		wantID string
+	}{
+		// the unread notification of the group is returned by the upsert
+		{"aggregated", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectNotifiedEvent).WithArgs(eventID, userID).WillReturnRows(idRows())
+			mock.ExpectQuery(upsertNotification).
+				WithArgs(sqlmock.AnyArg(), userID, models.NotificationLike, nil, nil, "like:post-1", eventID).
+				WillReturnRows(idRows(unreadID))
+			mock.ExpectExec(upsertActor).WithArgs(unreadID, actorID, eventID).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, unreadID},
+		// a retried delivery of the event is not counted twice
+		{"event already counted", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectNotifiedEvent).WithArgs(eventID, userID).WillReturnRows(idRows(unreadID))
+			mock.ExpectRollback()
+		}, unreadID},
+		{"post deleted", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectNotifiedEvent).WithArgs(eventID, userID).WillReturnRows(idRows())
+			mock.ExpectQuery(upsertNotification).WillReturnError(&pq.Error{Code: "23503"})
+			mock.ExpectRollback()
+		}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			mock.ExpectBegin()
+			tt.expect(mock)
+
+			repo := NewNotificationRepo(db, utils.NewLogger())
+			id, err := repo.Add(context.Background(), activity)
+			if err != nil {
+				t.Fatalf("Failed to add notification: %v", err)
+			}
+			if id != tt.wantID {
+				t.Errorf("got notification %q want %q", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestAddNotificationError(t *testing.T) {
+	db, mock := newTestDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectNotifiedEvent).WillReturnError(errors.New("database failed"))
+	mock.ExpectRollback()
+
+	repo := NewNotificationRepo(db, utils.NewLogger())
+	if _, err := repo.Add(context.Background(), NotificationActivity{}); err == nil {
+		t.Error("got no error want the database error")
+	}
+}
+
+func TestListNotificationsAggregated(t *testing.T) {
+	ids := newIDs(4)
+	userID, first, second, eventID := ids[0], ids[1], ids[2], ids[3]
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		kind        string
+		actorCount  int
+		actors      []string
+		wantMessage string
+	}{
+		{"one actor", models.NotificationFollow, 1, []string{"alice"}, "alice started following you"},
+		{"two actors", models.NotificationLike, 2, []string{"alice", "bob"}, "alice and bob liked your post"},
+		{"many actors", models.NotificationLike, 13, []string{"alice", "bob", "carol"}, "alice and 12 others liked your post"},
+		// the other actors were deleted in the meantime
+		{"two actors with one left", models.NotificationFavorite, 2, []string{"alice"}, "alice and 1 other saved your post"},
+		{"reply", models.NotificationReply, 1, []string{"alice"}, "alice replied to your comment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			rows := sqlmock.NewRows([]string{
+				"id", "type", "post_id", "comment_id", "last_event_id",
+				"read", "actor_count", "created_at", "updated_at",
+			}).
+				AddRow(first, tt.kind, nil, nil, eventID, false, tt.actorCount, now, now).
+				AddRow(second, tt.kind, nil, nil, ids[0], true, tt.actorCount, now, now)
+			mock.ExpectQuery(selectNotifications).WithArgs(userID, 21).WillReturnRows(rows)
+			actors := sqlmock.NewRows([]string{"notification_id", "actor_id", "username"})
+			for _, username := range tt.actors {
+				actors.AddRow(first, "id-"+username, username)
+				actors.AddRow(second, "id-"+username, username)
+			}
+			mock.ExpectQuery(selectActors).WithArgs(sqlmock.AnyArg(), notificationActorsShown).WillReturnRows(actors)
+
+			repo := NewNotificationRepo(db, utils.NewLogger())
+			notifications, next, err := repo.List(context.Background(), userID, false, pagination.Page{Limit: 20})
+			if err != nil {
+				t.Fatalf("Failed to list notifications: %v", err)
+			}
+			if len(notifications) != 2 || next != "" {
+				t.Fatalf("got %d notifications and cursor %q want 2 and none", len(notifications), next)
+			}
+
+			n := notifications[0]
+			if n.Message != tt.wantMessage {
+				t.Errorf("got message %q want %q", n.Message, tt.wantMessage)
+			}
+			if len(n.Actors) != len(tt.actors) || n.ActorCount != tt.actorCount {
+				t.Errorf("got %d of %d actors want %d of %d", len(n.Actors), n.ActorCount, len(tt.actors), tt.actorCount)
+			}
+			if n.Read || !notifications[1].Read {
+				t.Errorf("got read %v and %v want false and true", n.Read, notifications[1].Read)
+			}
+		})
+	}
+}
+
+func TestMarkRead(t *testing.T) {
+	userID, id := newIDs(1)[0], newIDs(1)[0]
+
+	tests := []struct {
+		name      string
+		ids       []string
+		wantQuery string
+	}{
+		{"all", nil, `UPDATE notifications SET read_at = NOW() WHERE user_id = $1 AND read_at IS NULL`},
+		{"some", []string{id}, `AND id = ANY($2)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			exec := mock.ExpectExec(regexp.QuoteMeta(tt.wantQuery))
+			if tt.ids == nil {
+				exec.WithArgs(userID)
+			} else {
+				exec.WithArgs(userID, pq.Array(tt.ids))
+			}
+			exec.WillReturnResult(sqlmock.NewResult(0, 1))
+
+			repo := NewNotificationRepo(db, utils.NewLogger())
+			n, err := repo.MarkRead(context.Background(), userID, tt.ids)
+			if err != nil {
+				t.Fatalf("Failed to mark notifications read: %v", err)
+			}
+			if n != 1 {
+				t.Errorf("got %d marked want 1", n)
+			}
+		})
+	}
+}
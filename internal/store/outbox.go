@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// insertEvent records an event in the outbox. It must run in the
+// transaction of the change it describes so both commit or neither does.
+func insertEvent(ctx context.Context, tx *sql.Tx, aggregateType, aggregateID, eventType string, payload any) error {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO outbox (
+			id, aggregate_type, aggregate_id, event_type, payload
+		) VALUES (
+			$1, $2, $3, $4, $5
+		)
+	`
+	_, err = tx.ExecContext(ctx, query, id.String(), aggregateType, aggregateID, eventType, data)
+	return err
+}
+
+type OutboxRepo struct {
+	db     *sql.DB
+	logger *utils.Logger
+}
+
+func NewOutboxRepo(db *sql.DB, lg *utils.Logger) OutboxRepo {
+	return OutboxRepo{db: db, logger: lg}
+}
+
+// Dispatch hands up to limit pending events to publish in the order they
+// were recorded and marks the published ones as dispatched. Rows are locked
+// while publishing so concurrent relays never publish the same event, a
+// relay that dies before committing leaves its events pending and they are
+// published again. It stops at the first failure and returns how many
+// events were dispatched.
+func (r *OutboxRepo) Dispatch(ctx context.Context, limit int, publish func(context.Context, models.Event) error) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, aggregate_type, aggregate_id, event_type, payload, created_at
+		FROM outbox
+		WHERE dispatched_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	events := []models.Event{}
+	for rows.Next() {
+		var event models.Event
+		err := rows.Scan(
+			&event.ID,
+			&event.AggregateType,
+			&event.AggregateID,
+			&event.Type,
+			&event.Payload,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var publishErr error
+	dispatched := make([]string, 0, len(events))
+	for _, event := range events {
+		if publishErr = publish(ctx, event); publishErr != nil {
+			break
+		}
+		dispatched = append(dispatched, event.ID)
+	}
+
+	if len(dispatched) > 0 {
+		query = `UPDATE outbox SET dispatched_at = NOW() WHERE id = ANY($1)`
+		if _, err := tx.ExecContext(ctx, query, pq.Array(dispatched)); err != nil {
+			return 0, errors.Join(publishErr, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, errors.Join(publishErr, err)
+		}
+	}
+
+	return len(dispatched), publishErr
+}
+
+// Prune deletes the events dispatched before the given time.
+func (r *OutboxRepo) Prune(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	res, err := r.db.ExecContext(ctx, `DELETE FROM outbox WHERE dispatched_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
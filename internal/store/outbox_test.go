@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/utils"
+)
+
+var (
+	selectPending = regexp.QuoteMeta(`FROM outbox WHERE dispatched_at IS NULL`)
+	markSent      = regexp.QuoteMeta(`UPDATE outbox SET dispatched_at = NOW() WHERE id = ANY($1)`)
+)
+
+func pendingRows(ids ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "aggregate_type", "aggregate_id", "event_type", "payload", "created_at"})
+	for _, id := range ids {
+		rows.AddRow(id, "post", "post-1", models.EventPostCreated, []byte(`{}`), time.Now())
+	}
+	return rows
+}
+
+func TestDispatch(t *testing.T) {
+	errPublish := errors.New("publish failed")
+
+	tests := []struct {
+		name    string
+		pending []string
+		// failAt is the event publishing fails on
+		failAt string
+		// marked are the events marked as dispatched, the transaction is
+		// rolled back when there are none
+		marked    string
+		want      int
+		wantErr   error
+		published []string
+	}{
+		{"nothing pending", nil, "", "", 0, nil, nil},
+		{"all published", []string{"e1", "e2", "e3"}, "", `{"e1","e2","e3"}`, 3, nil, []string{"e1", "e2", "e3"}},
+		{"stops at the first failure", []string{"e1", "e2", "e3"}, "e2", `{"e1"}`, 1, errPublish, []string{"e1", "e2"}},
+		{"first event fails", []string{"e1", "e2"}, "e1", "", 0, errPublish, []string{"e1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+			if err != nil {
+				t.Fatalf("Failed to create mock: %v", err)
+			}
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(selectPending).WithArgs(10).WillReturnRows(pendingRows(tt.pending...))
+			if tt.marked != "" {
+				mock.ExpectExec(markSent).WithArgs(tt.marked).WillReturnResult(sqlmock.NewResult(0, int64(tt.want)))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			var published []string
+			repo := NewOutboxRepo(db, utils.NewLogger())
+			n, err := repo.Dispatch(context.Background(), 10, func(_ context.Context, event models.Event) error {
+				published = append(published, event.ID)
+				if event.ID == tt.failAt {
+					return errPublish
+				}
+				return nil
+			})
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v want %v", err, tt.wantErr)
+			}
+			if n != tt.want {
+				t.Errorf("dispatched %d want %d", n, tt.want)
+			}
+			if !slices.Equal(published, tt.published) {
+				t.Errorf("published %v want %v", published, tt.published)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDispatchKeepsEventsWhenMarkingFails(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer db.Close()
+
+	errDB := errors.New("connection lost")
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectPending).WillReturnRows(pendingRows("e1"))
+	mock.ExpectExec(markSent).WillReturnError(errDB)
+	mock.ExpectRollback()
+
+	repo := NewOutboxRepo(db, utils.NewLogger())
+	n, err := repo.Dispatch(context.Background(), 10, func(context.Context, models.Event) error { return nil })
+	// the event stays pending and is published again
+	if n != 0 || !errors.Is(err, errDB) {
+		t.Errorf("got %d, %v want 0, %v", n, err, errDB)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
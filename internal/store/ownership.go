@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cakra17/social/internal/policy"
+)
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// resolveOwnership explains why a write scoped to its owner matched no row:
+// the row either belongs to somebody else or does not exist.
+func resolveOwnership(ctx context.Context, q queryer, table, id string, notFound error) error {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM ` + table + ` WHERE id = $1)`
+	if err := q.QueryRowContext(ctx, query, id).Scan(&exists); err != nil {
+		return err
+	}
+
+	if exists {
+		return policy.ErrForbidden
+	}
+	return notFound
+}
+
+// checkOwnership is resolveOwnership for statements without RETURNING.
+func checkOwnership(ctx context.Context, q queryer, res sql.Result, table, id string, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	return resolveOwnership(ctx, q, table, id, notFound)
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"slices"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/lib/pq"
+)
+
+const MaxPostMedia = 10
+
+// storedVariant is how a models.MediaVariant is kept in post_media.variants,
+// the key is not part of the API but has to be stored.
+type storedVariant struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+func encodeVariants(variants []models.MediaVariant) ([]byte, error) {
+	stored := make([]storedVariant, len(variants))
+	for i, v := range variants {
+		stored[i] = storedVariant{Name: v.Name, Key: v.Key, Width: v.Width, Height: v.Height}
+	}
+	return json.Marshal(stored)
+}
+
+func decodeVariants(data []byte) ([]models.MediaVariant, error) {
+	var stored []storedVariant
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+
+	variants := make([]models.MediaVariant, len(stored))
+	for i, v := range stored {
+		variants[i] = models.MediaVariant{Name: v.Name, Key: v.Key, Width: v.Width, Height: v.Height}
+	}
+	return variants, nil
+}
+
+// MediaKeys returns the keys of every stored file of the media items.
+func MediaKeys(media []models.PostMedia) []string {
+	var keys []string
+	for _, m := range media {
+		keys = append(keys, m.Key)
+		for _, v := range m.Variants {
+			if !slices.Contains(keys, v.Key) {
+				keys = append(keys, v.Key)
+			}
+		}
+	}
+	return keys
+}
+
+func postRefs(posts []models.Post) []*models.Post {
+	refs := make([]*models.Post, len(posts))
+	for i := range posts {
+		refs[i] = &posts[i]
+	}
+	return refs
+}
+
+func insertPostMedia(ctx context.Context, tx *sql.Tx, postID string, media []models.PostMedia) error {
+	query := `
+		INSERT INTO post_media (
+			id, post_id, position, kind, media_key,
+			alt_text, width, height, duration_ms, variants
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+		)
+	`
+	for _, m := range media {
+		variants, err := encodeVariants(m.Variants)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(
+			ctx, query,
+			m.ID,
+			postID,
+			m.Position,
+			cmp.Or(m.Kind, models.MediaKindImage),
+			m.Key,
+			m.AltText,
+			m.Width,
+			m.Height,
+			m.DurationMS,
+			variants,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPostMedia fills the ordered media of the posts with a single query.
+func loadPostMedia(ctx context.Context, db *sql.DB, posts ...*models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(posts))
+	byID := make(map[string]*models.Post, len(posts))
+	for i, post := range posts {
+		ids[i] = post.ID
+		byID[post.ID] = post
+		post.Media = []models.PostMedia{}
+	}
+
+	query := `
+		SELECT id, post_id, position, kind, media_key, alt_text, width, height, duration_ms, variants
+		FROM post_media WHERE post_id = ANY($1)
+		ORDER BY post_id, position
+	`
+	rows, err := db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			m        models.PostMedia
+			postID   string
+			variants []byte
+		)
+		err := rows.Scan(
+			&m.ID,
+			&postID,
+			&m.Position,
+			&m.Kind,
+			&m.Key,
+			&m.AltText,
+			&m.Width,
+			&m.Height,
+			&m.DurationMS,
+			&variants,
+		)
+		if err != nil {
+			return err
+		}
+		if m.Variants, err = decodeVariants(variants); err != nil {
+			return err
+		}
+
+		if post, ok := byID[postID]; ok {
+			post.Media = append(post.Media, m)
+		}
+	}
+
+	return rows.Err()
+}
@@ -1,82 +1,487 @@
 package store
 
 import (
+	"cmp"
 	"context"
 	"database/sql"
+	"errors"
 
 	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
 	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+	"github.com/lib/pq"
 )
 
+var (
+	ErrPostNotFound   = errors.New("post not found")
+	ErrUploadNotReady = errors.New("upload is not finalized or already attached")
+)
+
+const postSelect = `
+	SELECT
+		p.id,
+		COALESCE(p.caption, ''),
+		p.user_id,
+		u.username,
+		p.status,
+		COALESCE(p.processing_error, ''),
+		(SELECT COUNT(*) FROM likes l WHERE l.post_id = p.id),
+		(SELECT COUNT(*) FROM favorites f WHERE f.post_id = p.id),
+		(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND c.deleted_at IS NULL),
+		p.created_at,
+		p.updated_at
+	FROM posts p
+	INNER JOIN users u ON u.id = p.user_id
+`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPost(row rowScanner, post *models.Post) error {
+	return row.Scan(
+		&post.ID,
+		&post.Caption,
+		&post.UserID,
+		&post.Username,
+		&post.Status,
+		&post.ProcessingError,
+		&post.LikesCount,
+		&post.FavoritesCount,
+		&post.CommentsCount,
+		&post.CreatedAt,
+		&post.UpdatedAt,
+	)
+}
+
 type PostRepo struct {
-	db *sql.DB
-	logger *utils.Logger
+	db       *sql.DB
+	timeline *Timeline
+	logger   *utils.Logger
 }
 
-func NewPostRepo(db *sql.DB, lg *utils.Logger) PostRepo {
-	return PostRepo{ db: db, logger: lg }
+func NewPostRepo(db *sql.DB, tl *Timeline, lg *utils.Logger) PostRepo {
+	return PostRepo{db: db, timeline: tl, logger: lg}
 }
 
-func (r *PostRepo) Create(ctx context.Context, post *models.Post) error {
+func insertPost(ctx context.Context, tx *sql.Tx, post *models.Post) error {
+	post.Status = cmp.Or(post.Status, models.PostStatusReady)
+
 	query := `
 		INSERT INTO posts (
-			id, caption, media, 
-			user_id
+			id, caption, user_id, status
 		) VALUES (
 			$1, $2, $3, $4
 		) RETURNING created_at, updated_at
 	`
-	err := r.db.QueryRowContext(
-		ctx, query, 
-		post.ID, 
+	return tx.QueryRowContext(
+		ctx, query,
+		post.ID,
 		post.Caption,
-		post.Media, 
 		post.UserID,
+		post.Status,
 	).Scan(
 		&post.CreatedAt,
 		&post.UpdatedAt,
 	)
+}
+
+func (r *PostRepo) fanOut(ctx context.Context, postID, userID string) {
+	if r.timeline != nil {
+		if err := r.timeline.FanOut(ctx, postID, userID); err != nil {
+			r.logger.Error("Timeline Error", "Failed to fan out post", err.Error())
+		}
+	}
+}
 
+// Create stores a post with its media. The finalized image uploads the
+// media were made from are consumed in the same transaction, so an upload
+// can't end up in two posts.
+func (r *PostRepo) Create(ctx context.Context, post *models.Post, uploadIDs ...string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	if err := insertPost(ctx, tx, post); err != nil {
+		return err
+	}
+
+	if err := insertPostMedia(ctx, tx, post.ID, post.Media); err != nil {
+		return err
+	}
+
+	if len(uploadIDs) > 0 {
+		query := `
+			DELETE FROM uploads
+			WHERE id = ANY($1) AND user_id = $2 AND post_id IS NULL
+			AND finalized_at IS NOT NULL AND kind = $3
+		`
+		res, err := tx.ExecContext(ctx, query, pq.Array(uploadIDs), post.UserID, models.MediaKindImage)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n != int64(len(uploadIDs)) {
+			return ErrUploadNotReady
+		}
+	}
+
+	event := models.PostEvent{PostID: post.ID, UserID: post.UserID}
+	if err := insertEvent(ctx, tx, "post", post.ID, models.EventPostCreated, event); err != nil {
+		return err
+	}
 
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	r.fanOut(ctx, post.ID, post.UserID)
 	return nil
 }
 
-func (r *PostRepo) GetPhoto(ctx context.Context, id string) (string, error) {
-	var filepath string
+// CreateVideo creates a post in processing status and attaches the
+// finalized upload of its video to it. The post reaches followers once
+// CompleteProcessing stores its media.
+func (r *PostRepo) CreateVideo(ctx context.Context, post *models.Post, uploadID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	post.Status = models.PostStatusProcessing
+	if err := insertPost(ctx, tx, post); err != nil {
+		return err
+	}
 
 	query := `
-		SELECT media FROM posts WHERE id = $1
+		UPDATE uploads SET post_id = $1
+		WHERE id = $2 AND user_id = $3 AND post_id IS NULL
+		AND finalized_at IS NOT NULL AND kind = $4
 	`
-	err := r.db.QueryRowContext(ctx, query, id).Scan(&filepath)
-	
+	res, err := tx.ExecContext(ctx, query, post.ID, uploadID, post.UserID, models.MediaKindVideo)
 	if err != nil {
-		return "", err
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUploadNotReady
 	}
 
-	return filepath, nil
+	return tx.Commit()
 }
 
-func (r *PostRepo) Update(ctx context.Context, post *models.Post) error {
+// CompleteProcessing stores the processed media of a post, marks it ready
+// and fans it out.
+func (r *PostRepo) CompleteProcessing(ctx context.Context, postID string, media []models.PostMedia) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID string
 	query := `
-		UPDATE posts SET media = $1, caption = $2 WHERE id = $3 AND user_id = $4
+		UPDATE posts SET status = $1, processing_error = NULL
+		WHERE id = $2 AND status = $3
+		RETURNING user_id
 	`
-	_, err := r.db.ExecContext(ctx, query, post.Media, post.Caption, post.ID, post.UserID)
+	err = tx.QueryRowContext(ctx, query, models.PostStatusReady, postID, models.PostStatusProcessing).Scan(&userID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPostNotFound
+		}
+		return err
+	}
+
+	if err := insertPostMedia(ctx, tx, postID, media); err != nil {
+		return err
+	}
+
+	event := models.PostEvent{PostID: postID, UserID: userID}
+	if err := insertEvent(ctx, tx, "post", postID, models.EventPostCreated, event); err != nil {
 		return err
 	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	r.fanOut(ctx, postID, userID)
 	return nil
 }
 
-func (r *PostRepo) Delete(ctx context.Context, id string) error {
+// FailProcessing marks a post whose media could not be processed.
+func (r *PostRepo) FailProcessing(ctx context.Context, postID, reason string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID string
 	query := `
-		DELETE FROM posts WHERE id = $1
+		UPDATE posts SET status = $1, processing_error = $2
+		WHERE id = $3 AND status = $4
+		RETURNING user_id
 	`
-	_, err := r.db.ExecContext(ctx, query, id)
+	err = tx.QueryRowContext(ctx, query, models.PostStatusFailed, reason, postID, models.PostStatusProcessing).Scan(&userID)
 	if err != nil {
+		// deleted or already settled
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	event := models.PostEvent{PostID: postID, UserID: userID, Reason: reason}
+	if err := insertEvent(ctx, tx, "post", postID, models.EventPostProcessingFailed, event); err != nil {
 		return err
 	}
+
+	return tx.Commit()
+}
+
+// GetByID returns a post. Posts still processing or that failed are only
+// found by their author.
+func (r *PostRepo) GetByID(ctx context.Context, id, actorID string) (*models.Post, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := postSelect + `WHERE p.id = $1 AND (p.status = 'ready' OR p.user_id = $2)`
+
+	post := &models.Post{}
+	err := scanPost(r.db.QueryRowContext(ctx, query, id, actorID), post)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPostNotFound
+		}
+		return nil, err
+	}
+
+	if err := loadPostMedia(ctx, r.db, post); err != nil {
+		return nil, err
+	}
+
+	return post, nil
+}
+
+func (r *PostRepo) queryPosts(ctx context.Context, query string, args ...any) ([]models.Post, error) {
+	posts := []models.Post{}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var post models.Post
+		if err := scanPost(rows, &post); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := loadPostMedia(ctx, r.db, postRefs(posts)...); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// GetByUser returns a page of the posts of a user. Posts still processing
+// or that failed are only listed to their author.
+func (r *PostRepo) GetByUser(ctx context.Context, userID, actorID string, page pagination.Page) ([]models.Post, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	args := []any{userID, page.Fetch(), actorID}
+	query := postSelect + `WHERE p.user_id = $1 AND (p.status = 'ready' OR p.user_id = $3) `
+	if page.After != "" {
+		args = append(args, page.After)
+		query += `AND p.id < $4 `
+	}
+	query += `ORDER BY p.id DESC LIMIT $2`
+
+	posts, err := r.queryPosts(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	count := len(posts)
+	posts = pagination.Trim(page, posts)
+
+	// an empty page is told apart from a user that does not exist
+	if count == 0 {
+		var exists bool
+		query := `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`
+		if err := r.db.QueryRowContext(ctx, query, userID).Scan(&exists); err != nil {
+			return nil, "", err
+		}
+		if !exists {
+			return nil, "", ErrUserNotFound
+		}
+	}
+
+	return posts, page.Next(count, lastPostID(posts)), nil
+}
+
+// GetByIDs returns the posts with the given ids, newest first. Posts still
+// processing or that failed are left out unless the actor wrote them.
+func (r *PostRepo) GetByIDs(ctx context.Context, ids []string, actorID string) ([]models.Post, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	if len(ids) == 0 {
+		return []models.Post{}, nil
+	}
+
+	query := postSelect + `WHERE p.id = ANY($1) AND (p.status = 'ready' OR p.user_id = $2) ORDER BY p.id DESC`
+	return r.queryPosts(ctx, query, pq.Array(ids), actorID)
+}
+
+func (r *PostRepo) GetFeed(ctx context.Context, userID string, page pagination.Page) ([]models.Post, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	args := []any{userID, page.Fetch()}
+	query := postSelect + `
+		WHERE (
+			p.user_id = $1 OR (
+				p.user_id IN (SELECT followee_id FROM followers WHERE followers_id = $1)
+				AND p.status = 'ready'
+			)
+		)
+	`
+	if page.After != "" {
+		args = append(args, page.After)
+		query += `AND p.id < $3 `
+	}
+	query += `ORDER BY p.id DESC LIMIT $2`
+
+	posts, err := r.queryPosts(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	count := len(posts)
+	posts = pagination.Trim(page, posts)
+	return posts, page.Next(count, lastPostID(posts)), nil
+}
+
+func lastPostID(posts []models.Post) string {
+	if len(posts) == 0 {
+		return ""
+	}
+	return posts[len(posts)-1].ID
+}
+
+// GetMediaKeys returns the keys of every stored file of a post owned by
+// userID, the original media and their variants.
+func (r *PostRepo) GetMediaKeys(ctx context.Context, id, userID string) ([]string, error) {
+	var ownerID string
+
+	query := `SELECT user_id FROM posts WHERE id = $1`
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&ownerID)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPostNotFound
+		}
+		return nil, err
+	}
+
+	if ownerID != userID {
+		return nil, policy.ErrForbidden
+	}
+
+	post := &models.Post{ID: id}
+	if err := loadPostMedia(ctx, r.db, post); err != nil {
+		return nil, err
+	}
+	return MediaKeys(post.Media), nil
+}
+
+// Update changes the caption of a post, and replaces all of its media when
+// post.Media is not empty.
+func (r *PostRepo) Update(ctx context.Context, post *models.Post) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE posts SET caption = $1 WHERE id = $2 AND user_id = $3
+	`
+	res, err := tx.ExecContext(ctx, query, post.Caption, post.ID, post.UserID)
+	if err != nil {
+		return err
+	}
+	if err := checkOwnership(ctx, tx, res, "posts", post.ID, ErrPostNotFound); err != nil {
+		return err
+	}
+
+	event := models.PostEvent{PostID: post.ID, UserID: post.UserID}
+	if err := insertEvent(ctx, tx, "post", post.ID, models.EventPostUpdated, event); err != nil {
+		return err
+	}
+
+	if len(post.Media) > 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM post_media WHERE post_id = $1`, post.ID); err != nil {
+			return err
+		}
+		if err := insertPostMedia(ctx, tx, post.ID, post.Media); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostRepo) Delete(ctx context.Context, id, userID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM posts WHERE id = $1 AND user_id = $2 RETURNING id
+	`
+	err = tx.QueryRowContext(ctx, query, id, userID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return resolveOwnership(ctx, tx, "posts", id, ErrPostNotFound)
+		}
+		return err
+	}
+
+	event := models.PostEvent{PostID: id, UserID: userID}
+	if err := insertEvent(ctx, tx, "post", id, models.EventPostDeleted, event); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if r.timeline != nil {
+		if err := r.timeline.Remove(ctx, id, userID); err != nil {
+			r.logger.Error("Timeline Error", "Failed to remove post", err.Error())
+		}
+	}
 	return nil
 }
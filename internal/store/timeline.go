@@ -0,0 +1,340 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// MaxTimelineLength bounds how many post ids are kept per cached timeline.
+	MaxTimelineLength = 800
+	// CelebrityThreshold is the follower count above which posts are not
+	// fanned out on write and are pulled from postgres when the feed is read.
+	CelebrityThreshold = 10000
+	TimelineTTL        = 7 * 24 * time.Hour
+
+	fanOutBatchSize = 500
+
+	// timelineComplete is a member sorting before every post id. It marks a
+	// cached timeline that holds all of its posts, older pages of timelines
+	// without it are read from postgres. It also keeps the key of a timeline
+	// without posts, so empty timelines are not rebuilt on every read.
+	timelineComplete = ""
+)
+
+// Every member is stored with score 0 so the set is ordered by member. Post
+// ids are UUIDv7 which sort lexicographically by creation time, so the same
+// id can be used as the feed cursor.
+//
+// The script adds the post ids ARGV[3:] and trims the set to ARGV[1]
+// members, trimming drops the oldest ones and timelineComplete first. ARGV[2]
+// set to 1 drops timelineComplete in any case. Only timelines that are
+// already cached are touched, a missing timeline is rebuilt from postgres on
+// the next read.
+var fanOutScript = redis.NewScript(`
+local n = 0
+for _, key in ipairs(KEYS) do
+	if redis.call('EXISTS', key) == 1 then
+		for i = 3, #ARGV do
+			redis.call('ZADD', key, 0, ARGV[i])
+		end
+		if ARGV[2] == '1' then
+			redis.call('ZREM', key, '')
+		end
+		redis.call('ZREMRANGEBYRANK', key, 0, -(tonumber(ARGV[1]) + 1))
+		n = n + 1
+	end
+end
+return n
+`)
+
+type Timeline struct {
+	db     *sql.DB
+	redis  *redis.Client
+	logger *utils.Logger
+}
+
+func NewTimeline(db *sql.DB, rdb *redis.Client, lg *utils.Logger) *Timeline {
+	return &Timeline{db: db, redis: rdb, logger: lg}
+}
+
+func timelineKey(userID string) string {
+	return fmt.Sprintf("timeline:%s", userID)
+}
+
+func (t *Timeline) followerCount(ctx context.Context, userID string) (int, error) {
+	var count int
+	query := `SELECT followers_count FROM users WHERE id = $1`
+	err := t.db.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+func (t *Timeline) queryIDs(ctx context.Context, query string, args ...any) ([]string, error) {
+	var ids []string
+
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (t *Timeline) followerIDs(ctx context.Context, userID string) ([]string, error) {
+	query := `SELECT followers_id FROM followers WHERE followee_id = $1`
+	return t.queryIDs(ctx, query, userID)
+}
+
+// Audience returns the followers new posts of the author are pushed to,
+// nobody for celebrity accounts whose posts are pulled instead.
+func (t *Timeline) Audience(ctx context.Context, authorID string) ([]string, error) {
+	count, err := t.followerCount(ctx, authorID)
+	if err != nil {
+		return nil, err
+	}
+	if count > CelebrityThreshold {
+		return nil, nil
+	}
+	return t.followerIDs(ctx, authorID)
+}
+
+// FanOut pushes a new post into the cached timelines of the author and their
+// followers. Posts of celebrity accounts only go to the author's timeline.
+func (t *Timeline) FanOut(ctx context.Context, postID, authorID string) error {
+	keys := []string{timelineKey(authorID)}
+
+	followers, err := t.Audience(ctx, authorID)
+	if err != nil {
+		return err
+	}
+	for _, id := range followers {
+		keys = append(keys, timelineKey(id))
+	}
+
+	for start := 0; start < len(keys); start += fanOutBatchSize {
+		end := min(start+fanOutBatchSize, len(keys))
+		err := fanOutScript.Run(ctx, t.redis, keys[start:end], MaxTimelineLength, 0, postID).Err()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Remove deletes a post from every cached timeline it may have been pushed to.
+func (t *Timeline) Remove(ctx context.Context, postID, authorID string) error {
+	followers, err := t.followerIDs(ctx, authorID)
+	if err != nil {
+		return err
+	}
+
+	pipe := t.redis.Pipeline()
+	pipe.ZRem(ctx, timelineKey(authorID), postID)
+	for _, id := range followers {
+		pipe.ZRem(ctx, timelineKey(id), postID)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// AddAuthor backfills the recent posts of a newly followed account.
+func (t *Timeline) AddAuthor(ctx context.Context, userID, authorID string) error {
+	count, err := t.followerCount(ctx, authorID)
+	if err != nil {
+		return err
+	}
+	if count > CelebrityThreshold {
+		return nil
+	}
+
+	query := `SELECT id FROM posts WHERE user_id = $1 AND status = 'ready' ORDER BY id DESC LIMIT $2`
+	ids, err := t.queryIDs(ctx, query, authorID, MaxTimelineLength)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// older posts of the author were left out, the timeline is no longer
+	// complete
+	truncated := 0
+	if len(ids) == MaxTimelineLength {
+		truncated = 1
+	}
+
+	args := make([]any, 0, len(ids)+2)
+	args = append(args, MaxTimelineLength, truncated)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	return fanOutScript.Run(ctx, t.redis, []string{timelineKey(userID)}, args...).Err()
+}
+
+// RemoveAuthor drops the posts of an unfollowed account from a timeline.
+func (t *Timeline) RemoveAuthor(ctx context.Context, userID, authorID string) error {
+	query := `SELECT id FROM posts WHERE user_id = $1 ORDER BY id DESC LIMIT $2`
+	ids, err := t.queryIDs(ctx, query, authorID, MaxTimelineLength)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	members := make([]any, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	return t.redis.ZRem(ctx, timelineKey(userID), members...).Err()
+}
+
+// pushedIDs loads the push part of a timeline (own posts and posts of
+// followed non celebrity accounts) older than after from postgres.
+func (t *Timeline) pushedIDs(ctx context.Context, userID, after string, limit int) ([]string, error) {
+	args := []any{userID, CelebrityThreshold, limit}
+	query := `
+		SELECT p.id FROM posts p
+		WHERE (p.user_id = $1 OR (p.status = 'ready' AND p.user_id IN (
+			SELECT f.followee_id FROM followers f
+			INNER JOIN users u ON u.id = f.followee_id
+			WHERE f.followers_id = $1 AND u.followers_count <= $2
+		)))
+	`
+	if after != "" {
+		args = append(args, after)
+		query += `AND p.id < $4 `
+	}
+	query += `ORDER BY p.id DESC LIMIT $3`
+
+	return t.queryIDs(ctx, query, args...)
+}
+
+func (t *Timeline) rebuild(ctx context.Context, userID string) error {
+	ids, err := t.pushedIDs(ctx, userID, "", MaxTimelineLength)
+	if err != nil {
+		return err
+	}
+
+	members := make([]redis.Z, 0, len(ids)+1)
+	for _, id := range ids {
+		members = append(members, redis.Z{Member: id})
+	}
+	if len(ids) < MaxTimelineLength {
+		members = append(members, redis.Z{Member: timelineComplete})
+	}
+
+	key := timelineKey(userID)
+	pipe := t.redis.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.ZAdd(ctx, key, members...)
+	pipe.Expire(ctx, key, TimelineTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Feed returns the ids of the next page of a user's home timeline, merging
+// the cached push timeline with posts pulled from followed celebrities.
+func (t *Timeline) Feed(ctx context.Context, userID string, page pagination.Page) ([]string, string, error) {
+	key := timelineKey(userID)
+
+	exists, err := t.redis.Exists(ctx, key).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	if exists == 0 {
+		if err := t.rebuild(ctx, userID); err != nil {
+			return nil, "", err
+		}
+	} else if err := t.redis.Expire(ctx, key, TimelineTTL).Err(); err != nil {
+		return nil, "", err
+	}
+
+	max := "+"
+	if page.After != "" {
+		max = "(" + page.After
+	}
+	cached, err := t.redis.ZRevRangeByLex(ctx, key, &redis.ZRangeBy{
+		Min:   "-",
+		Max:   max,
+		Count: int64(page.Fetch()),
+	}).Result()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids := make([]string, 0, page.Fetch())
+	complete := false
+	for _, id := range cached {
+		if id == timelineComplete {
+			complete = true
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	// the page goes past the oldest cached post of a trimmed timeline, the
+	// rest is read from postgres
+	if len(ids) < page.Fetch() && !complete {
+		after := page.After
+		if len(ids) > 0 {
+			after = ids[len(ids)-1]
+		}
+		older, err := t.pushedIDs(ctx, userID, after, page.Fetch()-len(ids))
+		if err != nil {
+			return nil, "", err
+		}
+		ids = append(ids, older...)
+	}
+
+	args := []any{userID, CelebrityThreshold, page.Fetch()}
+	query := `
+		SELECT p.id FROM posts p
+		WHERE p.user_id IN (
+			SELECT f.followee_id FROM followers f
+			INNER JOIN users u ON u.id = f.followee_id
+			WHERE f.followers_id = $1 AND u.followers_count > $2
+		) AND p.status = 'ready'
+	`
+	if page.After != "" {
+		args = append(args, page.After)
+		query += `AND p.id < $4 `
+	}
+	query += `ORDER BY p.id DESC LIMIT $3`
+
+	pulled, err := t.queryIDs(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids = append(ids, pulled...)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	slices.Reverse(ids)
+
+	count := len(ids)
+	ids = pagination.Trim(page, ids)
+
+	var last string
+	if len(ids) > 0 {
+		last = ids[len(ids)-1]
+	}
+	return ids, page.Next(count, last), nil
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/pkg/pagination"
+)
+
+var (
+	selectFollowerCount = regexp.QuoteMeta(`SELECT followers_count FROM users WHERE id = $1`)
+	selectFollowerIDs   = regexp.QuoteMeta(`SELECT followers_id FROM followers WHERE followee_id = $1`)
+	selectPushedIDs     = regexp.QuoteMeta(`u.followers_count <= $2`)
+	selectPulledIDs     = regexp.QuoteMeta(`u.followers_count > $2`)
+)
+
+func TestFeed(t *testing.T) {
+	userID := newIDs(1)[0]
+	p := newIDs(5)
+
+	tests := []struct {
+		name string
+		// cached is the timeline in redis, nil when it is not cached
+		cached []string
+		limit  int
+		// pushed is what postgres returns for the push part, nil when it
+		// is not read
+		pushed     []string
+		pulled     []string
+		want       []string
+		wantCursor string
+	}{
+		{"rebuilds a missing timeline", nil, 10, []string{p[3], p[0]}, []string{p[1]}, []string{p[3], p[1], p[0]}, ""},
+		{"cached timeline", []string{timelineComplete, p[1], p[4]}, 2, nil, []string{p[3]}, []string{p[4], p[3]}, pagination.EncodeCursor(p[3])},
+		{"trimmed timeline", []string{p[4]}, 3, []string{p[1]}, nil, []string{p[4], p[1]}, ""},
+		{"full last page", []string{timelineComplete, p[1], p[4]}, 3, nil, []string{p[3]}, []string{p[4], p[3], p[1]}, ""},
+		{"empty timeline", []string{timelineComplete}, 3, nil, nil, []string{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			db, mock := newTestDB(t)
+			rdb, mr := newTestRedis(t)
+			tl := NewTimeline(db, rdb, utils.NewLogger())
+
+			key := timelineKey(userID)
+			for _, id := range tt.cached {
+				mr.ZAdd(key, 0, id)
+			}
+			if tt.cached != nil {
+				mr.SetTTL(key, time.Minute)
+			}
+
+			switch {
+			case tt.cached == nil:
+				mock.ExpectQuery(selectPushedIDs).WithArgs(userID, CelebrityThreshold, MaxTimelineLength).WillReturnRows(idRows(tt.pushed...))
+			case tt.pushed != nil:
+				mock.ExpectQuery(selectPushedIDs).WithArgs(userID, CelebrityThreshold, tt.limit+1-len(tt.cached), tt.cached[len(tt.cached)-1]).WillReturnRows(idRows(tt.pushed...))
+			}
+			mock.ExpectQuery(selectPulledIDs).WithArgs(userID, CelebrityThreshold, tt.limit+1).WillReturnRows(idRows(tt.pulled...))
+
+			ids, next, err := tl.Feed(ctx, userID, pagination.Page{Limit: tt.limit})
+			if err != nil {
+				t.Fatalf("Failed to read feed: %v", err)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("got %v want %v", ids, tt.want)
+			}
+			if next != tt.wantCursor {
+				t.Errorf("got cursor %q want %q", next, tt.wantCursor)
+			}
+			if ttl := mr.TTL(key); ttl != TimelineTTL {
+				t.Errorf("got ttl %s want %s", ttl, TimelineTTL)
+			}
+		})
+	}
+}
+
+func TestFeedRebuildMarksCompleteTimelines(t *testing.T) {
+	userID := newIDs(1)[0]
+	p := newIDs(2)
+
+	db, mock := newTestDB(t)
+	rdb, mr := newTestRedis(t)
+	tl := NewTimeline(db, rdb, utils.NewLogger())
+
+	mock.ExpectQuery(selectPushedIDs).WillReturnRows(idRows(p[1], p[0]))
+	mock.ExpectQuery(selectPulledIDs).WillReturnRows(idRows())
+
+	if _, _, err := tl.Feed(context.Background(), userID, pagination.Page{Limit: 10}); err != nil {
+		t.Fatalf("Failed to read feed: %v", err)
+	}
+
+	members, err := mr.ZMembers(timelineKey(userID))
+	if err != nil {
+		t.Fatalf("Failed to read timeline: %v", err)
+	}
+	if want := []string{timelineComplete, p[0], p[1]}; !slices.Equal(members, want) {
+		t.Errorf("got %q want %q", members, want)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	authorID := newIDs(1)[0]
+	cachedFollower, otherFollower := newIDs(1)[0], newIDs(1)[0]
+	postID := newIDs(1)[0]
+
+	tests := []struct {
+		name      string
+		followers int
+		// whose cached timeline gets the post
+		want []string
+	}{
+		{"followers", 2, []string{authorID, cachedFollower}},
+		{"celebrity", CelebrityThreshold + 1, []string{authorID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			rdb, mr := newTestRedis(t)
+			tl := NewTimeline(db, rdb, utils.NewLogger())
+
+			for _, id := range []string{authorID, cachedFollower} {
+				mr.ZAdd(timelineKey(id), 0, timelineComplete)
+			}
+
+			count := mock.NewRows([]string{"followers_count"}).AddRow(tt.followers)
+			mock.ExpectQuery(selectFollowerCount).WithArgs(authorID).WillReturnRows(count)
+			if tt.followers <= CelebrityThreshold {
+				mock.ExpectQuery(selectFollowerIDs).WithArgs(authorID).WillReturnRows(idRows(cachedFollower, otherFollower))
+			}
+
+			if err := tl.FanOut(context.Background(), postID, authorID); err != nil {
+				t.Fatalf("Failed to fan out: %v", err)
+			}
+
+			for _, id := range []string{authorID, cachedFollower, otherFollower} {
+				members, _ := mr.ZMembers(timelineKey(id))
+				got := slices.Contains(members, postID)
+				if want := slices.Contains(tt.want, id); got != want {
+					t.Errorf("timeline of %s holds the post: got %v want %v", id, got, want)
+				}
+			}
+			// a timeline that was not cached is rebuilt on its next read
+			if mr.Exists(timelineKey(otherFollower)) {
+				t.Errorf("timeline of a follower without a cached timeline was created")
+			}
+		})
+	}
+}
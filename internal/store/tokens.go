@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/utils"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+type TokenRepo struct {
+	db     *sql.DB
+	logger *utils.Logger
+}
+
+func NewTokenRepo(db *sql.DB, lg *utils.Logger) TokenRepo {
+	return TokenRepo{db: db, logger: lg}
+}
+
+func (r *TokenRepo) Create(ctx context.Context, token *models.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO refresh_tokens (
+			id, user_id, family_id, token_hash, expires_at
+		) VALUES (
+			$1, $2, $3, $4, $5
+		)
+	`
+	_, err := r.db.ExecContext(
+		ctx, query,
+		token.ID,
+		token.UserID,
+		token.FamilyID,
+		token.TokenHash,
+		token.ExpiresAt,
+	)
+	return err
+}
+
+// Rotate exchanges the refresh token with the given hash for next, which
+// joins the same family. Presenting a token that was already rotated means
+// it leaked, so the whole family is revoked and ErrRefreshTokenReused is
+// returned.
+func (r *TokenRepo) Rotate(ctx context.Context, hash []byte, next *models.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		id        string
+		revokedAt *time.Time
+		expiresAt time.Time
+	)
+	query := `
+		SELECT id, user_id, family_id, expires_at, revoked_at
+		FROM refresh_tokens WHERE token_hash = $1
+		FOR UPDATE
+	`
+	err = tx.QueryRowContext(ctx, query, hash).Scan(
+		&id,
+		&next.UserID,
+		&next.FamilyID,
+		&expiresAt,
+		&revokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidRefreshToken
+		}
+		return err
+	}
+
+	if revokedAt != nil {
+		query = `
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE family_id = $1 AND revoked_at IS NULL
+		`
+		if _, err := tx.ExecContext(ctx, query, next.FamilyID); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+
+	if time.Now().After(expiresAt) {
+		return ErrInvalidRefreshToken
+	}
+
+	query = `
+		INSERT INTO refresh_tokens (
+			id, user_id, family_id, token_hash, expires_at
+		) VALUES (
+			$1, $2, $3, $4, $5
+		)
+	`
+	_, err = tx.ExecContext(
+		ctx, query,
+		next.ID,
+		next.UserID,
+		next.FamilyID,
+		next.TokenHash,
+		next.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $1 WHERE id = $2`
+	if _, err := tx.ExecContext(ctx, query, next.ID, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeFamily ends the session the refresh token with the given hash
+// belongs to. Tokens of other users are treated as invalid.
+func (r *TokenRepo) RevokeFamily(ctx context.Context, userID string, hash []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family_id = (
+			SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2
+		)
+		AND revoked_at IS NULL
+	`
+	res, err := r.db.ExecContext(ctx, query, hash, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidRefreshToken
+	}
+	return nil
+}
+
+// RevokeAll revokes every refresh token of the user.
+func (r *TokenRepo) RevokeAll(ctx context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/utils"
+)
+
+var (
+	selectRefreshToken = regexp.QuoteMeta(`FROM refresh_tokens WHERE token_hash = $1`)
+	revokeFamily       = regexp.QuoteMeta(`WHERE family_id = $1 AND revoked_at IS NULL`)
+	insertRefreshToken = regexp.QuoteMeta(`INSERT INTO refresh_tokens`)
+	replaceToken       = regexp.QuoteMeta(`UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $1 WHERE id = $2`)
+)
+
+func TestRotate(t *testing.T) {
+	ids := newIDs(4)
+	tokenID, userID, familyID, nextID := ids[0], ids[1], ids[2], ids[3]
+	hash := []byte("hash")
+	revokedAt := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name      string
+		found     bool
+		expiresAt time.Time
+		revokedAt *time.Time
+		wantErr   error
+	}{
+		{"rotated", true, time.Now().Add(time.Hour), nil, nil},
+		{"unknown token", false, time.Time{}, nil, ErrInvalidRefreshToken},
+		{"expired", true, time.Now().Add(-time.Hour), nil, ErrInvalidRefreshToken},
+		{"reused", true, time.Now().Add(time.Hour), &revokedAt, ErrRefreshTokenReused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			mock.ExpectBegin()
+			rows := sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at"})
+			if tt.found {
+				rows.AddRow(tokenID, userID, familyID, tt.expiresAt, tt.revokedAt)
+			}
+			mock.ExpectQuery(selectRefreshToken).WithArgs(hash).WillReturnRows(rows)
+
+			switch {
+			case tt.revokedAt != nil:
+				// the whole session is revoked and that is kept
+				mock.ExpectExec(revokeFamily).WithArgs(familyID).WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+			case tt.wantErr == nil:
+				mock.ExpectExec(insertRefreshToken).WithArgs(nextID, userID, familyID, []byte("next"), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(replaceToken).WithArgs(nextID, tokenID).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			default:
+				mock.ExpectRollback()
+			}
+
+			next := &models.RefreshToken{ID: nextID, TokenHash: []byte("next"), ExpiresAt: time.Now().Add(time.Hour)}
+			repo := NewTokenRepo(db, utils.NewLogger())
+			err := repo.Rotate(context.Background(), hash, next)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v want %v", err, tt.wantErr)
+			}
+			if err == nil && (next.UserID != userID || next.FamilyID != familyID) {
+				t.Errorf("next token got user %s family %s want %s %s", next.UserID, next.FamilyID, userID, familyID)
+			}
+		})
+	}
+}
+
+func TestRevokeFamily(t *testing.T) {
+	userID := newIDs(1)[0]
+	hash := []byte("hash")
+
+	tests := []struct {
+		name     string
+		affected int64
+		wantErr  error
+	}{
+		{"revoked", 1, nil},
+		// an unknown token, one of another user or an already revoked one
+		{"nothing revoked", 0, ErrInvalidRefreshToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			mock.ExpectExec(regexp.QuoteMeta(`SELECT family_id FROM refresh_tokens WHERE token_hash = $1 AND user_id = $2`)).
+				WithArgs(hash, userID).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+
+			repo := NewTokenRepo(db, utils.NewLogger())
+			if err := repo.RevokeFamily(context.Background(), userID, hash); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/policy"
+	"github.com/cakra17/social/internal/utils"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("chunk does not start at the upload offset")
+)
+
+const uploadColumns = `
+	id, user_id, post_id, filename, size, received,
+	COALESCE(kind, ''), finalized_at, expires_at, created_at
+`
+
+func scanUpload(row rowScanner, upload *models.Upload) error {
+	return row.Scan(
+		&upload.ID,
+		&upload.UserID,
+		&upload.PostID,
+		&upload.Filename,
+		&upload.Size,
+		&upload.Received,
+		&upload.Kind,
+		&upload.FinalizedAt,
+		&upload.ExpiresAt,
+		&upload.CreatedAt,
+	)
+}
+
+type UploadRepo struct {
+	db     *sql.DB
+	logger *utils.Logger
+}
+
+func NewUploadRepo(db *sql.DB, lg *utils.Logger) UploadRepo {
+	return UploadRepo{db: db, logger: lg}
+}
+
+func (r *UploadRepo) Create(ctx context.Context, upload *models.Upload) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO uploads (
+			id, user_id, filename, size, expires_at
+		) VALUES (
+			$1, $2, $3, $4, $5
+		) RETURNING created_at
+	`
+	return r.db.QueryRowContext(
+		ctx, query,
+		upload.ID,
+		upload.UserID,
+		upload.Filename,
+		upload.Size,
+		upload.ExpiresAt,
+	).Scan(&upload.CreatedAt)
+}
+
+// Get returns an unexpired upload owned by userID.
+func (r *UploadRepo) Get(ctx context.Context, id, userID string) (*models.Upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `SELECT ` + uploadColumns + ` FROM uploads WHERE id = $1 AND expires_at > NOW()`
+
+	upload := &models.Upload{}
+	if err := scanUpload(r.db.QueryRowContext(ctx, query, id), upload); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+
+	if upload.UserID != userID {
+		return nil, policy.ErrForbidden
+	}
+	return upload, nil
+}
+
+// AddChunk records a chunk of size bytes written at offset and stored under
+// key, and advances the offset past it. The offset only moves when the
+// chunk still starts where the upload stands, otherwise another chunk got
+// there first and ErrUploadOffsetMismatch is returned.
+func (r *UploadRepo) AddChunk(ctx context.Context, id, userID string, offset, size int64, key string) (*models.Upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE uploads SET received = received + $1
+		WHERE id = $2 AND user_id = $3 AND received = $4
+		AND expires_at > NOW() AND finalized_at IS NULL
+		RETURNING ` + uploadColumns
+
+	upload := &models.Upload{}
+	if err := scanUpload(tx.QueryRowContext(ctx, query, size, id, userID, offset), upload); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUploadOffsetMismatch
+		}
+		return nil, err
+	}
+
+	query = `INSERT INTO upload_chunks (upload_id, start_offset, size, key) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, query, id, offset, size, key); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// ChunkKeys returns the keys of the chunks of an upload in order.
+func (r *UploadRepo) ChunkKeys(ctx context.Context, id string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `SELECT key FROM upload_chunks WHERE upload_id = $1 ORDER BY start_offset`
+	return queryKeys(r.db.QueryContext(ctx, query, id))
+}
+
+// PostChunkKeys returns the keys of the chunks of the uploads attached to a
+// post that are still being processed.
+func (r *UploadRepo) PostChunkKeys(ctx context.Context, postID string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `
+		SELECT c.key FROM upload_chunks c
+		JOIN uploads u ON u.id = c.upload_id
+		WHERE u.post_id = $1
+	`
+	return queryKeys(r.db.QueryContext(ctx, query, postID))
+}
+
+func queryKeys(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Finalize records the kind of a complete upload, after which it can be
+// attached to a post. It returns ErrUploadNotReady when the upload is
+// incomplete or already finalized.
+func (r *UploadRepo) Finalize(ctx context.Context, id, userID, kind string) (*models.Upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE uploads SET kind = $1, finalized_at = NOW()
+		WHERE id = $2 AND user_id = $3 AND expires_at > NOW()
+		AND received = size AND finalized_at IS NULL
+		RETURNING ` + uploadColumns
+
+	upload := &models.Upload{}
+	if err := scanUpload(r.db.QueryRowContext(ctx, query, kind, id, userID), upload); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUploadNotReady
+		}
+		return nil, err
+	}
+	return upload, nil
+}
+
+// Discard deletes an upload of the user that is not attached to a post and
+// returns the keys of its chunks.
+func (r *UploadRepo) Discard(ctx context.Context, id, userID string) ([]string, error) {
+	if _, err := r.Get(ctx, id, userID); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// locking the upload waits for a chunk being recorded, so its key is
+	// returned too
+	var locked string
+	query := `SELECT id FROM uploads WHERE id = $1 AND post_id IS NULL FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&locked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUploadNotReady
+		}
+		return nil, err
+	}
+
+	keys, err := queryKeys(tx.QueryContext(ctx, `DELETE FROM upload_chunks WHERE upload_id = $1 RETURNING key`, id))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM uploads WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// DeleteExpired deletes the uploads that were abandoned before being
+// attached to a post and returns the keys of their chunks.
+func (r *UploadRepo) DeleteExpired(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM upload_chunks c USING uploads u
+		WHERE c.upload_id = u.id AND u.post_id IS NULL AND u.expires_at <= NOW()
+		RETURNING c.key
+	`
+	keys, err := queryKeys(tx.QueryContext(ctx, query))
+	if err != nil {
+		return nil, err
+	}
+
+	query = `DELETE FROM uploads WHERE post_id IS NULL AND expires_at <= NOW()`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Delete deletes an upload and returns the keys of its chunks.
+func (r *UploadRepo) Delete(ctx context.Context, id string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	keys, err := queryKeys(tx.QueryContext(ctx, `DELETE FROM upload_chunks WHERE upload_id = $1 RETURNING key`, id))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM uploads WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -11,7 +11,7 @@ import (
 )
 
 type UserRepo struct {
-	db *sql.DB
+	db     *sql.DB
 	logger *utils.Logger
 }
 
@@ -19,8 +19,10 @@ const (
 	defaultTimeout = 5 * time.Second
 )
 
+var ErrUserNotFound = errors.New("User Not Found!")
+
 func NewUserRepo(db *sql.DB, lg *utils.Logger) UserRepo {
-	return UserRepo{ db: db, logger: lg }
+	return UserRepo{db: db, logger: lg}
 }
 
 func (r *UserRepo) CreateUser(ctx context.Context, user *models.User) error {
@@ -53,7 +55,7 @@ func (r *UserRepo) GetUserById(ctx context.Context, id string) (*models.User, er
 	defer cancel()
 
 	query := `
-		SELECT id, username, email, created_at
+		SELECT id, username, email, email_verified_at, created_at
 		FROM users WHERE id = $1
 	`
 
@@ -63,13 +65,14 @@ func (r *UserRepo) GetUserById(ctx context.Context, id string) (*models.User, er
 		&user.ID,
 		&user.Username,
 		&user.Email,
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 	)
-	
+
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
-			return nil, errors.New("User Not Found!")
+			return nil, ErrUserNotFound
 		default:
 			return nil, err
 		}
@@ -81,7 +84,7 @@ func (r *UserRepo) GetUserByEmail(ctx context.Context, email string) (*models.Us
 	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
-query := `
+	query := `
 		SELECT id, username, email, password
 		FROM users WHERE email = $1
 	`
@@ -94,11 +97,11 @@ query := `
 		&user.Email,
 		&user.Password,
 	)
-	
+
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
-			return nil, errors.New("User Not Found!")
+			return nil, ErrUserNotFound
 		default:
 			return nil, err
 		}
@@ -106,26 +109,234 @@ query := `
 	return user, nil
 }
 
-func (r *UserRepo) UpdateUser(ctx context.Context, user *models.UpdateUserPayload, ID string) error {
+// UpdateUser saves the profile and reports whether the email changed, a new
+// address has to be verified again.
+func (r *UserRepo) UpdateUser(ctx context.Context, user *models.UpdateUserPayload, ID string) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var changed bool
+	query := `SELECT email <> $1 FROM users WHERE id = $2 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, user.Email, ID).Scan(&changed); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrUserNotFound
+		}
+		return false, err
+	}
+
+	query = `
+		UPDATE users SET
+			username = $1,
+			email = $2,
+			email_verified_at = CASE WHEN $3 THEN NULL ELSE email_verified_at END
+		WHERE id = $4
+	`
+	if _, err := tx.ExecContext(ctx, query, user.Username, user.Email, changed, ID); err != nil {
+		return false, err
+	}
+	return changed, tx.Commit()
+}
+
+// VerifyEmail consumes the verification token with the given hash and marks
+// the address it was sent to as verified. It returns the id of the user.
+func (r *UserRepo) VerifyEmail(ctx context.Context, hash []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
 	}
 	defer tx.Rollback()
 
+	userID, err := consumeAccountToken(ctx, tx, models.TokenPurposeVerifyEmail, hash)
+	if err != nil {
+		return "", err
+	}
+
 	query := `
-		UPDATE users SET username = $1, email = $2 WHERE id = $3
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = $1
 	`
-	_, err = tx.ExecContext(ctx, query, user.Username, user.Email, ID)
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return "", err
+	}
+
+	return userID, tx.Commit()
+}
+
+// ResetPassword consumes the reset token with the given hash and sets the
+// password of its owner. Receiving the token proves the address works, so
+// it counts as verified too. It returns the id of the user.
+func (r *UserRepo) ResetPassword(ctx context.Context, hash []byte, password string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeAccountToken(ctx, tx, models.TokenPurposeResetPassword, hash)
+	if err != nil {
+		return "", err
+	}
+
+	query := `
+		UPDATE users SET
+			password = $1,
+			email_verified_at = COALESCE(email_verified_at, NOW())
+		WHERE id = $2
+	`
+	if _, err := tx.ExecContext(ctx, query, password, userID); err != nil {
+		return "", err
+	}
+
+	return userID, tx.Commit()
+}
+
+func (r *UserRepo) GetPassword(ctx context.Context, id string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	var password string
+	query := `SELECT password FROM users WHERE id = $1`
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&password); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+	return password, nil
+}
+
+// UpdatePassword sets the password of the user, pending reset links stop
+// working.
+func (r *UserRepo) UpdatePassword(ctx context.Context, id, password string) error {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET password = $1 WHERE id = $2`
+	res, err := tx.ExecContext(ctx, query, password, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
+
+	query = `DELETE FROM account_tokens WHERE user_id = $1 AND purpose = $2`
+	if _, err := tx.ExecContext(ctx, query, id, models.TokenPurposeResetPassword); err != nil {
+		return err
+	}
+
 	return tx.Commit()
 }
 
+func (r *UserRepo) IsEmailVerified(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	var verified bool
+	query := `SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1`
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&verified); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrUserNotFound
+		}
+		return false, err
+	}
+	return verified, nil
+}
+
+// GetUnverifiedWithoutToken returns up to limit users whose address is not
+// verified and who were never given a verification link, expired links
+// count as given, like the accounts created before addresses were verified.
+func (r *UserRepo) GetUnverifiedWithoutToken(ctx context.Context, limit int) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	query := `
+		SELECT u.id, u.username, u.email, u.email_verified_at, u.created_at
+		FROM users u
+		WHERE u.email_verified_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM account_tokens t WHERE t.user_id = u.id AND t.purpose = $1
+		)
+		ORDER BY u.id LIMIT $2
+	`
+	rows, err := r.db.QueryContext(ctx, query, models.TokenPurposeVerifyEmail, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+			&user.EmailVerifiedAt,
+			&user.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// TokenVersion returns the version the access tokens of the user are issued
+// with, tokens of older versions are revoked.
+func (r *UserRepo) TokenVersion(ctx context.Context, id string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	var version int64
+	query := `SELECT token_version FROM users WHERE id = $1`
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// BumpTokenVersion revokes every access token of the user issued so far.
+func (r *UserRepo) BumpTokenVersion(ctx context.Context, id string, min int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	var version int64
+	query := `
+		UPDATE users SET token_version = GREATEST(token_version, $2) + 1
+		WHERE id = $1 RETURNING token_version
+	`
+	if err := r.db.QueryRowContext(ctx, query, id, min).Scan(&version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
 func (r *UserRepo) Delete(ctx context.Context, id string) error {
 	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
@@ -137,10 +348,13 @@ func (r *UserRepo) Delete(ctx context.Context, id string) error {
 	defer tx.Rollback()
 
 	query := `DELETE FROM users WHERE id = $1`
-	_, err = tx.ExecContext(ctx, query, id)
+	res, err := tx.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrUserNotFound
+	}
 
 	return tx.Commit()
-}
\ No newline at end of file
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cakra17/social/internal/utils"
+)
+
+var bumpTokenVersion = regexp.QuoteMeta(`UPDATE users SET token_version = GREATEST(token_version, $2) + 1`)
+
+func TestBumpTokenVersion(t *testing.T) {
+	userID := newIDs(1)[0]
+
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		want    int64
+		wantErr error
+	}{
+		{"bumped", sqlmock.NewRows([]string{"token_version"}).AddRow(4), 4, nil},
+		{"unknown user", sqlmock.NewRows([]string{"token_version"}), 0, ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newTestDB(t)
+			mock.ExpectQuery(bumpTokenVersion).WithArgs(userID, 3).WillReturnRows(tt.rows)
+
+			repo := NewUserRepo(db, utils.NewLogger())
+			got, err := repo.BumpTokenVersion(context.Background(), userID, 3)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,39 +8,93 @@ import (
 type CustomError struct {
 	Code    int
 	Message string
+	Details string
+}
+
+// WithDetails returns a copy of the error that also tells the client what
+// exactly was wrong.
+func (e CustomError) WithDetails(details string) CustomError {
+	e.Details = details
+	return e
 }
 
 type CustomSuccess struct {
-	Code int
-	Message string
-	Data any
+	Code       int
+	Message    string
+	Data       any
+	NextCursor string
 }
 
 var (
-	ErrNoTokenProvided = CustomError{Code: http.StatusUnauthorized, Message: "No token provided"}
-	ErrTokenMalformed = CustomError{Code: http.StatusUnauthorized, Message: "Token Malformed"}
-	ErrTokenNotContainsInfo = CustomError{Code: http.StatusUnauthorized, Message: "Bearer token not contains user info"}
-	ErrTokenExpires = CustomError{Code: http.StatusUnauthorized, Message: "Token expires, please login again"}
-	ErrPayloadMalformed = CustomError{Code: http.StatusBadRequest, Message: "Payload Malformed"}
-	ErrFailedToCreateUser = CustomError{Code: http.StatusInternalServerError, Message: "Failed to Create User"}
-	ErrCredentialExist = CustomError{Code: http.StatusConflict, Message: "Credentials already used"}
-	ErrUserNotFound = CustomError{Code: http.StatusNotFound, Message: "User not found"}
-	ErrWrongPassword = CustomError{Code: http.StatusBadRequest, Message: "Wrong password"}
-	ErrInvalidUploadedFile = CustomError{Code: http.StatusBadRequest, Message: "Invalid uploaded file"}
-	ErrInvalidFileSize = CustomError{Code: http.StatusBadRequest, Message: "Invalid file size, max 5mb"}
-	ErrInvalidFileType = CustomError{Code: http.StatusBadRequest, Message: "Invalid file type"}
-  ErrInvalidPayload = CustomError{Code: http.StatusBadRequest, Message: "Invalid Payload"}
-	ErrFailedToUploadPhoto = CustomError{Code: http.StatusInternalServerError, Message: "Failed to upload photo"}
-  ErrFailedToCreatePost = CustomError{Code: http.StatusInternalServerError, Message: "Failed to create post"}
+	ErrNoTokenProvided             = CustomError{Code: http.StatusUnauthorized, Message: "No token provided"}
+	ErrTokenMalformed              = CustomError{Code: http.StatusUnauthorized, Message: "Token Malformed"}
+	ErrTokenNotContainsInfo        = CustomError{Code: http.StatusUnauthorized, Message: "Bearer token not contains user info"}
+	ErrTokenExpires                = CustomError{Code: http.StatusUnauthorized, Message: "Token expires, please login again"}
+	ErrTokenRevoked                = CustomError{Code: http.StatusUnauthorized, Message: "Token revoked, please login again"}
+	ErrInvalidRefreshToken         = CustomError{Code: http.StatusUnauthorized, Message: "Invalid refresh token, please login again"}
+	ErrFailedToGenerateToken       = CustomError{Code: http.StatusInternalServerError, Message: "Failed to generate token"}
+	ErrPayloadMalformed            = CustomError{Code: http.StatusBadRequest, Message: "Payload Malformed"}
+	ErrFailedToCreateUser          = CustomError{Code: http.StatusInternalServerError, Message: "Failed to Create User"}
+	ErrCredentialExist             = CustomError{Code: http.StatusConflict, Message: "Credentials already used"}
+	ErrUserNotFound                = CustomError{Code: http.StatusNotFound, Message: "User not found"}
+	ErrForbidden                   = CustomError{Code: http.StatusForbidden, Message: "You are not allowed to modify this resource"}
+	ErrWrongPassword               = CustomError{Code: http.StatusBadRequest, Message: "Wrong password"}
+	ErrInvalidUploadedFile         = CustomError{Code: http.StatusBadRequest, Message: "Invalid uploaded file"}
+	ErrInvalidFileSize             = CustomError{Code: http.StatusBadRequest, Message: "Invalid file size, max 10mb"}
+	ErrInvalidFileType             = CustomError{Code: http.StatusBadRequest, Message: "Invalid file type"}
+	ErrInvalidPayload              = CustomError{Code: http.StatusBadRequest, Message: "Invalid Payload"}
+	ErrFailedToUploadPhoto         = CustomError{Code: http.StatusInternalServerError, Message: "Failed to upload photo"}
+	ErrFailedToCreatePost          = CustomError{Code: http.StatusInternalServerError, Message: "Failed to create post"}
+	ErrPostNotFound                = CustomError{Code: http.StatusNotFound, Message: "Post not found"}
+	ErrFailedToGetPost             = CustomError{Code: http.StatusInternalServerError, Message: "Failed to get post"}
+	ErrInvalidPage                 = CustomError{Code: http.StatusBadRequest, Message: "Invalid cursor or limit"}
+	ErrFailedToGetFeed             = CustomError{Code: http.StatusInternalServerError, Message: "Failed to get feed"}
+	ErrCommentNotFound             = CustomError{Code: http.StatusNotFound, Message: "Comment not found"}
+	ErrFailedToCreateComment       = CustomError{Code: http.StatusInternalServerError, Message: "Failed to create comment"}
+	ErrFailedToUpdateComment       = CustomError{Code: http.StatusInternalServerError, Message: "Failed to update comment"}
+	ErrFailedToDeleteComment       = CustomError{Code: http.StatusInternalServerError, Message: "Failed to delete comment"}
+	ErrFailedToGetComment          = CustomError{Code: http.StatusInternalServerError, Message: "Failed to get comments"}
+	ErrLikeNotFound                = CustomError{Code: http.StatusNotFound, Message: "Like not found"}
+	ErrFailedToLikePost            = CustomError{Code: http.StatusInternalServerError, Message: "Failed to like post"}
+	ErrFailedToUnlikePost          = CustomError{Code: http.StatusInternalServerError, Message: "Failed to unlike post"}
+	ErrFailedToGetLikes            = CustomError{Code: http.StatusInternalServerError, Message: "Failed to get likes"}
+	ErrFavoriteNotFound            = CustomError{Code: http.StatusNotFound, Message: "Favorite not found"}
+	ErrFollowNotFound              = CustomError{Code: http.StatusNotFound, Message: "Follow not found"}
+	ErrCannotFollowSelf            = CustomError{Code: http.StatusBadRequest, Message: "You can't follow yourself"}
+	ErrMediaNotFound               = CustomError{Code: http.StatusNotFound, Message: "Media not found"}
+	ErrInvalidMediaURL             = CustomError{Code: http.StatusForbidden, Message: "Invalid or expired media url"}
+	ErrFailedToGetMedia            = CustomError{Code: http.StatusInternalServerError, Message: "Failed to get media"}
+	ErrUploadNotFound              = CustomError{Code: http.StatusNotFound, Message: "Upload not found"}
+	ErrUploadOffsetMismatch        = CustomError{Code: http.StatusConflict, Message: "Chunk does not start at the received offset"}
+	ErrUploadNotReady              = CustomError{Code: http.StatusConflict, Message: "Upload is not finalized or already used"}
+	ErrUploadTooLarge              = CustomError{Code: http.StatusRequestEntityTooLarge, Message: "Upload too large"}
+	ErrUnsupportedMediaType        = CustomError{Code: http.StatusUnsupportedMediaType, Message: "Unsupported media type"}
+	ErrTusVersion                  = CustomError{Code: http.StatusPreconditionFailed, Message: "Unsupported tus version"}
+	ErrFailedToGetNotifications    = CustomError{Code: http.StatusInternalServerError, Message: "Failed to get notifications"}
+	ErrFailedToUpdateNotifications = CustomError{Code: http.StatusInternalServerError, Message: "Failed to update notifications"}
+	ErrFailedToUpload              = CustomError{Code: http.StatusInternalServerError, Message: "Failed to upload file"}
+	ErrInvalidEventID              = CustomError{Code: http.StatusBadRequest, Message: "Invalid last event id"}
+	ErrFailedToOpenStream          = CustomError{Code: http.StatusInternalServerError, Message: "Failed to open event stream"}
+	ErrInvalidStreamTicket         = CustomError{Code: http.StatusUnauthorized, Message: "Invalid or already used stream ticket"}
+	ErrOriginNotAllowed            = CustomError{Code: http.StatusForbidden, Message: "Origin not allowed"}
+	ErrInvalidVerificationToken    = CustomError{Code: http.StatusBadRequest, Message: "Invalid or expired verification token"}
+	ErrEmailAlreadyVerified        = CustomError{Code: http.StatusConflict, Message: "Email already verified"}
+	ErrEmailNotVerified            = CustomError{Code: http.StatusForbidden, Message: "Verify your email address first"}
+	ErrFailedToSendVerification    = CustomError{Code: http.StatusInternalServerError, Message: "Failed to send verification email"}
+	ErrTooManyRequests             = CustomError{Code: http.StatusTooManyRequests, Message: "Too many requests, try again later"}
+	ErrInvalidResetToken           = CustomError{Code: http.StatusBadRequest, Message: "Invalid or expired password reset token"}
+	ErrFailedToSendPasswordReset   = CustomError{Code: http.StatusInternalServerError, Message: "Failed to send password reset email"}
 )
 
 type Response struct {
-	Message string `json:"message,omitempty"`
-	Data any `json:"data,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Data       any    `json:"data,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 type ErrorResponse struct {
 	Message string `json:"message"`
+	Details string `json:"details,omitempty"`
 }
 
 func ParseBody(r *http.Request, payload any) error {
@@ -51,12 +105,14 @@ func WriteJson(w http.ResponseWriter, successResponse CustomSuccess) {
 	var res Response
 	if successResponse.Message == "" {
 		res = Response{
-			Data: successResponse.Data,
+			Data:       successResponse.Data,
+			NextCursor: successResponse.NextCursor,
 		}
 	} else {
 		res = Response{
-			Message: successResponse.Message,
-			Data: successResponse.Data,
+			Message:    successResponse.Message,
+			Data:       successResponse.Data,
+			NextCursor: successResponse.NextCursor,
 		}
 	}
 
@@ -70,6 +126,7 @@ func WriteJson(w http.ResponseWriter, successResponse CustomSuccess) {
 func WriteError(w http.ResponseWriter, errorResponse CustomError) {
 	errRes := ErrorResponse{
 		Message: errorResponse.Message,
+		Details: errorResponse.Details,
 	}
 
 	errBytes, _ := json.Marshal(errRes)
@@ -77,4 +134,4 @@ func WriteError(w http.ResponseWriter, errorResponse CustomError) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(errorResponse.Code)
 	w.Write(errBytes)
-}
\ No newline at end of file
+}
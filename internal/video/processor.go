@@ -0,0 +1,259 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cakra17/social/internal/imaging"
+	"github.com/cakra17/social/internal/models"
+	"github.com/cakra17/social/internal/storage"
+	"github.com/cakra17/social/internal/store"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/cakra17/social/internal/worker"
+	"github.com/google/uuid"
+)
+
+// jobTimeout bounds the processing of a single video.
+const jobTimeout = 30 * time.Minute
+
+var ErrTooLong = errors.New("video is too long")
+
+// TranscodeJob asks for the upload attached to a post to be processed.
+type TranscodeJob struct {
+	PostID   string `json:"post_id"`
+	UploadID string `json:"upload_id"`
+}
+
+func (TranscodeJob) JobType() string { return "video.transcode" }
+
+type ProcessorConfig struct {
+	PostRepo   store.PostRepo
+	UploadRepo store.UploadRepo
+	MediaStore storage.MediaStore
+	// Staging holds the chunks of the uploads.
+	Staging    storage.MediaStore
+	Transcoder Transcoder
+	// WorkDir is where uploads are copied to and rendered in, ffmpeg needs
+	// them as local files.
+	WorkDir     string
+	MaxDuration time.Duration
+	Logger      *utils.Logger
+}
+
+// Processor turns uploaded videos into their web rendition and poster
+// frame, then publishes the post they are attached to.
+type Processor struct {
+	postRepo    store.PostRepo
+	uploadRepo  store.UploadRepo
+	mediaStore  storage.MediaStore
+	staging     storage.MediaStore
+	transcoder  Transcoder
+	workDir     string
+	maxDuration time.Duration
+	logger      *utils.Logger
+}
+
+func NewProcessor(cfg ProcessorConfig) *Processor {
+	return &Processor{
+		postRepo:    cfg.PostRepo,
+		uploadRepo:  cfg.UploadRepo,
+		mediaStore:  cfg.MediaStore,
+		staging:     cfg.Staging,
+		transcoder:  cfg.Transcoder,
+		workDir:     cfg.WorkDir,
+		maxDuration: cfg.MaxDuration,
+		logger:      cfg.Logger,
+	}
+}
+
+// Process handles a TranscodeJob. Failures are retried by the worker pool,
+// the post is marked failed once the video turns out to be unusable or the
+// last attempt fails.
+func (p *Processor) Process(ctx context.Context, job TranscodeJob) error {
+	jobCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	media, err := p.transcode(jobCtx, job)
+	if err == nil {
+		err = p.postRepo.CompleteProcessing(jobCtx, job.PostID, []models.PostMedia{media})
+		if err != nil {
+			p.deleteMedia(media)
+		}
+		// the post was deleted or another attempt already completed it
+		if errors.Is(err, store.ErrPostNotFound) {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		// interrupted by a shutdown, the job runs again
+		if ctx.Err() != nil {
+			return err
+		}
+
+		permanent := errors.Is(err, ErrTooLong) || errors.Is(err, ErrNoVideoStream)
+		if !permanent && !worker.LastAttempt(ctx) {
+			return err
+		}
+
+		ctx := context.WithoutCancel(ctx)
+		if err := p.postRepo.FailProcessing(ctx, job.PostID, failureReason(err)); err != nil {
+			return err
+		}
+		p.cleanup(ctx, job)
+		return worker.Permanent(err)
+	}
+
+	p.cleanup(context.WithoutCancel(ctx), job)
+	return nil
+}
+
+// cleanup deletes the upload of a processed job and its chunks.
+func (p *Processor) cleanup(ctx context.Context, job TranscodeJob) {
+	keys, err := p.uploadRepo.Delete(ctx, job.UploadID)
+	if err != nil {
+		p.logger.Error("Video Processor Error", "upload_id", job.UploadID, "msg", err.Error())
+	}
+	for _, key := range keys {
+		if err := p.staging.Delete(ctx, key); err != nil {
+			p.logger.Error("Video Processor Error", "key", key, "msg", err.Error())
+		}
+	}
+}
+
+// failureReason is the message stored on a failed post, it is shown to its
+// author so it must not leak internals.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrTooLong):
+		return err.Error()
+	case errors.Is(err, ErrNoVideoStream):
+		return "the file is not a video"
+	default:
+		return "the video could not be processed"
+	}
+}
+
+// transcode renders and stores the web rendition and poster of an upload.
+func (p *Processor) transcode(ctx context.Context, job TranscodeJob) (models.PostMedia, error) {
+	tmp, err := os.MkdirTemp(p.workDir, job.UploadID+"-")
+	if err != nil {
+		return models.PostMedia{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	in := filepath.Join(tmp, "upload")
+	if err := p.download(ctx, job.UploadID, in); err != nil {
+		return models.PostMedia{}, err
+	}
+
+	info, err := p.transcoder.Probe(ctx, in)
+	if err != nil {
+		return models.PostMedia{}, err
+	}
+	if p.maxDuration > 0 && info.Duration > p.maxDuration {
+		return models.PostMedia{}, fmt.Errorf("%w, max %s", ErrTooLong, p.maxDuration)
+	}
+
+	poster := filepath.Join(tmp, "poster.jpg")
+	if err := p.transcoder.Poster(ctx, in, poster, min(time.Second, info.Duration/2)); err != nil {
+		return models.PostMedia{}, err
+	}
+	rendition := filepath.Join(tmp, "video.mp4")
+	if err := p.transcoder.Transcode(ctx, in, rendition); err != nil {
+		return models.PostMedia{}, err
+	}
+	out, err := p.transcoder.Probe(ctx, rendition)
+	if err != nil {
+		return models.PostMedia{}, err
+	}
+
+	base := uuid.NewString()
+	media := models.PostMedia{
+		ID:         uuid.Must(uuid.NewV7()).String(),
+		Kind:       models.MediaKindVideo,
+		Key:        base + ".mp4",
+		Width:      out.Width,
+		Height:     out.Height,
+		DurationMS: int(info.Duration.Milliseconds()),
+	}
+
+	if err := p.putFile(ctx, media.Key, rendition, "video/mp4"); err != nil {
+		return models.PostMedia{}, err
+	}
+
+	data, err := os.ReadFile(poster)
+	if err != nil {
+		p.deleteMedia(media)
+		return models.PostMedia{}, err
+	}
+	variants, err := imaging.Process(data, imaging.DefaultLimits)
+	if err != nil {
+		p.deleteMedia(media)
+		return models.PostMedia{}, err
+	}
+	for _, v := range variants {
+		key := base + "_" + v.Name + v.Ext
+		if err := p.mediaStore.Put(ctx, key, bytes.NewReader(v.Data), int64(len(v.Data)), v.ContentType); err != nil {
+			p.deleteMedia(media)
+			return models.PostMedia{}, err
+		}
+		media.Variants = append(media.Variants, models.MediaVariant{
+			Name:   v.Name,
+			Key:    key,
+			Width:  v.Width,
+			Height: v.Height,
+		})
+	}
+
+	return media, nil
+}
+
+// download joins the chunks of an upload into the file at path.
+func (p *Processor) download(ctx context.Context, uploadID, path string) error {
+	keys, err := p.uploadRepo.ChunkKeys(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+	content := storage.Concat(ctx, p.staging, keys)
+	defer content.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (p *Processor) putFile(ctx context.Context, key, path, contentType string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return p.mediaStore.Put(ctx, key, f, stat.Size(), contentType)
+}
+
+func (p *Processor) deleteMedia(media models.PostMedia) {
+	ctx := context.Background()
+	for _, key := range store.MediaKeys([]models.PostMedia{media}) {
+		if err := p.mediaStore.Delete(ctx, key); err != nil {
+			p.logger.Error("Video Processor Error", "key", key, "msg", err.Error())
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package video
+
+import (
+	"bytes"
+	"slices"
+)
+
+// image formats stored in the same ISO container as MP4
+var imageBrands = []string{"avif", "avis", "heic", "heix", "heim", "heis", "mif1", "msf1"}
+
+// SniffLen is how many leading bytes Sniff looks at.
+const SniffLen = 16
+
+// Sniff reports whether data starts like a container ffmpeg can read
+// video from: MP4/QuickTime, Matroska/WebM or AVI. ffprobe has the final
+// word once the job runs.
+func Sniff(data []byte) bool {
+	switch {
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		return !slices.Contains(imageBrands, string(data[8:12]))
+	case bytes.HasPrefix(data, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		return true
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "AVI ":
+		return true
+	}
+	return false
+}
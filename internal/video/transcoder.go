@@ -0,0 +1,129 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// renditionSize is the longest side of the web rendition.
+const renditionSize = 1280
+
+var ErrNoVideoStream = errors.New("file has no video stream")
+
+// Info is what ffprobe reports about the first video stream of a file.
+type Info struct {
+	Width    int
+	Height   int
+	Duration time.Duration
+}
+
+// Transcoder runs the ffmpeg and ffprobe binaries.
+type Transcoder struct {
+	ffmpeg  string
+	ffprobe string
+}
+
+func NewTranscoder(ffmpeg, ffprobe string) Transcoder {
+	return Transcoder{ffmpeg: ffmpeg, ffprobe: ffprobe}
+}
+
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := bytes.TrimSpace(stderr.Bytes())
+		if len(msg) > 512 {
+			msg = msg[len(msg)-512:]
+		}
+		return nil, fmt.Errorf("%s: %w: %s", name, err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+type probeOutput struct {
+	Streams []struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// Probe reads the dimensions and duration of a video file.
+func (t Transcoder) Probe(ctx context.Context, path string) (Info, error) {
+	out, err := run(ctx, t.ffprobe,
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json",
+		path,
+	)
+	if err != nil {
+		return Info{}, err
+	}
+
+	var probe probeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return Info{}, fmt.Errorf("ffprobe: %w", err)
+	}
+	if len(probe.Streams) == 0 || probe.Streams[0].Width == 0 {
+		return Info{}, ErrNoVideoStream
+	}
+
+	seconds, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil {
+		return Info{}, fmt.Errorf("ffprobe: invalid duration %q", probe.Format.Duration)
+	}
+
+	return Info{
+		Width:    probe.Streams[0].Width,
+		Height:   probe.Streams[0].Height,
+		Duration: time.Duration(seconds * float64(time.Second)),
+	}, nil
+}
+
+// Poster extracts the frame at offset as a JPEG.
+func (t Transcoder) Poster(ctx context.Context, in, out string, offset time.Duration) error {
+	_, err := run(ctx, t.ffmpeg,
+		"-y", "-v", "error",
+		"-ss", strconv.FormatFloat(offset.Seconds(), 'f', 3, 64),
+		"-i", in,
+		"-frames:v", "1",
+		"-q:v", "2",
+		out,
+	)
+	return err
+}
+
+// Transcode encodes in as an H.264/AAC MP4 no larger than renditionSize on
+// its longest side, with the index up front so playback starts before the
+// whole file is downloaded.
+func (t Transcoder) Transcode(ctx context.Context, in, out string) error {
+	// scale the longest side down to renditionSize, never up, and keep both
+	// sides even as yuv420p requires
+	scale := fmt.Sprintf(
+		"scale='if(gte(iw,ih),trunc(min(%[1]d,iw)/2)*2,-2)':'if(gte(iw,ih),-2,trunc(min(%[1]d,ih)/2)*2)'",
+		renditionSize,
+	)
+	_, err := run(ctx, t.ffmpeg,
+		"-y", "-v", "error",
+		"-i", in,
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", scale,
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "128k",
+		"-movflags", "+faststart",
+		"-f", "mp4",
+		out,
+	)
+	return err
+}
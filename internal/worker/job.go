@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Payload is the typed body of a job, its JobType routes it to the handler
+// registered for it.
+type Payload interface {
+	JobType() string
+}
+
+// Job is the envelope stored in redis. It is encoded once when enqueued
+// and again on every retry, the encoded form identifies the job while it
+// is being processed.
+type Job struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Payload is the JSON encoded Payload, kept as bytes so the scripts
+	// that move jobs around never re-encode it.
+	Payload     []byte    `json:"payload"`
+	Attempt     int       `json:"attempt"`
+	MaxAttempts int       `json:"max_attempts"`
+	LastError   string    `json:"last_error,omitempty"`
+	EnqueuedAt  time.Time `json:"enqueued_at"`
+}
+
+type Option func(*Job)
+
+// WithMaxAttempts overrides how many times the job runs before it is moved
+// to the dead letter list.
+func WithMaxAttempts(n int) Option {
+	return func(j *Job) {
+		j.MaxAttempts = n
+	}
+}
+
+// permanentError marks an error that retrying can't fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job goes to the dead letter list without
+// being retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was wrapped by Permanent.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+type jobKey struct{}
+
+func withJob(ctx context.Context, job *Job) context.Context {
+	return context.WithValue(ctx, jobKey{}, job)
+}
+
+// LastAttempt reports whether the job handled with ctx won't be retried
+// if it fails.
+func LastAttempt(ctx context.Context) bool {
+	job, ok := ctx.Value(jobKey{}).(*Job)
+	return ok && job.Attempt+1 >= job.MaxAttempts
+}
+
+func decodeJob(data string) (*Job, error) {
+	job := &Job{}
+	if err := json.Unmarshal([]byte(data), job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
@@ -0,0 +1,261 @@
+package worker
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/cakra17/social/internal/utils"
+)
+
+// Handler processes one job. Returning an error retries the job with
+// exponential backoff until it runs out of attempts, Permanent errors go
+// straight to the dead letter list.
+type Handler func(ctx context.Context, job *Job) error
+
+type PoolConfig struct {
+	// Concurrency is the number of jobs processed at the same time.
+	Concurrency int
+	// PollInterval is how long an idle worker waits before looking for
+	// jobs again.
+	PollInterval time.Duration
+	// VisibilityTimeout is how long a job stays claimed without its worker
+	// reporting in, after that it is handed to another worker.
+	VisibilityTimeout time.Duration
+	// Backoff is the delay before the first retry, it doubles with every
+	// attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// Pool runs the handlers registered for each job type.
+type Pool struct {
+	queue    *Queue
+	cfg      PoolConfig
+	handlers map[string]Handler
+	logger   *utils.Logger
+
+	// stop stops claiming new jobs, cancel interrupts the running ones
+	stop   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewPool(q *Queue, cfg PoolConfig, lg *utils.Logger) *Pool {
+	cfg.PollInterval = cmp.Or(cfg.PollInterval, time.Second)
+	cfg.VisibilityTimeout = cmp.Or(cfg.VisibilityTimeout, 5*time.Minute)
+	cfg.Backoff = cmp.Or(cfg.Backoff, 5*time.Second)
+	cfg.MaxBackoff = max(cfg.MaxBackoff, cfg.Backoff)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		queue:    q,
+		cfg:      cfg,
+		handlers: map[string]Handler{},
+		logger:   lg,
+		stop:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Handle registers the handler of a job type, it must be called before
+// Start.
+func (p *Pool) Handle(jobType string, h Handler) {
+	p.handlers[jobType] = h
+}
+
+// Register registers fn as the handler of the jobs carrying a T.
+func Register[T Payload](p *Pool, fn func(ctx context.Context, payload T) error) {
+	var zero T
+	p.Handle(zero.JobType(), func(ctx context.Context, job *Job) error {
+		var payload T
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return Permanent(fmt.Errorf("decode %s payload: %w", job.Type, err))
+		}
+		return fn(ctx, payload)
+	})
+}
+
+// Start runs the workers and the scheduler that moves due retries and
+// expired jobs back to the queue.
+func (p *Pool) Start() {
+	for range max(p.cfg.Concurrency, 1) {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	p.wg.Add(1)
+	go p.schedule()
+}
+
+// Shutdown stops claiming jobs and waits for the running ones. When ctx is
+// done first the running jobs are interrupted and put back in the queue.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	close(p.stop)
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) stopping() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Pool) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-p.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (p *Pool) schedule() {
+	defer p.wg.Done()
+
+	for p.wait(p.cfg.PollInterval) {
+		if err := p.queue.promote(p.ctx); err != nil {
+			p.logger.Error("Worker Error", "msg", err.Error())
+		}
+	}
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+
+	for !p.stopping() {
+		job, data, err := p.queue.claim(p.ctx, time.Now().Add(p.cfg.VisibilityTimeout))
+		if err != nil {
+			p.logger.Error("Worker Error", "msg", err.Error())
+		}
+		if job == nil {
+			if !p.wait(p.cfg.PollInterval) {
+				return
+			}
+			continue
+		}
+
+		p.process(job, data)
+	}
+}
+
+// process runs a claimed job, extending its lease while it runs, and
+// settles it.
+func (p *Pool) process(job *Job, data string) {
+	// settling must go through even when the pool is interrupted
+	settleCtx := context.WithoutCancel(p.ctx)
+
+	handler, ok := p.handlers[job.Type]
+	if !ok {
+		p.fail(settleCtx, job, data, Permanent(fmt.Errorf("no handler for job type %q", job.Type)))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(withJob(p.ctx, job))
+	heartbeat := make(chan struct{})
+	go func() {
+		defer close(heartbeat)
+		ticker := time.NewTicker(p.cfg.VisibilityTimeout / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.queue.extend(ctx, data, time.Now().Add(p.cfg.VisibilityTimeout)); err != nil && ctx.Err() == nil {
+					p.logger.Error("Worker Error", "job", job.ID, "msg", err.Error())
+				}
+			}
+		}
+	}()
+
+	err := runHandler(ctx, handler, job)
+	cancel()
+	<-heartbeat
+
+	switch {
+	case err == nil:
+		if err := p.queue.ack(settleCtx, data); err != nil {
+			p.logger.Error("Worker Error", "job", job.ID, "msg", err.Error())
+		}
+	case p.ctx.Err() != nil && errors.Is(err, context.Canceled):
+		if err := p.queue.release(settleCtx, data); err != nil {
+			p.logger.Error("Worker Error", "job", job.ID, "msg", err.Error())
+		}
+	default:
+		p.fail(settleCtx, job, data, err)
+	}
+}
+
+// runHandler turns a panicking job into a failed one.
+func runHandler(ctx context.Context, h Handler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+func (p *Pool) fail(ctx context.Context, job *Job, data string, err error) {
+	next := *job
+	next.Attempt++
+	next.LastError = err.Error()
+
+	if IsPermanent(err) || next.Attempt >= next.MaxAttempts {
+		p.logger.Error("Worker Error", "job", job.ID, "type", job.Type, "msg", "job moved to dead letters", "error", err.Error())
+		encoded, encErr := json.Marshal(&next)
+		if encErr != nil {
+			encoded = []byte(data)
+		}
+		if err := p.queue.bury(ctx, data, encoded); err != nil {
+			p.logger.Error("Worker Error", "job", job.ID, "msg", err.Error())
+		}
+		return
+	}
+
+	p.logger.Warn("Worker", "job", job.ID, "type", job.Type, "attempt", next.Attempt, "error", err.Error())
+	if err := p.queue.retry(ctx, data, &next, time.Now().Add(p.backoff(next.Attempt))); err != nil {
+		p.logger.Error("Worker Error", "job", job.ID, "msg", err.Error())
+	}
+}
+
+// backoff doubles the delay with every attempt and spreads it by up to 20%
+// so jobs that failed together don't retry together.
+func (p *Pool) backoff(attempt int) time.Duration {
+	d := p.cfg.Backoff << min(attempt-1, 30)
+	if d <= 0 || d > p.cfg.MaxBackoff {
+		d = p.cfg.MaxBackoff
+	}
+	jitter := time.Duration(rand.Int64N(int64(d)/5 + 1))
+	return d - d/10 + jitter
+}
@@ -0,0 +1,257 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultMaxAttempts = 5
+	// maxDeadJobs bounds the dead letter list, the oldest are dropped.
+	maxDeadJobs   = 10000
+	moveBatchSize = 100
+)
+
+// A job lives in exactly one of four keys. Ready is a list consumed from its
+// tail. Claimed jobs sit in inflight scored by the deadline of their lease,
+// a worker that dies stops extending it and the job is reaped. Failed jobs
+// wait in delayed scored by when they run again, jobs that ran out of
+// attempts end up in dead.
+type keys struct {
+	prefix   string
+	ready    string
+	inflight string
+	delayed  string
+	dead     string
+}
+
+func newKeys(prefix string) keys {
+	return keys{
+		prefix:   prefix,
+		ready:    prefix + ":ready",
+		inflight: prefix + ":inflight",
+		delayed:  prefix + ":delayed",
+		dead:     prefix + ":dead",
+	}
+}
+
+// unique marks a job id enqueued by EnqueueOnce.
+func (k keys) unique(id string) string {
+	return k.prefix + ":unique:" + id
+}
+
+var enqueueOnceScript = redis.NewScript(`
+if not redis.call('SET', KEYS[1], '1', 'NX', 'PX', ARGV[2]) then
+	return 0
+end
+redis.call('LPUSH', KEYS[2], ARGV[1])
+return 1
+`)
+
+var claimScript = redis.NewScript(`
+local job = redis.call('RPOP', KEYS[1])
+if not job then
+	return false
+end
+redis.call('ZADD', KEYS[2], ARGV[1], job)
+return job
+`)
+
+// retryScript and buryScript only act on jobs still leased, a job whose
+// lease expired was already reaped and belongs to someone else.
+var retryScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[2])
+return 1
+`)
+
+var buryScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('LPUSH', KEYS[2], ARGV[2])
+redis.call('LTRIM', KEYS[2], 0, tonumber(ARGV[3]) - 1)
+return 1
+`)
+
+var releaseScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('RPUSH', KEYS[2], ARGV[1])
+return 1
+`)
+
+var promoteScript = redis.NewScript(`
+local jobs = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, job in ipairs(jobs) do
+	redis.call('ZREM', KEYS[1], job)
+	redis.call('LPUSH', KEYS[2], job)
+end
+return #jobs
+`)
+
+// reapScript counts an expired lease as a failed attempt, so a job that
+// crashes its worker every time still ends up in dead.
+var reapScript = redis.NewScript(`
+local jobs = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, data in ipairs(jobs) do
+	redis.call('ZREM', KEYS[1], data)
+	local job = cjson.decode(data)
+	job.attempt = job.attempt + 1
+	job.last_error = 'visibility timeout expired'
+	if job.attempt >= job.max_attempts then
+		redis.call('LPUSH', KEYS[3], cjson.encode(job))
+		redis.call('LTRIM', KEYS[3], 0, tonumber(ARGV[3]) - 1)
+	else
+		redis.call('LPUSH', KEYS[2], cjson.encode(job))
+	end
+end
+return #jobs
+`)
+
+// Queue stores jobs in redis. It is safe to share between handlers and
+// any number of pools, on any number of replicas.
+type Queue struct {
+	redis *redis.Client
+	keys  keys
+}
+
+// NewQueue creates a queue whose keys start with prefix.
+func NewQueue(rdb *redis.Client, prefix string) *Queue {
+	return &Queue{redis: rdb, keys: newKeys(prefix)}
+}
+
+// Enqueue schedules p to run as soon as a worker is free.
+func (q *Queue) Enqueue(ctx context.Context, p Payload, opts ...Option) error {
+	_, data, err := newJob(uuid.NewString(), p, opts)
+	if err != nil {
+		return err
+	}
+	return q.redis.LPush(ctx, q.keys.ready, data).Err()
+}
+
+// EnqueueOnce schedules p unless a job with the same id was enqueued within
+// the last ttl, so a producer can safely repeat an enqueue it isn't sure
+// went through. It reports whether the job was enqueued.
+func (q *Queue) EnqueueOnce(ctx context.Context, id string, ttl time.Duration, p Payload, opts ...Option) (bool, error) {
+	_, data, err := newJob(id, p, opts)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := enqueueOnceScript.Run(ctx, q.redis, []string{q.keys.unique(id), q.keys.ready}, data, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func newJob(id string, p Payload, opts []Option) (*Job, []byte, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	job := &Job{
+		ID:          id,
+		Type:        p.JobType(),
+		Payload:     payload,
+		MaxAttempts: defaultMaxAttempts,
+		EnqueuedAt:  time.Now().UTC(),
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+
+	data, err := json.Marshal(job)
+	return job, data, err
+}
+
+func msec(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// claim leases the oldest ready job until deadline. It returns a nil job
+// when the queue is empty.
+func (q *Queue) claim(ctx context.Context, deadline time.Time) (*Job, string, error) {
+	data, err := claimScript.Run(ctx, q.redis, []string{q.keys.ready, q.keys.inflight}, msec(deadline)).Text()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+
+	job, err := decodeJob(data)
+	if err != nil {
+		// a job that can't be decoded can't be handled either
+		q.bury(ctx, data, data)
+		return nil, "", err
+	}
+	return job, data, nil
+}
+
+// extend pushes back the lease deadline of a job being processed.
+func (q *Queue) extend(ctx context.Context, data string, deadline time.Time) error {
+	return q.redis.ZAddXX(ctx, q.keys.inflight, redis.Z{
+		Score:  float64(deadline.UnixMilli()),
+		Member: data,
+	}).Err()
+}
+
+func (q *Queue) ack(ctx context.Context, data string) error {
+	return q.redis.ZRem(ctx, q.keys.inflight, data).Err()
+}
+
+func (q *Queue) retry(ctx context.Context, data string, job *Job, at time.Time) error {
+	next, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return retryScript.Run(ctx, q.redis, []string{q.keys.inflight, q.keys.delayed}, data, next, msec(at)).Err()
+}
+
+func (q *Queue) bury(ctx context.Context, data string, next any) error {
+	return buryScript.Run(ctx, q.redis, []string{q.keys.inflight, q.keys.dead}, data, next, maxDeadJobs).Err()
+}
+
+// release puts a job that was interrupted by a shutdown back at the head
+// of the queue without counting an attempt.
+func (q *Queue) release(ctx context.Context, data string) error {
+	return releaseScript.Run(ctx, q.redis, []string{q.keys.inflight, q.keys.ready}, data).Err()
+}
+
+// promote moves the retries that are due and the jobs whose lease expired
+// back to ready.
+func (q *Queue) promote(ctx context.Context) error {
+	now := msec(time.Now())
+	err := promoteScript.Run(ctx, q.redis, []string{q.keys.delayed, q.keys.ready}, now, moveBatchSize).Err()
+	if err != nil {
+		return err
+	}
+	return reapScript.Run(ctx, q.redis, []string{q.keys.inflight, q.keys.ready, q.keys.dead}, now, moveBatchSize, maxDeadJobs).Err()
+}
+
+// DeadJobs returns the newest jobs that ran out of attempts.
+func (q *Queue) DeadJobs(ctx context.Context, limit int64) ([]Job, error) {
+	items, err := q.redis.LRange(ctx, q.keys.dead, 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]Job, 0, len(items))
+	for _, item := range items {
+		job, err := decodeJob(item)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, nil
+}
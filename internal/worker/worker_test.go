@@ -0,0 +1,310 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/cakra17/social/internal/utils"
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/go-redis/v9/maintnotifications"
+)
+
+type testPayload struct {
+	Value string `json:"value"`
+}
+
+func (testPayload) JobType() string { return "test" }
+
+func newTestQueue(t *testing.T) (*Queue, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		MaintNotificationsConfig: &maintnotifications.Config{
+			Mode: maintnotifications.ModeDisabled,
+		},
+	})
+	t.Cleanup(func() { rdb.Close() })
+	return NewQueue(rdb, "jobs"), rdb
+}
+
+func newTestPool(q *Queue) *Pool {
+	return NewPool(q, PoolConfig{
+		VisibilityTimeout: time.Minute,
+		Backoff:           time.Second,
+		MaxBackoff:        time.Minute,
+	}, utils.NewLogger())
+}
+
+// jobsIn decodes the jobs stored in a list or sorted set of the queue.
+func jobsIn(t *testing.T, rdb *redis.Client, key string) []*Job {
+	t.Helper()
+
+	ctx := context.Background()
+	var items []string
+	var err error
+	if rdb.Type(ctx, key).Val() == "zset" {
+		items, err = rdb.ZRange(ctx, key, 0, -1).Result()
+	} else {
+		items, err = rdb.LRange(ctx, key, 0, -1).Result()
+	}
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", key, err)
+	}
+
+	jobs := make([]*Job, 0, len(items))
+	for _, item := range items {
+		job, err := decodeJob(item)
+		if err != nil {
+			t.Fatalf("Failed to decode job of %s: %v", key, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+func TestClaimInOrder(t *testing.T) {
+	ctx := context.Background()
+	q, _ := newTestQueue(t)
+
+	for _, v := range []string{"a", "b", "c"} {
+		if err := q.Enqueue(ctx, testPayload{Value: v}); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+
+	for _, want := range []string{"a", "b", "c"} {
+		job, _, err := q.claim(ctx, time.Now().Add(time.Minute))
+		if err != nil || job == nil {
+			t.Fatalf("Failed to claim: %v", err)
+		}
+		if got := string(job.Payload); got != `{"value":"`+want+`"}` {
+			t.Errorf("claimed %s want %s", got, want)
+		}
+	}
+
+	job, _, err := q.claim(ctx, time.Now().Add(time.Minute))
+	if err != nil || job != nil {
+		t.Errorf("claimed %v, %v from an empty queue", job, err)
+	}
+}
+
+func TestEnqueueOnce(t *testing.T) {
+	ctx := context.Background()
+	q, rdb := newTestQueue(t)
+
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{"first", "job-1", true},
+		{"repeated", "job-1", false},
+		{"other id", "job-2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := q.EnqueueOnce(ctx, tt.id, time.Hour, testPayload{})
+			if err != nil {
+				t.Fatalf("Failed to enqueue: %v", err)
+			}
+			if ok != tt.want {
+				t.Errorf("got %v want %v", ok, tt.want)
+			}
+		})
+	}
+
+	if n := len(jobsIn(t, rdb, q.keys.ready)); n != 2 {
+		t.Errorf("got %d ready jobs want 2", n)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name        string
+		jobType     string
+		maxAttempts int
+		handler     Handler
+		// where the job ends up, empty when acked
+		wantKey     string
+		wantAttempt int
+		wantError   string
+	}{
+		{"success", "test", 5, func(context.Context, *Job) error { return nil }, "", 0, ""},
+		{"failure is retried", "test", 5, func(context.Context, *Job) error { return errFailed }, "delayed", 1, "failed"},
+		{"panic is retried", "test", 5, func(context.Context, *Job) error { panic("boom") }, "delayed", 1, "panic: boom"},
+		{"last attempt", "test", 1, func(context.Context, *Job) error { return errFailed }, "dead", 1, "failed"},
+		{"permanent failure", "test", 5, func(context.Context, *Job) error { return Permanent(errFailed) }, "dead", 1, "failed"},
+		{"no handler", "unknown", 5, nil, "dead", 1, `no handler for job type "unknown"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			q, rdb := newTestQueue(t)
+			p := newTestPool(q)
+			if tt.handler != nil {
+				p.Handle(tt.jobType, tt.handler)
+			}
+
+			if err := q.Enqueue(ctx, testPayload{}, WithMaxAttempts(tt.maxAttempts)); err != nil {
+				t.Fatalf("Failed to enqueue: %v", err)
+			}
+			job, data, err := q.claim(ctx, time.Now().Add(time.Minute))
+			if err != nil {
+				t.Fatalf("Failed to claim: %v", err)
+			}
+			job.Type = tt.jobType
+
+			p.process(job, data)
+
+			if n := len(jobsIn(t, rdb, q.keys.inflight)); n != 0 {
+				t.Errorf("%d jobs left in flight", n)
+			}
+			lists := map[string]string{"ready": q.keys.ready, "delayed": q.keys.delayed, "dead": q.keys.dead}
+			for key, list := range lists {
+				jobs := jobsIn(t, rdb, list)
+				if key != tt.wantKey {
+					if len(jobs) != 0 {
+						t.Errorf("job moved to %s want %q", key, tt.wantKey)
+					}
+					continue
+				}
+				if len(jobs) != 1 {
+					t.Fatalf("got %d jobs in %s want 1", len(jobs), key)
+				}
+				if jobs[0].Attempt != tt.wantAttempt || jobs[0].LastError != tt.wantError {
+					t.Errorf("got attempt %d error %q want %d %q", jobs[0].Attempt, jobs[0].LastError, tt.wantAttempt, tt.wantError)
+				}
+			}
+		})
+	}
+}
+
+func TestPromote(t *testing.T) {
+	ctx := context.Background()
+	q, rdb := newTestQueue(t)
+
+	for _, v := range []string{"expired", "last attempt", "leased", "due", "later"} {
+		opts := []Option{}
+		if v == "last attempt" {
+			opts = append(opts, WithMaxAttempts(1))
+		}
+		if err := q.Enqueue(ctx, testPayload{Value: v}, opts...); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+
+	claim := func(deadline time.Time) (*Job, string) {
+		job, data, err := q.claim(ctx, deadline)
+		if err != nil || job == nil {
+			t.Fatalf("Failed to claim: %v", err)
+		}
+		return job, data
+	}
+	past, future := time.Now().Add(-time.Second), time.Now().Add(time.Hour)
+
+	// workers that died holding a job
+	expired, expiredData := claim(past)
+	claim(past)
+	claim(future)
+	due, data := claim(future)
+	if err := q.retry(ctx, data, due, past); err != nil {
+		t.Fatalf("Failed to retry: %v", err)
+	}
+	later, data := claim(future)
+	if err := q.retry(ctx, data, later, future); err != nil {
+		t.Fatalf("Failed to retry: %v", err)
+	}
+
+	if err := q.promote(ctx); err != nil {
+		t.Fatalf("Failed to promote: %v", err)
+	}
+
+	payloads := func(key string) string {
+		var out []string
+		for _, job := range jobsIn(t, rdb, key) {
+			out = append(out, string(job.Payload))
+		}
+		return strings.Join(out, ",")
+	}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{q.keys.ready, `{"value":"expired"},{"value":"due"}`},
+		{q.keys.inflight, `{"value":"leased"}`},
+		{q.keys.delayed, `{"value":"later"}`},
+		{q.keys.dead, `{"value":"last attempt"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := payloads(tt.key); got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+
+	// an expired lease counts as an attempt
+	for _, job := range jobsIn(t, rdb, q.keys.ready) {
+		if string(job.Payload) == `{"value":"expired"}` && (job.Attempt != 1 || job.LastError != "visibility timeout expired") {
+			t.Errorf("reaped job has attempt %d error %q", job.Attempt, job.LastError)
+		}
+	}
+
+	// a reaped job can't be settled by the worker that lost it
+	if err := q.retry(ctx, expiredData, expired, past); err != nil {
+		t.Fatalf("Failed to retry: %v", err)
+	}
+	if got := payloads(q.keys.delayed); got != `{"value":"later"}` {
+		t.Errorf("retry of a reaped job changed the queue: %s", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := newTestPool(nil)
+
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{7, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			d := p.backoff(tt.attempt)
+			if d < tt.base-tt.base/10 || d > tt.base+tt.base/10 {
+				t.Errorf("attempt %d waits %s, want %s ±10%%", tt.attempt, d, tt.base)
+			}
+		}
+	}
+}
+
+func TestLastAttempt(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want bool
+	}{
+		{"outside a job", context.Background(), false},
+		{"attempts left", withJob(context.Background(), &Job{Attempt: 0, MaxAttempts: 2}), false},
+		{"last attempt", withJob(context.Background(), &Job{Attempt: 1, MaxAttempts: 2}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LastAttempt(tt.ctx); got != tt.want {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
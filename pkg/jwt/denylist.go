@@ -0,0 +1,105 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// versionCacheTTL is how long a token version read from the VersionStore is
+// cached in redis.
+const versionCacheTTL = 24 * time.Hour
+
+// cacheVersionScript caches the version ARGV[1] for ARGV[2] seconds unless a
+// higher one is cached already, so a version read before a bump can't be
+// cached over the bumped one.
+var cacheVersionScript = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '-1')
+if tonumber(ARGV[1]) > current then
+	redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+end
+return 0
+`)
+
+// VersionStore persists the token versions of users.
+type VersionStore interface {
+	TokenVersion(ctx context.Context, userID string) (int64, error)
+	// BumpTokenVersion raises the version of the user above both its stored
+	// version and min, and returns the new version.
+	BumpTokenVersion(ctx context.Context, userID string, min int64) (int64, error)
+}
+
+// Denylist keeps server side revocation state for issued tokens. Single
+// tokens are revoked by jti until they expire, all tokens of a user are
+// revoked at once by bumping the user's token version. Versions are kept by
+// the VersionStore and only cached in redis, so losing redis does not undo a
+// revocation.
+type Denylist struct {
+	redis    *redis.Client
+	versions VersionStore
+}
+
+func NewDenylist(rdb *redis.Client, versions VersionStore) *Denylist {
+	return &Denylist{redis: rdb, versions: versions}
+}
+
+func revokedKey(jti string) string {
+	return fmt.Sprintf("jwt:revoked:%s", jti)
+}
+
+func versionKey(userID string) string {
+	return fmt.Sprintf("jwt:version:%s", userID)
+}
+
+func (d *Denylist) Revoke(ctx context.Context, jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	return d.redis.Set(ctx, revokedKey(jti), 1, ttl).Err()
+}
+
+func (d *Denylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	n, err := d.redis.Exists(ctx, revokedKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (d *Denylist) cacheVersion(ctx context.Context, userID string, version int64) error {
+	ttl := int64(versionCacheTTL / time.Second)
+	return cacheVersionScript.Run(ctx, d.redis, []string{versionKey(userID)}, version, ttl).Err()
+}
+
+func (d *Denylist) Version(ctx context.Context, userID string) (int64, error) {
+	v, err := d.redis.Get(ctx, versionKey(userID)).Int64()
+	if !errors.Is(err, redis.Nil) {
+		return v, err
+	}
+
+	v, err = d.versions.TokenVersion(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := d.cacheVersion(ctx, userID, v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+func (d *Denylist) BumpVersion(ctx context.Context, userID string) error {
+	// versions that were only kept in redis are carried over
+	cached, err := d.redis.Get(ctx, versionKey(userID)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	v, err := d.versions.BumpTokenVersion(ctx, userID, cached)
+	if err != nil {
+		return err
+	}
+	return d.cacheVersion(ctx, userID, v)
+}
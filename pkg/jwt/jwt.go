@@ -9,38 +9,69 @@ import (
 
 	. "github.com/cakra17/social/internal/utils"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type JWTUser struct {
-	ID string `json:"userId"`
-	Email  string `json:"email"`
+	ID    string `json:"userId"`
+	Email string `json:"email"`
 }
 
 type JWTAuthenticator struct {
-	secret string
+	secret   string
 	duration time.Duration
+	denylist *Denylist
+	keyring  *Keyring
 }
 
 type userClaimsKey struct{}
 
 func NewJWTAuthenticator(secret string, duration time.Duration) *JWTAuthenticator {
 	return &JWTAuthenticator{
-		secret: secret,
+		secret:   secret,
 		duration: duration,
 	}
 }
 
-func (ja *JWTAuthenticator) BuildJWTClaims(user JWTUser) jwt.MapClaims {
+// WithDenylist makes the authenticator reject revoked tokens.
+func (ja *JWTAuthenticator) WithDenylist(d *Denylist) *JWTAuthenticator {
+	ja.denylist = d
+	return ja
+}
+
+// WithKeyring switches the authenticator from the shared HS256 secret to the
+// asymmetric keys of the keyring.
+func (ja *JWTAuthenticator) WithKeyring(k *Keyring) *JWTAuthenticator {
+	ja.keyring = k
+	return ja
+}
+
+func (ja *JWTAuthenticator) BuildJWTClaims(user JWTUser, version int64) jwt.MapClaims {
 	return jwt.MapClaims{
+		"jti":    uuid.NewString(),
 		"userId": user.ID,
-		"email": user.Email,
-		"exp": time.Now().Add(ja.duration).Unix(),
-		"iat": time.Now().Unix(),
+		"email":  user.Email,
+		"ver":    version,
+		"exp":    time.Now().Add(ja.duration).Unix(),
+		"iat":    time.Now().Unix(),
 	}
 }
 
-func (ja *JWTAuthenticator) GenerateToken(user JWTUser) (string, error) {
-	claims := ja.BuildJWTClaims(user)
+func (ja *JWTAuthenticator) GenerateToken(ctx context.Context, user JWTUser) (string, error) {
+	var version int64
+	if ja.denylist != nil {
+		v, err := ja.denylist.Version(ctx, user.ID)
+		if err != nil {
+			return "", err
+		}
+		version = v
+	}
+
+	claims := ja.BuildJWTClaims(user, version)
+	if ja.keyring != nil {
+		return ja.keyring.sign(claims)
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	tokenStr, err := token.SignedString([]byte(ja.secret))
@@ -52,6 +83,13 @@ func (ja *JWTAuthenticator) GenerateToken(user JWTUser) (string, error) {
 }
 
 func (ja *JWTAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
+	if ja.keyring != nil {
+		return jwt.Parse(token, ja.keyring.verificationKey,
+			jwt.WithExpirationRequired(),
+			jwt.WithValidMethods(ja.keyring.methods()),
+		)
+	}
+
 	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
@@ -61,7 +99,7 @@ func (ja *JWTAuthenticator) ValidateToken(token string) (*jwt.Token, error) {
 		jwt.WithExpirationRequired(),
 		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}),
 	)
-} 
+}
 
 func (ja *JWTAuthenticator) JWTMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -90,14 +128,82 @@ func (ja *JWTAuthenticator) JWTMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userClaimsKey{}, claims)
+		if revoked, err := ja.isRevoked(r.Context(), claims); err != nil || revoked {
+			WriteError(w, ErrTokenRevoked)
+			return
+		}
 
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
 	})
 }
 
+// isRevoked checks the token against the denylist, either the token itself
+// was revoked or it was issued before the user's tokens were revoked.
+func (ja *JWTAuthenticator) isRevoked(ctx context.Context, claims jwt.MapClaims) (bool, error) {
+	if ja.denylist == nil {
+		return false, nil
+	}
+
+	jti, _ := claims["jti"].(string)
+	userID, _ := claims["userId"].(string)
+	ver, _ := claims["ver"].(float64)
+	if jti == "" {
+		return true, nil
+	}
+
+	revoked, err := ja.denylist.IsRevoked(ctx, jti)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	current, err := ja.denylist.Version(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return int64(ver) < current, nil
+}
+
+// Revoked reports whether the token of the claims expired or was revoked
+// since it was validated. Connections outliving a request check it while
+// they are open.
+func (ja *JWTAuthenticator) Revoked(ctx context.Context, claims jwt.MapClaims) (bool, error) {
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil || !time.Now().Before(exp.Time) {
+		return true, nil
+	}
+	return ja.isRevoked(ctx, claims)
+}
+
+// Revoke denies the token the claims belong to until it expires.
+func (ja *JWTAuthenticator) Revoke(ctx context.Context, claims jwt.MapClaims) error {
+	if ja.denylist == nil {
+		return nil
+	}
+
+	jti, _ := claims["jti"].(string)
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil || jti == "" {
+		return fmt.Errorf("token can't be revoked")
+	}
+	return ja.denylist.Revoke(ctx, jti, time.Until(exp.Time))
+}
+
+// RevokeUser denies every token issued to the user so far.
+func (ja *JWTAuthenticator) RevokeUser(ctx context.Context, userID string) error {
+	if ja.denylist == nil {
+		return nil
+	}
+	return ja.denylist.BumpVersion(ctx, userID)
+}
+
+// WithClaims returns a context carrying the claims of an authenticated
+// token, as JWTMiddleware stores them.
+func WithClaims(ctx context.Context, claims jwt.MapClaims) context.Context {
+	return context.WithValue(ctx, userClaimsKey{}, claims)
+}
+
 func (ja *JWTAuthenticator) GetClaims(ctx context.Context) (jwt.MapClaims, bool) {
 	val := ctx.Value(userClaimsKey{})
 	claims, ok := val.(jwt.MapClaims)
 	return claims, ok
-}
\ No newline at end of file
+}
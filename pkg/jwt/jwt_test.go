@@ -0,0 +1,248 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/go-redis/v9/maintnotifications"
+)
+
+var errUnknownUser = errors.New("unknown user")
+
+// memoryVersions is a VersionStore of the users user-1 and user-2.
+type memoryVersions map[string]int64
+
+func (m memoryVersions) TokenVersion(_ context.Context, userID string) (int64, error) {
+	v, ok := m[userID]
+	if !ok {
+		return 0, errUnknownUser
+	}
+	return v, nil
+}
+
+func (m memoryVersions) BumpTokenVersion(_ context.Context, userID string, min int64) (int64, error) {
+	v, ok := m[userID]
+	if !ok {
+		return 0, errUnknownUser
+	}
+	m[userID] = max(v, min) + 1
+	return m[userID], nil
+}
+
+func newTestMiniredis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		MaintNotificationsConfig: &maintnotifications.Config{
+			Mode: maintnotifications.ModeDisabled,
+		},
+	})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb, mr
+}
+
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	rdb, _ := newTestMiniredis(t)
+	return rdb
+}
+
+func newTestAuthenticator(t *testing.T) *JWTAuthenticator {
+	t.Helper()
+	versions := memoryVersions{"user-1": 0, "user-2": 0}
+	return NewJWTAuthenticator("testsecret", time.Hour).WithDenylist(NewDenylist(newTestRedis(t), versions))
+}
+
+// authorize sends a request with the token through the middleware and
+// returns the status code and the claims the handler saw.
+func authorize(ja *JWTAuthenticator, token string) (int, map[string]any) {
+	var claims map[string]any
+	handler := ja.JWTMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ = ja.GetClaims(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Code, claims
+}
+
+func TestRevokedTokenIsRejected(t *testing.T) {
+	ctx := context.Background()
+	ja := newTestAuthenticator(t)
+
+	token, err := ja.GenerateToken(ctx, JWTUser{ID: "user-1", Email: "user@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	other, err := ja.GenerateToken(ctx, JWTUser{ID: "user-1", Email: "user@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	status, claims := authorize(ja, token)
+	if status != http.StatusNoContent {
+		t.Fatalf("token rejected before logout: got %v want %v", status, http.StatusNoContent)
+	}
+
+	// logout revokes the token of the request
+	if err := ja.Revoke(ctx, claims); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+
+	if status, _ := authorize(ja, token); status != http.StatusUnauthorized {
+		t.Errorf("logged out token accepted: got %v want %v", status, http.StatusUnauthorized)
+	}
+	if status, _ := authorize(ja, other); status != http.StatusNoContent {
+		t.Errorf("token of another session rejected: got %v want %v", status, http.StatusNoContent)
+	}
+}
+
+func TestRevokeUserRejectsEarlierTokens(t *testing.T) {
+	ctx := context.Background()
+	ja := newTestAuthenticator(t)
+	user := JWTUser{ID: "user-1", Email: "user@example.com"}
+
+	before, err := ja.GenerateToken(ctx, user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	if err := ja.RevokeUser(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to revoke user: %v", err)
+	}
+
+	after, err := ja.GenerateToken(ctx, user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"issued before revoking", before, http.StatusUnauthorized},
+		{"issued after revoking", after, http.StatusNoContent},
+		{"malformed", "not-a-token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, _ := authorize(ja, tt.token); status != tt.want {
+				t.Errorf("got %v want %v", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestRevokeUserSurvivesRedisFlush(t *testing.T) {
+	ctx := context.Background()
+	rdb, mr := newTestMiniredis(t)
+	versions := memoryVersions{"user-1": 0}
+	ja := NewJWTAuthenticator("testsecret", time.Hour).WithDenylist(NewDenylist(rdb, versions))
+	user := JWTUser{ID: "user-1", Email: "user@example.com"}
+
+	token, err := ja.GenerateToken(ctx, user)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if err := ja.RevokeUser(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to revoke user: %v", err)
+	}
+
+	mr.FlushAll()
+
+	if status, _ := authorize(ja, token); status != http.StatusUnauthorized {
+		t.Errorf("revoked token accepted after redis lost its state: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestBumpVersionCarriesOverCachedVersions(t *testing.T) {
+	ctx := context.Background()
+	rdb, mr := newTestMiniredis(t)
+	versions := memoryVersions{"user-1": 0}
+	d := NewDenylist(rdb, versions)
+
+	// a version that was only kept in redis
+	mr.Set(versionKey("user-1"), "5")
+
+	if err := d.BumpVersion(ctx, "user-1"); err != nil {
+		t.Fatalf("Failed to bump version: %v", err)
+	}
+	if versions["user-1"] != 6 {
+		t.Errorf("stored version %d want 6", versions["user-1"])
+	}
+	if got, err := d.Version(ctx, "user-1"); err != nil || got != 6 {
+		t.Errorf("got version %d, %v want 6", got, err)
+	}
+}
+
+func TestTokenOfUnknownUserIsRejected(t *testing.T) {
+	ctx := context.Background()
+	ja := newTestAuthenticator(t)
+
+	token, err := ja.GenerateToken(ctx, JWTUser{ID: "user-1"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	// the token version is read from the store the first time
+	ja.denylist.versions = memoryVersions{}
+
+	if status, _ := authorize(ja, token); status != http.StatusNoContent {
+		t.Errorf("cached version not used: got %v want %v", status, http.StatusNoContent)
+	}
+	ja.denylist.redis.FlushAll(ctx)
+	if status, _ := authorize(ja, token); status != http.StatusUnauthorized {
+		t.Errorf("token of a deleted user accepted: got %v want %v", status, http.StatusUnauthorized)
+	}
+}
+
+func TestRevokedAfterValidation(t *testing.T) {
+	ctx := context.Background()
+	user := JWTUser{ID: "user-1", Email: "user@example.com"}
+
+	tests := []struct {
+		name   string
+		revoke func(ja *JWTAuthenticator, claims map[string]any) error
+		want   bool
+	}{
+		{"still valid", func(*JWTAuthenticator, map[string]any) error { return nil }, false},
+		{"logged out", func(ja *JWTAuthenticator, claims map[string]any) error { return ja.Revoke(ctx, claims) }, true},
+		{"user revoked", func(ja *JWTAuthenticator, _ map[string]any) error { return ja.RevokeUser(ctx, user.ID) }, true},
+		{"expired", func(_ *JWTAuthenticator, claims map[string]any) error {
+			claims["exp"] = float64(time.Now().Add(-time.Second).Unix())
+			return nil
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ja := newTestAuthenticator(t)
+			token, err := ja.GenerateToken(ctx, user)
+			if err != nil {
+				t.Fatalf("Failed to generate token: %v", err)
+			}
+			_, claims := authorize(ja, token)
+			if err := tt.revoke(ja, claims); err != nil {
+				t.Fatalf("Failed to revoke: %v", err)
+			}
+
+			revoked, err := ja.Revoked(ctx, claims)
+			if err != nil {
+				t.Fatalf("Failed to check token: %v", err)
+			}
+			if revoked != tt.want {
+				t.Errorf("got revoked %v want %v", revoked, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/cakra17/social/pkg/keyset"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+)
+
+type KeyringConfig struct {
+	// Algorithm of newly generated keys, RS256 or EdDSA.
+	Algorithm string
+	// RotateEvery is how often a new signing key is generated.
+	RotateEvery time.Duration
+	// TokenTTL is how long a retired key keeps verifying, it must not be
+	// shorter than the lifetime of the tokens it signed.
+	TokenTTL time.Duration
+	// KEK encrypts the private keys in redis, see keyset.Config.
+	KEK []byte
+}
+
+// Keyring holds the asymmetric keys used to sign and verify tokens. The
+// newest key signs, retired keys keep verifying until every token they
+// signed has expired and are published in the JWKS so other services can
+// verify our tokens without holding any secret.
+//
+// Generated keys are shared by the replicas through redis, encrypted with a
+// key-encryption key every replica holds. Deployments that rather keep the
+// key in a file use NewStaticKeyring.
+type Keyring struct {
+	keys *keyset.Set[crypto.Signer]
+}
+
+// NewKeyring loads the rotating keys shared through redis.
+func NewKeyring(ctx context.Context, rdb *redis.Client, cfg KeyringConfig) (*Keyring, error) {
+	if cfg.Algorithm != AlgRS256 && cfg.Algorithm != AlgEdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", cfg.Algorithm)
+	}
+
+	keys, err := keyset.New(ctx, rdb, keyset.Config[crypto.Signer]{
+		Name:        "jwt",
+		RotateEvery: cfg.RotateEvery,
+		TTL:         cfg.TokenTTL,
+		Generate:    func() ([]byte, error) { return generateKey(cfg.Algorithm) },
+		Parse:       parsePrivateKey,
+		KEK:         cfg.KEK,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{keys: keys}, nil
+}
+
+// NewStaticKeyring signs with a PKCS#8 PEM encoded RSA or Ed25519 private
+// key only. It does not rotate, every replica must load the same key.
+func NewStaticKeyring(kid string, data []byte) (*Keyring, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM data found", kid)
+	}
+
+	private, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", kid, err)
+	}
+	return &Keyring{keys: keyset.Static(kid, private)}, nil
+}
+
+// generateKey returns a new PKCS#8 encoded private key.
+func generateKey(alg string) ([]byte, error) {
+	var private any
+	switch alg {
+	case AlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	case AlgEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	return x509.MarshalPKCS8PrivateKey(private)
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		return private, nil
+	case ed25519.PrivateKey:
+		return private, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+func signingMethod(private crypto.Signer) jwt.SigningMethod {
+	if _, ok := private.(ed25519.PrivateKey); ok {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// Run reloads and rotates the keys on schedule until ctx is done.
+func (k *Keyring) Run(ctx context.Context) {
+	k.keys.Run(ctx)
+}
+
+func (k *Keyring) methods() []string {
+	return []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+}
+
+// sign signs the claims with the current key and sets its kid header.
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	key, err := k.keys.Current()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(signingMethod(key.Value), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Value)
+}
+
+// verificationKey resolves the public key a token was signed with.
+func (k *Keyring) verificationKey(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	private, ok := k.keys.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	if t.Method.Alg() != signingMethod(private).Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+	}
+	return private.Public(), nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+func newJWK(key keyset.Key[crypto.Signer]) jwk {
+	b64 := base64.RawURLEncoding.EncodeToString
+	out := jwk{Kid: key.ID, Use: "sig", Alg: signingMethod(key.Value).Alg()}
+
+	switch public := key.Value.Public().(type) {
+	case *rsa.PublicKey:
+		out.Kty = "RSA"
+		out.N = b64(public.N.Bytes())
+		out.E = b64(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		out.Kty = "OKP"
+		out.Crv = "Ed25519"
+		out.X = b64(public)
+	}
+	return out
+}
+
+// JWKSHandler publishes the public part of every key that verifies, keys
+// about to sign included so verifiers know them in advance. It is cached no
+// longer than that advance.
+func (k *Keyring) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	published := k.keys.Keys()
+	keys := make([]jwk, 0, len(published))
+	for _, key := range published {
+		keys = append(keys, newJWK(key))
+	}
+
+	body, _ := json.Marshal(map[string][]jwk{"keys": keys})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(k.keys.MaxAge().Seconds())))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
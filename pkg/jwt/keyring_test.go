@@ -0,0 +1,250 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cakra17/social/pkg/keyset"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	testUser = JWTUser{ID: "user-1", Email: "user@example.com"}
+	testKEK  = bytes.Repeat([]byte("k"), keyset.KEKSize)
+)
+
+func newTestKeyring(t *testing.T, alg string) *Keyring {
+	t.Helper()
+
+	k, err := NewKeyring(context.Background(), newTestRedis(t), KeyringConfig{
+		Algorithm:   alg,
+		RotateEvery: 24 * time.Hour,
+		TokenTTL:    time.Hour,
+		KEK:         testKEK,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+	return k
+}
+
+func currentKey(t *testing.T, k *Keyring) keyset.Key[crypto.Signer] {
+	t.Helper()
+
+	key, err := k.keys.Current()
+	if err != nil {
+		t.Fatalf("Failed to get current key: %v", err)
+	}
+	return key
+}
+
+// jwksKeys fetches the JWKS of the keyring and returns its public keys by
+// kid, decoded the way a verifying service would.
+func jwksKeys(t *testing.T, k *Keyring) map[string]any {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	k.JWKSHandler(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("jwks handler returned %v", rr.Code)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &set); err != nil {
+		t.Fatalf("Failed to decode jwks: %v", err)
+	}
+
+	b64 := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatalf("Failed to decode jwk member: %v", err)
+		}
+		return b
+	}
+
+	keys := map[string]any{}
+	for _, key := range set.Keys {
+		switch key.Kty {
+		case "RSA":
+			keys[key.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(b64(key.N)),
+				E: int(new(big.Int).SetBytes(b64(key.E)).Int64()),
+			}
+		case "OKP":
+			keys[key.Kid] = ed25519.PublicKey(b64(key.X))
+		default:
+			t.Fatalf("unexpected key type %q", key.Kty)
+		}
+	}
+	return keys
+}
+
+func TestKeyringSignsAndPublishes(t *testing.T) {
+	tests := []struct {
+		alg string
+	}{
+		{AlgRS256},
+		{AlgEdDSA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			ja := NewJWTAuthenticator("", time.Hour).WithKeyring(newTestKeyring(t, tt.alg))
+
+			token, err := ja.GenerateToken(context.Background(), testUser)
+			if err != nil {
+				t.Fatalf("Failed to generate token: %v", err)
+			}
+
+			parsed, err := ja.ValidateToken(token)
+			if err != nil {
+				t.Fatalf("Failed to validate token: %v", err)
+			}
+			if parsed.Method.Alg() != tt.alg {
+				t.Errorf("token signed with %v want %v", parsed.Method.Alg(), tt.alg)
+			}
+
+			// another service verifies with the published key only
+			keys := jwksKeys(t, ja.keyring)
+			_, err = jwt.Parse(token, func(t *jwt.Token) (any, error) {
+				return keys[t.Header["kid"].(string)], nil
+			}, jwt.WithValidMethods([]string{tt.alg}))
+			if err != nil {
+				t.Errorf("token does not verify with the jwks: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeyringRejectsForeignTokens(t *testing.T) {
+	k := newTestKeyring(t, AlgRS256)
+	ja := NewJWTAuthenticator("testsecret", time.Hour).WithKeyring(k)
+	current := currentKey(t, k)
+	kid := current.ID
+
+	other := NewJWTAuthenticator("", time.Hour).WithKeyring(newTestKeyring(t, AlgRS256))
+	otherToken, err := other.GenerateToken(context.Background(), testUser)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	// an HS256 token signed with the public key the JWKS hands out
+	public, err := x509.MarshalPKIXPublicKey(current.Value.Public())
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, ja.BuildJWTClaims(testUser, 0))
+	confused.Header["kid"] = kid
+	confusedToken, err := confused.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	hs256, err := NewJWTAuthenticator("testsecret", time.Hour).GenerateToken(context.Background(), testUser)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	expired := jwt.NewWithClaims(signingMethod(current.Value), jwt.MapClaims{
+		"userId": testUser.ID,
+		"exp":    time.Now().Add(-time.Minute).Unix(),
+	})
+	expired.Header["kid"] = kid
+	expiredToken, err := expired.SignedString(current.Value)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"other keyring", otherToken},
+		{"algorithm confusion", confusedToken},
+		{"shared secret", hs256},
+		{"expired", expiredToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ja.ValidateToken(tt.token); err == nil {
+				t.Errorf("token accepted")
+			}
+		})
+	}
+}
+
+func TestReplicasVerifyEachOther(t *testing.T) {
+	rdb := newTestRedis(t)
+	cfg := KeyringConfig{Algorithm: AlgEdDSA, RotateEvery: 24 * time.Hour, TokenTTL: time.Hour, KEK: testKEK}
+
+	var authenticators []*JWTAuthenticator
+	for range 2 {
+		k, err := NewKeyring(context.Background(), rdb, cfg)
+		if err != nil {
+			t.Fatalf("Failed to create keyring: %v", err)
+		}
+		authenticators = append(authenticators, NewJWTAuthenticator("", time.Hour).WithKeyring(k))
+	}
+
+	token, err := authenticators[0].GenerateToken(context.Background(), testUser)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	if _, err := authenticators[1].ValidateToken(token); err != nil {
+		t.Errorf("replica rejected a token signed by another: %v", err)
+	}
+}
+
+func TestNewStaticKeyring(t *testing.T) {
+	der, err := generateKey(AlgEdDSA)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	valid := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"pkcs8 key", valid, false},
+		{"no pem", []byte("not a key"), true},
+		{"not a private key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := NewStaticKeyring("static", tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v want error %v", err, tt.wantErr)
+			}
+			if err == nil && currentKey(t, k).ID != "static" {
+				t.Errorf("signs with key %s want static", currentKey(t, k).ID)
+			}
+		})
+	}
+}
+
+func TestJWKSCachedLessThanKeysArePublishedAhead(t *testing.T) {
+	k := newTestKeyring(t, AlgEdDSA)
+
+	rr := httptest.NewRecorder()
+	k.JWKSHandler(rr, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+	// new keys are published two refreshes of a minute before they sign
+	if got, want := rr.Header().Get("Cache-Control"), "public, max-age=30"; got != want {
+		t.Errorf("got Cache-Control %q want %q", got, want)
+	}
+}
@@ -0,0 +1,393 @@
+// Package keyset keeps the rotating keys tokens and URLs are signed with.
+// Keys are stored in redis so every replica signs and verifies with the same
+// set and restarts keep them. Their material is encrypted there with a
+// key-encryption key every replica is configured with, reading redis is not
+// enough to sign.
+package keyset
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultRefresh = time.Minute
+	rotateLockTTL  = 10 * time.Second
+
+	// how long a replica that lost the race to create the first key waits
+	// for the winner to store it
+	firstKeyWait  = 5 * time.Second
+	firstKeyRetry = 100 * time.Millisecond
+)
+
+// KEKSize is the size of the key-encryption key, an AES-256 key.
+const KEKSize = 32
+
+var ErrNoKey = errors.New("keyset has no key")
+
+// Key is a key of the set, ID goes along with everything it signs.
+type Key[T any] struct {
+	ID    string
+	Value T
+}
+
+// stored is a key as kept in redis, Material is sealed with the KEK.
+type stored struct {
+	ID       string    `json:"id"`
+	Material []byte    `json:"material"`
+	Created  time.Time `json:"created_at"`
+	// Active is when the key starts signing. Keys are stored a while before
+	// so every replica can verify with them first.
+	Active time.Time `json:"active_at"`
+}
+
+type Config[T any] struct {
+	// Name tells the sets apart in redis.
+	Name string
+	// RotateEvery is how often a new key is generated, zero keeps the first
+	// one.
+	RotateEvery time.Duration
+	// TTL is how long a retired key keeps verifying, it must not be shorter
+	// than the lifetime of what it signed.
+	TTL time.Duration
+	// Refresh is how often Run reloads the set, a minute by default. New
+	// keys start signing two refreshes after they are generated.
+	Refresh time.Duration
+	// Generate returns the material of a new key, Parse turns it into the
+	// value the key is used as.
+	Generate func() ([]byte, error)
+	Parse    func([]byte) (T, error)
+	// KEK is the KEKSize bytes key the material is encrypted with in redis.
+	KEK []byte
+}
+
+type entry[T any] struct {
+	Key[T]
+	active time.Time
+}
+
+// Set is a set of keys, the newest active key signs and every key that
+// still verifies can be looked up by id.
+type Set[T any] struct {
+	redis *redis.Client
+	cfg   Config[T]
+	aead  cipher.AEAD
+	now   func() time.Time
+
+	mu sync.RWMutex
+	// newest first
+	keys []entry[T]
+}
+
+// New loads the set with the given name from redis, generating its first
+// key if there is none.
+func New[T any](ctx context.Context, rdb *redis.Client, cfg Config[T]) (*Set[T], error) {
+	if cfg.Name == "" || cfg.Generate == nil || cfg.Parse == nil {
+		return nil, errors.New("keyset needs a name, Generate and Parse")
+	}
+	if cfg.TTL <= 0 {
+		return nil, errors.New("keyset ttl must be greater than zero")
+	}
+	if len(cfg.KEK) != KEKSize {
+		return nil, fmt.Errorf("keyset kek must be %d bytes", KEKSize)
+	}
+	if cfg.Refresh <= 0 {
+		cfg.Refresh = defaultRefresh
+	}
+
+	block, err := aes.NewCipher(cfg.KEK)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Set[T]{redis: rdb, cfg: cfg, aead: aead, now: time.Now}
+	if err := s.Load(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Static returns a set holding only the given key. It never rotates, every
+// replica must be configured with the same key.
+func Static[T any](id string, value T) *Set[T] {
+	return &Set[T]{
+		cfg:  Config[T]{Refresh: defaultRefresh},
+		now:  time.Now,
+		keys: []entry[T]{{Key: Key[T]{ID: id, Value: value}}},
+	}
+}
+
+func (s *Set[T]) redisKey() string {
+	return fmt.Sprintf("keyset:%s", s.cfg.Name)
+}
+
+func (s *Set[T]) lockKey() string {
+	return fmt.Sprintf("keyset:%s:rotate", s.cfg.Name)
+}
+
+// seal encrypts the material of the key with the given id, the id and the
+// name of the set are authenticated along so material can't be moved to
+// another key.
+func (s *Set[T]) seal(id string, material []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(material)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, material, s.additionalData(id)), nil
+}
+
+func (s *Set[T]) open(id string, sealed []byte) ([]byte, error) {
+	size := s.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("sealed material too short")
+	}
+	return s.aead.Open(nil, sealed[:size], sealed[size:], s.additionalData(id))
+}
+
+func (s *Set[T]) additionalData(id string) []byte {
+	return []byte(s.cfg.Name + ":" + id)
+}
+
+func (s *Set[T]) read(ctx context.Context) ([]stored, error) {
+	data, err := s.redis.Get(ctx, s.redisKey()).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []stored
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("keyset %s: %w", s.cfg.Name, err)
+	}
+	return keys, nil
+}
+
+func (s *Set[T]) due(keys []stored, now time.Time) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	return s.cfg.RotateEvery > 0 && now.Sub(keys[0].Created) >= s.cfg.RotateEvery
+}
+
+// prune drops the keys that stopped verifying. A key retires when the key
+// after it becomes active and verifies for TTL more.
+func (s *Set[T]) prune(keys []stored, now time.Time) []stored {
+	for i := 1; i < len(keys); i++ {
+		retired := keys[i-1].Active
+		if retired.Before(now) && now.Sub(retired) > s.cfg.TTL {
+			return keys[:i]
+		}
+	}
+	return keys
+}
+
+// rotate adds a new key to the stored set unless another replica is doing
+// so or already did.
+func (s *Set[T]) rotate(ctx context.Context) ([]stored, error) {
+	ok, err := s.redis.SetNX(ctx, s.lockKey(), 1, rotateLockTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.read(ctx)
+	}
+	defer s.redis.Del(context.WithoutCancel(ctx), s.lockKey())
+
+	keys, err := s.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	if !s.due(keys, now) {
+		return keys, nil
+	}
+
+	material, err := s.cfg.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	// the first key signs right away, later ones once every replica had the
+	// chance to load them
+	active := now
+	if len(keys) > 0 {
+		active = now.Add(2 * s.cfg.Refresh)
+	}
+
+	id := uuid.NewString()
+	sealed, err := s.seal(id, material)
+	if err != nil {
+		return nil, err
+	}
+
+	key := stored{ID: id, Material: sealed, Created: now, Active: active}
+	keys = s.prune(append([]stored{key}, keys...), now)
+
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.redis.Set(ctx, s.redisKey(), data, 0).Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Load reloads the set from redis and rotates it when the newest key is due.
+func (s *Set[T]) Load(ctx context.Context) error {
+	if s.redis == nil {
+		return nil
+	}
+
+	keys, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	if s.due(keys, s.now()) {
+		if keys, err = s.rotate(ctx); err != nil {
+			return err
+		}
+	}
+
+	// another replica is storing the first key
+	for wait := time.Duration(0); len(keys) == 0 && wait < firstKeyWait; wait += firstKeyRetry {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(firstKeyRetry):
+		}
+		if keys, err = s.read(ctx); err != nil {
+			return err
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("keyset %s: %w", s.cfg.Name, ErrNoKey)
+	}
+
+	return s.apply(s.prune(keys, s.now()))
+}
+
+// apply replaces the keys in memory, keys loaded before are not parsed again.
+func (s *Set[T]) apply(keys []stored) error {
+	s.mu.RLock()
+	known := make(map[string]T, len(s.keys))
+	for _, e := range s.keys {
+		known[e.ID] = e.Value
+	}
+	s.mu.RUnlock()
+
+	entries := make([]entry[T], 0, len(keys))
+	for _, k := range keys {
+		value, ok := known[k.ID]
+		if !ok {
+			material, err := s.open(k.ID, k.Material)
+			if err != nil {
+				return fmt.Errorf("keyset %s: key %s: %w", s.cfg.Name, k.ID, err)
+			}
+			parsed, err := s.cfg.Parse(material)
+			if err != nil {
+				return fmt.Errorf("keyset %s: key %s: %w", s.cfg.Name, k.ID, err)
+			}
+			value = parsed
+		}
+		entries = append(entries, entry[T]{Key: Key[T]{ID: k.ID, Value: value}, active: k.Active})
+	}
+
+	s.mu.Lock()
+	s.keys = entries
+	s.mu.Unlock()
+	return nil
+}
+
+// Run reloads and rotates the set on schedule until ctx is done.
+func (s *Set[T]) Run(ctx context.Context) {
+	if s.redis == nil {
+		return
+	}
+
+	ticker := time.NewTicker(s.cfg.Refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Load(ctx); err != nil {
+				log.Printf("Failed to load %s keys: %v", s.cfg.Name, err)
+			}
+		}
+	}
+}
+
+// Current returns the key to sign with, the newest active one.
+func (s *Set[T]) Current() (Key[T], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.keys) == 0 {
+		return Key[T]{}, fmt.Errorf("keyset %s: %w", s.cfg.Name, ErrNoKey)
+	}
+
+	now := s.now()
+	for _, e := range s.keys {
+		if !e.active.After(now) {
+			return e.Key, nil
+		}
+	}
+	// only pending keys, which can only happen with clocks out of sync
+	return s.keys[len(s.keys)-1].Key, nil
+}
+
+// Lookup returns the value of the key with the given id if it still
+// verifies.
+func (s *Set[T]) Lookup(id string) (T, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.keys {
+		if e.ID == id {
+			return e.Value, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// MaxAge is how long a copy of Keys may be kept and still hold every key
+// before it signs. Every replica loads a new key within a refresh and it
+// signs two refreshes after it was generated, so copies must not outlive a
+// refresh, half of one leaves room for clock skew.
+func (s *Set[T]) MaxAge() time.Duration {
+	return s.cfg.Refresh / 2
+}
+
+// Keys returns every key that verifies, including the ones about to sign.
+func (s *Set[T]) Keys() []Key[T] {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]Key[T], len(s.keys))
+	for i, e := range s.keys {
+		keys[i] = e.Key
+	}
+	return keys
+}
@@ -0,0 +1,272 @@
+package keyset
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/go-redis/v9/maintnotifications"
+)
+
+const (
+	testRotateEvery = time.Hour
+	testTTL         = 10 * time.Minute
+	testRefresh     = time.Minute
+)
+
+var testKEK = bytes.Repeat([]byte("k"), KEKSize)
+
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		MaintNotificationsConfig: &maintnotifications.Config{
+			Mode: maintnotifications.ModeDisabled,
+		},
+	})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func testConfig() Config[[]byte] {
+	return Config[[]byte]{
+		Name:        "test",
+		RotateEvery: testRotateEvery,
+		TTL:         testTTL,
+		Refresh:     testRefresh,
+		Generate: func() ([]byte, error) {
+			secret := make([]byte, 32)
+			_, err := rand.Read(secret)
+			return secret, err
+		},
+		Parse: func(b []byte) ([]byte, error) { return b, nil },
+		KEK:   testKEK,
+	}
+}
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestSet(t *testing.T, rdb *redis.Client, c *clock) *Set[[]byte] {
+	t.Helper()
+
+	s, err := New(context.Background(), rdb, testConfig())
+	if err != nil {
+		t.Fatalf("Failed to create keyset: %v", err)
+	}
+	s.now = c.Now
+	return s
+}
+
+func current(t *testing.T, s *Set[[]byte]) Key[[]byte] {
+	t.Helper()
+
+	key, err := s.Current()
+	if err != nil {
+		t.Fatalf("Failed to get current key: %v", err)
+	}
+	return key
+}
+
+func TestReplicasShareKeys(t *testing.T) {
+	rdb := newTestRedis(t)
+	c := &clock{now: time.Now()}
+
+	a := newTestSet(t, rdb, c)
+	b := newTestSet(t, rdb, c)
+
+	ka, kb := current(t, a), current(t, b)
+	if ka.ID != kb.ID {
+		t.Fatalf("replicas sign with different keys: %s and %s", ka.ID, kb.ID)
+	}
+	if string(ka.Value) != string(kb.Value) {
+		t.Fatalf("replicas hold different material for key %s", ka.ID)
+	}
+}
+
+func TestFirstKeyIsCreatedOnce(t *testing.T) {
+	rdb := newTestRedis(t)
+
+	ids := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := New(context.Background(), rdb, testConfig())
+			if err != nil {
+				t.Errorf("Failed to create keyset: %v", err)
+				return
+			}
+			key, err := s.Current()
+			if err != nil {
+				t.Errorf("Failed to get current key: %v", err)
+				return
+			}
+			ids[i] = key.ID
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Fatalf("replicas created different first keys: %v", ids)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	c := &clock{now: time.Now()}
+
+	a := newTestSet(t, rdb, c)
+	b := newTestSet(t, rdb, c)
+	first := current(t, a).ID
+
+	// the first key was created by New on the wall clock
+	c.Advance(testRotateEvery + time.Second)
+	if err := a.Load(ctx); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if err := b.Load(ctx); err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+
+	keys := a.Keys()
+	if len(keys) != 2 {
+		t.Fatalf("got %d keys after rotating, want 2", len(keys))
+	}
+	next := keys[0].ID
+
+	steps := []struct {
+		name       string
+		advance    time.Duration
+		signing    string
+		firstKnown bool
+	}{
+		// the new key is published before it signs
+		{"pending", 0, first, true},
+		{"active", 2 * testRefresh, next, true},
+		{"retired", testTTL, next, true},
+		{"expired", time.Second, next, false},
+	}
+	for _, step := range steps {
+		c.Advance(step.advance)
+		for name, s := range map[string]*Set[[]byte]{"a": a, "b": b} {
+			if err := s.Load(ctx); err != nil {
+				t.Fatalf("%s: replica %s failed to load: %v", step.name, name, err)
+			}
+			if got := current(t, s).ID; got != step.signing {
+				t.Errorf("%s: replica %s signs with %s, want %s", step.name, name, got, step.signing)
+			}
+			if _, ok := s.Lookup(first); ok != step.firstKnown {
+				t.Errorf("%s: replica %s verifies with the first key: got %v want %v", step.name, name, ok, step.firstKnown)
+			}
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	s := Static("configured", []byte("secret"))
+	if err := s.Load(context.Background()); err != nil {
+		t.Fatalf("Failed to load static keyset: %v", err)
+	}
+
+	if got := current(t, s).ID; got != "configured" {
+		t.Errorf("got key %s want configured", got)
+	}
+	if _, ok := s.Lookup("other"); ok {
+		t.Errorf("unknown key found")
+	}
+	if got := len(s.Keys()); got != 1 {
+		t.Errorf("got %d keys want 1", got)
+	}
+}
+
+func TestMaterialIsEncrypted(t *testing.T) {
+	ctx := context.Background()
+	rdb := newTestRedis(t)
+	s := newTestSet(t, rdb, &clock{now: time.Now()})
+	key := current(t, s)
+
+	data, err := rdb.Get(ctx, s.redisKey()).Bytes()
+	if err != nil {
+		t.Fatalf("Failed to read stored keys: %v", err)
+	}
+	if bytes.Contains(data, key.Value) || bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(key.Value))) {
+		t.Errorf("key material stored in plain text")
+	}
+
+	// a replica with another kek can't use the keys
+	cfg := testConfig()
+	cfg.KEK = bytes.Repeat([]byte("x"), KEKSize)
+	if _, err := New(ctx, rdb, cfg); err == nil {
+		t.Errorf("keys opened with another kek")
+	}
+
+	// nor can material be moved to another key
+	var keys []stored
+	if err := json.Unmarshal(data, &keys); err != nil {
+		t.Fatalf("Failed to decode stored keys: %v", err)
+	}
+	keys[0].ID = "moved"
+	moved, _ := json.Marshal(keys)
+	if err := rdb.Set(ctx, s.redisKey(), moved, 0).Err(); err != nil {
+		t.Fatalf("Failed to store keys: %v", err)
+	}
+	if _, err := New(ctx, rdb, testConfig()); err == nil {
+		t.Errorf("material opened under another key id")
+	}
+}
+
+func TestNewRequiresKEK(t *testing.T) {
+	for _, kek := range [][]byte{nil, []byte("short")} {
+		cfg := testConfig()
+		cfg.KEK = kek
+		if _, err := New(context.Background(), newTestRedis(t), cfg); err == nil {
+			t.Errorf("keyset created with a %d bytes kek", len(kek))
+		}
+	}
+}
+
+func TestCurrentWithoutKeys(t *testing.T) {
+	var s Set[[]byte]
+	if _, err := s.Current(); !errors.Is(err, ErrNoKey) {
+		t.Errorf("got error %v want %v", err, ErrNoKey)
+	}
+}
+
+func TestMaxAgeEndsBeforeNewKeysSign(t *testing.T) {
+	s := newTestSet(t, newTestRedis(t), &clock{now: time.Now()})
+
+	// a new key is loaded by every replica within a refresh, a copy of the
+	// keys taken right before must expire before the key signs
+	if lead := 2 * testRefresh; testRefresh+s.MaxAge() >= lead {
+		t.Errorf("copies are kept %v, new keys sign after %v", s.MaxAge(), lead)
+	}
+}
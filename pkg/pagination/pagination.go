@@ -0,0 +1,90 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidLimit  = errors.New("invalid limit")
+)
+
+// Page is a keyset page request. After is the key of the last item of the
+// previous page, every list is ordered by UUIDv7 ids so the key is an id.
+type Page struct {
+	After string
+	Limit int
+}
+
+func EncodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func DecodeCursor(cursor string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+
+	id, err := uuid.Parse(string(b))
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+
+	return id.String(), nil
+}
+
+// Parse reads the cursor and limit query parameters of a list request.
+func Parse(r *http.Request) (Page, error) {
+	page := Page{Limit: DefaultLimit}
+	query := r.URL.Query()
+
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return Page{}, ErrInvalidLimit
+		}
+		page.Limit = min(n, MaxLimit)
+	}
+
+	if v := query.Get("cursor"); v != "" {
+		after, err := DecodeCursor(v)
+		if err != nil {
+			return Page{}, err
+		}
+		page.After = after
+	}
+
+	return page, nil
+}
+
+// Fetch is how many items to query for the page, one more than its limit so
+// a next page is only announced when it holds items.
+func (p Page) Fetch() int {
+	return p.Limit + 1
+}
+
+// Trim drops the extra item fetched for the page.
+func Trim[T any](p Page, items []T) []T {
+	return items[:min(len(items), p.Limit)]
+}
+
+// Next returns the cursor of the page after one whose query fetched count
+// items and that ends with lastKey once trimmed, or an empty string when
+// there is nothing left.
+func (p Page) Next(count int, lastKey string) string {
+	if count <= p.Limit || lastKey == "" {
+		return ""
+	}
+	return EncodeCursor(lastKey)
+}
@@ -0,0 +1,128 @@
+package pagination
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := uuid.Must(uuid.NewV7()).String()
+
+	got, err := DecodeCursor(EncodeCursor(id))
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if got != id {
+		t.Errorf("got %v want %v", got, id)
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	id := uuid.Must(uuid.NewV7()).String()
+	valid := EncodeCursor(id)
+
+	tests := []struct {
+		name    string
+		cursor  string
+		want    string
+		wantErr error
+	}{
+		{"valid", valid, id, nil},
+		{"not base64", "not a cursor!", "", ErrInvalidCursor},
+		{"not an id", EncodeCursor("1 OR 1=1"), "", ErrInvalidCursor},
+		{"truncated", valid[:len(valid)-4], "", ErrInvalidCursor},
+		{"padded", valid + "==", "", ErrInvalidCursor},
+		{"empty id", EncodeCursor(""), "", ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeCursor(tt.cursor)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	id := uuid.Must(uuid.NewV7()).String()
+
+	tests := []struct {
+		name    string
+		query   string
+		want    Page
+		wantErr error
+	}{
+		{"defaults", "", Page{Limit: DefaultLimit}, nil},
+		{"limit", "?limit=5", Page{Limit: 5}, nil},
+		{"limit capped", "?limit=1000", Page{Limit: MaxLimit}, nil},
+		{"zero limit", "?limit=0", Page{}, ErrInvalidLimit},
+		{"negative limit", "?limit=-1", Page{}, ErrInvalidLimit},
+		{"limit not a number", "?limit=ten", Page{}, ErrInvalidLimit},
+		{"cursor", "?cursor=" + EncodeCursor(id), Page{After: id, Limit: DefaultLimit}, nil},
+		{"tampered cursor", "?cursor=" + EncodeCursor(id+"x"), Page{}, ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(httptest.NewRequest("GET", "/posts"+tt.query, nil))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	id := uuid.Must(uuid.NewV7()).String()
+	page := Page{Limit: 2}
+
+	tests := []struct {
+		name    string
+		count   int
+		lastKey string
+		want    string
+	}{
+		{"more items", 3, id, EncodeCursor(id)},
+		{"full last page", 2, id, ""},
+		{"last page", 1, id, ""},
+		{"empty page", 0, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := page.Next(tt.count, tt.lastKey); got != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrim(t *testing.T) {
+	page := Page{Limit: 2}
+
+	tests := []struct {
+		name  string
+		items []int
+		want  int
+	}{
+		{"extra item", []int{1, 2, 3}, 2},
+		{"full page", []int{1, 2}, 2},
+		{"short page", []int{1}, 1},
+		{"empty page", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Trim(page, tt.items); len(got) != tt.want {
+				t.Errorf("got %v want %d items", got, tt.want)
+			}
+		})
+	}
+}
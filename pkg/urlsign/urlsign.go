@@ -0,0 +1,132 @@
+package urlsign
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/cakra17/social/pkg/keyset"
+	"github.com/redis/go-redis/v9"
+)
+
+const minSecretSize = 32
+
+var (
+	ErrInvalidSignature = errors.New("invalid url signature")
+	ErrExpired          = errors.New("url expired")
+)
+
+type SignerConfig struct {
+	// TTL is how long a signed URL stays valid.
+	TTL time.Duration
+	// RotateEvery is how often a new signing key is generated.
+	RotateEvery time.Duration
+	// KEK encrypts the keys in redis, see keyset.Config.
+	KEK []byte
+}
+
+// Signer signs resources with expiring HMAC-SHA256 signatures. The newest key
+// signs, retired keys keep verifying until every URL they signed has
+// expired.
+type Signer struct {
+	keys *keyset.Set[[]byte]
+	ttl  time.Duration
+}
+
+// NewSigner loads the rotating keys shared through redis.
+func NewSigner(ctx context.Context, rdb *redis.Client, cfg SignerConfig) (*Signer, error) {
+	if cfg.TTL <= 0 {
+		return nil, errors.New("url signer ttl must be greater than zero")
+	}
+
+	keys, err := keyset.New(ctx, rdb, keyset.Config[[]byte]{
+		Name:        "urlsign",
+		RotateEvery: cfg.RotateEvery,
+		TTL:         cfg.TTL,
+		Generate: func() ([]byte, error) {
+			secret := make([]byte, minSecretSize)
+			_, err := rand.Read(secret)
+			return secret, err
+		},
+		Parse: func(secret []byte) ([]byte, error) { return secret, nil },
+		KEK:   cfg.KEK,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{keys: keys, ttl: cfg.TTL}, nil
+}
+
+// NewStaticSigner signs with secret only. It does not rotate, every replica
+// must be configured with the same secret.
+func NewStaticSigner(cfg SignerConfig, kid string, secret []byte) (*Signer, error) {
+	if cfg.TTL <= 0 {
+		return nil, errors.New("url signer ttl must be greater than zero")
+	}
+	if len(secret) < minSecretSize {
+		return nil, fmt.Errorf("key %s: secret must be at least %d bytes", kid, minSecretSize)
+	}
+	return &Signer{keys: keyset.Static(kid, secret), ttl: cfg.TTL}, nil
+}
+
+// Run reloads and rotates the keys on schedule until ctx is done.
+func (s *Signer) Run(ctx context.Context) {
+	s.keys.Run(ctx)
+}
+
+func mac(secret []byte, resource string, expires int64) []byte {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "%s\n%d", resource, expires)
+	return h.Sum(nil)
+}
+
+// Sign returns the expires, kid and sig query parameters that grant access to
+// resource. Expiry is rounded to half the TTL so the same resource gets the
+// same URL for a while and clients can keep it cached. Without a key, which
+// the constructors rule out, nothing is granted.
+func (s *Signer) Sign(resource string) url.Values {
+	key, err := s.keys.Current()
+	if err != nil {
+		return url.Values{}
+	}
+
+	expires := time.Now().Truncate(s.ttl / 2).Add(s.ttl).Unix()
+
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("kid", key.ID)
+	q.Set("sig", base64.RawURLEncoding.EncodeToString(mac(key.Value, resource, expires)))
+	return q
+}
+
+// Verify checks the parameters produced by Sign and returns when the grant
+// expires.
+func (s *Signer) Verify(resource string, q url.Values) (time.Time, error) {
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidSignature
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get("sig"))
+	if err != nil {
+		return time.Time{}, ErrInvalidSignature
+	}
+
+	secret, ok := s.keys.Lookup(q.Get("kid"))
+	if !ok || !hmac.Equal(sig, mac(secret, resource, expires)) {
+		return time.Time{}, ErrInvalidSignature
+	}
+
+	expiresAt := time.Unix(expires, 0)
+	if time.Now().After(expiresAt) {
+		return time.Time{}, ErrExpired
+	}
+	return expiresAt, nil
+}
@@ -0,0 +1,140 @@
+package urlsign
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/cakra17/social/pkg/keyset"
+	"github.com/redis/go-redis/v9"
+	"github.com/redis/go-redis/v9/maintnotifications"
+)
+
+const testTTL = time.Hour
+
+var testSecret = []byte(strings.Repeat("s", minSecretSize))
+
+func newTestSigner(t *testing.T, secret []byte) *Signer {
+	t.Helper()
+
+	s, err := NewStaticSigner(SignerConfig{TTL: testTTL}, "test", secret)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return s
+}
+
+// signedAt returns the parameters of a grant expiring at expires.
+func signedAt(secret []byte, resource string, expires time.Time) url.Values {
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("kid", "test")
+	q.Set("sig", base64.RawURLEncoding.EncodeToString(mac(secret, resource, expires.Unix())))
+	return q
+}
+
+func with(q url.Values, key, value string) url.Values {
+	c := url.Values{}
+	for k, v := range q {
+		c[k] = v
+	}
+	c.Set(key, value)
+	return c
+}
+
+func TestVerify(t *testing.T) {
+	s := newTestSigner(t, testSecret)
+	signed := s.Sign("photo.jpg")
+
+	tests := []struct {
+		name     string
+		resource string
+		query    url.Values
+		wantErr  error
+	}{
+		{"valid", "photo.jpg", signed, nil},
+		{"other resource", "other.jpg", signed, ErrInvalidSignature},
+		{"extended expiry", "photo.jpg", with(signed, "expires", strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10)), ErrInvalidSignature},
+		{"tampered signature", "photo.jpg", with(signed, "sig", base64.RawURLEncoding.EncodeToString([]byte("forged"))), ErrInvalidSignature},
+		{"signature not base64", "photo.jpg", with(signed, "sig", "!!"), ErrInvalidSignature},
+		{"unknown key", "photo.jpg", with(signed, "kid", "other"), ErrInvalidSignature},
+		{"missing parameters", "photo.jpg", url.Values{}, ErrInvalidSignature},
+		{"other secret", "photo.jpg", signedAt([]byte(strings.Repeat("x", minSecretSize)), "photo.jpg", time.Now().Add(time.Hour)), ErrInvalidSignature},
+		{"expired", "photo.jpg", signedAt(testSecret, "photo.jpg", time.Now().Add(-time.Second)), ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Verify(tt.resource, tt.query)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSignExpiry(t *testing.T) {
+	s := newTestSigner(t, testSecret)
+
+	expiresAt, err := s.Verify("photo.jpg", s.Sign("photo.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to verify: %v", err)
+	}
+	// expiry is rounded down to half the ttl
+	if until := time.Until(expiresAt); until <= testTTL/2 || until > testTTL {
+		t.Errorf("grant expires in %s, want between %s and %s", until, testTTL/2, testTTL)
+	}
+
+	// the same resource keeps its URL while the expiry is not rounded up
+	if a, b := s.Sign("photo.jpg").Encode(), s.Sign("photo.jpg").Encode(); a != b {
+		t.Errorf("signed twice to different URLs: %s and %s", a, b)
+	}
+}
+
+func TestNewStaticSigner(t *testing.T) {
+	tests := []struct {
+		name   string
+		ttl    time.Duration
+		secret []byte
+	}{
+		{"zero ttl", 0, testSecret},
+		{"short secret", testTTL, []byte("short")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewStaticSigner(SignerConfig{TTL: tt.ttl}, "test", tt.secret); err == nil {
+				t.Errorf("signer created with %s", tt.name)
+			}
+		})
+	}
+}
+
+func TestReplicasVerifyEachOther(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+		MaintNotificationsConfig: &maintnotifications.Config{
+			Mode: maintnotifications.ModeDisabled,
+		},
+	})
+	t.Cleanup(func() { rdb.Close() })
+
+	cfg := SignerConfig{TTL: testTTL, RotateEvery: 24 * time.Hour, KEK: []byte(strings.Repeat("k", keyset.KEKSize))}
+	a, err := NewSigner(context.Background(), rdb, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	b, err := NewSigner(context.Background(), rdb, cfg)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	if _, err := b.Verify("photo.jpg", a.Sign("photo.jpg")); err != nil {
+		t.Errorf("replica rejected a url signed by another: %v", err)
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/cakra17/social/internal/config"
 	"github.com/cakra17/social/internal/handlers"
 	"github.com/cakra17/social/internal/models"
 	"github.com/cakra17/social/internal/store"
@@ -19,25 +21,27 @@ import (
 )
 
 var (
+	cfg = loadConfig()
+
 	db = store.ConnectDB(store.DBConfig{
-		DB_USERNAME: "admin",
-		DB_PASSWORD: "adminsecret",
-		DB_HOST: "localhost",
-		DB_PORT: "5432",
-		DB_NAME: "social",
-		DB_MaxOpenConn: 30,
-		DB_MaxIdleConn: 30,
-		DB_MaxConnLifetime: 15 * time.Minute,
-		DB_MaxConnIdletime: 15 * time.Minute,
+		DB_USERNAME:        cfg.DB.Username,
+		DB_PASSWORD:        cfg.DB.Password,
+		DB_HOST:            cfg.DB.Host,
+		DB_PORT:            cfg.DB.Port,
+		DB_NAME:            cfg.DB.Name,
+		DB_MaxOpenConn:     cfg.DB.MaxOpenConn,
+		DB_MaxIdleConn:     cfg.DB.MaxIdleConn,
+		DB_MaxConnLifetime: cfg.DB.MaxConnLifetime,
+		DB_MaxConnIdletime: cfg.DB.MaxConnIdletime,
 	})
 
-	jwtAuthenticator = jwt.NewJWTAuthenticator("mysecret", 5 * time.Hour)
-	logger = utils.NewLogger()
+	jwtAuthenticator = jwt.NewJWTAuthenticator(cfg.JWT.Secret, 5*time.Hour)
+	logger           = utils.NewLogger()
 
 	rdb = redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		Password: "",
-		DB: 0,
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
 		MaintNotificationsConfig: &maintnotifications.Config{
 			Mode: maintnotifications.ModeDisabled,
 		},
@@ -46,12 +50,24 @@ var (
 	userRepo = store.NewUserRepo(db, logger)
 
 	userHandler = handlers.NewUserHandler(handlers.UserHandlerConfig{
-		UserRepo: userRepo,
+		UserRepo:         userRepo,
 		JWTAuthenticator: jwtAuthenticator,
-		Redis: rdb,
+		Redis:            rdb,
 	})
 )
 
+func loadConfig() *config.Config {
+	if _, ok := os.LookupEnv("APP_ENV"); !ok {
+		os.Setenv("APP_ENV", config.EnvTest)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	return cfg
+}
+
 func TestCreateUserBadPayload(t *testing.T) {
 	req := httptest.NewRequest("POST", "/users", nil)
 	rr := httptest.NewRecorder()
@@ -62,7 +78,7 @@ func TestCreateUserBadPayload(t *testing.T) {
 		t.Errorf("handler return wromg status code: got %v want %v", status, http.StatusBadRequest)
 	}
 
-	var payload models.ErrorResponse
+	var payload utils.ErrorResponse
 	err := json.Unmarshal(rr.Body.Bytes(), &payload)
 	if err != nil {
 		log.Fatalf("Failed to Unmarshaling json: %v", err)
@@ -76,10 +92,10 @@ func TestCreateUserBadPayload(t *testing.T) {
 func TestCreateInvalidPayload(t *testing.T) {
 	userPayload := models.RegisterPayload{
 		Username: "dwda",
-		Email: "dasadsawdaw",
+		Email:    "dasadsawdaw",
 		Password: "wdwad",
 	}
-	jsonBytes,_ := json.Marshal(&userPayload)
+	jsonBytes, _ := json.Marshal(&userPayload)
 	req := httptest.NewRequest("POST", "/users", bytes.NewBuffer(jsonBytes))
 	rr := httptest.NewRecorder()
 
@@ -88,25 +104,25 @@ func TestCreateInvalidPayload(t *testing.T) {
 	if status := rr.Code; status != http.StatusBadRequest {
 		t.Errorf("handler return wromg status code: got %v want %v", status, http.StatusBadRequest)
 	}
-	
-	var payload models.ErrorResponse
+
+	var payload utils.ErrorResponse
 	err := json.Unmarshal(rr.Body.Bytes(), &payload)
 	if err != nil {
 		log.Fatalf("Failed to Unmarshaling json: %v", err)
 	}
 
 	if payload.Message != "Invalid Payload" {
-		t.Errorf("handler return wromg message: got %s want %v", payload.Message,"Invalid Payload")
+		t.Errorf("handler return wromg message: got %s want %v", payload.Message, "Invalid Payload")
 	}
 }
 
 func TestCreateDuplicateUser(t *testing.T) {
 	userPayload := models.RegisterPayload{
 		Username: "nightfall",
-		Email: "nightfall@gmail.com",
+		Email:    "nightfall@gmail.com",
 		Password: "nightfallgantenk",
 	}
-	jsonBytes,_ := json.Marshal(&userPayload)
+	jsonBytes, _ := json.Marshal(&userPayload)
 	req := httptest.NewRequest("POST", "/users", bytes.NewBuffer(jsonBytes))
 	rr := httptest.NewRecorder()
 
@@ -115,26 +131,26 @@ func TestCreateDuplicateUser(t *testing.T) {
 	if status := rr.Code; status != http.StatusConflict {
 		t.Errorf("handler return wromg status code: got %v want %v", status, http.StatusBadRequest)
 	}
-	
-	var payload models.ErrorResponse
+
+	var payload utils.ErrorResponse
 	err := json.Unmarshal(rr.Body.Bytes(), &payload)
 	if err != nil {
 		log.Fatalf("Failed to Unmarshaling json: %v", err)
 	}
 
 	if payload.Message != "Credentials already used" {
-		t.Errorf("handler return wromg message: got %s want %v", payload.Message,"Credentials already used")
+		t.Errorf("handler return wromg message: got %s want %v", payload.Message, "Credentials already used")
 	}
 }
 
 func TestCreateValidUser(t *testing.T) {
 	userPayload := models.RegisterPayload{
 		Username: "nightfall",
-		Email: "nightfall123@gmail.com",
+		Email:    "nightfall123@gmail.com",
 		Password: "nightfallgantenk",
 	}
 
-	jsonBytes,_ := json.Marshal(&userPayload)
+	jsonBytes, _ := json.Marshal(&userPayload)
 	req := httptest.NewRequest("POST", "/users", bytes.NewBuffer(jsonBytes))
 	rr := httptest.NewRecorder()
 
@@ -144,16 +160,12 @@ func TestCreateValidUser(t *testing.T) {
 		t.Errorf("handler return wromg status code: got %v want %v", status, http.StatusCreated)
 	}
 
-	var payload models.Response
+	var payload utils.Response
 	err := json.Unmarshal(rr.Body.Bytes(), &payload)
 	if err != nil {
 		log.Fatalf("Failed to Unmarshaling json: %v", err)
 	}
 
-	if !payload.Success {
-		t.Errorf("Failed to Create user")
-	}
-
 	if payload.Data == nil {
 		t.Errorf("handler didn't return correct data: got %v expect %v", payload.Data, userPayload)
 	}
@@ -169,7 +181,7 @@ func TestLoginWithInvalidPayload(t *testing.T) {
 		t.Errorf("handler return wromg status code: got %v want %v", status, http.StatusBadRequest)
 	}
 
-	var payload models.ErrorResponse
+	var payload utils.ErrorResponse
 	err := json.Unmarshal(rr.Body.Bytes(), &payload)
 	if err != nil {
 		log.Fatalf("Failed to Unmarshaling json: %v", err)
@@ -182,10 +194,10 @@ func TestLoginWithInvalidPayload(t *testing.T) {
 
 func TestLoginWithBadPayload(t *testing.T) {
 	userPayload := models.LoginPayload{
-		Email: "dasadsawdaw",
+		Email:    "dasadsawdaw",
 		Password: "wdwad",
 	}
-	jsonBytes,_ := json.Marshal(&userPayload)
+	jsonBytes, _ := json.Marshal(&userPayload)
 	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBytes))
 	rr := httptest.NewRecorder()
 
@@ -194,24 +206,24 @@ func TestLoginWithBadPayload(t *testing.T) {
 	if status := rr.Code; status != http.StatusBadRequest {
 		t.Errorf("handler return wromg status code: got %v want %v", status, http.StatusBadRequest)
 	}
-	
-	var payload models.ErrorResponse
+
+	var payload utils.ErrorResponse
 	err := json.Unmarshal(rr.Body.Bytes(), &payload)
 	if err != nil {
 		log.Fatalf("Failed to Unmarshaling json: %v", err)
 	}
 
 	if payload.Message != "Invalid Payload" {
-		t.Errorf("handler return wromg message: got %s want %v", payload.Message,"Invalid Payload")
+		t.Errorf("handler return wromg message: got %s want %v", payload.Message, "Invalid Payload")
 	}
 }
 
 func TestLoginWithFalseCredentials(t *testing.T) {
 	userPayload := models.LoginPayload{
-		Email: "nightfalloff@gmail.com",
+		Email:    "nightfalloff@gmail.com",
 		Password: "nightfallgantenk",
 	}
-	jsonBytes,_ := json.Marshal(&userPayload)
+	jsonBytes, _ := json.Marshal(&userPayload)
 	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBytes))
 	rr := httptest.NewRecorder()
 
@@ -221,7 +233,7 @@ func TestLoginWithFalseCredentials(t *testing.T) {
 		t.Errorf("handler return wromg status code: got %v want %v", status, http.StatusNotFound)
 	}
 
-	var payload models.ErrorResponse
+	var payload utils.ErrorResponse
 	err := json.Unmarshal(rr.Body.Bytes(), &payload)
 	if err != nil {
 		log.Fatalf("Failed to Unmarshaling json: %v", err)
@@ -234,10 +246,10 @@ func TestLoginWithFalseCredentials(t *testing.T) {
 
 func TestLoginWithWrongPassword(t *testing.T) {
 	userPayload := models.LoginPayload{
-		Email: "nightfall@gmail.com",
+		Email:    "nightfall@gmail.com",
 		Password: "nightfallgantenk123",
 	}
-	jsonBytes,_ := json.Marshal(&userPayload)
+	jsonBytes, _ := json.Marshal(&userPayload)
 	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBytes))
 	rr := httptest.NewRecorder()
 
@@ -246,8 +258,8 @@ func TestLoginWithWrongPassword(t *testing.T) {
 	if status := rr.Code; status != http.StatusBadRequest {
 		t.Errorf("handler return wromg status code: got %v want %v", status, http.StatusBadRequest)
 	}
-	
-	var payload models.ErrorResponse
+
+	var payload utils.ErrorResponse
 	err := json.Unmarshal(rr.Body.Bytes(), &payload)
 	if err != nil {
 		log.Fatalf("Failed to Unmarshaling json: %v", err)
@@ -260,10 +272,10 @@ func TestLoginWithWrongPassword(t *testing.T) {
 
 func TestLoginWithValidCredentials(t *testing.T) {
 	userPayload := models.LoginPayload{
-		Email: "nightfall@gmail.com",
+		Email:    "nightfall@gmail.com",
 		Password: "nightfallgantenk",
 	}
-	jsonBytes,_ := json.Marshal(&userPayload)
+	jsonBytes, _ := json.Marshal(&userPayload)
 	req := httptest.NewRequest("POST", "/login", bytes.NewBuffer(jsonBytes))
 	rr := httptest.NewRecorder()
 
@@ -272,18 +284,14 @@ func TestLoginWithValidCredentials(t *testing.T) {
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler return wromg status code: got %v want %v", status, http.StatusOK)
 	}
-	
-	var payload models.Response
+
+	var payload utils.Response
 	err := json.Unmarshal(rr.Body.Bytes(), &payload)
 	if err != nil {
 		log.Fatalf("Failed to Unmarshaling json: %v", err)
 	}
 
-	if !payload.Success {
-		t.Errorf("Failed to login")
-	}
-
 	if payload.Message != "success to login" {
 		t.Errorf("handler return wromg message: got %s want %v", payload.Message, "success to login")
 	}
-}
\ No newline at end of file
+}